@@ -1,10 +1,13 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"log"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/atotto/clipboard"
 	"github.com/vkuznet/ecm/crypt"
@@ -14,95 +17,306 @@ import (
 	"golang.org/x/term"
 )
 
-// helper function to read vault secret from stdin
-func secretPlain(verbose int) (string, error) {
+// helper function to read vault secret from stdin, the secret is never
+// logged and caller should wipe it once it is used
+func secretPlain() ([]byte, error) {
 	fmt.Print("\nEnter vault secret: ")
-	bytes, err := term.ReadPassword(int(syscall.Stdin))
+	secret, err := term.ReadPassword(int(syscall.Stdin))
 	if err != nil {
 		log.Println("unable to read stdin, error ", err)
-		return "", err
+		return nil, err
 	}
-	salt := strings.Replace(string(bytes), "\n", "", -1)
 	fmt.Println()
-	if verbose > 5 {
-		log.Printf("vault secret '%s'", salt)
-	}
-	return salt, nil
+	return bytes.TrimRight(secret, "\n"), nil
 }
 
 // decrypt record
-func decryptFile(dfile, cipher, pcopy string) {
+func decryptFile(dfile, cipher, keyFile, pcopy string) {
 	password, err := utils.ReadPassword()
 	if err != nil {
 		panic(err)
 	}
+	secret, err := crypt.CompositeSecret([]byte(password), keyFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer crypt.WipeBytes(secret)
 	write := "stdout"
 	if pcopy != "" {
 		write = "clipboard"
 	}
-	decryptInput(dfile, password, cipher, write, pcopy)
+	decryptInput(dfile, string(secret), cipher, write, pcopy)
 	//     os.Exit(0)
 }
 
+// helper function to recover vault from secret shares and set its new password
+func recoverVault(vault *vt.Vault, files string) error {
+	shares, err := readShares(files)
+	if err != nil {
+		return err
+	}
+	err = vault.RecoverKey(shares)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Vault data key is recovered from secret shares")
+	fmt.Println("Enter new vault password or leave it empty to only unlock the vault")
+	newPassword, err := utils.ReadPassword()
+	if err != nil {
+		return err
+	}
+	if newPassword == "" {
+		return nil
+	}
+	newPassword2, err := utils.ReadPassword()
+	if err != nil {
+		return err
+	}
+	if newPassword != newPassword2 {
+		return errors.New("provided password strings do not match")
+	}
+	err = vault.ChangeCredentials(newPassword, vault.KeyFile)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Vault password is changed")
+	return nil
+}
+
+// cliOptions represents options of cli actions, they are populated from
+// command line flags, see main function
+type cliOptions struct {
+	Encrypt       string // file to encrypt and place into the vault
+	Decrypt       string // file to decrypt
+	Add           string // kind of record to add
+	Pattern       string // query to search records
+	RecordID      string // ID of record to show
+	Edit          string // ID of record to edit
+	Copy          string // record attribute to copy to clipboard
+	Export        string // file to export records to
+	Import        string // file to import records from
+	Sync          string // URI to sync vault to
+	RecipientAdd  string // public key of recipient to add
+	RecipientRm   string // public key of recipient to remove
+	OTP           string // ID of record which TOTP code is copied to clipboard
+	Split         string // number and threshold of secret shares of vault data key
+	ShareDir      string // directory of written secret shares
+	RecoverShares string // secret share files to recover vault data key from
+	History       string // ID of record to show history of
+	Restore       string // record version to restore, e.g. rid:version
+	Delete        string // ID of record to move to the trash
+	Untrash       string // ID of record to restore from the trash
+	Attach        string // file to attach to the record, e.g. rid:file
+	Extract       string // record attachment to write to stdout, e.g. rid:name
+	Detach        string // record attachment to remove, e.g. rid:name
+	Folder        string // folder of records to show
+	Tag           string // tag of records to show
+	TagRename     string // tag to rename, e.g. old:new
+	TagMerge      string // tags to merge, e.g. tag1,tag2:tag
+	TagDelete     string // tag to delete
+	Audit         string // format of vault audit report
+	HIBP          string // local dataset of breached password hashes
+	Recreate      bool   // change master password and cipher of the vault
+	Rotate        bool   // rotate vault data key
+	Force         bool   // rotate vault data key even if it has recovery shares
+	Migrate       bool   // migrate records written by old vaults
+	Info          bool   // show vault info
+	Recipients    bool   // list vault recipients
+	Trash         bool   // list deleted records
+	GC            bool   // remove unreferenced attachment blobs
+	Tags          bool   // list vault tags
+	Verbose       int    // verbose level
+}
+
 // cli main function
 //gocyclo:ignore
-func cli(
-	vault *vt.Vault,
-	efile, dfile, add, pat, rid, edit, pcopy, export, vimport, sync string,
-	recreate, info bool,
-	verbose int,
-) {
+func cli(vault *vt.Vault, opts cliOptions) {
 
 	// decrypt file if given
-	if dfile != "" {
-		decryptFile(dfile, vault.Cipher, pcopy)
+	if opts.Decrypt != "" {
+		decryptFile(opts.Decrypt, vault.Cipher, vault.KeyFile, opts.Copy)
 		return
 	}
-	// get vault secret
-	if vault.Secret == "" {
-		salt, err := secretPlain(verbose)
+	// recover vault data key from secret shares
+	if opts.RecoverShares != "" {
+		err := recoverVault(vault, opts.RecoverShares)
+		if err != nil {
+			log.Fatalf("unable to recover vault, error %v", err)
+		}
+	}
+
+	// get vault secret unless vault is opened with recipient identity or
+	// recovered data key
+	if !vault.HasSecret() && vault.Identity == nil && vault.RecoveryKey == nil {
+		secret, err := secretPlain()
 		if err != nil {
 			log.Fatal(err)
 		}
-		vault.Secret = salt
+		vault.SetSecret(secret)
 	}
 
 	// encrypt given record
-	if efile != "" {
-		vault.EncryptFile(efile)
+	if opts.Encrypt != "" {
+		vault.EncryptFile(opts.Encrypt)
 		//         os.Exit(0)
 		return
 	}
 
+	// split vault data key into secret shares
+	if opts.Split != "" {
+		err := splitVaultKey(vault, opts.Split, opts.ShareDir)
+		if err != nil {
+			log.Fatalf("unable to split vault key, error %v", err)
+		}
+		return
+	}
+
+	// manage vault recipients
+	if opts.RecipientAdd != "" {
+		err := vault.AddRecipient(opts.RecipientAdd)
+		if err != nil {
+			log.Fatalf("unable to add vault recipient, error %v", err)
+		}
+		fmt.Printf("Recipient %s is added to the vault\n", opts.RecipientAdd)
+		return
+	}
+	if opts.RecipientRm != "" {
+		err := vault.RemoveRecipient(opts.RecipientRm)
+		if err != nil {
+			log.Fatalf("unable to remove vault recipient, error %v", err)
+		}
+		fmt.Printf("Recipient %s is removed from the vault, use -rotate to revoke its access to vault records\n", opts.RecipientRm)
+		return
+	}
+	if opts.Recipients {
+		rlist, err := vault.Recipients()
+		if err != nil {
+			log.Fatalf("unable to get vault recipients, error %v", err)
+		}
+		for _, r := range rlist {
+			fmt.Println(r)
+		}
+		return
+	}
+
+	// bind records written by old vaults to their file names
+	if opts.Migrate {
+		count, err := vault.Migrate()
+		if err != nil {
+			log.Fatalf("unable to migrate vault records, error %v", err)
+		}
+		fmt.Printf("Migrated %d vault records\n", count)
+		return
+	}
+
+	// manage vault tags, tags are taken from vault index and only records
+	// whose tags are changed are decrypted
+	if opts.Tags {
+		err := listTags(vault)
+		if err != nil {
+			log.Fatalf("unable to list vault tags, error %v", err)
+		}
+		return
+	}
+	if opts.TagRename != "" || opts.TagMerge != "" || opts.TagDelete != "" {
+		err := changeTags(vault, opts.TagRename, opts.TagMerge, opts.TagDelete)
+		if err != nil {
+			log.Fatalf("unable to change vault tags, error %v", err)
+		}
+		return
+	}
+
+	// search and look-up of single record only decrypt vault index and
+	// matched records
+	if opts.Pattern != "" || opts.RecordID != "" || opts.Folder != "" || opts.Tag != "" {
+		err := vault.ReadIndex()
+		if vt.PartialError(err) {
+			warnRecords(err)
+		} else if err != nil {
+			log.Fatal("unable to read vault index, error ", err)
+		}
+		var records []vt.VaultRecord
+		if opts.Pattern != "" || opts.Folder != "" || opts.Tag != "" {
+			query, err := vt.ParseQuery(opts.Pattern)
+			if err != nil {
+				log.Fatal("unable to search vault records, error ", err)
+			}
+			// folder and tag values are not parsed since they may contain
+			// spaces or special characters
+			if opts.Folder != "" {
+				query = query.And(vt.FieldQuery("folder", opts.Folder))
+			}
+			if opts.Tag != "" {
+				query = query.And(vt.FieldQuery("tag", vt.NormalizeTag(opts.Tag)))
+			}
+			records = vault.Search(query)
+		} else if rec, err := vault.LoadRecord(opts.RecordID); err == nil {
+			// copy record password to clipboard if necessary
+			if opts.Copy == "" {
+				opts.Copy = "Password" // by default we copy Password to clipboard
+			}
+			if _, ok := rec.Map[opts.Copy]; ok {
+				if err := clipboard.WriteAll(rec.Value(opts.Copy)); err != nil {
+					log.Printf("ERROR: unable to copy '%s' to clipboard", opts.Copy)
+				}
+			}
+			records = append(records, rec)
+		}
+		vt.TabularPrint(records)
+		return
+	}
+
 	// read from our vault
+	// records which can not be decrypted are reported while other records
+	// are kept
 	err := vault.Read()
-	if err != nil {
+	if vt.PartialError(err) {
+		warnRecords(err)
+	} else if err != nil {
 		log.Fatal("unable to read vault, error ", err)
 	}
 
 	// show vault info
-	if info {
+	if opts.Info {
 		fmt.Println(vault.Info())
 		//         os.Exit(0)
 		return
 	}
 
+	// check vault passwords against local dataset of breached passwords
+	if opts.HIBP != "" {
+		err := checkBreaches(vault, opts.HIBP)
+		if err != nil {
+			log.Fatalf("unable to check breached passwords, error %v", err)
+		}
+		return
+	}
+
+	// audit vault passwords
+	if opts.Audit != "" {
+		err := auditVault(vault, opts.Audit)
+		if err != nil {
+			log.Fatalf("unable to audit vault, error %v", err)
+		}
+		return
+	}
+
 	// sync vault
-	if sync != "" {
-		if strings.HasPrefix(sync, "file://") {
-			path := strings.Replace(sync, "file://", "", -1)
+	if opts.Sync != "" {
+		if strings.HasPrefix(opts.Sync, "file://") {
+			path := strings.Replace(opts.Sync, "file://", "", -1)
 			dst := storage.NewFileStorage(path)
 			err = vault.Sync(dst)
-		} else if strings.HasPrefix(sync, "googledrive://") {
-			path := strings.Replace(sync, "googledrive://", "", -1)
+		} else if strings.HasPrefix(opts.Sync, "googledrive://") {
+			path := strings.Replace(opts.Sync, "googledrive://", "", -1)
 			dst := storage.NewGoogleDriveStorage(path)
 			err = vault.Sync(dst)
-		} else if strings.HasPrefix(sync, "dropbox://") {
-			path := strings.Replace(sync, "dropbox://", "", -1)
+		} else if strings.HasPrefix(opts.Sync, "dropbox://") {
+			path := strings.Replace(opts.Sync, "dropbox://", "", -1)
 			dst := storage.NewDropboxStorage(path)
 			err = vault.Sync(dst)
-		} else if strings.HasPrefix(sync, "ssh://") {
-			path := strings.Replace(sync, "ssh://", "", -1)
+		} else if strings.HasPrefix(opts.Sync, "ssh://") {
+			path := strings.Replace(opts.Sync, "ssh://", "", -1)
 			dst := storage.NewSSHStorage(path)
 			err = vault.Sync(dst)
 		}
@@ -114,8 +328,11 @@ func cli(
 	}
 
 	// add given record
-	if add != "" {
-		rec, err := vault.AddRecord(add)
+	if opts.Add != "" {
+		if _, ok := vt.LookupSchema(opts.Add); !ok {
+			log.Fatalf("unknown record kind '%s', supported kinds: %s", opts.Add, strings.Join(vt.Kinds(), ", "))
+		}
+		rec, err := vault.AddRecord(opts.Add)
 		if err != nil {
 			log.Fatalf("unable to create new vault record, error '%s'", err)
 		}
@@ -127,8 +344,8 @@ func cli(
 		return
 	}
 	// edit given record
-	if edit != "" {
-		err := vault.EditRecord(edit)
+	if opts.Edit != "" {
+		err := vault.EditRecord(opts.Edit)
 		if err != nil {
 			log.Fatalf("unable to edit vault record, error '%s'", err)
 		}
@@ -136,8 +353,8 @@ func cli(
 		return
 	}
 	// export vault records
-	if export != "" && vimport == "" {
-		err = vault.Export(export)
+	if opts.Export != "" && opts.Import == "" {
+		err = vault.Export(opts.Export)
 		if err != nil {
 			log.Fatalf("unable to export vault records, error %v", err)
 		}
@@ -146,8 +363,8 @@ func cli(
 	}
 
 	// import records to the vault
-	if vimport != "" {
-		err = vault.Import(vimport, export)
+	if opts.Import != "" {
+		err = vault.Import(opts.Import, opts.Export)
 		if err != nil {
 			log.Fatalf("unable to import records to the vault, error %v", err)
 		}
@@ -155,14 +372,30 @@ func cli(
 		return
 	}
 
-	// change master password of the vault and re-encrypt all records
-	if recreate {
-		log.Printf("Supported ciphers: %v", crypt.SupportedCiphers)
+	// rotate vault data key and re-encrypt all records
+	if opts.Rotate {
+		n, k := vault.RecoveryShares()
+		err = vault.RotateKey(opts.Force)
+		if errors.Is(err, vt.ErrRecoveryShares) {
+			log.Fatalf("unable to rotate vault data key, error %v, use -force to rotate it and issue new shares", err)
+		} else if err != nil {
+			log.Fatalf("unable to rotate vault data key, error %v", err)
+		}
+		err = reissueShares(vault, n, k, opts.ShareDir)
+		if err != nil {
+			log.Fatalf("unable to issue new recovery shares, error %v", err)
+		}
+		return
+	}
+
+	// change master password and cipher of the vault
+	if opts.Recreate {
+		log.Printf("Supported ciphers: %v", crypt.SupportedCiphers())
 		newCipher, err := utils.ReadInput("Cipher to use:")
 		if err != nil {
 			log.Fatal(err)
 		}
-		if !utils.InList(newCipher, crypt.SupportedCiphers) {
+		if !utils.InList(newCipher, crypt.SupportedCiphers()) {
 			log.Fatal("Unsupported cipher")
 		}
 		newPassword, err := utils.ReadPassword()
@@ -176,40 +409,119 @@ func cli(
 		if newPassword != newPassword2 {
 			log.Fatal("provided password strings do not match")
 		}
-		err = vault.Recreate(newPassword, newCipher)
+		newKeyFile, err := utils.ReadInput("Key file to use (leave empty to use password only):")
+		if err != nil {
+			log.Fatal(err)
+		}
+		n, k := vault.RecoveryShares()
+		err = vault.Recreate(newPassword, newKeyFile, newCipher, opts.Force)
+		if errors.Is(err, vt.ErrRecoveryShares) {
+			log.Fatalf("unable to change vault cipher, error %v, use -force to rotate vault data key and issue new shares", err)
+		} else if err != nil {
+			log.Fatalf("unable to change vault master password, error %v", err)
+		}
+		err = reissueShares(vault, n, k, opts.ShareDir)
 		if err != nil {
-			log.Fatalf("unable to change vault master password and re-encrypt its records, error %v", err)
+			log.Fatalf("unable to issue new recovery shares, error %v", err)
 		}
 		//         os.Exit(0)
 		return
 	}
 
-	records := vault.Records
-	// perform search
-	if pat != "" {
-		records = vault.Find(pat)
-	} else if rid != "" {
-		var newRecords []vt.VaultRecord
-		// copy record password to clipboard if necessary
-		// find give record ID
-		for _, rec := range records {
-			if rec.ID == rid {
-				if pcopy == "" {
-					pcopy = "Password" // by default we copy Password to clipboard
-				}
-				if v, ok := rec.Map[pcopy]; ok {
-					if err := clipboard.WriteAll(v); err != nil {
-						log.Printf("ERROR: unable to copy '%s' to clipboard", pcopy)
-					}
-				}
-				newRecords = append(newRecords, rec)
-				break
-			}
+	// copy TOTP code of given record to clipboard
+	if opts.OTP != "" {
+		rec, err := vault.Record(opts.OTP)
+		if err != nil {
+			log.Fatal(err)
+		}
+		code, remaining, err := rec.OTP()
+		if err != nil {
+			log.Fatalf("unable to get TOTP code, error %v", err)
+		}
+		if err := clipboard.WriteAll(code); err != nil {
+			log.Fatalf("unable to copy TOTP code to clipboard, error %v", err)
+		}
+		fmt.Printf("TOTP code of record %s is copied to clipboard, valid for %v\n", opts.OTP, remaining.Round(time.Second))
+		return
+	}
+
+	// show history of given record
+	if opts.History != "" {
+		err := printHistory(vault, opts.History)
+		if err != nil {
+			log.Fatalf("unable to get record history, error %v", err)
+		}
+		return
+	}
+	// restore given version of the record
+	if opts.Restore != "" {
+		err := restoreVersion(vault, opts.Restore)
+		if err != nil {
+			log.Fatalf("unable to restore record, error %v", err)
+		}
+		return
+	}
+
+	// move given record to the trash
+	if opts.Delete != "" {
+		err := vault.DeleteRecord(opts.Delete)
+		if err != nil {
+			log.Fatalf("unable to delete record, error %v", err)
+		}
+		fmt.Printf("Record %s is moved to the trash, use -untrash to restore it\n", opts.Delete)
+		return
+	}
+	// restore given record from the trash
+	if opts.Untrash != "" {
+		err := vault.RestoreRecord(opts.Untrash)
+		if err != nil {
+			log.Fatalf("unable to restore record from the trash, error %v", err)
+		}
+		fmt.Printf("Record %s is restored from the trash\n", opts.Untrash)
+		return
+	}
+	// list deleted records
+	if opts.Trash {
+		err := printTrash(vault)
+		if err != nil {
+			log.Fatalf("unable to list vault trash, error %v", err)
+		}
+		return
+	}
+
+	// manage record attachments
+	if opts.Attach != "" {
+		err := attachFile(vault, opts.Attach)
+		if err != nil {
+			log.Fatalf("unable to attach file, error %v", err)
 		}
-		records = newRecords
+		return
+	}
+	if opts.Extract != "" {
+		err := extractAttachment(vault, opts.Extract)
+		if err != nil {
+			log.Fatalf("unable to extract attachment, error %v", err)
+		}
+		return
+	}
+	if opts.Detach != "" {
+		err := detachFile(vault, opts.Detach)
+		if err != nil {
+			log.Fatalf("unable to remove attachment, error %v", err)
+		}
+		return
+	}
+	// remove unreferenced attachment blobs
+	if opts.GC {
+		removed, err := vault.CollectBlobs()
+		if err != nil {
+			log.Fatalf("unable to collect vault blobs, error %v", err)
+		}
+		fmt.Printf("Removed %d unreferenced blobs\n", len(removed))
+		return
 	}
 
 	// print records
-	vt.TabularPrint(records)
+	vt.TabularPrint(vault.Records)
 
 }
@@ -95,27 +95,22 @@ func TestCLI(t *testing.T) {
 	}
 	log.Println("create vault at", vname)
 	vault := vt.Vault{
-		Secret:    secret,
 		Directory: vname,
 		Cipher:    crypt.GetCipher(cipher),
 		Verbose:   verbose,
 		Start:     time.Now()}
+	vault.SetSecret([]byte(secret))
 	err = vault.Create(vname)
 	if err != nil {
 		log.Fatalf("unable to create vault, error %v", err)
 	}
 
-	var efile, dfile, add, pat, rid, edit, pcopy, export, vimport, sync string
-	var recreate, info bool
+	opts := cliOptions{Verbose: verbose}
 	log.Println("emulate `ecm -import test.csv -export ecm.json`")
-	vimport = csvFile.Name()
-	export = ecmFile.Name()
-	log.Printf("will export records from %s (csv file) to %s (ecm json file)", vimport, export)
-	cli(&vault,
-		efile, dfile, add, pat, rid, edit, pcopy, export, vimport, sync,
-		recreate, info,
-		verbose,
-	)
+	opts.Import = csvFile.Name()
+	opts.Export = ecmFile.Name()
+	log.Printf("will export records from %s (csv file) to %s (ecm json file)", opts.Import, opts.Export)
+	cli(&vault, opts)
 
 	// read records from csvFile
 	if file, e := os.Open(ecmFile.Name()); e == nil {
@@ -128,14 +123,10 @@ func TestCLI(t *testing.T) {
 	}
 
 	log.Println("emulate `ecm -import ecm.json -export <vault>`")
-	vimport = ecmFile.Name()
-	export = vname
-	log.Printf("will export records from %s (csv file) to %s (vault.Directory %s)", vimport, export, vault.Directory)
-	cli(&vault,
-		efile, dfile, add, pat, rid, edit, pcopy, export, vimport, sync,
-		recreate, info,
-		verbose,
-	)
+	opts.Import = ecmFile.Name()
+	opts.Export = vname
+	log.Printf("will export records from %s (csv file) to %s (vault.Directory %s)", opts.Import, opts.Export, vault.Directory)
+	cli(&vault, opts)
 
 	// list vault directory
 	files, err := ioutil.ReadDir(vname)
@@ -156,12 +147,8 @@ func TestCLI(t *testing.T) {
 	log.Println(vault.Info())
 
 	// run cli -pat name-327
-	vimport = ""
-	export = ""
-	pat = "name-1"
-	cli(&vault,
-		efile, dfile, add, pat, rid, edit, pcopy, export, vimport, sync,
-		recreate, info,
-		verbose,
-	)
+	opts.Import = ""
+	opts.Export = ""
+	opts.Pattern = "name-1"
+	cli(&vault, opts)
 }
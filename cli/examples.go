@@ -13,9 +13,51 @@ func ecmExamples() {
 	fmt.Println("# get vault info")
 	fmt.Println("./ecm -info")
 	fmt.Println("")
+	fmt.Println("# search vault records by query, see -pat for query syntax")
+	fmt.Println("./ecm -pat 'kind:login tag:work (url:github OR name:~gitlab) sort:name'")
+	fmt.Println("")
 	fmt.Println("# get info about single vault record (and its password will be copied to clipboard)")
 	fmt.Println("./ecm -rid cc1ee1e4-183c-423f-9ce1-62f26287441b")
 	fmt.Println("")
+	fmt.Println("# copy current TOTP code of vault record to clipboard")
+	fmt.Println("./ecm -otp cc1ee1e4-183c-423f-9ce1-62f26287441b")
+	fmt.Println("")
+	fmt.Println("# show versions of vault record and restore one of them")
+	fmt.Println("./ecm -history cc1ee1e4-183c-423f-9ce1-62f26287441b")
+	fmt.Println("./ecm -restore cc1ee1e4-183c-423f-9ce1-62f26287441b:20221105T101530.123456789Z")
+	fmt.Println("")
+	fmt.Println("# delete vault record, list deleted records and restore it from the trash")
+	fmt.Println("./ecm -delete cc1ee1e4-183c-423f-9ce1-62f26287441b")
+	fmt.Println("./ecm -trash")
+	fmt.Println("./ecm -untrash cc1ee1e4-183c-423f-9ce1-62f26287441b")
+	fmt.Println("")
+	fmt.Println("# attach file to vault record, extract and remove it, and remove unused attachments")
+	fmt.Println("./ecm -attach cc1ee1e4-183c-423f-9ce1-62f26287441b:/path/passport.pdf")
+	fmt.Println("./ecm -extract cc1ee1e4-183c-423f-9ce1-62f26287441b:passport.pdf > passport.pdf")
+	fmt.Println("./ecm -detach cc1ee1e4-183c-423f-9ce1-62f26287441b:passport.pdf")
+	fmt.Println("./ecm -gc")
+	fmt.Println("")
+	fmt.Println("# list records of given folder and its sub-folders, or search records within the folder")
+	fmt.Println("./ecm -folder work/servers")
+	fmt.Println("./ecm -folder work -pat github")
+	fmt.Println("")
+	fmt.Println("# list vault tags, records with given tag, and rename, merge or delete tag in all records")
+	fmt.Println("./ecm -tags")
+	fmt.Println("./ecm -tag work -pat github")
+	fmt.Println("./ecm -tag-rename job:work")
+	fmt.Println("./ecm -tag-merge bank,finance:money")
+	fmt.Println("./ecm -tag-delete old")
+	fmt.Println("")
+	fmt.Println("# audit vault passwords and print report as table or JSON")
+	fmt.Println("./ecm -audit table")
+	fmt.Println("./ecm -audit json -audit-days 180")
+	fmt.Println("")
+	fmt.Println("# check vault passwords against local Have I Been Pwned dataset of SHA-1 hashes")
+	fmt.Println("./ecm -hibp /data/pwned-passwords-sha1-ordered-by-hash.txt")
+	fmt.Println("")
+	fmt.Println("# add new vault record of given kind, e.g. login, card, note, identity, ssh, wifi or api")
+	fmt.Println("./ecm -add card")
+	fmt.Println("")
 	fmt.Println("# edit given vault record")
 	fmt.Println("./ecm -rid cc1ee1e4-183c-423f-9ce1-62f26287441b")
 	fmt.Println("")
@@ -38,6 +80,50 @@ func ecmExamples() {
 	fmt.Println("# import CSV file and write its content to the vault area")
 	fmt.Println("./ecm -import file.csv -export ~/.ecm/Primary")
 	fmt.Println("")
+	fmt.Println("# change vault master password, only vault data key is re-encrypted")
+	fmt.Println("./ecm -recreate")
+	fmt.Println("")
+	fmt.Println("# rotate vault data key and re-encrypt all vault records")
+	fmt.Println("./ecm -rotate")
+	fmt.Println("")
+	fmt.Println("# generate key file and use it along with vault password,")
+	fmt.Println("# the key file is added to existing vault via -recreate")
+	fmt.Println("./ecm -keyfile-gen /media/usb/ecm.key")
+	fmt.Println("./ecm -keyfile /media/usb/ecm.key -pat name")
+	fmt.Println("")
+	fmt.Println("# split vault data key into 5 secret shares with threshold 3")
+	fmt.Println("# and recover the vault from any 3 of them with new password")
+	fmt.Println("./ecm -split 5:3 -share-dir /tmp/shares")
+	fmt.Println("./ecm -recover /tmp/shares/ecm-share-1.txt,/tmp/shares/ecm-share-2.txt,/tmp/shares/ecm-share-5.txt")
+	fmt.Println("")
+	fmt.Println("# rotate vault data key which has recovery shares, old shares become")
+	fmt.Println("# invalid and new ones are written to share directory")
+	fmt.Println("./ecm -rotate -force -share-dir /tmp/shares")
+	fmt.Println("")
+	fmt.Println("# migrate records written by old vaults")
+	fmt.Println("./ecm -migrate")
+	fmt.Println("")
+	fmt.Println("# generate X25519 identity and share vault with its recipient")
+	fmt.Println("./ecm -keygen ~/.ecm/identity.txt")
+	fmt.Println("./ecm -recipient-add ecmpub-...")
+	fmt.Println("")
+	fmt.Println("# open vault with X25519 identity instead of vault password")
+	fmt.Println("./ecm -identity ~/.ecm/identity.txt -pat name")
+	fmt.Println("")
+	fmt.Println("# remove recipient from the vault and revoke its access")
+	fmt.Println("./ecm -recipient-rm ecmpub-...")
+	fmt.Println("./ecm -rotate")
+	fmt.Println("")
 	fmt.Println("# generate random password of 16 characters with numbers and symbols")
 	fmt.Println("./ecm -gen=16:ns")
+	fmt.Println("")
+	fmt.Println("# generate password of 20 characters with at least 2 numbers and 1 symbol")
+	fmt.Println("# without ambiguous characters, e.g. 0 and O")
+	fmt.Println("./ecm -gen=20:n2s1a")
+	fmt.Println("")
+	fmt.Println("# generate password of 12 characters from custom alphabet")
+	fmt.Println("./ecm -gen=12 -alphabet=abcdef0123456789")
+	fmt.Println("")
+	fmt.Println("# generate diceware passphrase of 6 words")
+	fmt.Println("./ecm -gen=words:6")
 }
@@ -9,6 +9,7 @@ require (
 	github.com/vkuznet/ecm/utils v0.0.0-20220920150436-14c90da1146b
 	github.com/vkuznet/ecm/vault v0.0.0-20220920150436-14c90da1146b
 	golang.org/x/term v0.0.0-20220919170432-7a66f970e087
+	rsc.io/qr v0.2.0
 )
 
 require (
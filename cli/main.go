@@ -7,7 +7,6 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
-	"strconv"
 	"strings"
 	"time"
 
@@ -26,40 +25,74 @@ func ecmInfo() string {
 }
 
 func main() {
+	// options of cli actions
+	var opts cliOptions
 	var vname string
 	flag.StringVar(&vname, "vault", "", "vault directory name")
 	var cipher string
-	flag.StringVar(&cipher, "cipher", "", "cipher to use (aes, nacl)")
-	var dfile string
-	flag.StringVar(&dfile, "decrypt", "", "decrypt given file to stdout")
-	var efile string
-	flag.StringVar(&efile, "encrypt", "", "encrypt given file and place it into vault")
-	var pcopy string
-	flag.StringVar(&pcopy, "pcopy", "", "extract given attribute from the record and copy to clipboard")
-	var export string
-	flag.StringVar(&export, "export", "", "export vault records to given file (ECM JSON native format), or to vault if -import ecm.json is provided")
-	var vimport string
-	flag.StringVar(&vimport, "import", "", "import records from a given file. Support: CSV, JSON, or ecm.json (native format)")
-	var recreate bool
-	flag.BoolVar(&recreate, "recreate", false, "recreate vault and its records with new password/cipher")
-	var pat string
-	flag.StringVar(&pat, "pat", "", "search pattern in vault records")
-	var info bool
-	flag.BoolVar(&info, "info", false, "show vault info")
+	flag.StringVar(&cipher, "cipher", "", fmt.Sprintf("cipher to use (%s)", strings.Join(crypt.SupportedCiphers(), ", ")))
+	var kdf string
+	flag.StringVar(&kdf, "kdf", "", "key derivation function of new vault with optional cost parameters, e.g. argon2id:3:65536:4 or scrypt:15:8:1")
+	flag.StringVar(&opts.Decrypt, "decrypt", "", "decrypt given file to stdout")
+	flag.StringVar(&opts.Encrypt, "encrypt", "", "encrypt given file, or stdin if - is given, and place it into vault, the file is encrypted as a stream")
+	flag.StringVar(&opts.Copy, "pcopy", "", "extract given attribute from the record and copy to clipboard")
+	flag.StringVar(&opts.Export, "export", "", "export vault records to given file (ECM JSON native format), or to vault if -import ecm.json is provided")
+	flag.StringVar(&opts.Import, "import", "", "import records from a given file. Support: CSV, JSON, or ecm.json (native format)")
+	flag.BoolVar(&opts.Recreate, "recreate", false, "recreate vault and its records with new password/cipher")
+	flag.BoolVar(&opts.Rotate, "rotate", false, "rotate vault data key and re-encrypt all vault records for the current list of vault recipients")
+	flag.BoolVar(&opts.Force, "force", false, "rotate vault data key with -rotate or -recreate even if it has recovery shares, new shares are written to -share-dir")
+	var keyFile string
+	flag.StringVar(&keyFile, "keyfile", "", "key file used along with vault password to unlock the vault")
+	var keyFileGen string
+	flag.StringVar(&keyFileGen, "keyfile-gen", "", "generate new random key file with given name")
+	flag.BoolVar(&opts.Migrate, "migrate", false, "migrate vault records written by old vaults and bind them to their file names")
+	var identity string
+	flag.StringVar(&identity, "identity", "", "open vault with X25519 identity from given file instead of vault password")
+	var keygen string
+	flag.StringVar(&keygen, "keygen", "", "generate new X25519 identity, write it to given file and print its recipient")
+	flag.StringVar(&opts.RecipientAdd, "recipient-add", "", "add given recipient (ecmpub-...) to the vault")
+	flag.StringVar(&opts.RecipientRm, "recipient-rm", "", "remove given recipient from the vault")
+	flag.BoolVar(&opts.Recipients, "recipients", false, "list vault recipients")
+	flag.StringVar(&opts.Pattern, "pat", "", "search vault records by query, e.g. 'kind:login tag:work url:github sort:name', plain words are searched in names, tags and URLs")
+	flag.BoolVar(&opts.Info, "info", false, "show vault info")
 	var version bool
 	flag.BoolVar(&version, "version", false, "show version")
-	var edit string
-	flag.StringVar(&edit, "edit", "", "edit record with given ID")
-	var add string
-	flag.StringVar(&add, "add", "", "add new record (login|card|note|json|file)")
-	var rid string
-	flag.StringVar(&rid, "rid", "", "show record with given ID and copy its password to clipboard")
+	flag.StringVar(&opts.Edit, "edit", "", "edit record with given ID")
+	flag.StringVar(&opts.Add, "add", "", fmt.Sprintf("add new record of given kind (%s)", strings.Join(vt.Kinds(), "|")))
+	flag.StringVar(&opts.RecordID, "rid", "", "show record with given ID and copy its password to clipboard")
+	flag.StringVar(&opts.Split, "split", "", "split vault data key into N secret shares with threshold K, e.g. 5:3, shares are written as text and QR images to -share-dir")
+	flag.StringVar(&opts.ShareDir, "share-dir", ".", "directory of secret shares written by -split")
+	flag.StringVar(&opts.RecoverShares, "recover", "", "recover vault data key from comma separated list of secret share files and set new vault password")
+	flag.StringVar(&opts.OTP, "otp", "", "copy current TOTP code of record with given ID to clipboard")
+	flag.StringVar(&opts.History, "history", "", "show versions of record with given ID along with their changes")
+	flag.StringVar(&opts.Restore, "restore", "", "restore given version of the record, e.g. rid:version, versions are listed by -history")
+	flag.StringVar(&opts.Delete, "delete", "", "move record with given ID to the trash")
+	flag.StringVar(&opts.Untrash, "untrash", "", "restore record with given ID from the trash")
+	flag.BoolVar(&opts.Trash, "trash", false, "list deleted records kept in the trash")
+	flag.StringVar(&opts.Attach, "attach", "", "attach given file to the record, e.g. rid:file")
+	flag.StringVar(&opts.Extract, "extract", "", "write content of record attachment to stdout, e.g. rid:name")
+	flag.StringVar(&opts.Detach, "detach", "", "remove attachment from the record, e.g. rid:name, its content is removed by -gc")
+	flag.StringVar(&opts.Folder, "folder", "", "list records of given folder and its sub-folders, e.g. work/servers, use it with -pat to search within the folder")
+	flag.StringVar(&opts.Tag, "tag", "", "list records with given tag, use it with -pat or -folder to search records with the tag")
+	flag.BoolVar(&opts.Tags, "tags", false, "list vault tags along with number of records which have them")
+	flag.StringVar(&opts.TagRename, "tag-rename", "", "rename tag in all vault records, e.g. old:new")
+	flag.StringVar(&opts.TagMerge, "tag-merge", "", "merge tags into single tag in all vault records, e.g. tag1,tag2:tag")
+	flag.StringVar(&opts.TagDelete, "tag-delete", "", "remove given tag from all vault records")
+	flag.StringVar(&opts.Audit, "audit", "", "audit vault passwords and print report in given format, table or json")
+	var auditDays int
+	flag.IntVar(&auditDays, "audit-days", 365, "number of days after which password is reported as stale by -audit")
+	flag.StringVar(&opts.HIBP, "hibp", "", "check vault passwords against local Have I Been Pwned dataset of SHA-1 hashes, either sorted hash file or directory of hash range files")
+	flag.BoolVar(&opts.GC, "gc", false, "remove attachment blobs which are not used by vault records, their versions or deleted records")
+	var trashDays int
+	flag.IntVar(&trashDays, "trash-days", int(vt.DefaultTrashRetention/(24*time.Hour)), "number of days deleted records are kept in the trash, negative value keeps them forever, zero is not allowed")
 	var gen string
-	flag.StringVar(&gen, "gen", "", "generate password with given length:attributes. Attributes can be 'n' (numbers), s' (symbols) or their combinations), e.g. 16:ns will provide password of length 16 with numbers and symbols in it")
-	var sync string
-	flag.StringVar(&sync, "sync", "", "sync vault to provided URI, e.g. file:///path, dropbox:///path, googledrive:///path, ssh:///path")
-	var verbose int
-	flag.IntVar(&verbose, "verbose", 0, "verbose level")
+	flag.StringVar(&gen, "gen", "", "generate password with given length:attributes. Attributes can be 'n' (numbers), 's' (symbols), 'u' (upper case), 'l' (lower case) followed by optional minimum count, and 'a' (exclude ambiguous characters), e.g. 16:n2sa will provide password of length 16 with at least two numbers, symbols and without ambiguous characters. Use words:N to generate diceware passphrase of N words")
+	var alphabet string
+	flag.StringVar(&alphabet, "alphabet", "", "custom alphabet of generated password")
+	var maxLength int
+	flag.IntVar(&maxLength, "maxlen", 0, "maximum length of generated password")
+	flag.StringVar(&opts.Sync, "sync", "", "sync vault to provided URI, e.g. file:///path, dropbox:///path, googledrive:///path, ssh:///path")
+	flag.IntVar(&opts.Verbose, "verbose", 0, "verbose level")
 	var examples bool
 	flag.BoolVar(&examples, "examples", false, "show examples")
 	flag.Parse()
@@ -71,30 +104,70 @@ func main() {
 
 	// generate password if asked
 	if gen != "" {
-		arr := strings.Split(gen, ":")
-		i, e := strconv.Atoi(arr[0])
-		if e != nil {
-			log.Fatal(e)
+		password, entropy, err := generatePassword(gen, alphabet, maxLength)
+		if err != nil {
+			log.Fatal(err)
 		}
-		var numbers, symbols bool
-		if strings.Contains(gen, "n") {
-			numbers = true
+		fmt.Println("New password: ", password)
+		fmt.Printf("Entropy: %.1f bits\n", entropy)
+		os.Exit(0)
+	}
+
+	// generate new identity if asked
+	if keygen != "" {
+		id, err := crypt.GenerateIdentity()
+		if err != nil {
+			log.Fatal(err)
 		}
-		if strings.Contains(gen, "s") {
-			symbols = true
+		err = id.WriteIdentity(keygen)
+		if err != nil {
+			log.Fatal(err)
 		}
-		p := crypt.CreatePassword(i, numbers, symbols)
-		fmt.Println("New password: ", p)
+		fmt.Println("Public key: ", id.Recipient().String())
+		os.Exit(0)
+	}
+
+	// generate new key file if asked
+	if keyFileGen != "" {
+		err := crypt.GenerateKeyFile(keyFileGen)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("New key file: ", keyFileGen)
 		os.Exit(0)
 	}
 
 	// use file name in a log
-	if verbose > 0 {
+	if opts.Verbose > 0 {
 		log.SetFlags(log.LstdFlags | log.Lshortfile)
 	}
 
 	// initialize our vault
-	vault := vt.Vault{Cipher: crypt.GetCipher(cipher), Verbose: verbose, Start: time.Now()}
+	vault := vt.Vault{Cipher: crypt.GetCipher(cipher), KeyFile: keyFile, Verbose: opts.Verbose, Start: time.Now()}
+	// zero retention of the vault means default one, therefore it is not
+	// accepted instead of silently keeping deleted records for default period
+	if trashDays == 0 {
+		log.Fatal("-trash-days should not be zero, use negative value to keep deleted records forever")
+	}
+	vault.TrashRetention = time.Duration(trashDays) * 24 * time.Hour
+	if trashDays < 0 {
+		vault.TrashRetention = -1
+	}
+	vault.RotationPeriod = time.Duration(auditDays) * 24 * time.Hour
+	if kdf != "" {
+		vkdf, err := crypt.ParseKDF(kdf)
+		if err != nil {
+			log.Fatal(err)
+		}
+		vault.KDF = vkdf
+	}
+	if identity != "" {
+		id, err := crypt.ReadIdentity(identity)
+		if err != nil {
+			log.Fatal(err)
+		}
+		vault.Identity = id
+	}
 	if vname == "" {
 		// by default vault is located at $HOME/.ecm
 		udir, err := os.UserHomeDir()
@@ -115,20 +188,7 @@ func main() {
 		ecmExamples()
 		os.Exit(0)
 	}
-	cli(
-		&vault,
-		efile,
-		dfile,
-		add,
-		pat,
-		rid,
-		edit,
-		pcopy,
-		export,
-		vimport,
-		sync,
-		recreate,
-		info,
-		verbose,
-	)
+	cli(&vault, opts)
+	// wipe vault secret and decrypted records
+	vault.Lock()
 }
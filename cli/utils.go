@@ -4,41 +4,45 @@ import (
 	"bufio"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/atotto/clipboard"
 	"github.com/vkuznet/ecm/crypt"
-	utils "github.com/vkuznet/ecm/utils"
 	vt "github.com/vkuznet/ecm/vault"
 	// clone of "code.google.com/p/rsc/qr" which no longer available
 	// "github.com/vkuznet/rsc/qr"
 	// imaging library
+	qr "rsc.io/qr"
 )
 
 const (
 	separator = "---\n" // used in ecm data format
 )
 
-// helper function to extract cipher name from file extension
-func fileCipher(fname string) string {
-	arr := strings.Split(fname, ".")
-	cipher := strings.Split(arr[len(arr)-1], "-")[0]
-	if !utils.InList(cipher, crypt.SupportedCiphers) {
-		log.Fatalf("given cipher %s is not supported, please use one from the following %v", cipher, crypt.SupportedCiphers)
+// helper function to report vault records which can not be read
+func warnRecords(err error) {
+	for _, e := range vt.RecordFailures(err) {
+		log.Printf("WARNING: unable to read vault record %s, error %v", e.File, e.Err)
 	}
-	return cipher
 }
 
 // helper function to decrypt given input (file or stdin)
 func decryptInput(fname, password, cipher, write, attr string) {
 	var err error
-	if cipher == "" {
-		cipher = fileCipher(fname)
-	}
-	if !utils.InList(cipher, crypt.SupportedCiphers) {
-		log.Fatalf("given cipher %s is not supported, please use one from the following %v", cipher, crypt.SupportedCiphers)
+	// cipher is taken from encrypted data header, given one is only used
+	// for legacy data without header
+	cipher = crypt.GetCipher(cipher)
+	// encrypted vault blobs are decrypted as streams in constant memory
+	if fname != "-" && attr == "" && write != "clipboard" {
+		if decryptStream(fname, password, write) {
+			return
+		}
 	}
 	var data []byte
 	if fname == "-" { // stdin
@@ -52,7 +56,7 @@ func decryptInput(fname, password, cipher, write, attr string) {
 	if err != nil {
 		panic(err)
 	}
-	data, err = crypt.Decrypt(data, password, cipher)
+	data, err = decryptData(fname, data, password, cipher)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -62,11 +66,10 @@ func decryptInput(fname, password, cipher, write, attr string) {
 		if err != nil {
 			log.Fatal("unable to unarmashal vault record", err)
 		}
-		val, ok := rec.Map[attr]
-		if ok {
-			data = []byte(val)
+		if _, ok := rec.Map[attr]; ok {
+			data = rec.Secret(attr)
 		} else {
-			log.Fatalf("unable to extract attribute '%s' from the record %+v", attr, rec)
+			log.Fatalf("unable to extract attribute '%s' from the record %s", attr, rec.ID)
 		}
 	}
 	if write == "stdout" {
@@ -82,3 +85,337 @@ func decryptInput(fname, password, cipher, write, attr string) {
 		}
 	}
 }
+
+// helper function to decrypt given data, vault records are decrypted with
+// vault key if given file belongs to a vault, otherwise legacy key is used
+func decryptData(fname string, data []byte, password, cipher string) ([]byte, error) {
+	if fname != "-" {
+		if meta, err := vt.FindMeta(fname); err == nil {
+			return meta.DecryptRecord(filepath.Base(fname), data, []byte(password), cipher)
+		}
+	}
+	return crypt.Decrypt(data, password, cipher)
+}
+
+// helper function to decrypt encrypted stream from given file and write it
+// either to stdout or to output file, it returns false if given file is not
+// an encrypted stream
+func decryptStream(fname, password, write string) bool {
+	file, err := os.Open(fname)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	magic, _ := reader.Peek(len(crypt.StreamMagic))
+	if !crypt.IsStream(magic) {
+		return false
+	}
+	meta, err := vt.FindMeta(fname)
+	if err != nil {
+		log.Fatal("unable to read vault meta-data of encrypted file, error ", err)
+	}
+	key, err := meta.DataKey([]byte(password))
+	if err != nil {
+		log.Fatal(err)
+	}
+	stream, err := crypt.NewReader(reader, key)
+	if err != nil {
+		log.Fatal(err)
+	}
+	out := os.Stdout
+	if write != "stdout" {
+		out, err = os.OpenFile(write, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0755)
+		if err != nil {
+			log.Fatal("unable to create output file", err)
+		}
+		defer out.Close()
+	}
+	if _, err := io.Copy(out, stream); err != nil {
+		log.Fatal("unable to decrypt file, error ", err)
+	}
+	return true
+}
+
+// helper function to generate password or diceware passphrase according to
+// given specification, see -gen flag
+func generatePassword(spec, alphabet string, maxLength int) (string, float64, error) {
+	if strings.HasPrefix(spec, "words") {
+		words := 6
+		if arr := strings.Split(spec, ":"); len(arr) > 1 {
+			val, err := strconv.Atoi(arr[1])
+			if err != nil {
+				return "", 0, err
+			}
+			words = val
+		}
+		return crypt.Passphrase(words, "-")
+	}
+	policy, err := crypt.ParsePasswordPolicy(spec)
+	if err != nil {
+		return "", 0, err
+	}
+	policy.Alphabet = alphabet
+	policy.MaxLength = maxLength
+	return policy.Generate()
+}
+
+// helper function to split vault data key into secret shares according to
+// given N:K specification, every share is written to given directory as
+// text file and QR image
+func splitVaultKey(vault *vt.Vault, spec, dir string) error {
+	arr := strings.Split(spec, ":")
+	if len(arr) != 2 {
+		return fmt.Errorf("invalid split specification '%s', should be N:K", spec)
+	}
+	n, err := strconv.Atoi(arr[0])
+	if err != nil {
+		return err
+	}
+	k, err := strconv.Atoi(arr[1])
+	if err != nil {
+		return err
+	}
+	shares, err := vault.SplitKey(n, k)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+	for _, share := range shares {
+		fname := filepath.Join(dir, fmt.Sprintf("ecm-share-%d.txt", share.Index))
+		err := os.WriteFile(fname, []byte(share.String()+"\n"), 0600)
+		if err != nil {
+			return err
+		}
+		qname := filepath.Join(dir, fmt.Sprintf("ecm-share-%d.png", share.Index))
+		err = generateQRImage(share.String(), qname)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Share %d of %d (threshold %d): %s\n", share.Index, n, k, fname)
+		fmt.Println(share.String())
+	}
+	return nil
+}
+
+// helper function to issue new recovery shares with given number of shares
+// and threshold if old shares were invalidated by vault key rotation
+func reissueShares(vault *vt.Vault, n, k int, dir string) error {
+	if n == 0 {
+		return nil
+	}
+	if shares, _ := vault.RecoveryShares(); shares > 0 {
+		return nil
+	}
+	fmt.Printf("Old recovery shares can not recover the vault anymore, new shares are written to %s\n", dir)
+	return splitVaultKey(vault, fmt.Sprintf("%d:%d", n, k), dir)
+}
+
+// helper function to read secret shares from comma separated list of files
+func readShares(files string) ([]*crypt.Share, error) {
+	var shares []*crypt.Share
+	for _, fname := range strings.Split(files, ",") {
+		data, err := os.ReadFile(strings.TrimSpace(fname))
+		if err != nil {
+			return nil, err
+		}
+		share, err := crypt.ParseShare(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse secret share %s, error %v", fname, err)
+		}
+		shares = append(shares, share)
+	}
+	return shares, nil
+}
+
+// helper function to generate QR image file, see generateQRImage of ecm server
+func generateQRImage(text, fname string) error {
+	// qr.H = 65% redundant level
+	code, err := qr.Encode(text, qr.H)
+	if err != nil {
+		log.Println("unable to encode QR code", err)
+		return err
+	}
+	return os.WriteFile(fname, code.PNG(), 0600)
+}
+
+// helper function to print history of given record, every version is
+// followed by its changes with respect to the preceding version
+func printHistory(vault *vt.Vault, rid string) error {
+	versions, err := vault.History(rid)
+	if err != nil {
+		return err
+	}
+	for i, ver := range versions {
+		fmt.Printf("%s %s\n", ver.Version, ver.Time.Local().Format(time.RFC3339))
+		if i == len(versions)-1 {
+			continue
+		}
+		diff, err := vault.Diff(rid, versions[i+1].Version, ver.Version)
+		if err != nil {
+			fmt.Printf("    unable to compare versions, error %v\n", err)
+			continue
+		}
+		for _, d := range diff {
+			fmt.Printf("    %s\n", d.String())
+		}
+	}
+	return nil
+}
+
+// helper function to restore record version according to given rid:version
+// specification
+func restoreVersion(vault *vt.Vault, spec string) error {
+	arr := strings.Split(spec, ":")
+	if len(arr) != 2 {
+		return fmt.Errorf("invalid restore specification '%s', should be rid:version", spec)
+	}
+	err := vault.Restore(arr[0], arr[1])
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Record %s is restored to version %s\n", arr[0], arr[1])
+	return nil
+}
+
+// helper function to print deleted records of the vault
+func printTrash(vault *vt.Vault) error {
+	records, err := vault.Trash()
+	if err != nil {
+		return err
+	}
+	for _, r := range records {
+		name := ""
+		if rec, err := vault.TrashedRecord(r.ID); err == nil {
+			name = rec.Map["Name"]
+		}
+		expires := "never"
+		if !r.Expires.IsZero() {
+			expires = r.Expires.Local().Format(time.RFC3339)
+		}
+		fmt.Printf("%s %s deleted %s, purged %s\n", r.ID, name, r.Deleted.Local().Format(time.RFC3339), expires)
+	}
+	return nil
+}
+
+// helper function to split attachment specification into record ID and
+// file or attachment name
+func attachmentSpec(spec, name string) (string, string, error) {
+	arr := strings.SplitN(spec, ":", 2)
+	if len(arr) != 2 || arr[0] == "" || arr[1] == "" {
+		return "", "", fmt.Errorf("invalid attachment specification '%s', should be rid:%s", spec, name)
+	}
+	return arr[0], arr[1], nil
+}
+
+// helper function to attach file to vault record
+func attachFile(vault *vt.Vault, spec string) error {
+	rid, fname, err := attachmentSpec(spec, "file")
+	if err != nil {
+		return err
+	}
+	att, err := vault.AddAttachment(rid, fname)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("File %s is attached to record %s as %s\n", fname, rid, att.Name)
+	return nil
+}
+
+// helper function to write content of record attachment to stdout
+func extractAttachment(vault *vt.Vault, spec string) error {
+	rid, name, err := attachmentSpec(spec, "name")
+	if err != nil {
+		return err
+	}
+	return vault.ExtractAttachment(rid, name, os.Stdout)
+}
+
+// helper function to remove attachment from vault record
+func detachFile(vault *vt.Vault, spec string) error {
+	rid, name, err := attachmentSpec(spec, "name")
+	if err != nil {
+		return err
+	}
+	err = vault.RemoveAttachment(rid, name)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Attachment %s is removed from record %s\n", name, rid)
+	return nil
+}
+
+// helper function to list vault tags along with number of their records
+func listTags(vault *vt.Vault) error {
+	tags, err := vault.Tags()
+	if err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		fmt.Printf("%-30s %d\n", tag.Name, tag.Count)
+	}
+	return nil
+}
+
+// helper function to rename, merge or delete tags in vault records
+func changeTags(vault *vt.Vault, tagRename, tagMerge, tagDelete string) error {
+	var count int
+	var err error
+	if tagRename != "" {
+		arr := strings.SplitN(tagRename, ":", 2)
+		if len(arr) != 2 || arr[0] == "" || arr[1] == "" {
+			return fmt.Errorf("invalid tag rename specification '%s', should be old:new", tagRename)
+		}
+		count, err = vault.RenameTag(arr[0], arr[1])
+	} else if tagMerge != "" {
+		idx := strings.LastIndex(tagMerge, ":")
+		if idx <= 0 || idx == len(tagMerge)-1 {
+			return fmt.Errorf("invalid tag merge specification '%s', should be tag1,tag2:tag", tagMerge)
+		}
+		count, err = vault.MergeTags(strings.Split(tagMerge[:idx], ","), tagMerge[idx+1:])
+	} else {
+		count, err = vault.DeleteTag(tagDelete)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Tags of %d vault records are changed\n", count)
+	return nil
+}
+
+// helper function to audit vault passwords and print audit report in given
+// format, table or json
+func auditVault(vault *vt.Vault, format string) error {
+	report := vault.Audit()
+	switch strings.ToLower(format) {
+	case "table":
+		vt.TabularAuditPrint(report)
+	case "json":
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+	default:
+		return fmt.Errorf("unsupported audit format '%s', should be table or json", format)
+	}
+	return nil
+}
+
+// helper function to check vault passwords against local HIBP dataset
+func checkBreaches(vault *vt.Vault, path string) error {
+	db, err := vt.OpenBreachDB(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	breaches, err := vault.Breaches(db)
+	if err != nil {
+		return err
+	}
+	vt.TabularBreachPrint(breaches)
+	return nil
+}
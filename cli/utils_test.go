@@ -1,10 +1,13 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/atotto/clipboard"
@@ -101,3 +104,109 @@ func TestDecryptInputToClipboard(t *testing.T) {
 	}
 
 }
+
+// TestDecryptInputStream function
+func TestDecryptInputStream(t *testing.T) {
+	password := "test"
+	data := bytes.Repeat([]byte("test data "), 2*crypt.ChunkSize/10)
+	vdir, err := os.MkdirTemp(os.TempDir(), "vault-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(vdir)
+	vault := vt.Vault{Directory: vdir, Cipher: "aes"}
+	vault.SetSecret([]byte(password))
+	err = vault.Create(vdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, err := vault.AddRecord("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = vault.Attach(rec.ID, "data", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	blobs, err := vault.Blobs()
+	if err != nil || len(blobs) != 1 {
+		t.Fatalf("wrong vault blobs %v, error %v", blobs, err)
+	}
+	// create output file
+	outTmpFile, err := ioutil.TempFile(os.TempDir(), "output-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(outTmpFile.Name())
+	fname := filepath.Join(vdir, vt.BlobsDir, blobs[0])
+	decryptInput(fname, password, "", outTmpFile.Name(), "")
+	res, err := os.ReadFile(outTmpFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(res, data) {
+		t.Errorf("wrong decrypted stream written to out file, %d bytes instead of %d", len(res), len(data))
+	}
+}
+
+// TestGeneratePassword function
+func TestGeneratePassword(t *testing.T) {
+	password, _, err := generatePassword("16:n2s", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(password) != 16 {
+		t.Errorf("wrong password length %d", len(password))
+	}
+	if _, _, err := generatePassword("32:ns", "", 16); err == nil {
+		t.Error("password exceeds maximum length")
+	}
+	passphrase, entropy, err := generatePassword("words:4", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(strings.Split(passphrase, "-")) != 4 || entropy <= 0 {
+		t.Errorf("wrong passphrase %s with entropy %v", passphrase, entropy)
+	}
+}
+
+// TestSplitVaultKey function
+func TestSplitVaultKey(t *testing.T) {
+	vdir, err := os.MkdirTemp("", "vault")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(vdir)
+	sdir, err := os.MkdirTemp("", "shares")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(sdir)
+
+	vault := vt.Vault{Directory: vdir, Cipher: "aes"}
+	vault.SetSecret([]byte("test"))
+	if err := vault.Create(vdir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vault.AddRecord("login"); err != nil {
+		t.Fatal(err)
+	}
+	err = splitVaultKey(&vault, "3:2", sdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"ecm-share-1.txt", "ecm-share-3.txt", "ecm-share-2.png"} {
+		if _, err := os.Stat(filepath.Join(sdir, name)); err != nil {
+			t.Errorf("secret share file %s is not written", name)
+		}
+	}
+	files := filepath.Join(sdir, "ecm-share-1.txt") + "," + filepath.Join(sdir, "ecm-share-3.txt")
+	shares, err := readShares(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lost := vt.Vault{Directory: vdir, Cipher: "aes"}
+	if err := lost.RecoverKey(shares); err != nil {
+		t.Errorf("unable to recover vault key, error %v", err)
+	}
+}
@@ -6,45 +6,57 @@ package crypt
 // https://github.com/kisom/gocrypto
 
 import (
+	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/md5"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
-	"log"
-	"strings"
 
-	utils "github.com/vkuznet/ecm/utils"
+	"golang.org/x/crypto/hkdf"
 	"golang.org/x/crypto/nacl/secretbox"
 )
 
-// CreateHash creates a hash for given key
+// CreateHash creates a hash for given key. It is unsalted MD5 hash which
+// is only used by legacy key derivation, see LegacyKey
 func CreateHash(key string) string {
 	hasher := md5.New()
 	hasher.Write([]byte(key))
 	return hex.EncodeToString(hasher.Sum(nil))
 }
 
-// SupportedCiphers provides list of supported ciphers
-var SupportedCiphers = []string{"aes", "nacl"}
-
-// Cipher defines cipher interface
-type Cipher interface {
-	Encript(data []byte, key string) ([]byte, error)
-	Decript(data []byte, key string) ([]byte, error)
+// register ciphers provided by this module
+func init() {
+	RegisterCipher("aes", 1, &CipherAES{})
+	RegisterCipher("nacl", 2, &CipherNaCl{})
 }
 
 // CipherAES represents AES Cipher
 type CipherAES struct {
 }
 
-// Encrypt implementation for AES cipher
-func (c *CipherAES) Encrypt(data []byte, passphrase string) ([]byte, error) {
-	block, _ := aes.NewCipher([]byte(CreateHash(passphrase)))
-	gcm, err := cipher.NewGCM(block)
+// AEAD provides AES-GCM AEAD primitive for given encryption key
+func (c *CipherAES) AEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Encrypt implementation for AES cipher using given encryption key
+func (c *CipherAES) Encrypt(data, key []byte) ([]byte, error) {
+	return c.EncryptAD(data, key, nil)
+}
+
+// EncryptAD implementation for AES cipher using given encryption key and
+// associated data
+func (c *CipherAES) EncryptAD(data, key, ad []byte) ([]byte, error) {
+	gcm, err := c.AEAD(key)
 	if err != nil {
 		return []byte{}, err
 	}
@@ -52,24 +64,28 @@ func (c *CipherAES) Encrypt(data []byte, passphrase string) ([]byte, error) {
 	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
 		return []byte{}, err
 	}
-	ciphertext := gcm.Seal(nonce, nonce, data, nil)
+	ciphertext := gcm.Seal(nonce, nonce, data, ad)
 	return ciphertext, nil
 }
 
-// Decrypt implementation for AES Cipher
-func (c *CipherAES) Decrypt(data []byte, passphrase string) ([]byte, error) {
-	key := []byte(CreateHash(passphrase))
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		return []byte{}, err
-	}
-	gcm, err := cipher.NewGCM(block)
+// Decrypt implementation for AES cipher using given encryption key
+func (c *CipherAES) Decrypt(data, key []byte) ([]byte, error) {
+	return c.DecryptAD(data, key, nil)
+}
+
+// DecryptAD implementation for AES cipher using given encryption key and
+// associated data
+func (c *CipherAES) DecryptAD(data, key, ad []byte) ([]byte, error) {
+	gcm, err := c.AEAD(key)
 	if err != nil {
 		return []byte{}, err
 	}
 	nonceSize := gcm.NonceSize()
+	if len(data) < nonceSize+gcm.Overhead() {
+		return []byte{}, ErrDecrypt
+	}
 	nonce, ciphertext := data[:nonceSize], data[nonceSize:]
-	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, ad)
 	if err != nil {
 		return []byte{}, err
 	}
@@ -84,7 +100,8 @@ const (
 )
 
 // GenerateKey creates a new secret key either randomly if input key is
-// not provided or via key hash
+// not provided or via legacy key hash. It is kept for compatibility with
+// existing NaCl records, new keys should be derived via KDF
 func GenerateKey(passphrase string) (*[KeySize]byte, error) {
 	key := new([KeySize]byte)
 	if passphrase != "" {
@@ -127,12 +144,13 @@ var (
 type CipherNaCl struct {
 }
 
-// Encrypt implementation of NaCl cipher
-func (c *CipherNaCl) Encrypt(data []byte, passphrase string) ([]byte, error) {
-	key, err := GenerateKey(passphrase)
-	if err != nil {
-		return []byte{}, err
+// Encrypt implementation of NaCl cipher using given encryption key
+func (c *CipherNaCl) Encrypt(data, key []byte) ([]byte, error) {
+	if len(key) != KeySize {
+		return nil, ErrEncrypt
 	}
+	var skey [KeySize]byte
+	copy(skey[:], key)
 	nonce, err := GenerateNonce()
 	if err != nil {
 		return nil, ErrEncrypt
@@ -140,16 +158,57 @@ func (c *CipherNaCl) Encrypt(data []byte, passphrase string) ([]byte, error) {
 
 	out := make([]byte, len(nonce))
 	copy(out, nonce[:])
-	out = secretbox.Seal(out, data, nonce, key)
+	out = secretbox.Seal(out, data, nonce, &skey)
 	return out, nil
 }
 
-// Decrypt implementation of NaCl cipher
-func (c *CipherNaCl) Decrypt(data []byte, passphrase string) ([]byte, error) {
-	key, err := GenerateKey(passphrase)
+// EncryptAD implementation of NaCl cipher using given encryption key and
+// associated data. The secretbox does not support associated data, therefore
+// data is encrypted with the key derived from given key and associated data
+func (c *CipherNaCl) EncryptAD(data, key, ad []byte) ([]byte, error) {
+	if len(ad) == 0 {
+		return c.Encrypt(data, key)
+	}
+	skey, err := naclKey(key, ad)
 	if err != nil {
-		return []byte{}, err
+		return nil, ErrEncrypt
+	}
+	return c.Encrypt(data, skey)
+}
+
+// DecryptAD implementation of NaCl cipher using given encryption key and
+// associated data
+func (c *CipherNaCl) DecryptAD(data, key, ad []byte) ([]byte, error) {
+	if len(ad) == 0 {
+		return c.Decrypt(data, key)
+	}
+	skey, err := naclKey(key, ad)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return c.Decrypt(data, skey)
+}
+
+// helper function to derive NaCl key bound to given associated data
+func naclKey(key, ad []byte) ([]byte, error) {
+	if len(key) != KeySize {
+		return nil, ErrEncrypt
+	}
+	skey := make([]byte, KeySize)
+	kdf := hkdf.New(sha256.New, key, nil, append([]byte("ecm nacl "), ad...))
+	if _, err := io.ReadFull(kdf, skey); err != nil {
+		return nil, err
 	}
+	return skey, nil
+}
+
+// Decrypt implementation of NaCl cipher using given encryption key
+func (c *CipherNaCl) Decrypt(data, key []byte) ([]byte, error) {
+	if len(key) != KeySize {
+		return nil, ErrDecrypt
+	}
+	var skey [KeySize]byte
+	copy(skey[:], key)
 	if len(data) < (NonceSize + secretbox.Overhead) {
 		//         log.Println("message length is less than nonce size+overhead")
 		return nil, ErrDecrypt
@@ -157,7 +216,7 @@ func (c *CipherNaCl) Decrypt(data []byte, passphrase string) ([]byte, error) {
 
 	var nonce [NonceSize]byte
 	copy(nonce[:], data[:NonceSize])
-	out, ok := secretbox.Open(nil, data[NonceSize:], &nonce, key)
+	out, ok := secretbox.Open(nil, data[NonceSize:], &nonce, &skey)
 	if !ok {
 		//         log.Println("fail to open secret box")
 		return nil, ErrDecrypt
@@ -166,43 +225,163 @@ func (c *CipherNaCl) Decrypt(data []byte, passphrase string) ([]byte, error) {
 	return out, nil
 }
 
-// Encrypt wrapper function to encrypt given binary data blob using given passphrase and cipher
+// Encrypt wrapper function to encrypt given binary data blob using given
+// passphrase and cipher. The encryption key is derived via default key
+// derivation function with new random salt of every blob and encrypted blob
+// is prefixed with the header describing used cipher and key derivation
+// parameters
 func Encrypt(data []byte, passphrase, cipher string) ([]byte, error) {
-	if strings.ToLower(cipher) == "nacl" {
-		c := CipherNaCl{}
-		return c.Encrypt(data, passphrase)
-	} else if strings.ToLower(cipher) == "aes" {
-		c := CipherAES{}
-		return c.Encrypt(data, passphrase)
+	kdf, err := NewKDF("")
+	if err != nil {
+		return []byte{}, err
 	}
-	msg := fmt.Sprintf("unsupported cipher %s", cipher)
-	return []byte{}, errors.New(msg)
+	return EncryptWithKDF(data, passphrase, cipher, kdf)
 }
 
-// Decrypt wrapper function to decrypt given binary data blob using given passphrase and cipher
+// EncryptWithKDF wrapper function to encrypt given binary data blob using
+// key derived from given passphrase via given key derivation function
+func EncryptWithKDF(data []byte, passphrase, cipher string, kdf *KDF) ([]byte, error) {
+	if kdf == nil {
+		return []byte{}, errors.New("key derivation function is not provided")
+	}
+	header, err := NewHeader(cipher, kdf)
+	if err != nil {
+		return []byte{}, err
+	}
+	key, err := kdf.Key([]byte(passphrase))
+	if err != nil {
+		return []byte{}, err
+	}
+	return seal(data, key, header, nil)
+}
+
+// Decrypt wrapper function to decrypt given binary data blob using given
+// passphrase. The cipher and key derivation function are taken from the
+// blob header, while given cipher is only used by legacy blobs without header
 func Decrypt(data []byte, passphrase, cipher string) ([]byte, error) {
-	if strings.ToLower(cipher) == "nacl" {
-		c := CipherNaCl{}
-		return c.Decrypt(data, passphrase)
-	} else if strings.ToLower(cipher) == "aes" {
-		c := CipherAES{}
-		return c.Decrypt(data, passphrase)
-	}
-	msg := fmt.Sprintf("unsupported cipher %s", cipher)
-	return []byte{}, errors.New(msg)
-}
-
-// GetCipher returns either default or given cipher
-func GetCipher(cipher string) string {
-	if cipher == "" {
-		cipher = SupportedCiphers[0]
-	}
-	if !utils.InList(cipher, SupportedCiphers) {
-		log.Fatalf(
-			"given cipher %s is not supported, please use one from the following %v",
-			cipher,
-			SupportedCiphers,
-		)
-	}
-	return strings.ToLower(cipher)
+	return decryptPassphrase(data, []byte(passphrase), cipher, nil)
+}
+
+// helper function to decrypt given data using given passphrase, the key
+// derived from the passphrase is kept in given key cache
+func decryptPassphrase(data, passphrase []byte, cipher string, cache *KeyCache) ([]byte, error) {
+	header, body, err := ParseHeader(data)
+	if err == ErrNoHeader {
+		return decryptKey(data, LegacyKey(passphrase), cipher)
+	}
+	if err != nil {
+		return []byte{}, err
+	}
+	if header.KDF == nil {
+		return []byte{}, errors.New("encrypted data requires key and can not be decrypted with passphrase")
+	}
+	key, err := cache.Key(header.KDF, passphrase)
+	if err != nil {
+		return []byte{}, err
+	}
+	return open(body, key, header, nil)
+}
+
+// EncryptWithKey wrapper function to encrypt given binary data blob using
+// given key and cipher, the encrypted blob is prefixed with the header
+func EncryptWithKey(data, key []byte, cipher string) ([]byte, error) {
+	return EncryptWithKeyAD(data, key, cipher, nil)
+}
+
+// EncryptWithKeyAD wrapper function to encrypt given binary data blob using
+// given key and cipher and authenticate it along with given associated data,
+// e.g. the name of the record, such that the blob can not be used in place of
+// another one
+func EncryptWithKeyAD(data, key []byte, cipher string, ad []byte) ([]byte, error) {
+	header, err := NewHeader(cipher, nil)
+	if err != nil {
+		return []byte{}, err
+	}
+	return seal(data, key, header, ad)
+}
+
+// DecryptWithKey wrapper function to decrypt given binary data blob using
+// given key. The cipher is taken from the blob header, while given cipher is
+// only used by legacy blobs without header. Encrypted streams are decrypted
+// in memory, use NewReader to decrypt large streams
+func DecryptWithKey(data, key []byte, cipher string) ([]byte, error) {
+	return DecryptWithKeyAD(data, key, cipher, nil)
+}
+
+// DecryptWithKeyAD wrapper function to decrypt given binary data blob using
+// given key and associated data. Blobs of format versions prior to
+// HeaderVersionAD do not authenticate associated data, and it is ignored
+func DecryptWithKeyAD(data, key []byte, cipher string, ad []byte) ([]byte, error) {
+	header, body, err := ParseHeader(data)
+	if err == ErrNoHeader {
+		return decryptKey(data, key, cipher)
+	}
+	if err != nil {
+		return []byte{}, err
+	}
+	if header.Stream {
+		reader, err := NewReader(bytes.NewReader(data), key)
+		if err != nil {
+			return []byte{}, err
+		}
+		return io.ReadAll(reader)
+	}
+	return open(body, key, header, ad)
+}
+
+// helper function to encrypt data with given key and prefix it with header,
+// the header is authenticated along with given associated data
+func seal(data, key []byte, header *Header, ad []byte) ([]byte, error) {
+	out, err := header.Marshal()
+	if err != nil {
+		return []byte{}, err
+	}
+	c, err := adCipher(header.Cipher)
+	if err != nil {
+		return []byte{}, err
+	}
+	aad := append(append([]byte{}, out...), ad...)
+	edata, err := c.EncryptAD(data, key, aad)
+	if err != nil {
+		return []byte{}, err
+	}
+	return append(out, edata...), nil
+}
+
+// helper function to decrypt payload of encrypted blob with given header
+func open(body, key []byte, header *Header, ad []byte) ([]byte, error) {
+	if header.Version < HeaderVersionAD {
+		return decryptKey(body, key, header.Cipher)
+	}
+	hdata, err := header.Marshal()
+	if err != nil {
+		return []byte{}, err
+	}
+	c, err := adCipher(header.Cipher)
+	if err != nil {
+		return []byte{}, err
+	}
+	return c.DecryptAD(body, key, append(hdata, ad...))
+}
+
+// helper function to get cipher which supports associated data
+func adCipher(cipher string) (ADCipher, error) {
+	c, err := getCipher(cipher)
+	if err != nil {
+		return nil, err
+	}
+	ac, ok := c.(ADCipher)
+	if !ok {
+		return nil, fmt.Errorf("cipher %s does not support associated data", cipher)
+	}
+	return ac, nil
+}
+
+// helper function to decrypt data with given key and cipher
+func decryptKey(data, key []byte, cipher string) ([]byte, error) {
+	c, err := getCipher(cipher)
+	if err != nil {
+		return []byte{}, err
+	}
+	return c.Decrypt(data, key)
 }
@@ -1,7 +1,16 @@
 package crypt
 
 import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base32"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 // CryptEncodeDecode function
@@ -28,6 +37,402 @@ func CryptEncodeDecode(t *testing.T) {
 	}
 }
 
+// TestKDF function
+func TestKDF(t *testing.T) {
+	passphrase := "test"
+	data := []byte(passphrase)
+	for _, name := range SupportedKDFs {
+		kdf, err := NewKDF(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key, err := kdf.Key([]byte(passphrase))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(key) != KeySize {
+			t.Errorf("wrong key size %d with %s KDF", len(key), name)
+		}
+		// the same salt should provide the same key
+		spec, err := ParseKDF(kdf.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		spec.Salt = kdf.Salt
+		skey, err := spec.Key([]byte(passphrase))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(key, skey) {
+			t.Errorf("%s KDF provides different keys for the same salt", name)
+		}
+		// different salt should provide different key
+		other, err := NewKDF(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		okey, err := other.Key([]byte(passphrase))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Equal(key, okey) {
+			t.Errorf("%s KDF provides the same key for different salts", name)
+		}
+		for _, c := range SupportedCiphers() {
+			edata, err := EncryptWithKey(data, key, c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := DecryptWithKey(edata, okey, c); err == nil {
+				t.Errorf("%s cipher decrypts data with wrong key", c)
+			}
+			result, err := DecryptWithKey(edata, key, c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, result) {
+				t.Errorf("encrypt/decrypt failure with %s cipher and %s KDF", c, name)
+			}
+		}
+	}
+	if _, err := NewKDF("md4"); err == nil {
+		t.Error("fail to recognize unsupported KDF")
+	}
+	// KDF parameters of untrusted headers are bounded
+	for _, spec := range []string{
+		"argon2id:1000:65536:4", "argon2id:3:4194304:4", "argon2id:3:65536:64",
+		"scrypt:24:8:1", "scrypt:15:1024:1", "scrypt:20:32:1", "scrypt:15:8:255",
+	} {
+		if _, err := ParseKDF(spec); err == nil {
+			t.Errorf("KDF parameters %s are not bounded", spec)
+		}
+	}
+	kdf := &KDF{Name: KDFArgon2id, Salt: make([]byte, 1024), Time: 1, Memory: 1024, Threads: 1}
+	if err := kdf.Validate(); err == nil {
+		t.Error("KDF salt size is not bounded")
+	}
+}
+
+// TestKeyCache function
+func TestKeyCache(t *testing.T) {
+	kdf, err := NewKDF("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cache KeyCache
+	key, err := cache.Key(kdf, []byte("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ckey, err := cache.Key(kdf, []byte("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if &key[0] != &ckey[0] {
+		t.Error("key is derived again")
+	}
+	dkey, err := kdf.Key([]byte("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(key, dkey) {
+		t.Error("cached key does not match derived one")
+	}
+	// wiped cache zeroes its keys and derives them again
+	cache.Wipe()
+	if !bytes.Equal(key, make([]byte, KeySize)) {
+		t.Error("cached key is not wiped")
+	}
+	okey, err := cache.Key(kdf, []byte("other"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(okey, dkey) {
+		t.Error("key of previous passphrase is kept after wipe")
+	}
+	var nilCache *KeyCache
+	if nkey, err := nilCache.Key(kdf, []byte("test")); err != nil || !bytes.Equal(nkey, dkey) {
+		t.Errorf("nil cache does not derive key, error %v", err)
+	}
+
+	// concurrent callers of the same key share single key derivation
+	cache.Wipe()
+	keys := make([][]byte, 4)
+	var wg sync.WaitGroup
+	for i := range keys {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			keys[i], _ = cache.Key(kdf, []byte("test"))
+		}(i)
+	}
+	wg.Wait()
+	for _, k := range keys {
+		if len(k) == 0 || &k[0] != &keys[0][0] {
+			t.Fatal("concurrent callers derive key more than once")
+		}
+	}
+	if !bytes.Equal(keys[0], dkey) {
+		t.Error("concurrently derived key does not match derived one")
+	}
+}
+
+// TestLegacyKey function
+func TestLegacyKey(t *testing.T) {
+	passphrase := "test"
+	data := []byte(passphrase)
+	for _, c := range SupportedCiphers() {
+		// legacy blobs do not have header and use legacy key derivation
+		cipher, err := getCipher(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		edata, err := cipher.Encrypt(data, LegacyKey([]byte(passphrase)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := Decrypt(edata, passphrase, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, result) {
+			t.Errorf("legacy key does not decrypt %s cipher data", c)
+		}
+	}
+}
+
+// TestHeader function
+func TestHeader(t *testing.T) {
+	passphrase := "test"
+	data := []byte(passphrase)
+	for _, c := range SupportedCiphers() {
+		edata, err := Encrypt(data, passphrase, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		header, body, err := ParseHeader(edata)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header.Cipher != c || header.KDF == nil || header.KDF.Name != KDFArgon2id {
+			t.Errorf("wrong header %s of %s cipher data", header.String(), c)
+		}
+		if len(body) >= len(edata) {
+			t.Error("header is not stripped from encrypted data")
+		}
+		// every blob is encrypted with key derived with its own salt
+		odata, err := Encrypt(data, passphrase, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if oheader, _, err := ParseHeader(odata); err != nil || bytes.Equal(oheader.KDF.Salt, header.KDF.Salt) {
+			t.Errorf("blobs share KDF salt, error %v", err)
+		}
+		// cipher should be taken from the header rather than given one
+		for _, hint := range SupportedCiphers() {
+			result, err := Decrypt(edata, passphrase, hint)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, result) {
+				t.Errorf("encrypt/decrypt failure with %s cipher", c)
+			}
+		}
+		if _, err := Decrypt(edata, "wrong", c); err == nil {
+			t.Errorf("%s cipher decrypts data with wrong passphrase", c)
+		}
+		if _, err := Decrypt(edata[:len(HeaderMagic)+4], passphrase, c); err == nil {
+			t.Error("fail to recognize truncated header")
+		}
+		// data encrypted with raw key can not be decrypted with passphrase
+		kdf, err := NewKDF(KDFScrypt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key, err := kdf.Key([]byte(passphrase))
+		if err != nil {
+			t.Fatal(err)
+		}
+		edata, err = EncryptWithKey(data, key, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Decrypt(edata, passphrase, c); err == nil {
+			t.Error("data encrypted with raw key is decrypted with passphrase")
+		}
+	}
+}
+
+// TestAssociatedData function
+func TestAssociatedData(t *testing.T) {
+	data := []byte("test")
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range SupportedCiphers() {
+		edata, err := EncryptWithKeyAD(data, key, c, []byte("rid-1"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := DecryptWithKeyAD(edata, key, c, []byte("rid-1"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, result) {
+			t.Errorf("encrypt/decrypt failure with %s cipher", c)
+		}
+		if _, err := DecryptWithKeyAD(edata, key, c, []byte("rid-2")); err == nil {
+			t.Errorf("%s cipher decrypts data with wrong associated data", c)
+		}
+		if _, err := DecryptWithKey(edata, key, c); err == nil {
+			t.Errorf("%s cipher decrypts data without associated data", c)
+		}
+		// header is authenticated as well
+		header, _, err := ParseHeader(edata)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tdata := append([]byte{}, edata...)
+		tdata[len(HeaderMagic)] = 1
+		if _, err := DecryptWithKeyAD(tdata, key, c, []byte("rid-1")); err == nil {
+			t.Errorf("%s cipher decrypts data with altered header %s", c, header.String())
+		}
+	}
+}
+
+// TestRegistry function
+func TestRegistry(t *testing.T) {
+	ciphers := SupportedCiphers()
+	expect := []string{"aes", "nacl", "xchacha"}
+	if strings.Join(ciphers, ",") != strings.Join(expect, ",") {
+		t.Errorf("wrong list of supported ciphers %v, expect %v", ciphers, expect)
+	}
+	if c := GetCipher(""); c != DefaultCipher {
+		t.Errorf("wrong default cipher %s", c)
+	}
+	if c := GetCipher("XChaCha"); c != "xchacha" {
+		t.Errorf("wrong cipher %s", c)
+	}
+	if err := RegisterCipher("aes", 10, &CipherAES{}); err == nil {
+		t.Error("cipher is registered twice")
+	}
+	if err := RegisterCipher("test", 1, &CipherAES{}); err == nil {
+		t.Error("cipher ID is registered twice")
+	}
+	if _, err := Encrypt([]byte("test"), "test", "test"); err == nil {
+		t.Error("fail to recognize unsupported cipher")
+	}
+}
+
+// TestStream function
+func TestStream(t *testing.T) {
+	key, err := GenerateKey("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sizes := []int{0, 1, ChunkSize, ChunkSize + 1, 3*ChunkSize + 7}
+	for _, c := range SupportedCiphers() {
+		for _, size := range sizes {
+			data := make([]byte, size)
+			rand.Read(data)
+			var buf bytes.Buffer
+			writer, err := NewWriter(&buf, key[:], c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := io.Copy(writer, bytes.NewReader(data)); err != nil {
+				t.Fatal(err)
+			}
+			if err := writer.Close(); err != nil {
+				t.Fatal(err)
+			}
+			edata := buf.Bytes()
+			reader, err := NewReader(bytes.NewReader(edata), key[:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatalf("unable to read %d bytes stream with %s cipher, error %v", size, c, err)
+			}
+			if !bytes.Equal(data, result) {
+				t.Errorf("encrypt/decrypt failure of %d bytes stream with %s cipher", size, c)
+			}
+			result, err = DecryptWithKey(edata, key[:], "")
+			if err != nil || !bytes.Equal(data, result) {
+				t.Errorf("unable to decrypt %d bytes stream in memory, error %v", size, err)
+			}
+			if size <= ChunkSize {
+				continue
+			}
+			// stream without final chunk should be rejected
+			final := size%ChunkSize + 16
+			reader, err = NewReader(bytes.NewReader(edata[:len(edata)-final]), key[:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := io.ReadAll(reader); err == nil {
+				t.Errorf("truncated stream with %s cipher is accepted", c)
+			}
+		}
+	}
+}
+
+// TestRecipients function
+func TestRecipients(t *testing.T) {
+	data := []byte("test")
+	var identities []*Identity
+	var recipients []*Recipient
+	for i := 0; i < 3; i++ {
+		id, err := GenerateIdentity()
+		if err != nil {
+			t.Fatal(err)
+		}
+		identities = append(identities, id)
+		// string representations should be parsed back
+		pid, err := ParseIdentity(id.String())
+		if err != nil || pid.String() != id.String() {
+			t.Errorf("unable to parse identity %s, error %v", id.String(), err)
+		}
+		r, err := ParseRecipient(id.Recipient().String())
+		if err != nil || r.String() != id.Recipient().String() {
+			t.Errorf("unable to parse recipient %s, error %v", id.Recipient().String(), err)
+		}
+		recipients = append(recipients, r)
+	}
+	// encrypt data to first two recipients only
+	edata, err := EncryptTo(data, recipients[:2], "xchacha")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range identities[:2] {
+		result, err := DecryptWith(edata, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, result) {
+			t.Error("encrypt/decrypt failure with recipients")
+		}
+	}
+	if _, err := DecryptWith(edata, identities[2]); err != ErrNoIdentity {
+		t.Errorf("data is decrypted by wrong identity, error %v", err)
+	}
+
+	// identity should be written and read back from the file
+	fname := filepath.Join(t.TempDir(), "identity.txt")
+	if err := identities[0].WriteIdentity(fname); err != nil {
+		t.Fatal(err)
+	}
+	id, err := ReadIdentity(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.String() != identities[0].String() {
+		t.Error("wrong identity is read from the file")
+	}
+}
+
 // BenchmarkEncryptAES provides benchmark test for AES encrypt operation
 func BenchmarkEncryptAES(b *testing.B) {
 	salt := "test"
@@ -93,3 +498,245 @@ func BenchmarkDecryptNaCl(b *testing.B) {
 		}
 	}
 }
+
+// TestPasswordPolicy function
+func TestPasswordPolicy(t *testing.T) {
+	policy, err := ParsePasswordPolicy("20:n3s2u1a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		password, entropy, err := policy.Generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(password) != 20 {
+			t.Errorf("wrong password length %d", len(password))
+		}
+		var digits, symbols, upper int
+		for _, r := range password {
+			switch {
+			case strings.ContainsRune(AmbiguousChars, r):
+				t.Errorf("password %s contains ambiguous character %c", password, r)
+			case strings.ContainsRune(digitChars, r):
+				digits++
+			case strings.ContainsRune(symbolChars, r):
+				symbols++
+			case strings.ContainsRune(upperChars, r):
+				upper++
+			}
+		}
+		if digits < 3 || symbols < 2 || upper < 1 {
+			t.Errorf("password %s does not satisfy policy", password)
+		}
+		if entropy < 100 {
+			t.Errorf("wrong password entropy %v", entropy)
+		}
+	}
+
+	// custom alphabet and maximum length
+	policy = &PasswordPolicy{Length: 8, Alphabet: "abc", MaxLength: 8}
+	password, _, err := policy.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Trim(password, "abc") != "" {
+		t.Errorf("password %s uses characters outside of alphabet", password)
+	}
+	policy.Digits = 1
+	if _, _, err := policy.Generate(); err == nil {
+		t.Error("policy requires digits which are not in alphabet")
+	}
+	policy = &PasswordPolicy{Length: 16, MaxLength: 12}
+	if _, _, err := policy.Generate(); err == nil {
+		t.Error("password exceeds maximum length")
+	}
+	if _, err := ParsePasswordPolicy("16:x"); err == nil {
+		t.Error("unsupported policy attribute is accepted")
+	}
+}
+
+// TestPassphrase function
+func TestPassphrase(t *testing.T) {
+	passphrase, entropy, err := Passphrase(6, " ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	words := strings.Split(passphrase, " ")
+	if len(words) != 6 {
+		t.Errorf("wrong number of passphrase words %v", words)
+	}
+	if len(wordlist) != 7776 || entropy < 77 {
+		t.Errorf("wrong passphrase entropy %v of wordlist with %d words", entropy, len(wordlist))
+	}
+}
+
+// TestStrength function
+func TestStrength(t *testing.T) {
+	for _, password := range []string{"", "password", "qwerty", "P@ssw0rd", "abcdefgh", "aaaaaaaaaa", "13/05/1999", "johnsmith"} {
+		s := EstimateStrength(password, "John Smith")
+		if !s.Weak() {
+			t.Errorf("password '%s' should be weak, score %d guesses %v", password, s.Score, s.Guesses)
+		}
+		if s.Warning == "" {
+			t.Errorf("no warning for weak password '%s'", password)
+		}
+	}
+	for _, password := range []string{"Tr0ub4dor&3", "Tr0ub4dor"} {
+		if s := EstimateStrength(password); s.Score > 3 {
+			t.Errorf("l33t password '%s' should not be very strong, score %d guesses %v", password, s.Score, s.Guesses)
+		}
+	}
+	for _, password := range []string{"correct-horse-battery-staple", "xK9#mQ2$vL7@pR4!"} {
+		s := EstimateStrength(password)
+		if s.Weak() {
+			t.Errorf("password '%s' should be strong, score %d feedback %s", password, s.Score, s.Feedback())
+		}
+	}
+	policy := NewPasswordPolicy(16, true, true)
+	password, _, err := policy.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := EstimateStrength(password); s.Score != 4 {
+		t.Errorf("generated password '%s' should be very strong, feedback %s", password, s.Feedback())
+	}
+	if s := EstimateStrength("password"); s.CrackTimeString() != "less than a second" {
+		t.Errorf("wrong crack time %s", s.CrackTimeString())
+	}
+}
+
+// TestTOTP function
+func TestTOTP(t *testing.T) {
+	// test vectors from RFC 6238
+	seeds := map[string]string{
+		"SHA1":   "12345678901234567890",
+		"SHA256": "12345678901234567890123456789012",
+		"SHA512": "1234567890123456789012345678901234567890123456789012345678901234",
+	}
+	vectors := []struct {
+		time  int64
+		codes map[string]string
+	}{
+		{59, map[string]string{"SHA1": "94287082", "SHA256": "46119246", "SHA512": "90693936"}},
+		{1111111109, map[string]string{"SHA1": "07081804", "SHA256": "68084774", "SHA512": "25091201"}},
+		{20000000000, map[string]string{"SHA1": "65353130", "SHA256": "77737706", "SHA512": "47863826"}},
+	}
+	for alg, seed := range seeds {
+		secret := base32.StdEncoding.EncodeToString([]byte(seed))
+		uri := fmt.Sprintf("otpauth://totp/ACME:john@example.com?secret=%s&issuer=ACME&algorithm=%s&digits=8", secret, alg)
+		totp, err := ParseTOTP(uri)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if totp.Issuer != "ACME" || totp.Account != "john@example.com" || totp.Period != 30 {
+			t.Errorf("wrong TOTP parameters %+v", totp)
+		}
+		for _, v := range vectors {
+			code, remaining, err := totp.Code(time.Unix(v.time, 0))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if code != v.codes[alg] {
+				t.Errorf("wrong %s code %s for time %d, expect %s", alg, code, v.time, v.codes[alg])
+			}
+			if remaining <= 0 || remaining > 30*time.Second {
+				t.Errorf("wrong code validity %v", remaining)
+			}
+		}
+	}
+
+	// plain base32 seed in lower case with spaces and without padding
+	totp, err := ParseTOTP("gezd gnbv gy3t qojq gezd gnbv gy3t qojq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _, err := totp.Code(time.Unix(59, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != "287082" {
+		t.Errorf("wrong code %s of base32 seed", code)
+	}
+	for _, seed := range []string{"", "not base32!", "otpauth://hotp/x?secret=GEZDGNBV", "otpauth://totp/x?secret=GEZDGNBV&algorithm=MD5"} {
+		if _, err := ParseTOTP(seed); err == nil {
+			t.Errorf("invalid TOTP seed '%s' is accepted", seed)
+		}
+	}
+}
+
+// TestShamir function
+func TestShamir(t *testing.T) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		t.Fatal(err)
+	}
+	shares, err := SplitSecret(secret, 5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shares) != 5 {
+		t.Fatalf("wrong number of shares %d", len(shares))
+	}
+	// any three shares recover the secret
+	for _, idx := range [][]int{{0, 1, 2}, {4, 2, 0}, {1, 3, 4}, {0, 1, 2, 3, 4}} {
+		var subset []*Share
+		for _, i := range idx {
+			// shares survive text round trip
+			share, err := ParseShare(strings.ToLower(shares[i].String()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			subset = append(subset, share)
+		}
+		recovered, err := CombineShares(subset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(recovered, secret) {
+			t.Errorf("wrong secret recovered from shares %v", idx)
+		}
+	}
+	if _, err := CombineShares(shares[:2]); err == nil {
+		t.Error("secret is recovered from less than threshold shares")
+	}
+	if _, err := CombineShares([]*Share{shares[0], shares[0], shares[1]}); err == nil {
+		t.Error("secret is recovered from duplicate shares")
+	}
+	// corrupted share is detected by its checksum
+	text := []byte(shares[0].String())
+	if text[20] == 'A' {
+		text[20] = 'B'
+	} else {
+		text[20] = 'A'
+	}
+	if _, err := ParseShare(string(text)); err == nil {
+		t.Error("corrupted share is accepted")
+	}
+	if _, err := SplitSecret(secret, 3, 4); err == nil {
+		t.Error("threshold above number of shares is accepted")
+	}
+}
+
+// TestSecureBuffer function
+func TestSecureBuffer(t *testing.T) {
+	secret := []byte("secret")
+	buf := SecureBytes(secret)
+	if !bytes.Equal(secret, make([]byte, 6)) {
+		t.Error("source data is not wiped")
+	}
+	if !buf.Equal([]byte("secret")) || string(buf.Bytes()) != "secret" {
+		t.Error("wrong secure buffer data")
+	}
+	if fmt.Sprintf("%v", buf) == "secret" {
+		t.Error("secure buffer reveals its data")
+	}
+	data := buf.Bytes()
+	buf.Wipe()
+	if !buf.Wiped() || buf.Equal([]byte("secret")) || len(buf.Bytes()) != 0 {
+		t.Error("secure buffer is not wiped")
+	}
+	if !bytes.Equal(data, make([]byte, 6)) {
+		t.Error("secure buffer data is not zeroed")
+	}
+}
@@ -0,0 +1,208 @@
+package crypt
+
+// header module provides self-describing header of encrypted blobs. Every
+// blob produced by Encrypt starts with the header which describes how the
+// blob was encrypted, such that Decrypt does not need to guess the cipher
+// and key derivation function. Encrypted streams, see stream.go, use the
+// same header with its own magic value. The header has the following layout:
+//
+//	magic (4 bytes) | version (1) | cipher ID (1) | KDF ID (1) | KDF parameters
+//
+// where KDF parameters are only present for salted key derivation functions:
+//
+//	time (4 bytes, big endian) | memory (4) | threads (1) | salt size (1) | salt
+//
+// Starting from HeaderVersionAD the header is authenticated as associated
+// data of the encrypted payload, therefore it can not be altered
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// HeaderMagic defines magic value which starts every encrypted blob
+var HeaderMagic = []byte{'E', 'C', 'M', 0}
+
+// StreamMagic defines magic value which starts every encrypted stream
+var StreamMagic = []byte{'E', 'C', 'M', 'S'}
+
+// HeaderVersion defines current version of encrypted blob format
+const HeaderVersion uint8 = 2
+
+// HeaderVersionAD defines first version of encrypted blob format which
+// authenticates the header and associated data along with the payload
+const HeaderVersionAD uint8 = 2
+
+// KDFNone represents blobs encrypted with raw key which is not derived
+// from a passphrase, e.g. key kept by the application
+const KDFNone = "none"
+
+// ErrNoHeader is returned when data does not contain encrypted blob header
+var ErrNoHeader = errors.New("encrypted data does not contain header")
+
+// errTruncHeader is returned when encrypted data header is truncated
+var errTruncHeader = errors.New("truncated encrypted data header")
+
+// kdfIDs defines identifiers of key derivation functions stored in blob header
+var kdfIDs = map[string]uint8{
+	KDFNone:     0,
+	KDFLegacy:   1,
+	KDFArgon2id: 2,
+	KDFScrypt:   3,
+}
+
+// Header represents header of encrypted blob
+type Header struct {
+	Version uint8  // format version
+	Cipher  string // cipher used to encrypt the blob
+	KDF     *KDF   // key derivation function, nil if raw key is used
+	Stream  bool   // header of encrypted stream
+}
+
+// NewHeader creates new header of current format version for given cipher
+// and key derivation function
+func NewHeader(cipher string, kdf *KDF) (*Header, error) {
+	cipher = strings.ToLower(cipher)
+	if _, err := cipherID(cipher); err != nil {
+		return nil, err
+	}
+	if kdf != nil {
+		if err := kdf.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return &Header{Version: HeaderVersion, Cipher: cipher, KDF: kdf}, nil
+}
+
+// String provides string representation of the header
+func (h *Header) String() string {
+	kdf := KDFNone
+	if h.KDF != nil {
+		kdf = h.KDF.String()
+	}
+	return fmt.Sprintf("version:%d cipher:%s kdf:%s", h.Version, h.Cipher, kdf)
+}
+
+// Marshal provides binary representation of the header
+func (h *Header) Marshal() ([]byte, error) {
+	cid, err := cipherID(h.Cipher)
+	if err != nil {
+		return nil, err
+	}
+	kdf := KDFNone
+	if h.KDF != nil {
+		kdf = h.KDF.Name
+	}
+	kid, ok := kdfIDs[kdf]
+	if !ok {
+		return nil, fmt.Errorf("unsupported key derivation function %s", kdf)
+	}
+	var buf bytes.Buffer
+	if h.Stream {
+		buf.Write(StreamMagic)
+	} else {
+		buf.Write(HeaderMagic)
+	}
+	buf.WriteByte(h.Version)
+	buf.WriteByte(cid)
+	buf.WriteByte(kid)
+	if kdf == KDFNone || kdf == KDFLegacy {
+		return buf.Bytes(), nil
+	}
+	if len(h.KDF.Salt) > 255 {
+		return nil, fmt.Errorf("too large KDF salt %d", len(h.KDF.Salt))
+	}
+	binary.Write(&buf, binary.BigEndian, h.KDF.Time)
+	binary.Write(&buf, binary.BigEndian, h.KDF.Memory)
+	buf.WriteByte(h.KDF.Threads)
+	buf.WriteByte(uint8(len(h.KDF.Salt)))
+	buf.Write(h.KDF.Salt)
+	return buf.Bytes(), nil
+}
+
+// HasHeader checks if given data starts with encrypted blob or stream header
+func HasHeader(data []byte) bool {
+	return bytes.HasPrefix(data, HeaderMagic) || IsStream(data)
+}
+
+// IsStream checks if given data starts with encrypted stream header
+func IsStream(data []byte) bool {
+	return bytes.HasPrefix(data, StreamMagic)
+}
+
+// ParseHeader parses header of given encrypted blob and returns it along
+// with the encrypted payload which follows the header
+func ParseHeader(data []byte) (*Header, []byte, error) {
+	if !HasHeader(data) {
+		return nil, data, ErrNoHeader
+	}
+	r := bytes.NewReader(data)
+	h, err := ReadHeader(r)
+	if err != nil {
+		return nil, data, err
+	}
+	return h, data[len(data)-r.Len():], nil
+}
+
+// ReadHeader reads header of encrypted blob or stream from given reader
+func ReadHeader(r io.Reader) (*Header, error) {
+	buf := make([]byte, len(HeaderMagic)+3)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			if HasHeader(buf) {
+				return nil, errTruncHeader
+			}
+			return nil, ErrNoHeader
+		}
+		return nil, err
+	}
+	if !HasHeader(buf) {
+		return nil, ErrNoHeader
+	}
+	pos := len(HeaderMagic)
+	h := &Header{Version: buf[pos], Stream: IsStream(buf)}
+	if h.Version == 0 || h.Version > HeaderVersion {
+		return nil, fmt.Errorf("unsupported encrypted data format version %d", h.Version)
+	}
+	cid, kid := buf[pos+1], buf[pos+2]
+	cipher, err := cipherName(cid)
+	if err != nil {
+		return nil, err
+	}
+	h.Cipher = cipher
+	kdf := ""
+	for name, id := range kdfIDs {
+		if id == kid {
+			kdf = name
+		}
+	}
+	switch kdf {
+	case "":
+		return nil, fmt.Errorf("unsupported key derivation function ID %d", kid)
+	case KDFNone:
+		return h, nil
+	case KDFLegacy:
+		h.KDF = &KDF{Name: KDFLegacy}
+		return h, nil
+	}
+	buf = make([]byte, 10)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, errTruncHeader
+	}
+	h.KDF = &KDF{Name: kdf}
+	h.KDF.Time = binary.BigEndian.Uint32(buf)
+	h.KDF.Memory = binary.BigEndian.Uint32(buf[4:])
+	h.KDF.Threads = buf[8]
+	h.KDF.Salt = make([]byte, int(buf[9]))
+	if _, err := io.ReadFull(r, h.KDF.Salt); err != nil {
+		return nil, errTruncHeader
+	}
+	if err := h.KDF.Validate(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
@@ -0,0 +1,281 @@
+package crypt
+
+// kdf module provides key derivation functions used to turn vault
+// passphrase into encryption keys, for more information see
+// https://pkg.go.dev/golang.org/x/crypto/argon2
+// https://pkg.go.dev/golang.org/x/crypto/scrypt
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// KDFArgon2id represents Argon2id key derivation function
+	KDFArgon2id = "argon2id"
+	// KDFScrypt represents scrypt key derivation function
+	KDFScrypt = "scrypt"
+	// KDFLegacy represents unsalted MD5 key derivation used by old vaults
+	KDFLegacy = "md5"
+	// SaltSize is the size of random KDF salt
+	SaltSize = 16
+)
+
+// default cost parameters of supported key derivation functions,
+// they can be tuned by applications before creating new KDF objects
+var (
+	// Argon2Time defines default number of Argon2id passes
+	Argon2Time uint32 = 3
+	// Argon2Memory defines default Argon2id memory in KiB
+	Argon2Memory uint32 = 64 * 1024
+	// Argon2Threads defines default Argon2id parallelism
+	Argon2Threads uint8 = 4
+	// ScryptLogN defines default scrypt CPU/memory cost as log2(N)
+	ScryptLogN uint32 = 15
+	// ScryptR defines default scrypt block size
+	ScryptR uint32 = 8
+	// ScryptP defines default scrypt parallelism
+	ScryptP uint8 = 1
+)
+
+// upper bounds of KDF parameters, KDF parameters are read from headers of
+// untrusted data, e.g. from synced vaults, therefore they should not request
+// unbounded memory or CPU time
+const (
+	// MaxArgon2Time defines maximal number of Argon2id passes
+	MaxArgon2Time = 16
+	// MaxArgon2Memory defines maximal Argon2id memory in KiB, i.e. 1 GiB
+	MaxArgon2Memory = 1024 * 1024
+	// MaxScryptLogN defines maximal scrypt CPU/memory cost as log2(N)
+	MaxScryptLogN = 20
+	// MaxScryptR defines maximal scrypt block size
+	MaxScryptR = 32
+	// MaxScryptMemory defines maximal scrypt memory in bytes, i.e. 1 GiB
+	MaxScryptMemory = 1 << 30
+	// MaxKDFThreads defines maximal Argon2id or scrypt parallelism
+	MaxKDFThreads = 16
+	// MaxSaltSize defines maximal size of KDF salt
+	MaxSaltSize = 64
+)
+
+// SupportedKDFs provides list of supported key derivation functions
+var SupportedKDFs = []string{KDFArgon2id, KDFScrypt}
+
+// KDF represents key derivation function and its parameters
+type KDF struct {
+	Name    string // name of key derivation function
+	Salt    []byte // random salt
+	Time    uint32 // argon2id number of passes or scrypt log2(N)
+	Memory  uint32 // argon2id memory in KiB or scrypt block size r
+	Threads uint8  // argon2id or scrypt parallelism
+}
+
+// NewKDF creates new key derivation function with random salt and default
+// cost parameters, if name is empty Argon2id is used
+func NewKDF(name string) (*KDF, error) {
+	if name == "" {
+		name = KDFArgon2id
+	}
+	k := &KDF{Name: strings.ToLower(name)}
+	switch k.Name {
+	case KDFArgon2id:
+		k.Time, k.Memory, k.Threads = Argon2Time, Argon2Memory, Argon2Threads
+	case KDFScrypt:
+		k.Time, k.Memory, k.Threads = ScryptLogN, ScryptR, ScryptP
+	default:
+		return nil, fmt.Errorf("unsupported key derivation function %s", name)
+	}
+	k.Salt = make([]byte, SaltSize)
+	if _, err := io.ReadFull(rand.Reader, k.Salt); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// ParseKDF creates new key derivation function from given specification
+// which has name:time:memory:threads form, e.g. argon2id:3:65536:4 or
+// scrypt:15:8:1, cost parameters are optional
+func ParseKDF(spec string) (*KDF, error) {
+	arr := strings.Split(spec, ":")
+	k, err := NewKDF(arr[0])
+	if err != nil {
+		return nil, err
+	}
+	for i, v := range arr[1:] {
+		val, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid KDF parameter '%s', error %v", v, err)
+		}
+		switch i {
+		case 0:
+			k.Time = uint32(val)
+		case 1:
+			k.Memory = uint32(val)
+		case 2:
+			k.Threads = uint8(val)
+		}
+	}
+	return k, k.Validate()
+}
+
+// String provides string representation of KDF
+func (k *KDF) String() string {
+	return fmt.Sprintf("%s:%d:%d:%d", k.Name, k.Time, k.Memory, k.Threads)
+}
+
+// Validate checks KDF parameters
+func (k *KDF) Validate() error {
+	switch k.Name {
+	case KDFLegacy:
+		return nil
+	case KDFArgon2id, KDFScrypt:
+	default:
+		return fmt.Errorf("unsupported key derivation function %s", k.Name)
+	}
+	if len(k.Salt) == 0 {
+		return errors.New("KDF salt is not set")
+	}
+	if len(k.Salt) > MaxSaltSize {
+		return fmt.Errorf("too large KDF salt of %d bytes", len(k.Salt))
+	}
+	if k.Time == 0 || k.Memory == 0 || k.Threads == 0 {
+		return fmt.Errorf("invalid KDF parameters %s", k.String())
+	}
+	if k.Threads > MaxKDFThreads {
+		return fmt.Errorf("too large %s parallelism %d", k.Name, k.Threads)
+	}
+	if k.Name == KDFArgon2id {
+		if k.Time > MaxArgon2Time {
+			return fmt.Errorf("too large argon2id number of passes %d", k.Time)
+		}
+		if k.Memory > MaxArgon2Memory {
+			return fmt.Errorf("too large argon2id memory %d", k.Memory)
+		}
+		return nil
+	}
+	if k.Time > MaxScryptLogN {
+		return fmt.Errorf("too large scrypt cost %d", k.Time)
+	}
+	if k.Memory > MaxScryptR {
+		return fmt.Errorf("too large scrypt block size %d", k.Memory)
+	}
+	// scrypt uses 128*r*N bytes of memory
+	if 128*uint64(k.Memory)<<k.Time > MaxScryptMemory {
+		return fmt.Errorf("too large scrypt memory of %s parameters", k.String())
+	}
+	return nil
+}
+
+// Key derives encryption key of KeySize length from given passphrase
+func (k *KDF) Key(passphrase []byte) ([]byte, error) {
+	if err := k.Validate(); err != nil {
+		return nil, err
+	}
+	switch k.Name {
+	case KDFArgon2id:
+		return argon2.IDKey(passphrase, k.Salt, k.Time, k.Memory, k.Threads, KeySize), nil
+	case KDFScrypt:
+		return scrypt.Key(passphrase, k.Salt, 1<<k.Time, int(k.Memory), int(k.Threads), KeySize)
+	}
+	return LegacyKey(passphrase), nil
+}
+
+// KeyCache keeps keys derived from single passphrase to avoid expensive key
+// derivation for every record of the vault. The keys are indexed by KDF
+// parameters and salt only, therefore the cache is owned by the user of the
+// passphrase, e.g. the vault, and it should be wiped once the passphrase is
+// changed or no longer needed. Nil cache derives keys without caching them
+type KeyCache struct {
+	mu   sync.Mutex
+	keys map[string]*cachedKey
+}
+
+// helper type which keeps cached key, the key is derived once while other
+// callers of the same key wait for it and callers of other keys do not
+type cachedKey struct {
+	once sync.Once
+	key  []byte
+	err  error
+}
+
+// error of cached key which is wiped before it is derived
+var errKeyWiped = errors.New("cached key is wiped")
+
+// Key provides key derived from given passphrase via given key derivation
+// function, the key is derived once and kept in the cache
+func (c *KeyCache) Key(k *KDF, passphrase []byte) ([]byte, error) {
+	if c == nil || k.Name == KDFLegacy {
+		return k.Key(passphrase)
+	}
+	ckey := fmt.Sprintf("%s:%s", k.String(), hex.EncodeToString(k.Salt))
+	c.mu.Lock()
+	entry, ok := c.keys[ckey]
+	if !ok {
+		if c.keys == nil {
+			c.keys = make(map[string]*cachedKey)
+		}
+		entry = &cachedKey{}
+		c.keys[ckey] = entry
+	}
+	c.mu.Unlock()
+	// expensive key derivation runs without cache lock
+	entry.once.Do(func() {
+		entry.key, entry.err = k.Key(passphrase)
+	})
+	if errors.Is(entry.err, errKeyWiped) {
+		return k.Key(passphrase)
+	}
+	if entry.err != nil {
+		// failed derivation is not cached
+		c.mu.Lock()
+		if c.keys[ckey] == entry {
+			delete(c.keys, ckey)
+		}
+		c.mu.Unlock()
+		return nil, entry.err
+	}
+	return entry.key, nil
+}
+
+// Decrypt decrypts given data using given passphrase like Decrypt function
+// does, keys derived from the passphrase are kept in the cache
+func (c *KeyCache) Decrypt(data, passphrase []byte, cipher string) ([]byte, error) {
+	return decryptPassphrase(data, passphrase, cipher, c)
+}
+
+// Wipe zeroes cached keys and removes them from the cache
+func (c *KeyCache) Wipe() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	keys := c.keys
+	c.keys = nil
+	c.mu.Unlock()
+	for _, entry := range keys {
+		// key which is being derived is wiped once it is derived
+		entry.once.Do(func() {
+			entry.err = errKeyWiped
+		})
+		WipeBytes(entry.key)
+	}
+}
+
+// LegacyKey provides key derived via unsalted MD5 hash of the passphrase.
+// It is used by AES and NaCl ciphers of old vaults and should only be
+// used to read existing records
+func LegacyKey(passphrase []byte) []byte {
+	hasher := md5.New()
+	hasher.Write(passphrase)
+	return []byte(hex.EncodeToString(hasher.Sum(nil)))
+}
@@ -0,0 +1,65 @@
+package crypt
+
+// keyfile module provides key files which are used as a second factor along
+// with passphrase. The key file content is mixed into the passphrase before
+// key derivation, therefore both of them are required to derive the key.
+// The idea is similar to KeePass composite keys, see
+// https://keepass.info/help/base/keys.html
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+)
+
+// KeyFileSize defines size of random content of generated key files
+const KeyFileSize = 64
+
+// GenerateKeyFile creates new key file with random content, existing
+// files are never overwritten
+func GenerateKeyFile(fname string) error {
+	data := make([]byte, KeyFileSize)
+	if _, err := io.ReadFull(rand.Reader, data); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(fname, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// CompositeSecret combines given passphrase and content of given key file
+// into the secret used for key derivation. The copy of the passphrase is
+// returned if key file is not provided, the caller should wipe the secret
+// once it is used. Any file can be used as a key file, but it should never
+// change since its content is used as is
+func CompositeSecret(passphrase []byte, keyFile string) ([]byte, error) {
+	if keyFile == "" {
+		return append([]byte{}, passphrase...), nil
+	}
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	defer WipeBytes(data)
+	if len(data) == 0 {
+		return nil, errors.New("key file " + keyFile + " is empty")
+	}
+	phash := sha256.Sum256(passphrase)
+	khash := sha256.Sum256(data)
+	hasher := sha256.New()
+	hasher.Write([]byte("ecm composite key"))
+	hasher.Write(phash[:])
+	hasher.Write(khash[:])
+	secret := make([]byte, hex.EncodedLen(sha256.Size))
+	hex.Encode(secret, hasher.Sum(nil))
+	return secret, nil
+}
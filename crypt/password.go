@@ -1,37 +1,251 @@
 package crypt
 
+// password module provides password and passphrase generators based on
+// crypto/rand. Passwords are generated according to password policy, while
+// passphrases are built from words of embedded EFF large diceware wordlist
+// (7776 words, i.e. 12.9 bits of entropy per word), see
+// https://www.eff.org/deeplinks/2016/07/new-wordlists-random-passphrases
+// The original code was based on https://github.com/AlanBar13/pass-generator
+
 import (
-	"math/rand"
-	"time"
+	"crypto/rand"
+	_ "embed"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+const (
+	lowerChars  = "abcdefghijklmnopqrstuvwxyz"
+	upperChars  = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	digitChars  = "0123456789"
+	symbolChars = "!@#$%&*+_-="
 )
 
-// code is based on https://github.com/AlanBar13/pass-generator
+// AmbiguousChars defines characters which are easy to confuse with each other
+const AmbiguousChars = "0O1lI|"
+
+//go:embed wordlist.txt
+var wordlistData string
+
+// wordlist keeps words of embedded EFF large diceware wordlist
+var wordlist = strings.Fields(wordlistData)
+
+// PasswordPolicy defines rules of generated passwords
+type PasswordPolicy struct {
+	Length      int    // password length
+	MaxLength   int    // maximum password length, e.g. imposed by a site, zero means no limit
+	Lower       int    // minimum number of lower case letters
+	Upper       int    // minimum number of upper case letters
+	Digits      int    // minimum number of digits
+	Symbols     int    // minimum number of symbols
+	UseDigits   bool   // use digits in password
+	UseSymbols  bool   // use symbols in password
+	NoAmbiguous bool   // exclude ambiguous characters, see AmbiguousChars
+	Alphabet    string // custom alphabet which replaces default characters
+}
+
+// charClass represents character class of password policy
+type charClass struct {
+	Name  string // class name
+	Chars []rune // class characters present in policy alphabet
+	Min   int    // minimum number of class characters in password
+}
+
+// NewPasswordPolicy creates password policy of given length which uses
+// letters and optionally digits and symbols
+func NewPasswordPolicy(length int, digits, symbols bool) *PasswordPolicy {
+	return &PasswordPolicy{Length: length, UseDigits: digits, UseSymbols: symbols}
+}
+
+// ParsePasswordPolicy parses password policy from its string representation
+// <length>:<attributes>, where attributes can be 'n' (numbers), 's' (symbols),
+// 'u' (upper case letters), 'l' (lower case letters) followed by optional
+// minimum number of class characters and 'a' to exclude ambiguous characters,
+// e.g. 16:n2s1a defines password of length 16 with at least two numbers and
+// one symbol without ambiguous characters
+func ParsePasswordPolicy(spec string) (*PasswordPolicy, error) {
+	arr := strings.SplitN(spec, ":", 2)
+	length, err := strconv.Atoi(arr[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid password length '%s'", arr[0])
+	}
+	policy := &PasswordPolicy{Length: length}
+	if len(arr) == 1 {
+		return policy, nil
+	}
+	attrs := arr[1]
+	for i := 0; i < len(attrs); i++ {
+		attr := attrs[i]
+		j := i + 1
+		for j < len(attrs) && attrs[j] >= '0' && attrs[j] <= '9' {
+			j++
+		}
+		count := 0
+		if j > i+1 {
+			count, _ = strconv.Atoi(attrs[i+1 : j])
+		}
+		switch attr {
+		case 'n':
+			policy.UseDigits = true
+			policy.Digits = count
+		case 's':
+			policy.UseSymbols = true
+			policy.Symbols = count
+		case 'u':
+			policy.Upper = count
+		case 'l':
+			policy.Lower = count
+		case 'a':
+			policy.NoAmbiguous = true
+		default:
+			return nil, fmt.Errorf("unsupported password attribute '%c'", attr)
+		}
+		i = j - 1
+	}
+	return policy, nil
+}
+
+// helper function to build policy alphabet and its character classes
+func (p *PasswordPolicy) alphabet() ([]rune, []charClass, error) {
+	chars := p.Alphabet
+	if chars == "" {
+		chars = lowerChars + upperChars
+		if p.UseDigits || p.Digits > 0 {
+			chars += digitChars
+		}
+		if p.UseSymbols || p.Symbols > 0 {
+			chars += symbolChars
+		}
+	}
+	var alphabet []rune
+	seen := make(map[rune]bool)
+	for _, r := range chars {
+		if seen[r] || (p.NoAmbiguous && strings.ContainsRune(AmbiguousChars, r)) {
+			continue
+		}
+		seen[r] = true
+		alphabet = append(alphabet, r)
+	}
+	if len(alphabet) == 0 {
+		return nil, nil, errors.New("password policy alphabet is empty")
+	}
+	var classes []charClass
+	for _, c := range []charClass{
+		{Name: "lower case letters", Chars: []rune(lowerChars), Min: p.Lower},
+		{Name: "upper case letters", Chars: []rune(upperChars), Min: p.Upper},
+		{Name: "digits", Chars: []rune(digitChars), Min: p.Digits},
+		{Name: "symbols", Chars: []rune(symbolChars), Min: p.Symbols},
+	} {
+		if c.Min <= 0 {
+			continue
+		}
+		var present []rune
+		for _, r := range c.Chars {
+			if seen[r] {
+				present = append(present, r)
+			}
+		}
+		if len(present) == 0 {
+			return nil, nil, fmt.Errorf("password policy requires %s which are not present in its alphabet", c.Name)
+		}
+		c.Chars = present
+		classes = append(classes, c)
+	}
+	return alphabet, classes, nil
+}
 
-const voc string = "abcdfghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
-const numbers string = "0123456789"
-const symbols string = "!@#$%&*+_-="
+// Generate generates new password according to the policy, it returns the
+// password along with its entropy in bits
+func (p *PasswordPolicy) Generate() (string, float64, error) {
+	if p.Length <= 0 {
+		return "", 0, errors.New("password length should be positive")
+	}
+	if p.MaxLength > 0 && p.Length > p.MaxLength {
+		return "", 0, fmt.Errorf("password length %d exceeds maximum length %d", p.Length, p.MaxLength)
+	}
+	alphabet, classes, err := p.alphabet()
+	if err != nil {
+		return "", 0, err
+	}
+	var password []rune
+	for _, c := range classes {
+		for i := 0; i < c.Min; i++ {
+			r, err := randRune(c.Chars)
+			if err != nil {
+				return "", 0, err
+			}
+			password = append(password, r)
+		}
+	}
+	if len(password) > p.Length {
+		return "", 0, fmt.Errorf("password policy requires %d characters which exceeds password length %d", len(password), p.Length)
+	}
+	for len(password) < p.Length {
+		r, err := randRune(alphabet)
+		if err != nil {
+			return "", 0, err
+		}
+		password = append(password, r)
+	}
+	// shuffle password to place required characters at random positions
+	for i := len(password) - 1; i > 0; i-- {
+		j, err := randInt(i + 1)
+		if err != nil {
+			return "", 0, err
+		}
+		password[i], password[j] = password[j], password[i]
+	}
+	entropy := float64(p.Length) * math.Log2(float64(len(alphabet)))
+	return string(password), entropy, nil
+}
 
-func init() {
-	rand.Seed(time.Now().UnixNano())
+// Passphrase generates diceware passphrase of given number of words taken
+// from embedded wordlist and joined by given separator, it returns the
+// passphrase along with its entropy in bits
+func Passphrase(words int, separator string) (string, float64, error) {
+	if words <= 0 {
+		return "", 0, errors.New("number of passphrase words should be positive")
+	}
+	var out []string
+	for i := 0; i < words; i++ {
+		idx, err := randInt(len(wordlist))
+		if err != nil {
+			return "", 0, err
+		}
+		out = append(out, wordlist[idx])
+	}
+	entropy := float64(words) * math.Log2(float64(len(wordlist)))
+	return strings.Join(out, separator), entropy, nil
 }
 
 // CreatePassword creates password of certain length
 func CreatePassword(length int, hasNumbers bool, hasSymbols bool) string {
-	chars := voc
-	if hasNumbers {
-		chars = chars + numbers
+	password, _, err := NewPasswordPolicy(length, hasNumbers, hasSymbols).Generate()
+	if err != nil {
+		log.Println("unable to generate password, error ", err)
 	}
-	if hasSymbols {
-		chars = chars + symbols
+	return password
+}
+
+// helper function to get random integer in [0, n) range
+func randInt(n int) (int, error) {
+	v, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, err
 	}
-	return generatePassword(length, chars)
+	return int(v.Int64()), nil
 }
 
-// helper function to generate password of certain length and chars
-func generatePassword(length int, chars string) string {
-	password := ""
-	for i := 0; i < length; i++ {
-		password += string([]rune(chars)[rand.Intn(len(chars))])
+// helper function to get random rune from given list of runes
+func randRune(runes []rune) (rune, error) {
+	idx, err := randInt(len(runes))
+	if err != nil {
+		return 0, err
 	}
-	return password
+	return runes[idx], nil
 }
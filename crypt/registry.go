@@ -0,0 +1,132 @@
+package crypt
+
+// registry module keeps ciphers known to crypt module. Every cipher registers
+// itself under a name and a numeric ID which is stored in encrypted blob
+// header, therefore IDs of registered ciphers should never change
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultCipher defines cipher used when cipher is not provided
+const DefaultCipher = "aes"
+
+// Cipher defines cipher interface, ciphers encrypt and decrypt data with
+// given key of KeySize length
+type Cipher interface {
+	Encrypt(data, key []byte) ([]byte, error)
+	Decrypt(data, key []byte) ([]byte, error)
+}
+
+// ADCipher defines cipher which authenticates associated data along with
+// encrypted data, i.e. data can only be decrypted with the same associated
+// data it was encrypted with
+type ADCipher interface {
+	Cipher
+	EncryptAD(data, key, ad []byte) ([]byte, error)
+	DecryptAD(data, key, ad []byte) ([]byte, error)
+}
+
+// cipherEntry represents registered cipher
+type cipherEntry struct {
+	Name   string // cipher name
+	ID     uint8  // cipher ID used in encrypted blob header
+	Cipher Cipher // cipher implementation
+}
+
+// registry keeps registered ciphers
+var registry = struct {
+	sync.RWMutex
+	ciphers map[string]cipherEntry
+}{ciphers: make(map[string]cipherEntry)}
+
+// RegisterCipher registers given cipher under given name and ID
+func RegisterCipher(name string, id uint8, c Cipher) error {
+	name = strings.ToLower(name)
+	if name == "" || c == nil {
+		return fmt.Errorf("unable to register cipher '%s'", name)
+	}
+	if id == 0 {
+		return fmt.Errorf("cipher %s should have non zero ID", name)
+	}
+	registry.Lock()
+	defer registry.Unlock()
+	if _, ok := registry.ciphers[name]; ok {
+		return fmt.Errorf("cipher %s is already registered", name)
+	}
+	for _, e := range registry.ciphers {
+		if e.ID == id {
+			return fmt.Errorf("cipher ID %d is already used by %s cipher", id, e.Name)
+		}
+	}
+	registry.ciphers[name] = cipherEntry{Name: name, ID: id, Cipher: c}
+	return nil
+}
+
+// SupportedCiphers provides list of registered ciphers ordered by their IDs
+func SupportedCiphers() []string {
+	registry.RLock()
+	defer registry.RUnlock()
+	var entries []cipherEntry
+	for _, e := range registry.ciphers {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].ID < entries[j].ID })
+	var out []string
+	for _, e := range entries {
+		out = append(out, e.Name)
+	}
+	return out
+}
+
+// GetCipher returns either default or given cipher
+func GetCipher(cipher string) string {
+	if cipher == "" {
+		cipher = DefaultCipher
+	}
+	cipher = strings.ToLower(cipher)
+	if _, err := getCipher(cipher); err != nil {
+		log.Fatalf(
+			"given cipher %s is not supported, please use one from the following %v",
+			cipher,
+			SupportedCiphers(),
+		)
+	}
+	return cipher
+}
+
+// helper function to find registered cipher by its name
+func getCipher(name string) (Cipher, error) {
+	registry.RLock()
+	defer registry.RUnlock()
+	if e, ok := registry.ciphers[strings.ToLower(name)]; ok {
+		return e.Cipher, nil
+	}
+	return nil, fmt.Errorf("unsupported cipher %s", name)
+}
+
+// helper function to find ID of registered cipher
+func cipherID(name string) (uint8, error) {
+	registry.RLock()
+	defer registry.RUnlock()
+	if e, ok := registry.ciphers[strings.ToLower(name)]; ok {
+		return e.ID, nil
+	}
+	return 0, fmt.Errorf("unsupported cipher %s", name)
+}
+
+// helper function to find name of registered cipher by its ID
+func cipherName(id uint8) (string, error) {
+	registry.RLock()
+	defer registry.RUnlock()
+	for _, e := range registry.ciphers {
+		if e.ID == id {
+			return e.Name, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported cipher ID %d", id)
+}
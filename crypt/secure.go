@@ -0,0 +1,107 @@
+package crypt
+
+// secure module provides buffers for sensitive data, e.g. vault secret and
+// decrypted record values. The buffer memory is locked, when it is supported
+// by the platform, such that it is never swapped to disk, and the buffer is
+// explicitly zeroed once its data is no longer needed. Go strings are
+// immutable and can not be zeroed, therefore buffer data is only provided
+// as bytes.
+
+import (
+	"crypto/subtle"
+	"sync"
+)
+
+// SecureBuffer represents buffer of sensitive data
+type SecureBuffer struct {
+	mu     sync.Mutex
+	mem    []byte // memory of the buffer
+	data   []byte // buffer data
+	locked bool   // memory is locked
+	wiped  bool   // buffer is wiped
+}
+
+// NewSecureBuffer creates new secure buffer of given size
+func NewSecureBuffer(size int) *SecureBuffer {
+	mem := make([]byte, size)
+	b := &SecureBuffer{mem: mem, data: mem}
+	if size > 0 {
+		b.locked = lockMemory(mem) == nil
+	}
+	return b
+}
+
+// SecureBytes creates new secure buffer with a copy of given data, the
+// given data is wiped afterwards
+func SecureBytes(data []byte) *SecureBuffer {
+	b := NewSecureBuffer(len(data))
+	copy(b.data, data)
+	WipeBytes(data)
+	return b
+}
+
+// Bytes provides data of the buffer, the data is only valid until the
+// buffer is wiped
+func (b *SecureBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.data
+}
+
+// Len provides size of the buffer data
+func (b *SecureBuffer) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.data)
+}
+
+// Locked checks if buffer memory is locked
+func (b *SecureBuffer) Locked() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.locked
+}
+
+// Wiped checks if buffer is wiped
+func (b *SecureBuffer) Wiped() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.wiped
+}
+
+// Equal compares data of the buffer with given data in constant time
+func (b *SecureBuffer) Equal(data []byte) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return !b.wiped && subtle.ConstantTimeCompare(b.data, data) == 1
+}
+
+// Wipe zeroes the buffer memory and unlocks it, the buffer can not be used
+// afterwards
+func (b *SecureBuffer) Wipe() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.wiped {
+		return
+	}
+	WipeBytes(b.mem)
+	if b.locked {
+		unlockMemory(b.mem)
+		b.locked = false
+	}
+	b.data = nil
+	b.wiped = true
+}
+
+// String implements fmt.Stringer interface and never reveals buffer data,
+// therefore the buffer can be safely logged
+func (b *SecureBuffer) String() string {
+	return "[secure buffer]"
+}
+
+// WipeBytes zeroes given data
+func WipeBytes(data []byte) {
+	for i := range data {
+		data[i] = 0
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+
+package crypt
+
+import "errors"
+
+// helper function to lock memory, it is not supported on this platform
+func lockMemory(mem []byte) error {
+	return errors.New("memory locking is not supported")
+}
+
+// helper function to unlock memory
+func unlockMemory(mem []byte) error {
+	return nil
+}
@@ -0,0 +1,15 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package crypt
+
+import "syscall"
+
+// helper function to lock memory such that it is never swapped to disk
+func lockMemory(mem []byte) error {
+	return syscall.Mlock(mem)
+}
+
+// helper function to unlock memory
+func unlockMemory(mem []byte) error {
+	return syscall.Munlock(mem)
+}
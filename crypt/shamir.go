@@ -0,0 +1,223 @@
+package crypt
+
+// shamir module provides Shamir secret sharing over GF(256), see
+// https://en.wikipedia.org/wiki/Shamir%27s_secret_sharing
+// The secret is split into N shares such that any K of them recover the
+// secret while K-1 shares reveal nothing about it. Every byte of the secret
+// is shared independently using random polynomial of degree K-1.
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// SharePrefix defines prefix of text representation of secret shares
+const SharePrefix = "ECM-SHARE-"
+
+// ShareVersion defines version of secret share format
+const ShareVersion = 1
+
+// size of secret fingerprint and share checksum
+const (
+	shareFingerprintSize = 4
+	shareChecksumSize    = 4
+)
+
+// base32 encoding of shares, it only uses characters of QR alphanumeric mode
+var shareEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Share represents single share of the secret
+type Share struct {
+	Threshold   int    // number of shares required to recover the secret
+	Index       byte   // share index, i.e. x coordinate of the share
+	Fingerprint []byte // fingerprint of the secret used to check recovery
+	Data        []byte // share data
+}
+
+// SplitSecret splits given secret into n shares with threshold k
+func SplitSecret(secret []byte, n, k int) ([]*Share, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("unable to split empty secret")
+	}
+	if k < 2 || k > n || n > 255 {
+		return nil, fmt.Errorf("invalid number of shares %d with threshold %d, should be 2 <= threshold <= shares <= 255", n, k)
+	}
+	fingerprint := shareFingerprint(secret)
+	var shares []*Share
+	for i := 1; i <= n; i++ {
+		shares = append(shares, &Share{
+			Threshold:   k,
+			Index:       byte(i),
+			Fingerprint: fingerprint,
+			Data:        make([]byte, len(secret)),
+		})
+	}
+	coeffs := make([]byte, k)
+	for pos, b := range secret {
+		// random polynomial with the secret byte as its constant term
+		coeffs[0] = b
+		if _, err := io.ReadFull(rand.Reader, coeffs[1:]); err != nil {
+			return nil, err
+		}
+		for _, s := range shares {
+			s.Data[pos] = gfEval(coeffs, s.Index)
+		}
+	}
+	return shares, nil
+}
+
+// CombineShares recovers the secret from given shares, the number of shares
+// should be at least the threshold of the shares
+func CombineShares(shares []*Share) ([]byte, error) {
+	if len(shares) == 0 {
+		return nil, errors.New("no secret shares are provided")
+	}
+	first := shares[0]
+	seen := make(map[byte]bool)
+	for _, s := range shares {
+		if s.Threshold != first.Threshold || len(s.Data) != len(first.Data) ||
+			!bytes.Equal(s.Fingerprint, first.Fingerprint) {
+			return nil, errors.New("secret shares belong to different secrets")
+		}
+		if s.Index == 0 {
+			return nil, errors.New("invalid secret share index 0")
+		}
+		if seen[s.Index] {
+			return nil, fmt.Errorf("duplicate secret share %d", s.Index)
+		}
+		seen[s.Index] = true
+	}
+	if len(shares) < first.Threshold {
+		return nil, fmt.Errorf("%d secret shares are required, %d are provided", first.Threshold, len(shares))
+	}
+	shares = shares[:first.Threshold]
+	secret := make([]byte, len(first.Data))
+	for pos := range secret {
+		// Lagrange interpolation at x=0
+		var value byte
+		for i, si := range shares {
+			basis := byte(1)
+			for j, sj := range shares {
+				if i == j {
+					continue
+				}
+				// basis *= xj / (xj - xi), subtraction is xor in GF(256)
+				basis = gfMul(basis, gfDiv(sj.Index, sj.Index^si.Index))
+			}
+			value ^= gfMul(si.Data[pos], basis)
+		}
+		secret[pos] = value
+	}
+	if !bytes.Equal(shareFingerprint(secret), first.Fingerprint) {
+		return nil, errors.New("recovered secret does not match fingerprint of secret shares")
+	}
+	return secret, nil
+}
+
+// String provides text representation of the share, it consists of share
+// prefix followed by base32 encoded share version, threshold, index,
+// fingerprint, data and checksum
+func (s *Share) String() string {
+	var buf bytes.Buffer
+	buf.WriteByte(ShareVersion)
+	buf.WriteByte(byte(s.Threshold))
+	buf.WriteByte(s.Index)
+	buf.Write(s.Fingerprint)
+	buf.Write(s.Data)
+	sum := sha256.Sum256(buf.Bytes())
+	buf.Write(sum[:shareChecksumSize])
+	return SharePrefix + shareEncoding.EncodeToString(buf.Bytes())
+}
+
+// ParseShare parses secret share from its text representation
+func ParseShare(text string) (*Share, error) {
+	text = strings.ToUpper(strings.Join(strings.Fields(text), ""))
+	if !strings.HasPrefix(text, SharePrefix) {
+		return nil, fmt.Errorf("secret share should start with %s", SharePrefix)
+	}
+	data, err := shareEncoding.DecodeString(strings.TrimPrefix(text, SharePrefix))
+	if err != nil {
+		return nil, fmt.Errorf("invalid secret share encoding, error %v", err)
+	}
+	if len(data) <= 3+shareFingerprintSize+shareChecksumSize {
+		return nil, errors.New("secret share is too short")
+	}
+	payload := data[:len(data)-shareChecksumSize]
+	sum := sha256.Sum256(payload)
+	if !bytes.Equal(sum[:shareChecksumSize], data[len(data)-shareChecksumSize:]) {
+		return nil, errors.New("secret share checksum mismatch, please check share text")
+	}
+	if payload[0] != ShareVersion {
+		return nil, fmt.Errorf("unsupported secret share version %d", payload[0])
+	}
+	share := &Share{
+		Threshold:   int(payload[1]),
+		Index:       payload[2],
+		Fingerprint: payload[3 : 3+shareFingerprintSize],
+		Data:        payload[3+shareFingerprintSize:],
+	}
+	return share, nil
+}
+
+// helper function to calculate fingerprint of the secret
+func shareFingerprint(secret []byte) []byte {
+	sum := sha256.Sum256(append([]byte("ecm share "), secret...))
+	return sum[:shareFingerprintSize]
+}
+
+// GF(256) arithmetic with reducing polynomial x^8+x^4+x^3+x+1 (0x11b)
+var gfExp, gfLog = gfTables()
+
+// helper function to build exponent and logarithm tables of GF(256)
+// using 3 as generator
+func gfTables() ([512]byte, [256]byte) {
+	var exp [512]byte
+	var log [256]byte
+	x := byte(1)
+	for i := 0; i < 255; i++ {
+		exp[i] = x
+		log[x] = byte(i)
+		// multiply x by generator 3, i.e. x*2 xor x
+		x2 := x << 1
+		if x&0x80 != 0 {
+			x2 ^= 0x1b
+		}
+		x = x2 ^ x
+	}
+	for i := 255; i < 512; i++ {
+		exp[i] = exp[i-255]
+	}
+	return exp, log
+}
+
+// helper function to multiply two elements of GF(256)
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+// helper function to divide two elements of GF(256), b should not be zero
+func gfDiv(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+255-int(gfLog[b])]
+}
+
+// helper function to evaluate polynomial with given coefficients at x
+func gfEval(coeffs []byte, x byte) byte {
+	// Horner's method
+	var value byte
+	for i := len(coeffs) - 1; i >= 0; i-- {
+		value = gfMul(value, x) ^ coeffs[i]
+	}
+	return value
+}
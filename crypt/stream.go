@@ -0,0 +1,277 @@
+package crypt
+
+// stream module provides chunked AEAD encryption of data streams, it allows
+// to encrypt and decrypt large files in constant memory. The encrypted
+// stream has the following layout:
+//
+//	header | salt | chunk 0 | chunk 1 | ... | final chunk
+//
+// The stream key is derived via HKDF from the given key and random salt.
+// Every chunk holds up to ChunkSize bytes of sealed data and is encrypted
+// with the nonce built from the chunk counter and final chunk marker,
+// therefore chunks can not be reordered, dropped or appended without
+// being detected. The stream header is authenticated as associated data
+// of every chunk. For more information see
+// https://eprint.iacr.org/2015/189.pdf
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// ChunkSize is the size of plain data chunk of encrypted stream
+	ChunkSize = 64 * 1024
+	// StreamSaltSize is the size of random salt of encrypted stream
+	StreamSaltSize = 16
+)
+
+// ErrStream is returned when encrypted stream is corrupted or truncated
+var ErrStream = errors.New("corrupted or truncated encrypted stream")
+
+// AEADCipher defines cipher which provides AEAD primitive, such ciphers
+// can be used to encrypt data streams
+type AEADCipher interface {
+	Cipher
+	AEAD(key []byte) (cipher.AEAD, error)
+}
+
+// helper function to create AEAD primitive of given cipher for the stream
+// key derived from given key and salt
+func streamAEAD(name string, key, salt []byte) (cipher.AEAD, error) {
+	c, err := getCipher(name)
+	if err != nil {
+		return nil, err
+	}
+	ac, ok := c.(AEADCipher)
+	if !ok {
+		return nil, errors.New("cipher " + name + " does not support streams")
+	}
+	skey := make([]byte, KeySize)
+	kdf := hkdf.New(sha256.New, key, salt, []byte("ecm stream"))
+	if _, err := io.ReadFull(kdf, skey); err != nil {
+		return nil, err
+	}
+	return ac.AEAD(skey)
+}
+
+// helper function to build nonce of given stream chunk
+func streamNonce(nonce []byte, counter uint64, last bool) {
+	for i := range nonce {
+		nonce[i] = 0
+	}
+	size := len(nonce)
+	binary.BigEndian.PutUint64(nonce[size-9:size-1], counter)
+	if last {
+		nonce[size-1] = 1
+	}
+}
+
+// StreamWriter encrypts data written to it and writes encrypted
+// stream to underlying writer
+type StreamWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	nonce   []byte
+	ad      []byte
+	buf     []byte
+	out     []byte
+	counter uint64
+	closed  bool
+}
+
+// NewWriter creates new stream writer which encrypts data with given key
+// and cipher. Ciphers which do not provide AEAD primitive, e.g. nacl, are
+// replaced by DefaultCipher. The Close method should be called to write
+// the final chunk of the stream
+func NewWriter(w io.Writer, key []byte, name string) (*StreamWriter, error) {
+	if name == "" {
+		name = DefaultCipher
+	}
+	c, err := getCipher(name)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := c.(AEADCipher); !ok {
+		name = DefaultCipher
+	}
+	header, err := NewHeader(name, nil)
+	if err != nil {
+		return nil, err
+	}
+	header.Stream = true
+	hdata, err := header.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, StreamSaltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	aead, err := streamAEAD(name, key, salt)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(append(hdata, salt...)); err != nil {
+		return nil, err
+	}
+	sw := &StreamWriter{
+		w:     w,
+		aead:  aead,
+		nonce: make([]byte, aead.NonceSize()),
+		ad:    hdata,
+		buf:   make([]byte, 0, ChunkSize),
+		out:   make([]byte, 0, ChunkSize+aead.Overhead()),
+	}
+	return sw, nil
+}
+
+// Write encrypts given data and writes it to underlying writer
+func (s *StreamWriter) Write(p []byte) (int, error) {
+	if s.closed {
+		return 0, errors.New("write to closed encrypted stream")
+	}
+	total := len(p)
+	for len(p) > 0 {
+		// full chunk is written only when we know it is not the last one
+		if len(s.buf) == ChunkSize {
+			if err := s.flush(false); err != nil {
+				return total - len(p), err
+			}
+		}
+		n := ChunkSize - len(s.buf)
+		if n > len(p) {
+			n = len(p)
+		}
+		s.buf = append(s.buf, p[:n]...)
+		p = p[n:]
+	}
+	return total, nil
+}
+
+// Close writes final chunk of the stream, it does not close underlying writer
+func (s *StreamWriter) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return s.flush(true)
+}
+
+// helper function to encrypt and write current chunk
+func (s *StreamWriter) flush(last bool) error {
+	streamNonce(s.nonce, s.counter, last)
+	s.out = s.aead.Seal(s.out[:0], s.nonce, s.buf, s.ad)
+	if _, err := s.w.Write(s.out); err != nil {
+		return err
+	}
+	s.counter++
+	s.buf = s.buf[:0]
+	return nil
+}
+
+// StreamReader decrypts encrypted stream from underlying reader
+type StreamReader struct {
+	r       *bufio.Reader
+	aead    cipher.AEAD
+	nonce   []byte
+	ad      []byte
+	buf     []byte
+	plain   []byte
+	counter uint64
+	done    bool
+	err     error
+}
+
+// NewReader creates new stream reader which decrypts stream with given key,
+// the cipher is taken from the stream header
+func NewReader(r io.Reader, key []byte) (*StreamReader, error) {
+	br := bufio.NewReaderSize(r, ChunkSize)
+	header, err := ReadHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	if !header.Stream {
+		return nil, errors.New("encrypted data is not a stream")
+	}
+	if header.KDF != nil {
+		return nil, errors.New("encrypted stream should be encrypted with raw key")
+	}
+	salt := make([]byte, StreamSaltSize)
+	if _, err := io.ReadFull(br, salt); err != nil {
+		return nil, ErrStream
+	}
+	aead, err := streamAEAD(header.Cipher, key, salt)
+	if err != nil {
+		return nil, err
+	}
+	var ad []byte
+	if header.Version >= HeaderVersionAD {
+		ad, err = header.Marshal()
+		if err != nil {
+			return nil, err
+		}
+	}
+	sr := &StreamReader{
+		r:     br,
+		aead:  aead,
+		nonce: make([]byte, aead.NonceSize()),
+		ad:    ad,
+		buf:   make([]byte, ChunkSize+aead.Overhead()),
+	}
+	return sr, nil
+}
+
+// Read reads decrypted data of the stream
+func (s *StreamReader) Read(p []byte) (int, error) {
+	for len(s.plain) == 0 {
+		if s.err != nil {
+			return 0, s.err
+		}
+		if s.done {
+			return 0, io.EOF
+		}
+		s.err = s.readChunk()
+	}
+	n := copy(p, s.plain)
+	s.plain = s.plain[n:]
+	return n, nil
+}
+
+// helper function to read and decrypt next chunk of the stream
+func (s *StreamReader) readChunk() error {
+	n, err := io.ReadFull(s.r, s.buf)
+	last := false
+	switch err {
+	case nil:
+		// full chunk is the last one if nothing follows it
+		if _, err := s.r.Peek(1); err == io.EOF {
+			last = true
+		} else if err != nil {
+			return err
+		}
+	case io.ErrUnexpectedEOF:
+		last = true
+	case io.EOF:
+		// stream ended without final chunk
+		return ErrStream
+	default:
+		return err
+	}
+	streamNonce(s.nonce, s.counter, last)
+	plain, err := s.aead.Open(s.buf[:0], s.nonce, s.buf[:n], s.ad)
+	if err != nil {
+		return ErrStream
+	}
+	s.counter++
+	s.plain = plain
+	s.done = last
+	return nil
+}
@@ -0,0 +1,833 @@
+package crypt
+
+// strength module provides password strength estimation in the spirit of
+// zxcvbn, see https://github.com/dropbox/zxcvbn
+// The password is split into the sequence of matches, e.g. dictionary words,
+// keyboard patterns, repeats, sequences and dates, and every match provides
+// estimated number of guesses needed to find it. The sequence of matches
+// which requires the least number of guesses defines password strength.
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// MinStrengthScore defines minimal score of password which is not weak
+const MinStrengthScore = 3
+
+// GuessRate defines number of guesses per second used to estimate crack
+// time, it corresponds to offline attack on slow password hashing
+const GuessRate = 1e4
+
+// maximum password length which is analyzed by strength estimator
+const maxStrengthLength = 100
+
+// rank of l33t word which is not found in embedded dictionaries, it
+// corresponds to size of english frequency list used by zxcvbn
+const l33tWordRank = 30000
+
+// minimal length of l33t word which is not found in embedded dictionaries
+const l33tWordLength = 5
+
+// commonPasswords keeps most common passwords ordered by their popularity
+var commonPasswords = []string{
+	"123456", "password", "12345678", "qwerty", "123456789", "12345", "1234",
+	"111111", "1234567", "dragon", "123123", "baseball", "abc123", "football",
+	"monkey", "letmein", "696969", "shadow", "master", "666666", "qwertyuiop",
+	"123321", "mustang", "1234567890", "michael", "654321", "superman",
+	"1qaz2wsx", "7777777", "121212", "000000", "qazwsx", "123qwe", "killer",
+	"trustno1", "jordan", "jennifer", "zxcvbnm", "asdfgh", "hunter", "buster",
+	"soccer", "harley", "batman", "andrew", "tigger", "sunshine", "iloveyou",
+	"2000", "charlie", "robert", "thomas", "hockey", "ranger", "daniel",
+	"starwars", "klaster", "112233", "george", "computer", "michelle",
+	"jessica", "pepper", "1111", "zxcvbn", "555555", "11111111", "131313",
+	"freedom", "777777", "pass", "maggie", "159753", "aaaaaa", "ginger",
+	"princess", "joshua", "cheese", "amanda", "summer", "love", "ashley",
+	"6969", "nicole", "chelsea", "biteme", "matthew", "access", "yankees",
+	"987654321", "dallas", "austin", "thunder", "taylor", "matrix", "admin",
+	"welcome", "login", "passw0rd", "password1", "qwerty123", "secret",
+	"changeme", "default", "guest", "root", "test", "temp", "hello",
+	"whatever", "flower", "hottie", "loveme", "zaq1zaq1", "solo",
+}
+
+// l33t substitutions used by dictionary matching
+var l33tTable = map[rune][]rune{
+	'4': {'a'}, '@': {'a'}, '8': {'b'}, '(': {'c'}, '{': {'c'}, '[': {'c'},
+	'3': {'e'}, '6': {'g'}, '9': {'g'}, '1': {'i', 'l'}, '!': {'i'}, '|': {'i', 'l'},
+	'0': {'o'}, '$': {'s'}, '5': {'s'}, '7': {'t'}, '+': {'t'}, '%': {'x'}, '2': {'z'},
+}
+
+// keyboard rows used by spatial matching, shifted characters are mapped to
+// their unshifted keys
+var (
+	keyboardRows = []string{"`1234567890-=", "qwertyuiop[]\\", "asdfghjkl;'", "zxcvbnm,./"}
+	keyboardKeys = "~!@#$%^&*()_+{}|:\"<>?"
+	keyboardBase = "`1234567890-=[]\\;',./"
+)
+
+// Strength represents estimated strength of a password
+type Strength struct {
+	Score       int           // score from 0 (too guessable) to 4 (very unguessable)
+	Guesses     float64       // estimated number of guesses needed to find the password
+	CrackTime   time.Duration // estimated time to crack the password, see GuessRate
+	Warning     string        // warning about the weakest part of the password
+	Suggestions []string      // suggestions how to make the password stronger
+}
+
+// strengthMatch represents part of the password recognized by a matcher
+type strengthMatch struct {
+	Start, End int     // match position in password, end is exclusive
+	Log10      float64 // log10 of number of guesses
+	Kind       string  // match kind
+	Token      string  // matched token
+	Rank       int     // rank of dictionary word
+	L33t       bool    // dictionary match uses l33t substitutions
+	Reversed   bool    // dictionary match is reversed
+	Upper      bool    // dictionary match uses upper case letters
+	Common     bool    // dictionary match is common password
+	Input      bool    // dictionary match is one of user inputs
+}
+
+// EstimateStrength estimates strength of given password, optional inputs,
+// e.g. record name or login, are considered as easy to guess words
+func EstimateStrength(password string, inputs ...string) *Strength {
+	runes := []rune(password)
+	if len(runes) > maxStrengthLength {
+		runes = runes[:maxStrengthLength]
+	}
+	dicts := strengthDicts(inputs)
+	var matches []strengthMatch
+	matches = append(matches, dictMatches(runes, dicts)...)
+	matches = append(matches, spatialMatches(runes)...)
+	matches = append(matches, repeatMatches(runes)...)
+	matches = append(matches, sequenceMatches(runes)...)
+	matches = append(matches, dateMatches(runes)...)
+	log10, sequence := mostGuessable(runes, matches)
+
+	guesses := math.Pow(10, log10)
+	s := &Strength{Guesses: guesses}
+	seconds := guesses / GuessRate
+	if seconds*float64(time.Second) >= float64(math.MaxInt64) {
+		s.CrackTime = time.Duration(math.MaxInt64)
+	} else {
+		s.CrackTime = time.Duration(seconds * float64(time.Second))
+	}
+	switch {
+	case log10 < 3:
+		s.Score = 0
+	case log10 < 6:
+		s.Score = 1
+	case log10 < 8:
+		s.Score = 2
+	case log10 < 10:
+		s.Score = 3
+	default:
+		s.Score = 4
+	}
+	s.feedback(sequence)
+	return s
+}
+
+// Weak checks if password strength is below MinStrengthScore
+func (s *Strength) Weak() bool {
+	return s.Score < MinStrengthScore
+}
+
+// CrackTimeString provides human readable estimate of crack time
+func (s *Strength) CrackTimeString() string {
+	seconds := s.Guesses / GuessRate
+	units := []struct {
+		name    string
+		seconds float64
+	}{
+		{"year", 365 * 24 * 3600},
+		{"month", 31 * 24 * 3600},
+		{"day", 24 * 3600},
+		{"hour", 3600},
+		{"minute", 60},
+		{"second", 1},
+	}
+	if seconds < 1 {
+		return "less than a second"
+	}
+	if seconds > 100*units[0].seconds {
+		return "centuries"
+	}
+	for _, u := range units {
+		if seconds >= u.seconds {
+			n := int(math.Round(seconds / u.seconds))
+			if n == 1 {
+				return fmt.Sprintf("1 %s", u.name)
+			}
+			return fmt.Sprintf("%d %ss", n, u.name)
+		}
+	}
+	return "less than a second"
+}
+
+// Feedback provides human readable feedback about password strength
+func (s *Strength) Feedback() string {
+	out := fmt.Sprintf("strength %d/4, estimated crack time %s", s.Score, s.CrackTimeString())
+	if s.Warning != "" {
+		out += "\n" + s.Warning
+	}
+	for _, msg := range s.Suggestions {
+		out += "\n- " + msg
+	}
+	return out
+}
+
+// helper function to build feedback from the sequence of matches
+func (s *Strength) feedback(sequence []strengthMatch) {
+	if len(sequence) == 0 {
+		s.Warning = "Password is empty"
+		s.Suggestions = []string{"Use a few words, avoid common phrases", "No need for symbols, digits, or uppercase letters"}
+		return
+	}
+	if !s.Weak() {
+		return
+	}
+	s.Suggestions = []string{"Add another word or two, uncommon words are better"}
+	// feedback is based on the longest match
+	longest := sequence[0]
+	for _, m := range sequence {
+		if m.End-m.Start > longest.End-longest.Start {
+			longest = m
+		}
+	}
+	switch longest.Kind {
+	case "dictionary":
+		switch {
+		case longest.Input:
+			s.Warning = "Passwords based on record name, login or URL are easy to guess"
+		case longest.Common && longest.Rank <= 10:
+			s.Warning = "This is a top-10 common password"
+		case longest.Common:
+			s.Warning = "This is a very common password"
+		case len(sequence) == 1:
+			s.Warning = "A word by itself is easy to guess"
+		}
+		if longest.Upper {
+			s.Suggestions = append(s.Suggestions, "Capitalization doesn't help very much")
+		}
+		if longest.Reversed {
+			s.Suggestions = append(s.Suggestions, "Reversed words aren't much harder to guess")
+		}
+		if longest.L33t {
+			s.Suggestions = append(s.Suggestions, "Predictable substitutions like '@' instead of 'a' don't help very much")
+		}
+	case "spatial":
+		s.Warning = "Keyboard patterns like qwerty are easy to guess"
+		s.Suggestions = append(s.Suggestions, "Use a longer keyboard pattern with more turns")
+	case "repeat":
+		s.Warning = "Repeats like \"aaa\" or \"abcabc\" are easy to guess"
+		s.Suggestions = append(s.Suggestions, "Avoid repeated words and characters")
+	case "sequence":
+		s.Warning = "Sequences like abc or 6543 are easy to guess"
+		s.Suggestions = append(s.Suggestions, "Avoid sequences")
+	case "date":
+		s.Warning = "Dates and years are often easy to guess"
+		s.Suggestions = append(s.Suggestions, "Avoid dates and years that are associated with you")
+	}
+}
+
+// static dictionaries of strength estimator, they are built once
+var (
+	baseDicts     map[string]map[string]int
+	baseDictsOnce sync.Once
+)
+
+// helper function to build dictionaries of strength estimator, every
+// dictionary maps lower case word to its rank
+func strengthDicts(inputs []string) map[string]map[string]int {
+	baseDictsOnce.Do(func() {
+		baseDicts = make(map[string]map[string]int)
+		common := make(map[string]int)
+		for i, w := range commonPasswords {
+			if _, ok := common[w]; !ok {
+				common[w] = i + 1
+			}
+		}
+		baseDicts["common"] = common
+		words := make(map[string]int)
+		for i, w := range wordlist {
+			words[w] = i + 1
+		}
+		baseDicts["words"] = words
+	})
+	dicts := make(map[string]map[string]int)
+	for name, dict := range baseDicts {
+		dicts[name] = dict
+	}
+	user := make(map[string]int)
+	for _, input := range inputs {
+		for _, w := range strings.FieldsFunc(strings.ToLower(input), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}) {
+			if _, ok := user[w]; !ok && len(w) > 2 {
+				user[w] = len(user) + 1
+			}
+		}
+	}
+	dicts["user"] = user
+	return dicts
+}
+
+// helper function to find dictionary matches, including reversed and l33t ones
+func dictMatches(runes []rune, dicts map[string]map[string]int) []strengthMatch {
+	var matches []strengthMatch
+	n := len(runes)
+	for i := 0; i < n; i++ {
+		for j := i + 3; j <= n; j++ {
+			token := string(runes[i:j])
+			lower := strings.ToLower(token)
+			candidates := []string{lower}
+			candidates = append(candidates, unl33t(lower)...)
+			found := false
+			for idx, word := range candidates {
+				for _, reversed := range []bool{false, true} {
+					w := word
+					if reversed {
+						w = reverseString(word)
+						if w == word {
+							continue
+						}
+					}
+					for name, dict := range dicts {
+						rank, ok := dict[w]
+						if !ok {
+							continue
+						}
+						m := strengthMatch{
+							Start: i, End: j, Kind: "dictionary", Token: token, Rank: rank,
+							L33t: idx > 0, Reversed: reversed, Common: name == "common", Input: name == "user",
+						}
+						guesses := float64(rank)
+						upper := upperVariations(token)
+						m.Upper = upper > 1
+						guesses *= upper
+						if m.L33t {
+							guesses *= l33tVariations(lower)
+						}
+						if reversed {
+							guesses *= 2
+						}
+						m.Log10 = math.Log10(math.Max(guesses, 50))
+						matches = append(matches, m)
+						found = true
+					}
+				}
+			}
+			// l33t token which looks like a word is guessable even if the
+			// word is not in embedded dictionaries, e.g. Tr0ub4dor
+			if !found && len(candidates) > 1 && j-i >= l33tWordLength {
+				for _, word := range candidates[1:] {
+					if !pronounceable(word) {
+						continue
+					}
+					m := strengthMatch{
+						Start: i, End: j, Kind: "dictionary", Token: token, Rank: l33tWordRank, L33t: true,
+					}
+					upper := upperVariations(token)
+					m.Upper = upper > 1
+					guesses := float64(l33tWordRank) * upper * l33tVariations(lower)
+					m.Log10 = math.Log10(guesses)
+					matches = append(matches, m)
+					break
+				}
+			}
+		}
+	}
+	return matches
+}
+
+// helper function to check if given word is pronounceable, i.e. it consists
+// of letters with vowels and without long runs of vowels or consonants
+func pronounceable(word string) bool {
+	var vowels, consonants, nvowels int
+	for _, r := range word {
+		if r < 'a' || r > 'z' {
+			return false
+		}
+		if strings.ContainsRune("aeiouy", r) {
+			vowels, consonants = vowels+1, 0
+			nvowels++
+		} else {
+			vowels, consonants = 0, consonants+1
+		}
+		if vowels > 2 || consonants > 3 {
+			return false
+		}
+	}
+	return nvowels > 0
+}
+
+// helper function to provide possible translations of l33t token
+func unl33t(token string) []string {
+	out := []string{""}
+	changed := false
+	for _, r := range token {
+		subs, ok := l33tTable[r]
+		if !ok {
+			subs = []rune{r}
+		} else {
+			changed = true
+		}
+		var next []string
+		for _, prefix := range out {
+			for _, s := range subs {
+				next = append(next, prefix+string(s))
+			}
+		}
+		// limit number of translations
+		if len(next) > 16 {
+			next = next[:16]
+		}
+		out = next
+	}
+	if !changed {
+		return nil
+	}
+	return out
+}
+
+// helper function to estimate number of upper case variations of token
+func upperVariations(token string) float64 {
+	var upper, lower int
+	for _, r := range token {
+		if unicode.IsUpper(r) {
+			upper++
+		} else if unicode.IsLower(r) {
+			lower++
+		}
+	}
+	if upper == 0 {
+		return 1
+	}
+	runes := []rune(token)
+	first, last := unicode.IsUpper(runes[0]), unicode.IsUpper(runes[len(runes)-1])
+	if lower == 0 || (upper == 1 && (first || last)) {
+		return 2
+	}
+	var variations float64
+	for k := 1; k <= upper && k <= lower; k++ {
+		variations += binomial(upper+lower, k)
+	}
+	return math.Max(variations, 2)
+}
+
+// helper function to estimate number of l33t variations of token
+func l33tVariations(token string) float64 {
+	var subs int
+	for _, r := range token {
+		if _, ok := l33tTable[r]; ok {
+			subs++
+		}
+	}
+	return math.Max(math.Pow(2, float64(subs)), 2)
+}
+
+// helper function to find keyboard patterns, e.g. qwerty or zxcvbn
+func spatialMatches(runes []rune) []strengthMatch {
+	var matches []strengthMatch
+	n := len(runes)
+	i := 0
+	for i < n-2 {
+		j := i + 1
+		turns := 0
+		lastDir := -1
+		for j < n {
+			dir := keyDirection(runes[j-1], runes[j])
+			if dir < 0 {
+				break
+			}
+			if dir != lastDir {
+				turns++
+				lastDir = dir
+			}
+			j++
+		}
+		if j-i >= 3 {
+			length := float64(j - i)
+			// starting positions * possible turns of given length
+			guesses := 47 * length * math.Pow(4, float64(turns))
+			token := string(runes[i:j])
+			guesses *= upperVariations(token)
+			matches = append(matches, strengthMatch{
+				Start: i, End: j, Kind: "spatial", Token: token, Log10: math.Log10(guesses),
+			})
+			i = j - 1
+			continue
+		}
+		i++
+	}
+	return matches
+}
+
+// helper function to get position of given key on the keyboard
+func keyPosition(r rune) (int, int, bool) {
+	r = unicode.ToLower(r)
+	if idx := strings.IndexRune(keyboardKeys, r); idx >= 0 {
+		// both strings consist of ASCII characters only
+		r = rune(keyboardBase[idx])
+	}
+	for row, keys := range keyboardRows {
+		if col := strings.IndexRune(keys, r); col >= 0 {
+			return row, col, true
+		}
+	}
+	return 0, 0, false
+}
+
+// helper function to get direction between two adjacent keys, it returns
+// -1 if keys are not adjacent on the keyboard
+func keyDirection(a, b rune) int {
+	ra, ca, ok := keyPosition(a)
+	if !ok {
+		return -1
+	}
+	rb, cb, ok := keyPosition(b)
+	if !ok {
+		return -1
+	}
+	// keyboard rows are shifted by half key, therefore key has two
+	// neighbors in the row above and below it
+	switch {
+	case ra == rb && cb == ca+1:
+		return 0
+	case ra == rb && cb == ca-1:
+		return 1
+	case rb == ra-1 && (cb == ca || cb == ca+1):
+		return 2
+	case rb == ra+1 && (cb == ca || cb == ca-1):
+		return 3
+	}
+	return -1
+}
+
+// helper function to find repeated characters or repeated substrings
+func repeatMatches(runes []rune) []strengthMatch {
+	var matches []strengthMatch
+	n := len(runes)
+	for i := 0; i < n; i++ {
+		for size := 1; size <= (n-i)/2; size++ {
+			base := string(runes[i : i+size])
+			j := i + size
+			count := 1
+			for j+size <= n && string(runes[j:j+size]) == base {
+				j += size
+				count++
+			}
+			if count < 2 || (size == 1 && count < 3) {
+				continue
+			}
+			guesses := bruteforceLog10([]rune(base)) + math.Log10(float64(count))
+			matches = append(matches, strengthMatch{
+				Start: i, End: j, Kind: "repeat", Token: string(runes[i:j]), Log10: guesses,
+			})
+		}
+	}
+	return matches
+}
+
+// helper function to find sequences like abcd, 97531 or zyx
+func sequenceMatches(runes []rune) []strengthMatch {
+	var matches []strengthMatch
+	n := len(runes)
+	i := 0
+	for i < n-2 {
+		delta := int(runes[i+1]) - int(runes[i])
+		if delta == 0 || delta > 5 || delta < -5 || !sameClass(runes[i], runes[i+1]) {
+			i++
+			continue
+		}
+		j := i + 2
+		for j < n && int(runes[j])-int(runes[j-1]) == delta && sameClass(runes[j-1], runes[j]) {
+			j++
+		}
+		if j-i >= 3 {
+			first := runes[i]
+			var base float64
+			switch {
+			case strings.ContainsRune("aAzZ019", first):
+				base = 4
+			case unicode.IsDigit(first):
+				base = 10
+			default:
+				base = 26
+			}
+			if delta < 0 {
+				base *= 2
+			}
+			guesses := base * float64(j-i)
+			matches = append(matches, strengthMatch{
+				Start: i, End: j, Kind: "sequence", Token: string(runes[i:j]), Log10: math.Log10(guesses),
+			})
+			i = j - 1
+			continue
+		}
+		i++
+	}
+	return matches
+}
+
+// helper function to check if two runes belong to the same character class
+func sameClass(a, b rune) bool {
+	switch {
+	case unicode.IsDigit(a):
+		return unicode.IsDigit(b)
+	case unicode.IsLower(a):
+		return unicode.IsLower(b)
+	case unicode.IsUpper(a):
+		return unicode.IsUpper(b)
+	}
+	return false
+}
+
+// helper function to find years and dates, e.g. 1987, 13/05/1999 or 19990513
+func dateMatches(runes []rune) []strengthMatch {
+	var matches []strengthMatch
+	n := len(runes)
+	ref := time.Now().Year()
+	for i := 0; i < n; i++ {
+		for j := i + 4; j <= n && j <= i+10; j++ {
+			token := string(runes[i:j])
+			var yearSpace float64
+			separator := false
+			if year, err := strconv.Atoi(token); err == nil && len(token) == 4 {
+				if year < 1900 || year > 2099 {
+					continue
+				}
+				yearSpace = math.Max(math.Abs(float64(year-ref)), 20)
+				matches = append(matches, strengthMatch{
+					Start: i, End: j, Kind: "date", Token: token, Log10: math.Log10(yearSpace),
+				})
+				continue
+			}
+			year, ok := parseDate(token)
+			if !ok {
+				continue
+			}
+			if strings.ContainsAny(token, "/-._ ") {
+				separator = true
+			}
+			yearSpace = math.Max(math.Abs(float64(year-ref)), 20)
+			guesses := yearSpace * 365
+			if separator {
+				guesses *= 4
+			}
+			matches = append(matches, strengthMatch{
+				Start: i, End: j, Kind: "date", Token: token, Log10: math.Log10(guesses),
+			})
+		}
+	}
+	return matches
+}
+
+// helper function to parse date token in day-month-year, month-day-year or
+// year-month-day order with optional separators, it returns year of the date
+func parseDate(token string) (int, bool) {
+	var parts []string
+	sep := strings.IndexAny(token, "/-._ ")
+	if sep >= 0 {
+		parts = strings.FieldsFunc(token, func(r rune) bool {
+			return strings.ContainsRune("/-._ ", r)
+		})
+		if len(parts) != 3 {
+			return 0, false
+		}
+	} else {
+		// split digits only tokens, e.g. 13051999 or 19990513
+		switch len(token) {
+		case 6:
+			parts = []string{token[:2], token[2:4], token[4:]}
+		case 8:
+			if y, err := strconv.Atoi(token[:4]); err == nil && y >= 1900 && y <= 2099 {
+				parts = []string{token[:4], token[4:6], token[6:]}
+			} else {
+				parts = []string{token[:2], token[2:4], token[4:]}
+			}
+		default:
+			return 0, false
+		}
+	}
+	var vals []int
+	for _, p := range parts {
+		v, err := strconv.Atoi(p)
+		if err != nil {
+			return 0, false
+		}
+		vals = append(vals, v)
+	}
+	// try year at the end and at the beginning
+	for _, order := range [][3]int{{2, 1, 0}, {2, 0, 1}, {0, 1, 2}} {
+		year, month, day := vals[order[0]], vals[order[1]], vals[order[2]]
+		if len(parts[order[0]]) == 2 {
+			if year > 50 {
+				year += 1900
+			} else {
+				year += 2000
+			}
+		} else if len(parts[order[0]]) != 4 {
+			continue
+		}
+		if year >= 1900 && year <= 2099 && month >= 1 && month <= 12 && day >= 1 && day <= 31 {
+			return year, true
+		}
+	}
+	return 0, false
+}
+
+// helper function to estimate log10 of guesses of bruteforce attack
+func bruteforceLog10(runes []rune) float64 {
+	return float64(len(runes)) * math.Log10(bruteforceCardinality(runes))
+}
+
+// helper function to get cardinality of characters used in given runes
+func bruteforceCardinality(runes []rune) float64 {
+	var lower, upper, digit, symbol, other bool
+	for _, r := range runes {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < 128:
+			symbol = true
+		default:
+			other = true
+		}
+	}
+	var card float64
+	if lower {
+		card += 26
+	}
+	if upper {
+		card += 26
+	}
+	if digit {
+		card += 10
+	}
+	if symbol {
+		card += 33
+	}
+	if other {
+		card += 100
+	}
+	return math.Max(card, 10)
+}
+
+// helper function to find sequence of matches which covers the password
+// with the least number of guesses, uncovered parts of the password are
+// considered as bruteforce matches. It returns log10 of number of guesses
+// along with the sequence of matches
+func mostGuessable(runes []rune, matches []strengthMatch) (float64, []strengthMatch) {
+	n := len(runes)
+	if n == 0 {
+		return 0, nil
+	}
+	byEnd := make(map[int][]strengthMatch)
+	for _, m := range matches {
+		byEnd[m.End] = append(byEnd[m.End], m)
+	}
+	// best[k][i] is minimal log10 of product of guesses of k matches
+	// covering first i characters of the password
+	inf := math.Inf(1)
+	best := make([][]float64, n+1)
+	back := make([][]strengthMatch, n+1)
+	for k := range best {
+		best[k] = make([]float64, n+1)
+		back[k] = make([]strengthMatch, n+1)
+		for i := range best[k] {
+			best[k][i] = inf
+		}
+	}
+	best[0][0] = 0
+	for i := 1; i <= n; i++ {
+		candidates := append([]strengthMatch{}, byEnd[i]...)
+		for j := 0; j < i; j++ {
+			bf := bruteforceLog10(runes[j:i])
+			candidates = append(candidates, strengthMatch{
+				Start: j, End: i, Kind: "bruteforce", Token: string(runes[j:i]), Log10: bf,
+			})
+		}
+		for _, m := range candidates {
+			for k := 1; k <= i; k++ {
+				prev := best[k-1][m.Start]
+				if prev == inf {
+					continue
+				}
+				// adjacent bruteforce matches are merged into single one
+				if m.Kind == "bruteforce" && k > 1 && back[k-1][m.Start].Kind == "bruteforce" {
+					continue
+				}
+				if v := prev + m.Log10; v < best[k][i] {
+					best[k][i] = v
+					back[k][i] = m
+				}
+			}
+		}
+	}
+	// total guesses are k! * product of guesses plus penalty for every
+	// additional match
+	total, bestK := inf, 0
+	for k := 1; k <= n; k++ {
+		if best[k][n] == inf {
+			continue
+		}
+		v := logFactorial(k) + best[k][n]
+		v = logAdd(v, 4*float64(k-1))
+		if v < total {
+			total, bestK = v, k
+		}
+	}
+	var sequence []strengthMatch
+	for k, i := bestK, n; k > 0; k-- {
+		m := back[k][i]
+		sequence = append([]strengthMatch{m}, sequence...)
+		i = m.Start
+	}
+	return total, sequence
+}
+
+// helper function to calculate log10 of k!
+func logFactorial(k int) float64 {
+	var v float64
+	for i := 2; i <= k; i++ {
+		v += math.Log10(float64(i))
+	}
+	return v
+}
+
+// helper function to calculate log10(10^a + 10^b)
+func logAdd(a, b float64) float64 {
+	if a < b {
+		a, b = b, a
+	}
+	return a + math.Log10(1+math.Pow(10, b-a))
+}
+
+// helper function to calculate binomial coefficient
+func binomial(n, k int) float64 {
+	v := 1.0
+	for i := 1; i <= k; i++ {
+		v *= float64(n-k+i) / float64(i)
+	}
+	return v
+}
+
+// helper function to reverse given string
+func reverseString(s string) string {
+	runes := []rune(s)
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		runes[i], runes[j] = runes[j], runes[i]
+	}
+	return string(runes)
+}
@@ -0,0 +1,176 @@
+package crypt
+
+// totp module provides time-based one-time passwords according to
+// RFC 6238, see https://www.rfc-editor.org/rfc/rfc6238
+// TOTP seeds can be provided either as otpauth:// URIs, see
+// https://github.com/google/google-authenticator/wiki/Key-Uri-Format
+// or as plain base32 encoded secrets.
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TOTP default parameters
+const (
+	TOTPDigits    = 6
+	TOTPPeriod    = 30
+	TOTPAlgorithm = "SHA1"
+)
+
+// TOTP represents time-based one-time password generator
+type TOTP struct {
+	Secret    []byte // shared secret
+	Algorithm string // HMAC algorithm: SHA1, SHA256 or SHA512
+	Digits    int    // number of code digits
+	Period    int    // code validity period in seconds
+	Issuer    string // optional issuer of the secret
+	Account   string // optional account name
+}
+
+// ParseTOTP parses TOTP seed given either as otpauth:// URI or as base32
+// encoded secret
+func ParseTOTP(seed string) (*TOTP, error) {
+	seed = strings.TrimSpace(seed)
+	if seed == "" {
+		return nil, errors.New("empty TOTP seed")
+	}
+	if !strings.HasPrefix(strings.ToLower(seed), "otpauth://") {
+		secret, err := decodeBase32(seed)
+		if err != nil {
+			return nil, err
+		}
+		return &TOTP{Secret: secret, Algorithm: TOTPAlgorithm, Digits: TOTPDigits, Period: TOTPPeriod}, nil
+	}
+	uri, err := url.Parse(seed)
+	if err != nil {
+		return nil, err
+	}
+	if strings.ToLower(uri.Host) != "totp" {
+		return nil, fmt.Errorf("unsupported otpauth type '%s'", uri.Host)
+	}
+	query := uri.Query()
+	secret, err := decodeBase32(query.Get("secret"))
+	if err != nil {
+		return nil, err
+	}
+	totp := &TOTP{Secret: secret, Algorithm: TOTPAlgorithm, Digits: TOTPDigits, Period: TOTPPeriod}
+	if val := query.Get("algorithm"); val != "" {
+		totp.Algorithm = strings.ToUpper(val)
+	}
+	if val := query.Get("digits"); val != "" {
+		if totp.Digits, err = strconv.Atoi(val); err != nil {
+			return nil, fmt.Errorf("invalid TOTP digits '%s'", val)
+		}
+	}
+	if val := query.Get("period"); val != "" {
+		if totp.Period, err = strconv.Atoi(val); err != nil {
+			return nil, fmt.Errorf("invalid TOTP period '%s'", val)
+		}
+	}
+	// label has issuer:account form where issuer is optional
+	label := strings.TrimPrefix(uri.Path, "/")
+	if arr := strings.SplitN(label, ":", 2); len(arr) == 2 {
+		totp.Issuer = strings.TrimSpace(arr[0])
+		totp.Account = strings.TrimSpace(arr[1])
+	} else {
+		totp.Account = label
+	}
+	if val := query.Get("issuer"); val != "" {
+		totp.Issuer = val
+	}
+	if err := totp.validate(); err != nil {
+		return nil, err
+	}
+	return totp, nil
+}
+
+// helper function to validate TOTP parameters
+func (t *TOTP) validate() error {
+	if _, err := t.hash(); err != nil {
+		return err
+	}
+	if t.Digits < 6 || t.Digits > 10 {
+		return fmt.Errorf("unsupported number of TOTP digits %d", t.Digits)
+	}
+	if t.Period <= 0 {
+		return fmt.Errorf("invalid TOTP period %d", t.Period)
+	}
+	return nil
+}
+
+// helper function to get hash function of TOTP algorithm
+func (t *TOTP) hash() (func() hash.Hash, error) {
+	switch strings.ToUpper(t.Algorithm) {
+	case "", "SHA1":
+		return sha1.New, nil
+	case "SHA256":
+		return sha256.New, nil
+	case "SHA512":
+		return sha512.New, nil
+	}
+	return nil, fmt.Errorf("unsupported TOTP algorithm '%s'", t.Algorithm)
+}
+
+// Code provides TOTP code for given time along with its remaining validity
+func (t *TOTP) Code(now time.Time) (string, time.Duration, error) {
+	if err := t.validate(); err != nil {
+		return "", 0, err
+	}
+	period := int64(t.Period)
+	counter := now.Unix() / period
+	code, err := t.HOTP(uint64(counter))
+	if err != nil {
+		return "", 0, err
+	}
+	next := time.Unix((counter+1)*period, 0)
+	return code, next.Sub(now), nil
+}
+
+// HOTP provides HMAC-based one-time password for given counter according
+// to RFC 4226, see https://www.rfc-editor.org/rfc/rfc4226
+func (t *TOTP) HOTP(counter uint64) (string, error) {
+	hfunc, err := t.hash()
+	if err != nil {
+		return "", err
+	}
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, counter)
+	mac := hmac.New(hfunc, t.Secret)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+	// dynamic truncation
+	offset := sum[len(sum)-1] & 0x0f
+	value := int64(binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff)
+	mod := int64(1)
+	for i := 0; i < t.Digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", t.Digits, value%mod), nil
+}
+
+// helper function to decode base32 secret, secrets are often provided in
+// lower case, with spaces and without padding
+func decodeBase32(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+	secret = strings.TrimRight(secret, "=")
+	if secret == "" {
+		return nil, errors.New("empty TOTP secret")
+	}
+	data, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base32 TOTP secret, error %v", err)
+	}
+	return data, nil
+}
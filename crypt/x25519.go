@@ -0,0 +1,273 @@
+package crypt
+
+// x25519 module provides public key encryption to a list of recipients in
+// the spirit of age tool, see https://age-encryption.org/v1
+// Every recipient gets a stanza which holds the file key wrapped by the key
+// derived from X25519 shared secret of ephemeral and recipient keys, while
+// the data itself is encrypted with the file key. The encrypted data has the
+// following layout:
+//
+//	magic (4 bytes) | stanzas size (4 bytes, big endian) | stanzas (JSON) | blob
+//
+// where blob is produced by EncryptWithKey using the file key
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// IdentityPrefix defines prefix of string representation of identity
+	IdentityPrefix = "ECM-SECRET-KEY-"
+	// RecipientPrefix defines prefix of string representation of recipient
+	RecipientPrefix = "ecmpub-"
+)
+
+// RecipientsMagic defines magic value which starts data encrypted to recipients
+var RecipientsMagic = []byte{'E', 'C', 'M', 'R'}
+
+// ErrNoIdentity is returned when data is not encrypted to given identity
+var ErrNoIdentity = errors.New("data is not encrypted to given identity")
+
+// keyEncoding defines encoding of identity and recipient keys
+var keyEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Identity represents X25519 private key used to decrypt data
+type Identity struct {
+	secret []byte
+	public []byte
+}
+
+// Recipient represents X25519 public key used to encrypt data
+type Recipient struct {
+	public []byte
+}
+
+// Stanza represents file key wrapped for a single recipient
+type Stanza struct {
+	Recipient  string // recipient public key
+	Ephemeral  []byte // ephemeral public key
+	WrappedKey []byte // file key encrypted with X25519 shared key
+}
+
+// GenerateIdentity creates new random identity
+func GenerateIdentity() (*Identity, error) {
+	secret := make([]byte, curve25519.ScalarSize)
+	if _, err := io.ReadFull(rand.Reader, secret); err != nil {
+		return nil, err
+	}
+	return newIdentity(secret)
+}
+
+// helper function to create identity from given secret key
+func newIdentity(secret []byte) (*Identity, error) {
+	public, err := curve25519.X25519(secret, curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{secret: secret, public: public}, nil
+}
+
+// ParseIdentity parses identity from its string representation
+func ParseIdentity(s string) (*Identity, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, IdentityPrefix) {
+		return nil, fmt.Errorf("identity should start with %s", IdentityPrefix)
+	}
+	secret, err := keyEncoding.DecodeString(strings.TrimPrefix(s, IdentityPrefix))
+	if err != nil || len(secret) != curve25519.ScalarSize {
+		return nil, errors.New("malformed identity")
+	}
+	return newIdentity(secret)
+}
+
+// ReadIdentity reads identity from given file, lines starting with # are
+// considered as comments
+func ReadIdentity(fname string) (*Identity, error) {
+	file, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		return ParseIdentity(line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("no identity found in %s", fname)
+}
+
+// WriteIdentity writes identity along with its recipient to given file
+func (i *Identity) WriteIdentity(fname string) error {
+	var buf bytes.Buffer
+	buf.WriteString(fmt.Sprintf("# created: %s\n", time.Now().Format(time.RFC3339)))
+	buf.WriteString(fmt.Sprintf("# public key: %s\n", i.Recipient().String()))
+	buf.WriteString(i.String() + "\n")
+	file, err := os.OpenFile(fname, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(buf.Bytes()); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// String provides string representation of identity
+func (i *Identity) String() string {
+	return IdentityPrefix + keyEncoding.EncodeToString(i.secret)
+}
+
+// Recipient provides recipient of given identity
+func (i *Identity) Recipient() *Recipient {
+	return &Recipient{public: i.public}
+}
+
+// ParseRecipient parses recipient from its string representation
+func ParseRecipient(s string) (*Recipient, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, RecipientPrefix) {
+		return nil, fmt.Errorf("recipient should start with %s", RecipientPrefix)
+	}
+	public, err := keyEncoding.DecodeString(strings.ToUpper(strings.TrimPrefix(s, RecipientPrefix)))
+	if err != nil || len(public) != curve25519.PointSize {
+		return nil, errors.New("malformed recipient")
+	}
+	return &Recipient{public: public}, nil
+}
+
+// String provides string representation of recipient
+func (r *Recipient) String() string {
+	return RecipientPrefix + strings.ToLower(keyEncoding.EncodeToString(r.public))
+}
+
+// helper function to derive wrapping key from X25519 shared secret
+func wrappingKey(shared, ephemeral, public []byte) ([]byte, error) {
+	salt := append(append([]byte{}, ephemeral...), public...)
+	key := make([]byte, KeySize)
+	kdf := hkdf.New(sha256.New, shared, salt, []byte("ecm x25519"))
+	if _, err := io.ReadFull(kdf, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// WrapKey wraps given file key for given recipient
+func WrapKey(fileKey []byte, r *Recipient) (*Stanza, error) {
+	ephSecret := make([]byte, curve25519.ScalarSize)
+	if _, err := io.ReadFull(rand.Reader, ephSecret); err != nil {
+		return nil, err
+	}
+	ephemeral, err := curve25519.X25519(ephSecret, curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := curve25519.X25519(ephSecret, r.public)
+	if err != nil {
+		return nil, err
+	}
+	key, err := wrappingKey(shared, ephemeral, r.public)
+	if err != nil {
+		return nil, err
+	}
+	wrappedKey, err := EncryptWithKey(fileKey, key, DefaultCipher)
+	if err != nil {
+		return nil, err
+	}
+	return &Stanza{Recipient: r.String(), Ephemeral: ephemeral, WrappedKey: wrappedKey}, nil
+}
+
+// UnwrapKey unwraps file key from given stanzas using identity
+func (i *Identity) UnwrapKey(stanzas []*Stanza) ([]byte, error) {
+	recipient := i.Recipient().String()
+	for _, s := range stanzas {
+		if s.Recipient != recipient {
+			continue
+		}
+		shared, err := curve25519.X25519(i.secret, s.Ephemeral)
+		if err != nil {
+			return nil, err
+		}
+		key, err := wrappingKey(shared, s.Ephemeral, i.public)
+		if err != nil {
+			return nil, err
+		}
+		return DecryptWithKey(s.WrappedKey, key, "")
+	}
+	return nil, ErrNoIdentity
+}
+
+// EncryptTo encrypts given data to given list of recipients
+func EncryptTo(data []byte, recipients []*Recipient, cipher string) ([]byte, error) {
+	if len(recipients) == 0 {
+		return nil, errors.New("no recipients provided")
+	}
+	fileKey, err := GenerateKey("")
+	if err != nil {
+		return nil, err
+	}
+	var stanzas []*Stanza
+	for _, r := range recipients {
+		s, err := WrapKey(fileKey[:], r)
+		if err != nil {
+			return nil, err
+		}
+		stanzas = append(stanzas, s)
+	}
+	sdata, err := json.Marshal(stanzas)
+	if err != nil {
+		return nil, err
+	}
+	blob, err := EncryptWithKey(data, fileKey[:], cipher)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(RecipientsMagic)+4, len(RecipientsMagic)+4+len(sdata)+len(blob))
+	copy(out, RecipientsMagic)
+	binary.BigEndian.PutUint32(out[len(RecipientsMagic):], uint32(len(sdata)))
+	out = append(out, sdata...)
+	return append(out, blob...), nil
+}
+
+// DecryptWith decrypts data encrypted to recipients using given identity
+func DecryptWith(data []byte, i *Identity) ([]byte, error) {
+	pos := len(RecipientsMagic)
+	if !bytes.HasPrefix(data, RecipientsMagic) || len(data) < pos+4 {
+		return nil, errors.New("data is not encrypted to recipients")
+	}
+	size := int(binary.BigEndian.Uint32(data[pos:]))
+	pos += 4
+	if len(data) < pos+size {
+		return nil, errors.New("truncated recipients data")
+	}
+	var stanzas []*Stanza
+	if err := json.Unmarshal(data[pos:pos+size], &stanzas); err != nil {
+		return nil, err
+	}
+	fileKey, err := i.UnwrapKey(stanzas)
+	if err != nil {
+		return nil, err
+	}
+	return DecryptWithKey(data[pos+size:], fileKey, "")
+}
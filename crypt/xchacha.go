@@ -0,0 +1,69 @@
+package crypt
+
+// xchacha module provides XChaCha20-Poly1305 cipher, for more information see
+// https://pkg.go.dev/golang.org/x/crypto/chacha20poly1305
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// register XChaCha20-Poly1305 cipher
+func init() {
+	RegisterCipher("xchacha", 3, &CipherXChaCha{})
+}
+
+// CipherXChaCha represents XChaCha20-Poly1305 Cipher
+type CipherXChaCha struct {
+}
+
+// AEAD provides XChaCha20-Poly1305 AEAD primitive for given encryption key
+func (c *CipherXChaCha) AEAD(key []byte) (cipher.AEAD, error) {
+	return chacha20poly1305.NewX(key)
+}
+
+// Encrypt implementation of XChaCha20-Poly1305 cipher using given encryption key
+func (c *CipherXChaCha) Encrypt(data, key []byte) ([]byte, error) {
+	return c.EncryptAD(data, key, nil)
+}
+
+// EncryptAD implementation of XChaCha20-Poly1305 cipher using given encryption
+// key and associated data
+func (c *CipherXChaCha) EncryptAD(data, key, ad []byte) ([]byte, error) {
+	aead, err := c.AEAD(key)
+	if err != nil {
+		return []byte{}, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(data)+aead.Overhead())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return []byte{}, err
+	}
+	return aead.Seal(nonce, nonce, data, ad), nil
+}
+
+// Decrypt implementation of XChaCha20-Poly1305 cipher using given encryption key
+func (c *CipherXChaCha) Decrypt(data, key []byte) ([]byte, error) {
+	return c.DecryptAD(data, key, nil)
+}
+
+// DecryptAD implementation of XChaCha20-Poly1305 cipher using given encryption
+// key and associated data
+func (c *CipherXChaCha) DecryptAD(data, key, ad []byte) ([]byte, error) {
+	aead, err := c.AEAD(key)
+	if err != nil {
+		return []byte{}, err
+	}
+	nonceSize := aead.NonceSize()
+	if len(data) < nonceSize+aead.Overhead() {
+		return []byte{}, ErrDecrypt
+	}
+	nonce, ciphertext := data[:nonceSize], data[nonceSize:]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, ad)
+	if err != nil {
+		return []byte{}, err
+	}
+	return plaintext, nil
+}
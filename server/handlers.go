@@ -1,10 +1,10 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	_ "embed"
 	"encoding/base32"
-	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -95,7 +95,7 @@ func VaultHandler(w http.ResponseWriter, r *http.Request) {
 		responseMsg(w, r, fmt.Sprintf("%v", err), "VaultHandler", http.StatusBadRequest)
 		return
 	}
-	vault := vt.Vault{Cipher: crypt.GetCipher(""), Secret: "", Directory: vdir}
+	vault := vt.Vault{Cipher: crypt.GetCipher(""), Directory: vdir}
 	files, err := vault.Files()
 	if err != nil {
 		responseMsg(w, r, err.Error(), "VaultHandler", http.StatusInternalServerError)
@@ -139,7 +139,9 @@ func VaultAuthHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusMethodNotAllowed)
 }
 
-// VaultRecordsHandler provides basic functionality of status response
+// VaultRecordsHandler provides encrypted vault records, the server does not
+// hold vault keys, therefore records are queried by clients once they are
+// decrypted
 func VaultRecordsHandler(w http.ResponseWriter, r *http.Request) {
 	// parse input parameters to identify if we need to construct id records
 	var idRecord bool
@@ -192,54 +194,24 @@ func VaultRecordsHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write(data)
 }
 
-// VaultRecordHandler provides basic functionality of status response
+// VaultRecordHandler provides encrypted vault record, content of record
+// attachments is uploaded as encrypted blob named by blob parameter
 func VaultRecordHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method == "POST" {
-		defer r.Body.Close()
-		body, err := io.ReadAll(r.Body)
-		if err != nil {
-			log.Println("unable to read body", err)
-		}
-		password := "test"
-		cipher := "aes"
-		data, err := crypt.Decrypt(body, password, cipher)
-		if err != nil {
-			log.Println("unable to decrypt", err)
-		}
-		var rec vt.VaultRecord
-		err = json.Unmarshal(data, &rec)
-		if err != nil {
-			log.Println("unable to unmarshal", err, "received data:", string(data))
-		}
-		tags, _ := rec.Map["Tags"]
-		if tags == "file" {
-			// TMP: write file
-			name, _ := rec.Map["Name"]
-			fname := fmt.Sprintf("/tmp/%s", name)
-			file, err := os.Create(fname)
-			if err != nil {
-				log.Fatal(err)
-			}
-			defer file.Close()
-			data, _ := rec.Map["Data"] // is a hex string
-			raw, err := hex.DecodeString(data)
-			if err != nil {
-				log.Fatal(err)
-			}
-			err = os.WriteFile(fname, []byte(raw), 0755)
-			if err != nil {
-				log.Fatal(err)
-			}
-		} else {
-			log.Println("received", rec)
-		}
-		return
-	}
 	vdir, err := getVault(r)
 	if err != nil {
 		responseMsg(w, r, fmt.Sprintf("%v", err), "VaultRecordHandler", http.StatusBadRequest)
 		return
 	}
+	if r.Method == "POST" {
+		name := r.FormValue("blob")
+		if name == "" {
+			responseMsg(w, r, "blob parameter is not provided", "VaultRecordHandler", http.StatusBadRequest)
+			return
+		}
+		vault := vt.Vault{Directory: vdir}
+		writeBlob(w, r, &vault, name, "VaultRecordHandler")
+		return
+	}
 	log.Println("vault", vdir)
 	rid, err := getVaultRecord(r)
 	if err != nil {
@@ -252,14 +224,192 @@ func VaultRecordHandler(w http.ResponseWriter, r *http.Request) {
 		responseMsg(w, r, fmt.Sprintf("%v", err), "VaultRecordHandler", http.StatusBadRequest)
 		return
 	}
-	data, err := os.ReadFile(fname)
+	file, err := os.Open(fname)
 	if err != nil {
 		responseMsg(w, r, fmt.Sprintf("%v", err), "VaultRecordHandler", http.StatusBadRequest)
 		return
 	}
+	defer file.Close()
+	// stream file content to avoid loading large files into memory
+	if _, err := io.Copy(w, file); err != nil {
+		log.Println("unable to write", fname, err)
+	}
+}
+
+// VaultHistoryHandler provides list of versions of vault record
+func VaultHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	vdir, err := getVault(r)
+	if err != nil {
+		responseMsg(w, r, fmt.Sprintf("%v", err), "VaultHistoryHandler", http.StatusBadRequest)
+		return
+	}
+	rid, err := getVaultRecord(r)
+	if err != nil {
+		responseMsg(w, r, fmt.Sprintf("%v", err), "VaultHistoryHandler", http.StatusBadRequest)
+		return
+	}
+	vault := vt.Vault{Directory: vdir}
+	versions, err := vault.History(rid)
+	if err != nil {
+		responseMsg(w, r, fmt.Sprintf("%v", err), "VaultHistoryHandler", http.StatusBadRequest)
+		return
+	}
+	data, err := json.Marshal(versions)
+	if err != nil {
+		responseMsg(w, r, err.Error(), "VaultHistoryHandler", http.StatusInternalServerError)
+		return
+	}
+	w.Write(data)
+}
+
+// VaultVersionHandler provides encrypted version of vault record (GET
+// request) or restores it (POST request), record versions are encrypted
+// as vault records and they are decrypted by the client
+func VaultVersionHandler(w http.ResponseWriter, r *http.Request) {
+	vdir, err := getVault(r)
+	if err != nil {
+		responseMsg(w, r, fmt.Sprintf("%v", err), "VaultVersionHandler", http.StatusBadRequest)
+		return
+	}
+	rid, err := getVaultRecord(r)
+	if err != nil {
+		responseMsg(w, r, fmt.Sprintf("%v", err), "VaultVersionHandler", http.StatusBadRequest)
+		return
+	}
+	version := mux.Vars(r)["version"]
+	vault := vt.Vault{Directory: vdir}
+	if r.Method == "POST" {
+		err = vault.Restore(rid, version)
+		if err != nil {
+			responseMsg(w, r, fmt.Sprintf("%v", err), "VaultVersionHandler", http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	fname, err := vault.VersionFile(rid, version)
+	if err != nil {
+		responseMsg(w, r, fmt.Sprintf("%v", err), "VaultVersionHandler", http.StatusBadRequest)
+		return
+	}
+	data, err := os.ReadFile(fname)
+	if err != nil {
+		responseMsg(w, r, err.Error(), "VaultVersionHandler", http.StatusInternalServerError)
+		return
+	}
+	w.Write(data)
+}
+
+// VaultTrashHandler provides list of deleted vault records (GET request)
+// or restores deleted record (POST request)
+func VaultTrashHandler(w http.ResponseWriter, r *http.Request) {
+	vdir, err := getVault(r)
+	if err != nil {
+		responseMsg(w, r, fmt.Sprintf("%v", err), "VaultTrashHandler", http.StatusBadRequest)
+		return
+	}
+	vault := vt.Vault{Directory: vdir}
+	if r.Method == "POST" {
+		rid, err := getVaultRecord(r)
+		if err != nil {
+			responseMsg(w, r, fmt.Sprintf("%v", err), "VaultTrashHandler", http.StatusBadRequest)
+			return
+		}
+		err = vault.RestoreRecord(rid)
+		if err != nil {
+			responseMsg(w, r, fmt.Sprintf("%v", err), "VaultTrashHandler", http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	records, err := vault.Trash()
+	if err != nil {
+		responseMsg(w, r, err.Error(), "VaultTrashHandler", http.StatusInternalServerError)
+		return
+	}
+	data, err := json.Marshal(records)
+	if err != nil {
+		responseMsg(w, r, err.Error(), "VaultTrashHandler", http.StatusInternalServerError)
+		return
+	}
 	w.Write(data)
 }
 
+// VaultBlobsHandler provides list of vault blobs
+func VaultBlobsHandler(w http.ResponseWriter, r *http.Request) {
+	vdir, err := getVault(r)
+	if err != nil {
+		responseMsg(w, r, fmt.Sprintf("%v", err), "VaultBlobsHandler", http.StatusBadRequest)
+		return
+	}
+	vault := vt.Vault{Directory: vdir}
+	blobs, err := vault.Blobs()
+	if err != nil {
+		responseMsg(w, r, err.Error(), "VaultBlobsHandler", http.StatusInternalServerError)
+		return
+	}
+	if blobs == nil {
+		blobs = []string{}
+	}
+	data, err := json.Marshal(blobs)
+	if err != nil {
+		responseMsg(w, r, err.Error(), "VaultBlobsHandler", http.StatusInternalServerError)
+		return
+	}
+	w.Write(data)
+}
+
+// VaultBlobHandler provides and stores encrypted vault blobs, blobs are
+// transferred as is since server does not hold vault keys
+func VaultBlobHandler(w http.ResponseWriter, r *http.Request) {
+	vdir, err := getVault(r)
+	if err != nil {
+		responseMsg(w, r, fmt.Sprintf("%v", err), "VaultBlobHandler", http.StatusBadRequest)
+		return
+	}
+	vault := vt.Vault{Directory: vdir}
+	name := mux.Vars(r)["name"]
+	if r.Method == "POST" {
+		writeBlob(w, r, &vault, name, "VaultBlobHandler")
+		return
+	}
+	fname, err := vault.BlobFile(name)
+	if err != nil {
+		responseMsg(w, r, fmt.Sprintf("%v", err), "VaultBlobHandler", http.StatusBadRequest)
+		return
+	}
+	file, err := os.Open(fname)
+	if err != nil {
+		responseMsg(w, r, fmt.Sprintf("%v", err), "VaultBlobHandler", http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+	if _, err := io.Copy(w, file); err != nil {
+		log.Println("unable to write", fname, err)
+	}
+}
+
+// helper function to write encrypted blob with given name from HTTP request
+// body, the blob is stored as is since server does not hold vault keys
+func writeBlob(w http.ResponseWriter, r *http.Request, vault *vt.Vault, name, api string) {
+	defer r.Body.Close()
+	if _, err := vault.BlobFile(name); err != nil {
+		responseMsg(w, r, fmt.Sprintf("%v", err), api, http.StatusBadRequest)
+		return
+	}
+	reader := bufio.NewReader(r.Body)
+	if magic, _ := reader.Peek(len(crypt.StreamMagic)); !crypt.IsStream(magic) {
+		responseMsg(w, r, "blob is not encrypted stream", api, http.StatusBadRequest)
+		return
+	}
+	if err := vault.WriteBlob(name, reader); err != nil {
+		responseMsg(w, r, fmt.Sprintf("%v", err), api, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
 // VaultAddHandler provides basic functionality of status response
 func VaultAddHandler(w http.ResponseWriter, r *http.Request) {
 	vdir, err := getVault(r)
@@ -283,7 +433,8 @@ func VaultDeleteHandler(w http.ResponseWriter, r *http.Request) {
 		responseMsg(w, r, fmt.Sprintf("%v", err), "VaultDeleteHandler", http.StatusBadRequest)
 		return
 	}
-	vault := vt.Vault{Cipher: crypt.GetCipher(""), Secret: "", Directory: vdir}
+	// record is moved to vault trash, it is kept there until it is purged
+	vault := vt.Vault{Cipher: crypt.GetCipher(""), Directory: vdir}
 	err = vault.DeleteRecord(rid)
 	if err != nil {
 		responseMsg(w, r, fmt.Sprintf("%v", err), "VaultDeleteHandler", http.StatusBadRequest)
@@ -371,6 +522,7 @@ func MainHandler(w http.ResponseWriter, r *http.Request) {
 	tmplData := make(TmplRecord)
 	tmplData["User"] = user
 	tmplData["Mobile"] = mobile
+	tmplData["Schemas"] = vt.Schemas()
 	page := tmplPage("main.tmpl", tmplData)
 	w.Write([]byte(page))
 }
@@ -106,6 +106,12 @@ func srvRouter() *mux.Router {
 	router.HandleFunc(basePath("/vault/{vault:[0-9a-zA-Z]+}/auth"), VaultAuthHandler).Methods("POST")
 	router.HandleFunc(basePath("/vault/{vault:[0-9a-zA-Z]+}/records"), VaultRecordsHandler).Methods("GET")
 	router.HandleFunc(basePath("/vault/{vault:[0-9a-zA-Z]+}"), VaultHandler).Methods("GET")
+	router.HandleFunc(basePath("/vault/{vault:[0-9a-zA-Z]+}/blobs"), VaultBlobsHandler).Methods("GET")
+	router.HandleFunc(basePath("/vault/{vault:[0-9a-zA-Z]+}/blobs/{name:[0-9a-f]+}"), VaultBlobHandler).Methods("GET", "POST")
+	router.HandleFunc(basePath("/vault/{vault:[0-9a-zA-Z]+}/trash"), VaultTrashHandler).Methods("GET")
+	router.HandleFunc(basePath("/vault/{vault:[0-9a-zA-Z]+}/trash/{rid:[0-9a-zA-Z-]+}"), VaultTrashHandler).Methods("POST")
+	router.HandleFunc(basePath("/vault/{vault:[0-9a-zA-Z]+}/{rid:[0-9a-zA-Z-]+}/history"), VaultHistoryHandler).Methods("GET")
+	router.HandleFunc(basePath("/vault/{vault:[0-9a-zA-Z]+}/{rid:[0-9a-zA-Z-]+}/history/{version:[0-9a-zA-Z\\.]+}"), VaultVersionHandler).Methods("GET", "POST")
 	router.HandleFunc(basePath("/vault/{vault:[0-9a-zA-Z]+}/{rid:[0-9a-zA-Z-\\.]+}"), VaultRecordHandler).Methods("GET", "POST")
 	router.HandleFunc(basePath("/vault/{vault:[0-9a-zA-Z]+}/{rid:[0-9a-zA-Z-]+}"), VaultDeleteHandler).Methods("DELETE")
 	router.HandleFunc(basePath("/vault/{vault:[0-9a-zA-Z]+}"), VaultAddHandler).Methods("POST")
@@ -1,5 +1,10 @@
 package storage
 
+import (
+	"fmt"
+	"io"
+)
+
 // TODO: I need to put actual implementations
 
 // DropboxStorage provides file-system based storage
@@ -26,3 +31,18 @@ func (f *DropboxStorage) Write(fname string, rec []byte) error {
 func (f *DropboxStorage) Records() ([]string, error) {
 	return []string{}, nil
 }
+
+// ReadBlob implements Storage.ReadBlob method
+func (f *DropboxStorage) ReadBlob(name string) (io.ReadCloser, error) {
+	return nil, fmt.Errorf("dropbox storage ReadBlob, %w", ErrNotImplemented)
+}
+
+// WriteBlob implements Storage.WriteBlob method
+func (f *DropboxStorage) WriteBlob(name string, r io.Reader) error {
+	return fmt.Errorf("dropbox storage WriteBlob, %w", ErrNotImplemented)
+}
+
+// Blobs implement Storage Blobs method
+func (f *DropboxStorage) Blobs() ([]string, error) {
+	return []string{}, nil
+}
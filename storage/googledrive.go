@@ -1,5 +1,10 @@
 package storage
 
+import (
+	"fmt"
+	"io"
+)
+
 // TODO: I need to put actual implementations
 
 // GoogleDriveStorage provides file-system based storage
@@ -26,3 +31,18 @@ func (f *GoogleDriveStorage) Write(fname string, rec []byte) error {
 func (f *GoogleDriveStorage) Records() ([]string, error) {
 	return []string{}, nil
 }
+
+// ReadBlob implements Storage.ReadBlob method
+func (f *GoogleDriveStorage) ReadBlob(name string) (io.ReadCloser, error) {
+	return nil, fmt.Errorf("google drive storage ReadBlob, %w", ErrNotImplemented)
+}
+
+// WriteBlob implements Storage.WriteBlob method
+func (f *GoogleDriveStorage) WriteBlob(name string, r io.Reader) error {
+	return fmt.Errorf("google drive storage WriteBlob, %w", ErrNotImplemented)
+}
+
+// Blobs implement Storage Blobs method
+func (f *GoogleDriveStorage) Blobs() ([]string, error) {
+	return []string{}, nil
+}
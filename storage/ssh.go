@@ -1,5 +1,10 @@
 package storage
 
+import (
+	"fmt"
+	"io"
+)
+
 // TODO: I need to put actual implementations
 
 // SSHStorage provides file-system based storage
@@ -26,3 +31,18 @@ func (f *SSHStorage) Write(fname string, rec []byte) error {
 func (f *SSHStorage) Records() ([]string, error) {
 	return []string{}, nil
 }
+
+// ReadBlob implements Storage.ReadBlob method
+func (f *SSHStorage) ReadBlob(name string) (io.ReadCloser, error) {
+	return nil, fmt.Errorf("ssh storage ReadBlob, %w", ErrNotImplemented)
+}
+
+// WriteBlob implements Storage.WriteBlob method
+func (f *SSHStorage) WriteBlob(name string, r io.Reader) error {
+	return fmt.Errorf("ssh storage WriteBlob, %w", ErrNotImplemented)
+}
+
+// Blobs implement Storage Blobs method
+func (f *SSHStorage) Blobs() ([]string, error) {
+	return []string{}, nil
+}
@@ -1,6 +1,8 @@
 package storage
 
 import (
+	"errors"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
@@ -16,8 +18,20 @@ type Storage interface {
 	Write(fname string, rec []byte) error
 	// Records return list of record ids from storage
 	Records() ([]string, error)
+	// ReadBlob reads encrypted blob with given name from storage
+	ReadBlob(name string) (io.ReadCloser, error)
+	// WriteBlob writes encrypted blob with given name to storage
+	WriteBlob(name string, r io.Reader) error
+	// Blobs return list of blob names from storage
+	Blobs() ([]string, error)
 }
 
+// BlobsDir defines name of storage area which keeps encrypted blobs
+const BlobsDir = "blobs"
+
+// ErrNotImplemented is returned by storages which do not support given method
+var ErrNotImplemented = errors.New("not implemented")
+
 // FileStorage provides file-system based storage
 type FileStorage struct {
 	Path string
@@ -69,6 +83,10 @@ func (f *FileStorage) Records() ([]string, error) {
 		return records, err
 	}
 	for _, f := range files {
+		// blobs are kept in their own area
+		if f.IsDir() {
+			continue
+		}
 		base := filepath.Base(f.Name())
 		ext := filepath.Ext(f.Name())
 		fname := strings.Replace(base, ext, "", -1)
@@ -76,3 +94,51 @@ func (f *FileStorage) Records() ([]string, error) {
 	}
 	return records, nil
 }
+
+// ReadBlob implements Storage.ReadBlob method
+func (f *FileStorage) ReadBlob(name string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(f.Path, BlobsDir, filepath.Base(name)))
+}
+
+// WriteBlob implements Storage.WriteBlob method
+func (f *FileStorage) WriteBlob(name string, r io.Reader) error {
+	bdir := filepath.Join(f.Path, BlobsDir)
+	err := os.MkdirAll(bdir, 0700)
+	if err != nil {
+		return err
+	}
+	fileName := filepath.Join(bdir, filepath.Base(name))
+	file, err := os.Create(fileName + ".tmp")
+	if err != nil {
+		log.Println("unable to create file name", fileName, " error ", err)
+		return err
+	}
+	defer os.Remove(file.Name())
+	_, err = io.Copy(file, r)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), fileName)
+}
+
+// Blobs implement Storage Blobs method
+func (f *FileStorage) Blobs() ([]string, error) {
+	var blobs []string
+	files, err := ioutil.ReadDir(filepath.Join(f.Path, BlobsDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return blobs, nil
+		}
+		return blobs, err
+	}
+	for _, f := range files {
+		if f.IsDir() || strings.HasSuffix(f.Name(), ".tmp") {
+			continue
+		}
+		blobs = append(blobs, f.Name())
+	}
+	return blobs, nil
+}
@@ -3,11 +3,16 @@ package sync
 import (
 	"bufio"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
 
 	// ecm packages
 	"github.com/vkuznet/ecm/utils"
@@ -143,3 +148,76 @@ func SyncFromServer(rurl, dst string) error {
 	}
 	return nil
 }
+
+// helper function to check if given name is valid blob name
+func validBlob(name string) bool {
+	if len(name) != 2*sha256.Size {
+		return false
+	}
+	_, err := hex.DecodeString(name)
+	return err == nil
+}
+
+// SyncBlobsFromServer fetches encrypted blobs which are missing in
+// destination vault from HTTP end-point, the blobs are transferred
+// independently of vault records
+func SyncBlobsFromServer(rurl, dst string) error {
+	client := &http.Client{}
+	resp, err := client.Get(rurl)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	// blobs represent list of names of vault blobs
+	var blobs []string
+	err = json.Unmarshal(data, &blobs)
+	if err != nil {
+		return err
+	}
+	bdir := filepath.Join(dst, "blobs")
+	err = os.MkdirAll(bdir, 0700)
+	if err != nil {
+		return err
+	}
+	for _, name := range blobs {
+		fname := filepath.Join(bdir, name)
+		if !validBlob(name) || utils.FileExist(fname) {
+			continue
+		}
+		err = fetchBlob(client, fmt.Sprintf("%s/%s", strings.TrimSuffix(rurl, "/"), name), fname)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// helper function to fetch blob from given url and write it to given file
+func fetchBlob(client *http.Client, rurl, fname string) error {
+	resp, err := client.Get(rurl)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unable to fetch %s, status %s", rurl, resp.Status)
+	}
+	tmp := fmt.Sprintf("%s.tmp", fname)
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	_, err = io.Copy(file, resp.Body)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, fname)
+}
@@ -18,6 +18,9 @@ import (
 // initGrid controls when we read our grid view
 var initGrid bool
 
+// gridDone is closed when grid view is destroyed on lock
+var gridDone chan struct{}
+
 // helper function to start our UI app
 func gpgApp(vault *vt.Vault, interval int) {
 
@@ -29,7 +32,7 @@ func gpgApp(vault *vt.Vault, interval int) {
 	input, auth := authView(app, pages, text, vault, interval)
 	pages.AddPage("auth", auth, true, true)
 	pages.AddPage("text", text, true, false)
-	go lockECM(app, pages, input, vault, interval)
+	go lockECM(app, pages, input, text, vault, interval)
 
 	// Start the application.
 	if err := app.SetRoot(pages, true).EnableMouse(true).Run(); err != nil {
@@ -37,18 +40,37 @@ func gpgApp(vault *vt.Vault, interval int) {
 	}
 }
 
-// helper function to lock ecm
-func lockECM(app *tview.Application, pages *tview.Pages, input *tview.InputField, vault *vt.Vault, interval int) {
+// helper function to lock ecm, it wipes vault secret and decrypted records
+// from memory and destroys grid view which is rebuilt on unlock
+func lockECM(app *tview.Application, pages *tview.Pages, input *tview.InputField, text *tview.TextView, vault *vt.Vault, interval int) {
 	for {
 		if time.Since(vault.Start).Seconds() > float64(interval) {
-			log.Println("time to lock the screen")
-			pages.HidePage("grid")
-			pages.HidePage("text")
-			pages.ShowPage("auth")
-			pages.SwitchToPage("auth")
+			app.QueueUpdateDraw(func() {
+				if initGrid {
+					log.Println("time to lock the screen")
+				}
+				pages.HidePage("grid")
+				pages.HidePage("text")
+				pages.ShowPage("auth")
+				pages.SwitchToPage("auth")
+				if gridDone != nil {
+					close(gridDone)
+					gridDone = nil
+				}
+				pages.RemovePage("grid")
+				pages.RemovePage("history")
+				pages.RemovePage("restore")
+				pages.RemovePage("delete")
+				pages.RemovePage("kind")
+				pages.RemovePage("folders")
+				pages.RemovePage("tags")
+				pages.RemovePage("audit")
+				text.SetText("")
+				vault.Lock()
+				initGrid = false
+				input.SetText("")
+			})
 			vault.Start = time.Now()
-			input.SetText("")
-			app.ForceDraw()
 		}
 		time.Sleep(1 * time.Second)
 	}
@@ -60,15 +82,25 @@ func authView(app *tview.Application, pages *tview.Pages, textView *tview.TextVi
 	input.SetFieldWidth(50).
 		SetMaskCharacter('*').
 		SetDoneFunc(func(key tcell.Key) {
-			secret := input.GetText()
-			if initGrid && secret != vault.Secret {
+			secret := []byte(input.GetText())
+			input.SetText("")
+			if initGrid && !vault.CheckSecret(secret) {
 				log.Println("wrong password")
 				return
 			}
 			if !initGrid {
-				vault.Secret = secret
+				if err := vault.Unlock(secret); err != nil {
+					log.Println("wrong password")
+					return
+				}
+				// records which can not be decrypted are reported while
+				// other records are kept
 				err := vault.Read()
-				if err != nil {
+				if vt.PartialError(err) {
+					for _, e := range vt.RecordFailures(err) {
+						log.Printf("WARNING: unable to read vault record %s, error %v", e.File, e.Err)
+					}
+				} else if err != nil {
 					log.Fatal("unable to read vault, error ", err)
 				}
 				log.Printf("read %d vault records", len(vault.Records))
@@ -85,6 +117,9 @@ func authView(app *tview.Application, pages *tview.Pages, textView *tview.TextVi
 	frame := tview.NewFrame(input)
 	frame.SetBorders(10, 1, 1, 1, 10, 1)
 	frame.AddText("\U0001F512 Encrypted Content Manager (ECM)", true, tview.AlignLeft, TitleColor)
+	if vault.KeyFile != "" {
+		frame.AddText("key file: "+vault.KeyFile, true, tview.AlignLeft, TitleColor)
+	}
 	frame.AddText("\u00A9 2021 - github.com/vkuznet - \U0001F510", false, tview.AlignLeft, TitleColor)
 	return input, frame
 }
@@ -158,6 +193,9 @@ func listForm(list *tview.List, records []vt.VaultRecord) *tview.List {
 	return list
 }
 
+// label of record form field which keeps record folder
+const folderLabel = "Folder"
+
 // helper function to present recordForm
 func recordForm(app *tview.Application, form *tview.Form, list *tview.List, info *tview.TextView, index int, vault *vt.Vault) *tview.Form {
 	var rec vt.VaultRecord
@@ -165,28 +203,71 @@ func recordForm(app *tview.Application, form *tview.Form, list *tview.List, info
 		rec = vault.Records[index]
 	}
 	form.Clear(true) // clear the form
+	// weak password should be confirmed by saving the record twice
+	weakConfirm := false
+	// record fields are rendered from schema of record kind
+	schema, _ := rec.Schema()
+	if rec.ID != "" {
+		input := tview.NewInputField().SetLabel(folderLabel).SetText(rec.Folder).SetFieldWidth(100)
+		input.SetPlaceholder("folder path, e.g. work/servers")
+		form.AddFormItem(input)
+	}
 	for _, key := range rec.Keys() {
-		val, _ := rec.Map[key]
+		val := rec.Value(key)
+		field, _ := schema.Field(key)
 		if strings.ToLower(key) == "password" {
+			form.AddPasswordField(key, val, 100, '*', func(text string) {
+				weakConfirm = false
+				strength := crypt.EstimateStrength(text, rec.Map["Name"], rec.Map["Login"], rec.Map["URL"])
+				info = info.SetText(strengthInfo(strength) + helpKey())
+			})
+		} else if vt.SensitiveKey(key) {
 			form.AddPasswordField(key, val, 100, '*', nil)
 		} else {
-			form.AddInputField(key, val, 100, nil, nil)
+			input := tview.NewInputField().SetLabel(key).SetText(val).SetFieldWidth(100)
+			input.SetPlaceholder(field.Placeholder)
+			form.AddFormItem(input)
 		}
 	}
-	form.SetBorder(true).SetTitle("Record form").SetTitleAlign(tview.AlignCenter)
+	form.SetBorder(true).SetTitle(recordTitle(rec)).SetTitleAlign(tview.AlignCenter)
 	if len(vault.Records) == 0 {
 		return form
 	}
 	form.AddButton("Save", func() {
 		uid := rec.ID
 		rmap := make(vt.Record)
+		folder := rec.Folder
 		for i := 0; i < form.GetFormItemCount(); i++ {
 			item := form.GetFormItem(i)
 			key := item.GetLabel()
 			val := form.GetFormItemByLabel(key).(*tview.InputField).GetText()
+			if key == folderLabel {
+				folder = val
+				continue
+			}
 			rmap[key] = val
 		}
-		rec := vt.VaultRecord{ID: uid, Map: rmap, ModificationTime: time.Now()}
+		folder, err := vt.CleanFolder(folder)
+		if err != nil {
+			msg := fmt.Sprintf("[red]WARNING: %v[white]\n", err)
+			info = info.SetText(msg + helpKey())
+			return
+		}
+		rec := vt.VaultRecord{ID: uid, Kind: rec.Kind, Folder: folder, Map: rmap, Attachments: rec.Attachments, ModificationTime: time.Now()}
+		if err := rec.Validate(); err != nil {
+			msg := fmt.Sprintf("[red]WARNING: %v[white]\n", err)
+			info = info.SetText(msg + helpKey())
+			return
+		}
+		if _, ok := rmap["Password"]; ok && !weakConfirm {
+			if strength := rec.PasswordStrength(); strength.Weak() {
+				weakConfirm = true
+				msg := "[red]WARNING: record password is weak, press Save again to keep it[white]\n"
+				info = info.SetText(msg + strengthInfo(strength) + helpKey())
+				return
+			}
+		}
+		weakConfirm = false
 		vault.Update(rec)
 		vault.Write()
 
@@ -205,6 +286,31 @@ func recordForm(app *tview.Application, form *tview.Form, list *tview.List, info
 	return form
 }
 
+// helper function to provide record form title with live TOTP code of the record
+func recordTitle(rec vt.VaultRecord) string {
+	title := "Record form"
+	if s, ok := rec.Schema(); ok {
+		title = fmt.Sprintf("%s record", s.Title)
+	}
+	if len(rec.Secret(vt.TOTPKey)) > 0 {
+		code, remaining, err := rec.OTP()
+		if err != nil {
+			return title + ", invalid TOTP seed"
+		}
+		title = fmt.Sprintf("%s, TOTP %s (%ds)", title, code, int(remaining.Seconds()))
+	}
+	return title
+}
+
+// helper function to present password strength feedback
+func strengthInfo(strength *crypt.Strength) string {
+	msg := fmt.Sprintf("Password strength %d/4, estimated crack time %s", strength.Score, strength.CrackTimeString())
+	if strength.Warning != "" {
+		msg += "\n" + strength.Warning
+	}
+	return msg
+}
+
 // helper function to build our application grid view
 //gocyclo:ignore
 func gridView(app *tview.Application, pages *tview.Pages, textView *tview.TextView, vault *vt.Vault) *tview.Grid {
@@ -216,19 +322,41 @@ func gridView(app *tview.Application, pages *tview.Pages, textView *tview.TextVi
 	focusIndex := 1  // defaul focus index points to list view
 	recordIndex := 0 // index of currently shown record
 
+	// helper function to show subset of vault records in the list along
+	// with given message
+	showRecords := func(records []vt.VaultRecord, msg string) {
+		list = listForm(list, records)
+		info.SetText(msg + helpKey())
+		if len(records) > 0 {
+			for idx, r := range vault.Records {
+				if r.ID == records[0].ID {
+					recordIndex = idx
+					break
+				}
+			}
+			form = recordForm(app, form, list, info, recordIndex, vault)
+		}
+	}
+
 	// add new frame for search bar
 	input := tview.NewInputField()
 	input.SetFieldWidth(50)
 	input.SetDoneFunc(func(key tcell.Key) {
 		pat := input.GetText()
-		records := vault.Find(pat)
+		records, err := vault.Query(pat)
 		msg := fmt.Sprintf("found %d records", len(records))
+		if err != nil {
+			msg = fmt.Sprintf("[red]invalid query:[white] %v", err)
+		}
 		if vault.Verbose > 0 {
 			log.Println(msg)
 		}
 		if info != nil {
 			info = info.SetText(msg + helpKey())
 		}
+		if len(records) == 0 {
+			return
+		}
 		if list != nil {
 			list = listForm(list, records)
 		}
@@ -250,7 +378,7 @@ func gridView(app *tview.Application, pages *tview.Pages, textView *tview.TextVi
 	})
 	frame := tview.NewFrame(input)
 	frame.SetBorders(2, 1, 1, 1, 10, 1)
-	frame.AddText("\U0001F50D Search within the vault", true, tview.AlignLeft, TitleColor)
+	frame.AddText("\U0001F50D Search within the vault, e.g. tag:work url:github OR name:~gitlab", true, tview.AlignLeft, TitleColor)
 	find = frame
 
 	// add search bar
@@ -277,6 +405,26 @@ func gridView(app *tview.Application, pages *tview.Pages, textView *tview.TextVi
 		}
 	})
 
+	// refresh live TOTP code of the current record until grid is destroyed
+	done := make(chan struct{})
+	gridDone = done
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				app.QueueUpdateDraw(func() {
+					if recordIndex < len(vault.Records) {
+						form.SetTitle(recordTitle(vault.Records[recordIndex]))
+					}
+				})
+			}
+		}
+	}()
+
 	// construct grid view
 	grid := tview.NewGrid()
 	grid.SetBorders(false)
@@ -291,22 +439,110 @@ func gridView(app *tview.Application, pages *tview.Pages, textView *tview.TextVi
 		key := event.Key()
 		switch key {
 		case tcell.KeyCtrlA:
-			rec, err := vault.AddRecord("login")
+			// new record is created from schema of selected kind
+			var buttons []string
+			kinds := make(map[string]string)
+			for _, s := range vt.Schemas() {
+				if s.Kind == "file" {
+					// file records are created when files are encrypted
+					continue
+				}
+				buttons = append(buttons, s.Title)
+				kinds[s.Title] = s.Kind
+			}
+			modal := tview.NewModal().
+				SetText("Select kind of new record").
+				AddButtons(append(buttons, "Cancel")).
+				SetDoneFunc(func(buttonIndex int, buttonLabel string) {
+					pages.RemovePage("kind")
+					kind, ok := kinds[buttonLabel]
+					if !ok {
+						app.SetFocus(list)
+						focusIndex = 1
+						return
+					}
+					rec, err := vault.AddRecord(kind)
+					if err != nil {
+						log.Println("error while adding new record", err)
+						return
+					}
+					for i, r := range vault.Records {
+						if r.ID == rec.ID {
+							recordIndex = i
+							break
+						}
+					}
+					list = listForm(list, vault.Records)
+					list.SetCurrentItem(recordIndex)
+					form = recordForm(app, form, list, info, recordIndex, vault)
+					app.SetFocus(form)
+					focusIndex = 2
+				})
+			pages.AddPage("kind", modal, false, true)
+			return nil
+		case tcell.KeyCtrlK:
+			tree, err := folderView(app, vault, func(folder string, ok bool) {
+				pages.RemovePage("folders")
+				pages.SwitchToPage("grid")
+				if ok {
+					// show records of selected folder and its sub-folders
+					var records []vt.VaultRecord
+					for _, r := range vault.Records {
+						if vt.InFolder(r.Folder, folder) {
+							records = append(records, r)
+						}
+					}
+					msg := fmt.Sprintf("folder %s has %d records", folderTitle(folder), len(records))
+					showRecords(records, msg)
+				}
+				app.SetFocus(list)
+				focusIndex = 1
+			})
 			if err != nil {
-				log.Println("error while adding new record", err)
+				info.SetText(fmt.Sprintf("unable to get vault folders, error %v", err) + helpKey())
+				return event
 			}
-			var idx int
-			for i, r := range vault.Records {
-				if r.ID == rec.ID {
-					idx = i
-					break
+			pages.AddPage("folders", tree, true, true)
+			pages.SwitchToPage("folders")
+			return nil
+		case tcell.KeyCtrlU:
+			tags, err := tagView(app, vault, func(tag string, ok bool) {
+				pages.RemovePage("tags")
+				pages.SwitchToPage("grid")
+				if ok {
+					// show records with selected tag or all records
+					var records []vt.VaultRecord
+					for _, r := range vault.Records {
+						if tag == "" || r.HasTag(tag) {
+							records = append(records, r)
+						}
+					}
+					msg := fmt.Sprintf("found %d records", len(records))
+					if tag != "" {
+						msg = fmt.Sprintf("tag %s has %d records", tag, len(records))
+					}
+					showRecords(records, msg)
 				}
+				app.SetFocus(list)
+				focusIndex = 1
+			})
+			if err != nil {
+				info.SetText(fmt.Sprintf("unable to get vault tags, error %v", err) + helpKey())
+				return event
 			}
-			list = listForm(list, vault.Records)
-			list.SetCurrentItem(idx)
-			app.SetFocus(form)
-			focusIndex = 2
-			return event
+			pages.AddPage("tags", tags, true, true)
+			pages.SwitchToPage("tags")
+			return nil
+		case tcell.KeyCtrlW:
+			view := auditView(app, vault, func() {
+				pages.RemovePage("audit")
+				pages.SwitchToPage("grid")
+				app.SetFocus(list)
+				focusIndex = 1
+			})
+			pages.AddPage("audit", view, true, true)
+			pages.SwitchToPage("audit")
+			return nil
 		case tcell.KeyCtrlR:
 			list = listForm(list, vault.Records)
 			info.SetText(helpKey())
@@ -369,6 +605,67 @@ func gridView(app *tview.Application, pages *tview.Pages, textView *tview.TextVi
 				app.SetFocus(form)
 			}
 			return event
+		case tcell.KeyCtrlO:
+			if recordIndex < len(vault.Records) {
+				rec := vault.Records[recordIndex]
+				code, remaining, err := rec.OTP()
+				if err != nil {
+					info.SetText(fmt.Sprintf("unable to get TOTP code, error %v", err) + helpKey())
+					return event
+				}
+				if err := clipboard.WriteAll(code); err != nil {
+					log.Println("unable to copy to clipboard, error", err)
+				}
+				msg := fmt.Sprintf("TOTP code is copied to clipboard, valid for %ds", int(remaining.Seconds()))
+				info.SetText(msg + helpKey())
+			}
+			return event
+		case tcell.KeyCtrlD:
+			if recordIndex < len(vault.Records) {
+				rec := vault.Records[recordIndex]
+				modal := tview.NewModal().
+					SetText(fmt.Sprintf("Move record %s to the trash?", rec.Map["Name"])).
+					AddButtons([]string{"Delete", "Cancel"}).
+					SetDoneFunc(func(buttonIndex int, buttonLabel string) {
+						pages.RemovePage("delete")
+						if buttonLabel == "Delete" {
+							msg := fmt.Sprintf("Record %s is moved to the trash", rec.ID)
+							if err := vault.DeleteRecord(rec.ID); err != nil {
+								msg = fmt.Sprintf("unable to delete record %s, error %v", rec.ID, err)
+							}
+							if recordIndex >= len(vault.Records) && recordIndex > 0 {
+								recordIndex--
+							}
+							list = listForm(list, vault.Records)
+							list.SetCurrentItem(recordIndex)
+							form = recordForm(app, form, list, info, recordIndex, vault)
+							info.SetText(msg + helpKey())
+						}
+						app.SetFocus(list)
+						focusIndex = 1
+					})
+				pages.AddPage("delete", modal, false, true)
+			}
+			return nil
+		case tcell.KeyCtrlY:
+			if recordIndex < len(vault.Records) {
+				rid := vault.Records[recordIndex].ID
+				history, err := historyView(app, pages, vault, rid, func(msg string) {
+					list = listForm(list, vault.Records)
+					list.SetCurrentItem(recordIndex)
+					form = recordForm(app, form, list, info, recordIndex, vault)
+					info.SetText(msg + helpKey())
+					app.SetFocus(list)
+					focusIndex = 1
+				})
+				if err != nil {
+					info.SetText(fmt.Sprintf("unable to get record history, error %v", err) + helpKey())
+					return event
+				}
+				pages.AddPage("history", history, true, true)
+				pages.SwitchToPage("history")
+			}
+			return nil
 		case tcell.KeyCtrlT:
 			pages.HidePage("auth")
 			pages.HidePage("grid")
@@ -403,6 +700,215 @@ func gridView(app *tview.Application, pages *tview.Pages, textView *tview.TextVi
 	return grid
 }
 
+// helper function to provide title of vault folder
+func folderTitle(folder string) string {
+	if folder == "" {
+		return "Vault"
+	}
+	return folder
+}
+
+// helper function to build tree view of vault folders along with number of
+// records in them. The done function is called with selected folder or
+// without it if view is closed by Escape
+func folderView(app *tview.Application, vault *vt.Vault, done func(folder string, ok bool)) (*tview.TreeView, error) {
+	folders, err := vault.Folders()
+	if err != nil {
+		return nil, err
+	}
+	root := tview.NewTreeNode(fmt.Sprintf("Vault (%d)", len(vault.Records))).
+		SetReference("").
+		SetColor(tcell.ColorGreen)
+	nodes := map[string]*tview.TreeNode{"": root}
+	// sub-folders follow their parent folder
+	for _, f := range folders {
+		node := tview.NewTreeNode(fmt.Sprintf("%s (%d)", f.Name, f.Total)).SetReference(f.Path)
+		if parent, ok := nodes[vt.ParentFolder(f.Path)]; ok {
+			parent.AddChild(node)
+		}
+		nodes[f.Path] = node
+	}
+	tree := tview.NewTreeView().SetRoot(root).SetCurrentNode(root)
+	tree.SetBorder(true).SetTitle("Folders, press Enter to show folder records, Escape to return")
+	tree.SetSelectedFunc(func(node *tview.TreeNode) {
+		done(node.GetReference().(string), true)
+	})
+	tree.SetDoneFunc(func(key tcell.Key) {
+		if key == tcell.KeyEscape {
+			done("", false)
+		}
+	})
+	tree.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if event.Key() == tcell.KeyCtrlQ {
+			app.Stop()
+		}
+		return event
+	})
+	return tree, nil
+}
+
+// helper function to build list of vault tags along with number of records
+// which have them. The done function is called with selected tag, empty tag
+// represents all records, or without it if list is closed by Escape
+func tagView(app *tview.Application, vault *vt.Vault, done func(tag string, ok bool)) (*tview.List, error) {
+	tags, err := vault.Tags()
+	if err != nil {
+		return nil, err
+	}
+	list := tview.NewList().ShowSecondaryText(false)
+	list.SetBorder(true).SetTitle("Tags, press Enter to show tag records, Escape to return")
+	list.AddItem(fmt.Sprintf("All records (%d)", len(vault.Records)), "", 0, func() {
+		done("", true)
+	})
+	for _, t := range tags {
+		tag := t.Name
+		list.AddItem(fmt.Sprintf("%s (%d)", tag, t.Count), "", 0, func() {
+			done(tag, true)
+		})
+	}
+	list.SetDoneFunc(func() {
+		done("", false)
+	})
+	list.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if event.Key() == tcell.KeyCtrlQ {
+			app.Stop()
+		}
+		return event
+	})
+	return list, nil
+}
+
+// helper function to build view of vault audit report, it shows audit
+// summary along with found issues. The done function is called when view
+// is closed by Escape
+func auditView(app *tview.Application, vault *vt.Vault, done func()) *tview.TextView {
+	report := vault.Audit()
+	view := tview.NewTextView().SetDynamicColors(true).SetScrollable(true)
+	view.SetBorder(true).SetTitle("Vault audit, press Escape to return")
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("Audit of %d records with %d passwords, rotation period %d days\n\n",
+		report.Records, report.Passwords, report.Days))
+	for _, kind := range vt.AuditKinds {
+		color := "green"
+		if report.Counts[kind] > 0 {
+			color = "red"
+		}
+		sb.WriteString(fmt.Sprintf("[%s]%-10s %d[white]\n", color, kind, report.Counts[kind]))
+	}
+	if len(report.Issues) > 0 {
+		sb.WriteString("\n")
+	}
+	for _, issue := range report.Issues {
+		name := tview.Escape(issue.Name)
+		sb.WriteString(fmt.Sprintf("[yellow]%-10s[white] %s: %s\n", issue.Kind, name, tview.Escape(issue.Detail)))
+	}
+	view.SetText(sb.String())
+	view.SetDoneFunc(func(key tcell.Key) {
+		if key == tcell.KeyEscape {
+			done()
+		}
+	})
+	view.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if event.Key() == tcell.KeyCtrlQ {
+			app.Stop()
+		}
+		return event
+	})
+	return view
+}
+
+// helper function to build history view of given record, it lists record
+// versions along with their changes and restores selected version. The done
+// function is called with status message when view is closed
+func historyView(app *tview.Application, pages *tview.Pages, vault *vt.Vault, rid string, done func(msg string)) (*tview.Flex, error) {
+	versions, err := vault.History(rid)
+	if err != nil {
+		return nil, err
+	}
+	diffView := tview.NewTextView()
+	diffView.SetDynamicColors(true)
+	diffView.SetBorder(true).SetTitle("Changes with respect to current record")
+	list := tview.NewList()
+	list.SetBorder(true).SetTitle("Record history")
+
+	// helper function to close history view and return to grid view
+	closeView := func(msg string) {
+		pages.RemovePage("restore")
+		pages.RemovePage("history")
+		pages.SwitchToPage("grid")
+		done(msg)
+	}
+	for _, ver := range versions {
+		tstamp := ver.Time.Local().Format("2006-01-02 15:04:05")
+		list.AddItem(tstamp, ver.Version, rune('-'), nil)
+	}
+	list.SetChangedFunc(func(index int, mainText, version string, shortcut rune) {
+		diffView.SetText(versionDiff(vault, rid, version))
+	})
+	list.SetSelectedFunc(func(index int, mainText, version string, shortcut rune) {
+		if version == vt.CurrentVersion {
+			return
+		}
+		modal := tview.NewModal().
+			SetText(fmt.Sprintf("Restore record version from %s?", mainText)).
+			AddButtons([]string{"Restore", "Cancel"}).
+			SetDoneFunc(func(buttonIndex int, buttonLabel string) {
+				if buttonLabel != "Restore" {
+					pages.RemovePage("restore")
+					app.SetFocus(list)
+					return
+				}
+				msg := fmt.Sprintf("Record %s is restored to version %s", rid, version)
+				if err := vault.Restore(rid, version); err != nil {
+					msg = fmt.Sprintf("unable to restore record %s, error %v", rid, err)
+				}
+				closeView(msg)
+			})
+		pages.AddPage("restore", modal, false, true)
+	})
+	list.SetDoneFunc(func() {
+		closeView("")
+	})
+	diffView.SetText(versionDiff(vault, rid, vt.CurrentVersion))
+
+	flex := tview.NewFlex().
+		AddItem(list, 0, 1, true).
+		AddItem(diffView, 0, 2, false)
+	flex.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if event.Key() == tcell.KeyCtrlQ {
+			app.Stop()
+		}
+		return event
+	})
+	return flex, nil
+}
+
+// helper function to present changes of given record version with respect
+// to the current record
+func versionDiff(vault *vt.Vault, rid, version string) string {
+	if version == vt.CurrentVersion {
+		return "Current record, press Enter on other version to restore it, Escape to return"
+	}
+	diff, err := vault.Diff(rid, vt.CurrentVersion, version)
+	if err != nil {
+		return fmt.Sprintf("unable to read record version, error %v", err)
+	}
+	if len(diff) == 0 {
+		return "Version does not differ from current record"
+	}
+	var lines []string
+	for _, d := range diff {
+		color := "[yellow]"
+		if d.Kind() == "added" {
+			color = "[green]"
+		} else if d.Kind() == "removed" {
+			color = "[red]"
+		}
+		lines = append(lines, color+tview.Escape(d.String())+"[white]")
+	}
+	return strings.Join(lines, "\n")
+}
+
 // helper function to copy key content from the form to clipboard
 func copyToClipboard(key string, form *tview.Form, verbose int) {
 	val := form.GetFormItemByLabel(key).(*tview.InputField).GetText()
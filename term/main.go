@@ -6,6 +6,7 @@ import (
 	"log"
 	"os"
 	"runtime"
+	"strings"
 	"time"
 
 	crypt "github.com/vkuznet/ecm/crypt"
@@ -26,7 +27,11 @@ func main() {
 	var vname string
 	flag.StringVar(&vname, "vault", "", "vault name")
 	var cipher string
-	flag.StringVar(&cipher, "cipher", "", "cipher to use (aes, nacl)")
+	flag.StringVar(&cipher, "cipher", "", fmt.Sprintf("cipher to use (%s)", strings.Join(crypt.SupportedCiphers(), ", ")))
+	var kdf string
+	flag.StringVar(&kdf, "kdf", "", "key derivation function of new vault with optional cost parameters, e.g. argon2id:3:65536:4 or scrypt:15:8:1")
+	var keyFile string
+	flag.StringVar(&keyFile, "keyfile", "", "key file used along with vault password to unlock the vault")
 	var version bool
 	flag.BoolVar(&version, "version", false, "show version")
 	var lockInterval int
@@ -46,7 +51,14 @@ func main() {
 	}
 
 	// initialize our vault
-	vault := vt.Vault{Cipher: crypt.GetCipher(cipher), Verbose: verbose, Start: time.Now()}
+	vault := vt.Vault{Cipher: crypt.GetCipher(cipher), KeyFile: keyFile, Verbose: verbose, Start: time.Now()}
+	if kdf != "" {
+		vkdf, err := crypt.ParseKDF(kdf)
+		if err != nil {
+			log.Fatal(err)
+		}
+		vault.KDF = vkdf
+	}
 
 	// create vault if necessary
 	err := vault.Create(vname)
@@ -34,8 +34,8 @@ func printRecord(rec vt.VaultRecord) {
 
 	defer w.Flush()
 	fmt.Fprintf(w, "\n")
-	for key, val := range rec.Map {
-		fmt.Fprintf(w, "%s\t%s\n", key, val)
+	for key := range rec.Map {
+		fmt.Fprintf(w, "%s\t%s\n", key, rec.Value(key))
 	}
 	fmt.Fprintf(w, "\n")
 }
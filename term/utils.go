@@ -7,11 +7,11 @@ import (
 	"io"
 	"log"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/atotto/clipboard"
 	"github.com/vkuznet/ecm/crypt"
-	utils "github.com/vkuznet/ecm/utils"
 	vt "github.com/vkuznet/ecm/vault"
 )
 
@@ -85,33 +85,27 @@ func helpKeys() string {
 	info = fmt.Sprintf("%s, [red]Ctrl-F[white] switch to Search", info)
 	info = fmt.Sprintf("%s, [red]Ctrl-L[white] switch to Records", info)
 	info = fmt.Sprintf("%s, [red]Ctrl-E[white] record edit mode", info)
+	info = fmt.Sprintf("%s, [red]Ctrl-A[white] add record", info)
+	info = fmt.Sprintf("%s, [red]Ctrl-K[white] folders", info)
+	info = fmt.Sprintf("%s, [red]Ctrl-U[white] tags", info)
+	info = fmt.Sprintf("%s, [red]Ctrl-W[white] audit", info)
 	info = fmt.Sprintf("%s\n", info)
 	info = fmt.Sprintf("%s, [red]Ctrl-G[white] generate password", info)
 	info = fmt.Sprintf("%s, [red]Ctrl-P[white] copy password to clipboard", info)
+	info = fmt.Sprintf("%s, [red]Ctrl-O[white] copy TOTP code to clipboard", info)
+	info = fmt.Sprintf("%s, [red]Ctrl-Y[white] record history", info)
+	info = fmt.Sprintf("%s, [red]Ctrl-D[white] delete record", info)
 	info = fmt.Sprintf("%s\n", info)
 	info = fmt.Sprintf("%s, [red]Ctrl-Q[white] Exit", info)
 	return info
 }
 
-// helper function to extract cipher name from file extension
-func fileCipher(fname string) string {
-	arr := strings.Split(fname, ".")
-	cipher := strings.Split(arr[len(arr)-1], "-")[0]
-	if !utils.InList(cipher, crypt.SupportedCiphers) {
-		log.Fatalf("given cipher %s is not supported, please use one from the following %v", cipher, crypt.SupportedCiphers)
-	}
-	return cipher
-}
-
 // helper function to decrypt given input (file or stdin)
 func decryptInput(fname, password, cipher, write, attr string) {
 	var err error
-	if cipher == "" {
-		cipher = fileCipher(fname)
-	}
-	if !utils.InList(cipher, crypt.SupportedCiphers) {
-		log.Fatalf("given cipher %s is not supported, please use one from the following %v", cipher, crypt.SupportedCiphers)
-	}
+	// cipher is taken from encrypted data header, given one is only used
+	// for legacy data without header
+	cipher = crypt.GetCipher(cipher)
 	var data []byte
 	if fname == "-" { // stdin
 		var input string
@@ -124,7 +118,7 @@ func decryptInput(fname, password, cipher, write, attr string) {
 	if err != nil {
 		panic(err)
 	}
-	data, err = crypt.Decrypt(data, password, cipher)
+	data, err = decryptData(fname, data, password, cipher)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -134,11 +128,10 @@ func decryptInput(fname, password, cipher, write, attr string) {
 		if err != nil {
 			log.Fatal("unable to unarmashal vault record", err)
 		}
-		val, ok := rec.Map[attr]
-		if ok {
-			data = []byte(val)
+		if _, ok := rec.Map[attr]; ok {
+			data = rec.Secret(attr)
 		} else {
-			log.Fatalf("unable to extract attribute '%s' from the record %+v", attr, rec)
+			log.Fatalf("unable to extract attribute '%s' from the record %s", attr, rec.ID)
 		}
 	}
 	if write == "stdout" {
@@ -154,3 +147,14 @@ func decryptInput(fname, password, cipher, write, attr string) {
 		}
 	}
 }
+
+// helper function to decrypt given data, vault records are decrypted with
+// vault key if given file belongs to a vault, otherwise legacy key is used
+func decryptData(fname string, data []byte, password, cipher string) ([]byte, error) {
+	if fname != "-" {
+		if meta, err := vt.FindMeta(fname); err == nil {
+			return meta.DecryptRecord(filepath.Base(fname), data, []byte(password), cipher)
+		}
+	}
+	return crypt.Decrypt(data, password, cipher)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+
+	"fyne.io/fyne/v2"
+	container "fyne.io/fyne/v2/container"
+	theme "fyne.io/fyne/v2/theme"
+	widget "fyne.io/fyne/v2/widget"
+	vt "github.com/vkuznet/ecm/vault"
+)
+
+// Audit represents vault audit tab
+type Audit struct {
+	window  fyne.Window
+	app     fyne.App
+	summary *widget.Label
+	issues  *fyne.Container
+}
+
+func newUIAudit(a fyne.App, w fyne.Window) *Audit {
+	return &Audit{app: a, window: w}
+}
+
+// Refresh performs audit of vault records and shows audit summary along with
+// found issues
+func (r *Audit) Refresh() {
+	report := _vault.Audit()
+	summary := fmt.Sprintf("Audit of %d records with %d passwords, rotation period %d days",
+		report.Records, report.Passwords, report.Days)
+	if report.Healthy() {
+		summary += ", no issues found"
+	}
+	r.summary.SetText(summary)
+	r.issues.Objects = nil
+	for _, kind := range vt.AuditKinds {
+		label := newBoldLabel(fmt.Sprintf("%s: %d", kind, report.Counts[kind]))
+		r.issues.Add(label)
+	}
+	for _, issue := range report.Issues {
+		label := widget.NewLabel(fmt.Sprintf("%s: %s, %s", issue.Kind, issue.Name, issue.Detail))
+		label.Wrapping = fyne.TextWrapWord
+		r.issues.Add(label)
+	}
+	r.issues.Refresh()
+}
+
+func (r *Audit) buildUI() *container.Scroll {
+	r.summary = widget.NewLabel("Press Audit to check vault passwords")
+	r.summary.Wrapping = fyne.TextWrapWord
+	r.issues = container.NewVBox()
+	btn := &widget.Button{
+		Text:     "Audit",
+		Icon:     theme.SearchIcon(),
+		OnTapped: r.Refresh,
+	}
+	return container.NewScroll(container.NewVBox(
+		colorButtonContainer(btn, btnColor),
+		r.summary,
+		r.issues,
+	))
+}
+
+func (r *Audit) tabItem() *container.TabItem {
+	return &container.TabItem{Text: "Audit", Icon: theme.WarningIcon(), Content: r.buildUI()}
+}
@@ -38,6 +38,13 @@ func AppWindow(app fyne.App, w fyne.Window) {
 	}
 }
 
+// helper function to report vault records which can not be read
+func warnRecords(err error) {
+	for _, e := range vt.RecordFailures(err) {
+		appLog("WARNING", fmt.Sprintf("unable to read vault record %s", e.File), e.Err)
+	}
+}
+
 // helper function to unify error messages
 func appLog(level, msg string, err error) {
 	tstamp := time.Now().Format(time.RFC3339)
@@ -107,6 +114,7 @@ func Create(app fyne.App, window fyne.Window) *container.AppTabs {
 		appRecords.tabItem(),
 		newUIRecord(app, window).tabItem(),
 		newUIPassword(app, window).tabItem(),
+		newUIAudit(app, window).tabItem(),
 		newUISync(app, window, appRecords).tabItem(),
 		newUISettings(app, window).tabItem(),
 		logTabItem(app, window),
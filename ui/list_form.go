@@ -2,16 +2,15 @@ package main
 
 import (
 	"fmt"
-	"log"
-	"os"
-	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
 	fyne "fyne.io/fyne/v2"
 	container "fyne.io/fyne/v2/container"
+	dialog "fyne.io/fyne/v2/dialog"
 	theme "fyne.io/fyne/v2/theme"
 	widget "fyne.io/fyne/v2/widget"
-	utils "github.com/vkuznet/ecm/utils"
 	vt "github.com/vkuznet/ecm/vault"
 )
 
@@ -21,6 +20,11 @@ type vaultRecords struct {
 	records map[string]Entry
 }
 
+// otpLabels keeps labels of live TOTP codes of records shown in records list
+var otpLabels = make(map[string]*widget.Label)
+var otpMutex sync.Mutex
+var otpOnce sync.Once
+
 func newUIVaultRecords(a fyne.App, w fyne.Window) *vaultRecords {
 	return &vaultRecords{app: a, window: w}
 }
@@ -35,45 +39,91 @@ func recordName(rec vt.VaultRecord) string {
 	return name
 }
 
+// global variable to keep number of breaches of record passwords, it is
+// empty if breached passwords dataset is not configured
+var breachCounts map[string]int
+
+// helper function to update number of breaches of record passwords from
+// local HIBP dataset kept in given path
+func updateBreaches(path string) {
+	breachCounts = nil
+	if path == "" {
+		return
+	}
+	db, err := vt.OpenBreachDB(path)
+	if err != nil {
+		appLog("ERROR", "unable to open breached passwords dataset", err)
+		return
+	}
+	defer db.Close()
+	breaches, err := _vault.Breaches(db)
+	if err != nil {
+		appLog("ERROR", "unable to check breached passwords", err)
+		return
+	}
+	breachCounts = make(map[string]int)
+	for _, b := range breaches {
+		breachCounts[b.ID] = b.Count
+	}
+}
+
+// helper function to provide title of record in records list, the title
+// has breaches column if breached passwords dataset is configured
+func recordTitle(rec vt.VaultRecord) string {
+	name := recordName(rec)
+	if count, ok := breachCounts[rec.ID]; ok {
+		return fmt.Sprintf("%s | breaches: %d", name, count)
+	}
+	return name
+}
+
 // helper function to provide row container
 func (a *vaultRecords) rowContainer(rec vt.VaultRecord) *fyne.Container {
 	var objects []fyne.CanvasObject
 	var entries []*widget.Entry
 	var keys []string
-	for _, k := range vt.OrderedKeys {
-		if v, ok := rec.Map[k]; ok {
+	// record keys follow order of its kind schema
+	for _, k := range rec.Keys() {
+		if _, ok := rec.Map[k]; ok {
 			keys = append(keys, k)
-			entry, container := a.singleRow(k, v)
+			entry, container := a.singleRow(k, rec.Value(k))
 			entries = append(entries, entry)
 			objects = append(objects, container)
 		}
 	}
-	for k, v := range rec.Map {
-		if !utils.InList(k, vt.OrderedKeys) {
-			keys = append(keys, k)
-			entry, container := a.singleRow(k, v)
-			entries = append(entries, entry)
-			objects = append(objects, container)
-		}
+	if len(rec.Secret(vt.TOTPKey)) > 0 {
+		objects = append(objects, a.otpRow(rec))
 	}
+	folderEntry, folderContainer := a.singleRow("Folder", rec.Folder)
+	entries = append(entries, folderEntry)
+	objects = append(objects, folderContainer)
 
 	// update button
 	btnUpdate := copyButton(a.window, "Update", "", theme.MenuIcon())
 	btnUpdate.OnTapped = func() {
 		for i, k := range keys {
 			if _, ok := rec.Map[k]; ok {
-				rec.Map[k] = entries[i].Text
+				rec.SetValue(k, entries[i].Text)
 			}
 		}
-		rec.WriteRecord(
-			_vault.Directory,
-			_vault.Secret,
-			_vault.Cipher,
-			_vault.Verbose,
-		)
+		folder, err := vt.CleanFolder(folderEntry.Text)
+		if err != nil {
+			dialog.ShowError(err, a.window)
+			return
+		}
+		rec.Folder = folder
+		if err := rec.Validate(); err != nil {
+			dialog.ShowError(err, a.window)
+			return
+		}
+		if err := _vault.WriteRecord(rec); err != nil {
+			appLog("ERROR", "unable to write vault record", err)
+		}
 		for _, entry := range entries {
 			entry.Disable()
 		}
+		a.refreshFolders()
+		a.refreshTags()
 	}
 
 	// edit button
@@ -85,35 +135,20 @@ func (a *vaultRecords) rowContainer(rec vt.VaultRecord) *fyne.Container {
 	}
 	btnRemove := copyButton(a.window, "Remove", "", theme.DeleteIcon())
 	btnRemove.OnTapped = func() {
-		msg := fmt.Sprintf("delete record %s", rec.ID)
-		appLog("WARNINIG", msg, nil)
-		log.Println("initial number of records", len(_vault.Records))
-		for _, r := range _vault.Records {
-			fmt.Println("rec", r.ID)
-		}
-		err := _vault.DeleteRecord(rec.ID)
-		if err != nil {
-			msg := fmt.Sprintf("unable to delete %s", rec.ID)
-			appLog("ERROR", msg, err)
-		}
-		// delete physical record on device
-		pref := a.app.Preferences()
-		vdir := pref.String("VaultDirectory")
-		fname := filepath.Join(vdir, rec.ID)
-		if utils.FileExist(fname) {
-			err := os.Remove(fname)
+		msg := fmt.Sprintf("Move record %s to the trash?", recordName(rec))
+		dialog.ShowConfirm("Delete record", msg, func(ok bool) {
+			if !ok {
+				return
+			}
+			// record file is moved to vault trash
+			err := _vault.DeleteRecord(rec.ID)
 			if err != nil {
-				msg := fmt.Sprintf("Unable to remove %s", fname)
+				msg := fmt.Sprintf("unable to delete %s", rec.ID)
 				appLog("ERROR", msg, err)
 			}
-		}
-		// list new records
-		log.Println("new number of records", len(_vault.Records))
-		for _, r := range _vault.Records {
-			fmt.Println("rec", r.ID)
-		}
-		// refresh app widget
-		a.Refresh()
+			// refresh app widget
+			a.Refresh()
+		}, a.window)
 	}
 	btnContainer := container.NewGridWithColumns(3,
 		colorButtonContainer(btnEdit, greenColor),
@@ -124,12 +159,64 @@ func (a *vaultRecords) rowContainer(rec vt.VaultRecord) *fyne.Container {
 	return container.NewVBox(objects...)
 }
 
+// helper function to create row container with live TOTP code of the record
+func (a *vaultRecords) otpRow(rec vt.VaultRecord) *fyne.Container {
+	label := widget.NewLabel("OTP")
+	code := widget.NewLabel(otpText(rec))
+	otpMutex.Lock()
+	otpLabels[rec.ID] = code
+	otpMutex.Unlock()
+	btn := &widget.Button{
+		Icon: theme.ContentCopyIcon(),
+		OnTapped: func() {
+			if val, _, err := rec.OTP(); err == nil {
+				a.window.Clipboard().SetContent(val)
+			} else {
+				appLog("ERROR", "unable to get TOTP code", err)
+			}
+		},
+	}
+	return container.NewHBox(
+		container.NewGridWrap(fyne.NewSize(100, 40), label),
+		container.NewGridWrap(fyne.NewSize(200, 40), code),
+		container.NewGridWrap(fyne.NewSize(40, 40), btn),
+	)
+}
+
+// helper function to provide TOTP code of the record along with its validity
+func otpText(rec vt.VaultRecord) string {
+	code, remaining, err := rec.OTP()
+	if err != nil {
+		return "invalid TOTP seed"
+	}
+	return fmt.Sprintf("%s (%ds)", code, int(remaining.Seconds()))
+}
+
+// helper function to refresh live TOTP codes of records list
+func refreshOTP() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		otpMutex.Lock()
+		for rid, label := range otpLabels {
+			rec, err := _vault.Record(rid)
+			if err != nil {
+				// record is no longer in the vault
+				delete(otpLabels, rid)
+				continue
+			}
+			label.SetText(otpText(rec))
+		}
+		otpMutex.Unlock()
+	}
+}
+
 // helper function to create single row container
 func (a *vaultRecords) singleRow(key, val string) (*widget.Entry, *fyne.Container) {
 	label := widget.NewLabel(key)
 	entry := widget.NewEntry()
 	entry.Text = val
-	if key == "Password" || key == "password" {
+	if vt.SensitiveKey(key) {
 		entry = widget.NewPasswordEntry()
 		entry.Text = val
 		entry.Refresh()
@@ -160,7 +247,7 @@ func (a *vaultRecords) singleRow(key, val string) (*widget.Entry, *fyne.Containe
 func (a *vaultRecords) buildRecordsList(records []vt.VaultRecord) *widget.Accordion {
 	entries := widget.NewAccordion()
 	for _, rec := range records {
-		entries.Append(widget.NewAccordionItem(recordName(rec), a.rowContainer(rec)))
+		entries.Append(widget.NewAccordionItem(recordTitle(rec), a.rowContainer(rec)))
 	}
 	return entries
 }
@@ -169,29 +256,154 @@ func (a *vaultRecords) buildRecordsList(records []vt.VaultRecord) *widget.Accord
 // we will refresh it dyring sync process
 var uiRecords *widget.Accordion
 
+// global variables to keep folders tree and sub-folders of vault folders
+var uiFolders *widget.Tree
+var folderChildren map[string][]string
+
+// global variables to keep tags selector and tags of its options
+var uiTags *widget.Select
+var tagOptions map[string]string
+
+// option of tags selector which shows all records
+const allTags = "All tags"
+
 // Refresh refresh records in UI
 func (a *vaultRecords) Refresh() {
+	updateBreaches(a.app.Preferences().String("BreachDataset"))
+	a.showRecords(_vault.Records)
+	a.refreshFolders()
+	a.refreshTags()
+}
+
+// helper function to show given records in accordion records
+func (a *vaultRecords) showRecords(records []vt.VaultRecord) {
 	uiRecords.Items = nil
-	for _, rec := range _vault.Records {
-		uiRecords.Append(widget.NewAccordionItem(recordName(rec), a.rowContainer(rec)))
+	for _, rec := range records {
+		uiRecords.Append(widget.NewAccordionItem(recordTitle(rec), a.rowContainer(rec)))
 	}
 	uiRecords.Refresh()
 }
 
+// helper function to update sub-folders of vault folders, the vault root
+// is represented by empty folder
+func (a *vaultRecords) refreshFolders() {
+	folderChildren = make(map[string][]string)
+	folders, err := _vault.Folders()
+	if err != nil {
+		appLog("ERROR", "unable to read vault folders", err)
+	}
+	for _, f := range folders {
+		parent := vt.ParentFolder(f.Path)
+		folderChildren[parent] = append(folderChildren[parent], f.Path)
+	}
+	if uiFolders != nil {
+		uiFolders.Refresh()
+	}
+}
+
+// helper function to show records of given folder and its sub-folders
+func (a *vaultRecords) showFolder(folder string) {
+	var records []vt.VaultRecord
+	for _, rec := range _vault.Records {
+		if vt.InFolder(rec.Folder, folder) {
+			records = append(records, rec)
+		}
+	}
+	a.showRecords(records)
+}
+
+// helper function to update options of tags selector, every option shows
+// tag along with number of its records
+func (a *vaultRecords) refreshTags() {
+	tagOptions = map[string]string{allTags: ""}
+	options := []string{allTags}
+	tags, err := _vault.Tags()
+	if err != nil {
+		appLog("ERROR", "unable to read vault tags", err)
+	}
+	for _, t := range tags {
+		option := fmt.Sprintf("%s (%d)", t.Name, t.Count)
+		tagOptions[option] = t.Name
+		options = append(options, option)
+	}
+	if uiTags != nil {
+		uiTags.Options = options
+		uiTags.Refresh()
+	}
+}
+
+// helper function to show records with given tag, empty tag shows all
+// records
+func (a *vaultRecords) showTag(tag string) {
+	var records []vt.VaultRecord
+	for _, rec := range _vault.Records {
+		if tag == "" || rec.HasTag(tag) {
+			records = append(records, rec)
+		}
+	}
+	a.showRecords(records)
+}
+
+// helper function to build expandable navigation of vault folders
+func (a *vaultRecords) buildFoldersTree() *widget.Tree {
+	a.refreshFolders()
+	tree := widget.NewTree(
+		func(uid widget.TreeNodeID) []widget.TreeNodeID {
+			if uid == "" {
+				// single top level node represents the whole vault
+				return []widget.TreeNodeID{vt.FolderSeparator}
+			}
+			if uid == vt.FolderSeparator {
+				uid = ""
+			}
+			return folderChildren[uid]
+		},
+		func(uid widget.TreeNodeID) bool {
+			if uid == "" || uid == vt.FolderSeparator {
+				return true
+			}
+			return len(folderChildren[uid]) > 0
+		},
+		func(branch bool) fyne.CanvasObject {
+			return widget.NewLabel("Folder")
+		},
+		func(uid widget.TreeNodeID, branch bool, obj fyne.CanvasObject) {
+			name := "All records"
+			if uid != vt.FolderSeparator {
+				name = vt.FolderName(uid)
+			}
+			obj.(*widget.Label).SetText(name)
+		},
+	)
+	tree.OnSelected = func(uid widget.TreeNodeID) {
+		if uid == vt.FolderSeparator {
+			uid = ""
+		}
+		a.showFolder(uid)
+	}
+	tree.OpenBranch(vt.FolderSeparator)
+	return tree
+}
+
+// helper function to show vault records which match given query
+func (a *vaultRecords) search(query string) {
+	records, err := _vault.Query(query)
+	if err != nil {
+		appLog("ERROR", "invalid search query", err)
+		return
+	}
+	// reset items of accordion
+	// see https://yourbasic.org/golang/clear-slice/
+	a.showRecords(records)
+}
+
 // helper function to create appropriate copy button with custom text and icon
 func (a *vaultRecords) searchButton(entry *widget.Entry) *widget.Button {
 	return &widget.Button{
 		Text: "",
 		Icon: theme.SearchIcon(),
 		OnTapped: func() {
-			key := entry.Text
-			// reset items of accordion
-			// see https://yourbasic.org/golang/clear-slice/
-			uiRecords.Items = nil
-			for _, rec := range _vault.Find(key) {
-				uiRecords.Append(widget.NewAccordionItem(recordName(rec), a.rowContainer(rec)))
-			}
-			uiRecords.Refresh()
+			a.search(entry.Text)
 		},
 	}
 }
@@ -200,33 +412,39 @@ func (a *vaultRecords) searchButton(entry *widget.Entry) *widget.Button {
 func (a *vaultRecords) buildUI() *container.Scroll {
 
 	// build initial set of accordion records
+	updateBreaches(a.app.Preferences().String("BreachDataset"))
 	accRecords := a.buildRecordsList(_vault.Records)
 	uiRecords = accRecords
+	otpOnce.Do(func() { go refreshOTP() })
 
 	// setup search entry
 	search := widget.NewEntry()
-	search.OnSubmitted = func(v string) {
-		// reset items of accordion
-		// see https://yourbasic.org/golang/clear-slice/
-		accRecords.Items = nil
-		for _, rec := range _vault.Find(v) {
-			accRecords.Append(widget.NewAccordionItem(recordName(rec), a.rowContainer(rec)))
-		}
-		accRecords.Refresh()
-	}
-	search.PlaceHolder = "search keyword"
+	search.OnSubmitted = a.search
+	search.PlaceHolder = "search, e.g. tag:work url:github"
 	searchContainer := container.NewGridWrap(inputSize, search)
 
-	// TODO: assign OnTapped action to perform search across records see OnSubmitted function
 	btn := a.searchButton(search)
 	btnContainer := colorButtonContainer(btn, btnColor)
+
+	// setup tags selector
+	uiTags = widget.NewSelect(nil, func(option string) {
+		a.showTag(tagOptions[option])
+	})
+	uiTags.PlaceHolder = allTags
+	a.refreshTags()
+
 	searchRowContainer := container.NewHBox(
-		searchContainer, btnContainer,
+		searchContainer, btnContainer, uiTags,
 	)
 
-	// return final container with search and accordion records
+	// setup folders navigation
+	uiFolders = a.buildFoldersTree()
+	foldersContainer := container.NewGridWrap(fyne.NewSize(inputSize.Width, 150), uiFolders)
+
+	// return final container with search, folders and accordion records
 	return container.NewScroll(container.NewVBox(
 		container.NewVBox(searchRowContainer),
+		container.NewVBox(foldersContainer),
 		container.NewVBox(accRecords),
 	))
 }
@@ -234,7 +452,7 @@ func (a *vaultRecords) buildUI() *container.Scroll {
 // helper function to create record form item representation
 // func (a *vaultRecords) formItem(key, val string) *widget.FormItem {
 func (a *vaultRecords) formItem(vrec vt.VaultRecord, key, val string) *widget.FormItem {
-	if key == "Password" || key == "password" {
+	if vt.SensitiveKey(key) {
 		rec := widget.NewPasswordEntry()
 		rec.Text = val
 		rec.Refresh()
@@ -259,22 +477,22 @@ func (a *vaultRecords) formItem(vrec vt.VaultRecord, key, val string) *widget.Fo
 func (a *vaultRecords) recordContainer(record vt.VaultRecord) *fyne.Container {
 	// create entry object
 	var items []*widget.FormItem
-	for _, k := range vt.OrderedKeys {
-		if v, ok := record.Map[k]; ok {
-			items = append(items, a.formItem(record, k, v))
-		}
-	}
-	// other keys can follow ordered ones
-	for k, v := range record.Map {
-		if !utils.InList(k, vt.OrderedKeys) {
-			items = append(items, a.formItem(record, k, v))
+	for _, k := range record.Keys() {
+		if _, ok := record.Map[k]; ok {
+			items = append(items, a.formItem(record, k, record.Value(k)))
 		}
 	}
 	form := &widget.Form{
 		Items:      items,
 		SubmitText: "Update",
 		OnSubmit: func() {
-			record.WriteRecord(_vault.Directory, _vault.Secret, _vault.Cipher, _vault.Verbose)
+			if err := record.Validate(); err != nil {
+				dialog.ShowError(err, a.window)
+				return
+			}
+			if err := _vault.WriteRecord(record); err != nil {
+				appLog("ERROR", "unable to write vault record", err)
+			}
 		},
 	}
 	recContainer := container.NewVBox(form)
@@ -45,7 +45,10 @@ func startApp(app fyne.App, w fyne.Window) {
 	err := _vault.Read()
 	msg := fmt.Sprintf("Vault at %s has %d records", _vault.Directory, len(_vault.Records))
 	appLog("INFO", msg, err)
-	if err != nil {
+	if vt.PartialError(err) {
+		warnRecords(err)
+		AppWindow(app, w)
+	} else if err != nil {
 		appLog("ERROR", "unable to read vault records", err)
 	} else {
 		AppWindow(app, w)
@@ -59,7 +62,8 @@ func loginButton(app fyne.App, w fyne.Window, entry *widget.Entry) *widget.Butto
 		//         Icon: theme.LoginIcon(),
 		Icon: resourceLockSvg,
 		OnTapped: func() {
-			_vault.Secret = entry.Text
+			_vault.SetSecret([]byte(entry.Text))
+			entry.Text = ""
 			startApp(app, w)
 		},
 	}
@@ -89,12 +93,14 @@ func LoginWindow(app fyne.App, w fyne.Window) {
 	if _vault == nil {
 		cipher := pref.String("VaultCipher")
 		vdir := pref.String("VaultDirectory")
-		_vault = &vt.Vault{Directory: vdir, Cipher: cipher, Start: time.Now()}
+		keyFile := pref.String("VaultKeyFile")
+		_vault = &vt.Vault{Directory: vdir, Cipher: cipher, KeyFile: keyFile, Start: time.Now()}
 	}
 
 	passwordEntry = widget.NewPasswordEntry()
 	passwordEntry.OnSubmitted = func(p string) {
-		_vault.Secret = p
+		_vault.SetSecret([]byte(p))
+		passwordEntry.Text = ""
 		startApp(app, w)
 	}
 	passwordEntry.PlaceHolder = "Enter Master Password"
@@ -172,8 +178,8 @@ func autoLogout(app fyne.App, w fyne.Window, ctx context.Context) {
 			thr, err := strconv.Atoi(strThr)
 			if err == nil && foregroundTime > 0 && now-foregroundTime > int64(thr) {
 				log.Println("autologin reset")
-				_vault.Secret = ""
-				_vault.Records = nil
+				// wipe vault secret and decrypted records from memory
+				_vault.Lock()
 				passwordEntry.Text = ""
 				appTabs = nil
 				foregroundTime = 0
@@ -197,8 +203,7 @@ func logoutContainer(app fyne.App, w fyne.Window) *fyne.Container {
 		Text: "Logout",
 		Icon: theme.LogoutIcon(),
 		OnTapped: func() {
-			_vault.Secret = ""
-			_vault.Records = nil
+			_vault.Lock()
 			passwordEntry.Text = ""
 			appTabs = nil
 			LoginWindow(app, w)
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"log"
 	"strings"
 
@@ -29,36 +30,55 @@ func (r *Password) CharactersChange(v string) {
 func (r *Password) buildUI() *fyne.Container {
 	// widgets
 	genPassword := &widget.Entry{PlaceHolder: "generated password"}
+	entropy := widget.NewLabel("")
 	length := binding.NewInt()
 	length.Set(16)
 	strLength := binding.IntToString(length)
 	size := widget.NewEntryWithData(strLength)
-	names := []string{"letters", "letters+digits", "letters+digits+symbols"}
+	names := []string{"letters", "letters+digits", "letters+digits+symbols", "diceware words"}
 	characters := widget.NewSelect(names, r.CharactersChange)
 	characters.SetSelected("letters+digits+symbols")
+	noAmbiguous := widget.NewCheck("exclude ambiguous characters", nil)
 
 	// form widget
 	passForm := &widget.Form{
 		Items: []*widget.FormItem{
 			widget.NewFormItem("New password", genPassword),
+			widget.NewFormItem("Entropy", entropy),
 			widget.NewFormItem("Characters", characters),
 			widget.NewFormItem("Size", size),
+			widget.NewFormItem("", noAmbiguous),
 		},
 		SubmitText: "Generate password",
 		OnSubmit: func() {
-			var hasNumbers, hasSymbols bool
+			val, err := length.Get()
+			if err != nil {
+				log.Println("ERROR: unable to get password size", err)
+				return
+			}
+			var password string
+			var bits float64
 			idx := characters.SelectedIndex()
-			if idx > -1 && strings.Contains(names[idx], "digits") {
-				hasNumbers = true
-			} else if idx > -1 && strings.Contains(names[idx], "symbols") {
-				hasSymbols = true
+			if idx > -1 && names[idx] == "diceware words" {
+				password, bits, err = crypt.Passphrase(val, "-")
+			} else {
+				policy := crypt.NewPasswordPolicy(val, false, false)
+				if idx > -1 && strings.Contains(names[idx], "digits") {
+					policy.UseDigits = true
+				}
+				if idx > -1 && strings.Contains(names[idx], "symbols") {
+					policy.UseSymbols = true
+				}
+				policy.NoAmbiguous = noAmbiguous.Checked
+				password, bits, err = policy.Generate()
 			}
-			val, err := length.Get()
 			if err != nil {
-				log.Println("ERROR:", "TODO SOMETHING")
+				log.Println("ERROR: unable to generate password", err)
+				return
 			}
-			genPassword.Text = crypt.CreatePassword(val, hasNumbers, hasSymbols)
+			genPassword.Text = password
 			genPassword.Refresh()
+			entropy.SetText(fmt.Sprintf("%.1f bits", bits))
 			r.window.Clipboard().SetContent(genPassword.Text)
 		},
 	}
@@ -25,6 +25,7 @@ var btnColor, greenColor, blueColor, redColor, authColor, grayColor color.NRGBA
 // VaultCipher: aes
 // VaultDirectory: /path/.ecm/Primary
 // VaultName: Primary
+// BreachDataset: /path/pwned-passwords-sha1-ordered-by-hash.txt
 // cloud: dropbox:ECM
 // local: http://...
 func appSettings(app fyne.App) {
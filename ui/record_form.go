@@ -1,49 +1,19 @@
 package main
 
 import (
+	"fmt"
 	"log"
 
 	"fyne.io/fyne/v2"
 	container "fyne.io/fyne/v2/container"
 	binding "fyne.io/fyne/v2/data/binding"
+	dialog "fyne.io/fyne/v2/dialog"
 	theme "fyne.io/fyne/v2/theme"
 	widget "fyne.io/fyne/v2/widget"
+	crypt "github.com/vkuznet/ecm/crypt"
 	vt "github.com/vkuznet/ecm/vault"
 )
 
-// LoginRecord represents login UI record
-type LoginRecord struct {
-	Name     binding.String
-	URL      binding.String
-	Login    binding.String
-	Password binding.String
-	Note     binding.String
-	Tags     binding.String
-}
-
-// JSONRecord represents json UI record
-type JSONRecord struct {
-	Name binding.String
-	JSON binding.String
-}
-
-// CardRecord represents card UI record
-type CardRecord struct {
-	Name       binding.String
-	CardNumber binding.String
-	Code       binding.String
-	Date       binding.String
-	Phone      binding.String
-	Tags       binding.String
-}
-
-// NoteRecord represents note UI record
-type NoteRecord struct {
-	Name binding.String
-	Note binding.String
-	Tags binding.String
-}
-
 // SyncRecord represents sycn UI record
 type SyncRecord struct {
 	Name    binding.String
@@ -63,47 +33,10 @@ type Record struct {
 	app    fyne.App
 
 	// binding records
-	LoginRecord *LoginRecord
-	JSONRecord  *JSONRecord
-	NoteRecord  *NoteRecord
-	CardRecord  *CardRecord
-	SyncRecord  *SyncRecord
+	SyncRecord *SyncRecord
 	//     UploadRecord *UploadRecord
 }
 
-func newLoginRecord() *LoginRecord {
-	return &LoginRecord{
-		Name:     binding.NewString(),
-		URL:      binding.NewString(),
-		Login:    binding.NewString(),
-		Password: binding.NewString(),
-		Note:     binding.NewString(),
-		Tags:     binding.NewString(),
-	}
-}
-func newJSONRecord() *JSONRecord {
-	return &JSONRecord{
-		Name: binding.NewString(),
-		JSON: binding.NewString(),
-	}
-}
-func newNoteRecord() *NoteRecord {
-	return &NoteRecord{
-		Name: binding.NewString(),
-		Note: binding.NewString(),
-		Tags: binding.NewString(),
-	}
-}
-func newCardRecord() *CardRecord {
-	return &CardRecord{
-		Name:       binding.NewString(),
-		CardNumber: binding.NewString(),
-		Code:       binding.NewString(),
-		Date:       binding.NewString(),
-		Phone:      binding.NewString(),
-		Tags:       binding.NewString(),
-	}
-}
 func newSyncRecord() *SyncRecord {
 	return &SyncRecord{
 		FromURI: binding.NewString(),
@@ -119,13 +52,9 @@ func newSyncRecord() *SyncRecord {
 
 func newUIRecord(a fyne.App, w fyne.Window) *Record {
 	return &Record{
-		app:         a,
-		window:      w,
-		LoginRecord: newLoginRecord(),
-		JSONRecord:  newJSONRecord(),
-		NoteRecord:  newNoteRecord(),
-		CardRecord:  newCardRecord(),
-		SyncRecord:  newSyncRecord(),
+		app:        a,
+		window:     w,
+		SyncRecord: newSyncRecord(),
 		//         UploadRecord: newUploadRecord(),
 	}
 }
@@ -141,73 +70,86 @@ func (r *Record) updateVaultRecord(rec *vt.VaultRecord) {
 	}
 	r.window.SetContent(Create(r.app, r.window))
 }
-func (r *Record) LoginForm() {
-	rec := vt.NewVaultRecord("login")
-	if val, err := r.LoginRecord.Name.Get(); err == nil {
-		rec.Map["Name"] = val
-	}
-	if val, err := r.LoginRecord.Login.Get(); err == nil {
-		rec.Map["Login"] = val
-	}
-	if val, err := r.LoginRecord.Password.Get(); err == nil {
-		rec.Map["Password"] = val
-	}
-	if val, err := r.LoginRecord.Tags.Get(); err == nil {
-		rec.Map["Tags"] = val
-	}
-	if val, err := r.LoginRecord.Note.Get(); err == nil {
-		rec.Map["Note"] = val
-	}
-	if val, err := r.LoginRecord.URL.Get(); err == nil {
-		rec.Map["URL"] = val
-	}
-	r.updateVaultRecord(rec)
-}
-func (r *Record) JSONForm() {
-	rec := vt.NewVaultRecord("json")
-	if val, err := r.LoginRecord.Name.Get(); err == nil {
-		rec.Map["Name"] = val
-	}
-	if val, err := r.LoginRecord.Name.Get(); err == nil {
-		rec.Map["JSON"] = val
-	}
-	r.updateVaultRecord(rec)
-}
-func (r *Record) NoteForm() {
-	rec := vt.NewVaultRecord("note")
-	if val, err := r.NoteRecord.Name.Get(); err == nil {
-		rec.Map["Name"] = val
-	}
-	if val, err := r.NoteRecord.Name.Get(); err == nil {
-		rec.Map["Note"] = val
-	}
-	if val, err := r.NoteRecord.Tags.Get(); err == nil {
-		rec.Map["Tags"] = val
+
+// helper function to save new record of given kind with values of form entries
+func (r *Record) saveRecord(kind string, entries map[string]*widget.Entry) {
+	rec := vt.NewVaultRecord(kind)
+	for key, entry := range entries {
+		rec.Map[key] = entry.Text
+	}
+	if err := rec.Validate(); err != nil {
+		dialog.ShowError(err, r.window)
+		return
+	}
+	// warn about weak password before saving the record
+	if _, ok := rec.Map["Password"]; ok {
+		if strength := rec.PasswordStrength(); strength.Weak() {
+			msg := fmt.Sprintf("Record password is weak, %s\n\nSave record anyway?", strength.Feedback())
+			dialog.ShowConfirm("Weak password", msg, func(save bool) {
+				if save {
+					r.updateVaultRecord(rec)
+				}
+			}, r.window)
+			return
+		}
 	}
 	r.updateVaultRecord(rec)
 }
-func (r *Record) CardForm() {
-	rec := vt.NewVaultRecord("card")
-	if val, err := r.CardRecord.Name.Get(); err == nil {
-		rec.Map["Name"] = val
-	}
-	if val, err := r.CardRecord.Name.Get(); err == nil {
-		rec.Map["Card"] = val
-	}
-	if val, err := r.CardRecord.Name.Get(); err == nil {
-		rec.Map["Code"] = val
-	}
-	if val, err := r.CardRecord.Name.Get(); err == nil {
-		rec.Map["Date"] = val
-	}
-	if val, err := r.CardRecord.Name.Get(); err == nil {
-		rec.Map["Phone"] = val
-	}
-	if val, err := r.CardRecord.Tags.Get(); err == nil {
-		rec.Map["Tags"] = val
+
+// helper function to provide password strength feedback of record form
+func (r *Record) passwordStrength(entries map[string]*widget.Entry) string {
+	value := func(key string) string {
+		if entry, ok := entries[key]; ok {
+			return entry.Text
+		}
+		return ""
+	}
+	password := value("Password")
+	if password == "" {
+		return ""
+	}
+	strength := crypt.EstimateStrength(password, value("Name"), value("Login"), value("URL"))
+	msg := fmt.Sprintf("%d/4, crack time %s", strength.Score, strength.CrackTimeString())
+	if strength.Warning != "" {
+		msg += "\n" + strength.Warning
+	}
+	return msg
+}
+
+// helper function to build form of new record from schema of its kind
+func (r *Record) schemaForm(schema vt.Schema) *widget.Form {
+	entries := make(map[string]*widget.Entry)
+	var items []*widget.FormItem
+	for _, field := range schema.Fields {
+		var entry *widget.Entry
+		if field.Concealed() {
+			entry = widget.NewPasswordEntry()
+		} else if field.Type == vt.MultilineField {
+			entry = widget.NewMultiLineEntry()
+		} else {
+			entry = widget.NewEntry()
+		}
+		entry.PlaceHolder = field.Placeholder
+		entry.Validator = field.Validate
+		entries[field.Key] = entry
+		items = append(items, widget.NewFormItem(field.Key, entry))
+		if field.Key == "Password" {
+			strength := widget.NewLabel("")
+			strength.Wrapping = fyne.TextWrapWord
+			entry.OnChanged = func(string) {
+				strength.SetText(r.passwordStrength(entries))
+			}
+			items = append(items, widget.NewFormItem("Strength", strength))
+		}
+	}
+	return &widget.Form{
+		Items: items,
+		OnSubmit: func() {
+			r.saveRecord(schema.Kind, entries)
+		},
 	}
-	r.updateVaultRecord(rec)
 }
+
 func (r *Record) SyncForm() {
 	fromURI, _ := r.SyncRecord.FromURI.Get()
 	toURI, _ := r.SyncRecord.ToURI.Get()
@@ -222,94 +164,16 @@ func (r *Record) SyncForm() {
 
 func (r *Record) buildUI() *container.Scroll {
 
-	// login form container
-	loginEntryName := widget.NewEntryWithData(r.LoginRecord.Name)
-	loginEntryName.PlaceHolder = "record name"
-
-	loginEntryUrl := widget.NewEntryWithData(r.LoginRecord.URL)
-	loginEntryUrl.PlaceHolder = "e.g. http://abc.com"
-
-	loginEntryLogin := widget.NewEntryWithData(r.LoginRecord.Login)
-	loginEntryLogin.PlaceHolder = "login name"
-
-	loginEntryPassword := widget.NewEntryWithData(r.LoginRecord.Password)
-	loginEntryPassword.Password = true
-
-	loginEntryTags := widget.NewEntryWithData(r.LoginRecord.Tags)
-	loginEntryTags.PlaceHolder = "tag1,tag2,..."
-
-	loginEntryNote := widget.NewEntryWithData(r.LoginRecord.Note)
-	loginEntryNote.PlaceHolder = "some text"
-	loginEntryNote.MultiLine = true
-
-	loginForm := &widget.Form{
-		Items: []*widget.FormItem{
-			widget.NewFormItem("Name", loginEntryName),
-			widget.NewFormItem("URL", loginEntryUrl),
-			widget.NewFormItem("Login", loginEntryLogin),
-			widget.NewFormItem("Password", loginEntryPassword),
-			widget.NewFormItem("Tags", loginEntryTags),
-		},
-		OnSubmit: r.LoginForm,
-	}
-	loginContainer := container.NewVBox(loginForm)
-
-	// json form container
-	jsonEntryName := widget.NewEntryWithData(r.JSONRecord.Name)
-	jsonEntryName.PlaceHolder = "record name"
-	jsonEntryJson := &widget.Entry{PlaceHolder: "{\"foo\":1}", MultiLine: true}
-
-	jsonForm := &widget.Form{
-		Items: []*widget.FormItem{
-			widget.NewFormItem("Name", jsonEntryName),
-			widget.NewFormItem("JSON", jsonEntryJson),
-		},
-		OnSubmit: r.JSONForm,
-	}
-	jsonContainer := container.NewVBox(jsonForm)
-
-	// note form container
-	noteEntryName := widget.NewEntryWithData(r.NoteRecord.Name)
-	noteEntryName.PlaceHolder = "record name"
-	noteEntryNote := widget.NewEntryWithData(r.NoteRecord.Note)
-	noteEntryNote.PlaceHolder = "record name"
-	noteEntryTags := widget.NewEntryWithData(r.NoteRecord.Tags)
-	noteEntryTags.PlaceHolder = "tag1,tag2,..."
-	noteForm := &widget.Form{
-		Items: []*widget.FormItem{
-			widget.NewFormItem("Name", noteEntryName),
-			widget.NewFormItem("Note", noteEntryNote),
-			widget.NewFormItem("Tags", noteEntryTags),
-		},
-		OnSubmit: r.NoteForm,
-	}
-	noteContainer := container.NewVBox(noteForm)
-
-	// card form container
-	cardEntryName := widget.NewEntryWithData(r.CardRecord.Name)
-	cardEntryName.PlaceHolder = "record name"
-	cardEntryCard := widget.NewEntryWithData(r.CardRecord.CardNumber)
-	cardEntryCard.PlaceHolder = "1234-4567-xxxx"
-	cardEntryCode := widget.NewEntryWithData(r.CardRecord.Code)
-	cardEntryCode.PlaceHolder = "123"
-	cardEntryDate := widget.NewEntryWithData(r.CardRecord.Date)
-	cardEntryDate.PlaceHolder = "mm/dd/YYYY"
-	cardEntryPhone := widget.NewEntryWithData(r.CardRecord.Phone)
-	cardEntryPhone.PlaceHolder = "+1-888-888-8888"
-	cardEntryTags := widget.NewEntryWithData(r.CardRecord.Tags)
-	cardEntryTags.PlaceHolder = "tag1,tag2,..."
-	cardForm := &widget.Form{
-		Items: []*widget.FormItem{
-			widget.NewFormItem("Name", cardEntryName),
-			widget.NewFormItem("Card Number", cardEntryCard),
-			widget.NewFormItem("CVC Number", cardEntryCode),
-			widget.NewFormItem("Date", cardEntryDate),
-			widget.NewFormItem("Phone", cardEntryPhone),
-			widget.NewFormItem("Tags", cardEntryTags),
-		},
-		OnSubmit: r.CardForm,
+	// forms of new records are rendered from schemas of record kinds
+	var items []*widget.AccordionItem
+	for _, schema := range vt.Schemas() {
+		if schema.Kind == "file" {
+			// file records are created when files are encrypted
+			continue
+		}
+		title := fmt.Sprintf("%s Record", schema.Title)
+		items = append(items, widget.NewAccordionItem(title, container.NewVBox(r.schemaForm(schema))))
 	}
-	cardContainer := container.NewVBox(cardForm)
 
 	// sync form container
 	fromURI := widget.NewEntryWithData(r.SyncRecord.FromURI)
@@ -337,15 +201,10 @@ func (r *Record) buildUI() *container.Scroll {
 	//     }
 	//     fileContainer := container.NewVBox(fileForm)
 
+	//     items = append(items, widget.NewAccordionItem("File upload", fileContainer))
+	items = append(items, widget.NewAccordionItem("Sync", syncContainer))
 	return container.NewScroll(container.NewVBox(
-		&widget.Accordion{Items: []*widget.AccordionItem{
-			{Title: "Login Record", Detail: loginContainer},
-			{Title: "JSON Record", Detail: jsonContainer},
-			{Title: "Note Record", Detail: noteContainer},
-			{Title: "Card Record", Detail: cardContainer},
-			//             {Title: "File upload", Detail: fileContainer},
-			{Title: "Sync", Detail: syncContainer},
-		}},
+		&widget.Accordion{Items: items},
 	))
 }
 func (r *Record) tabItem() *container.TabItem {
@@ -12,6 +12,7 @@ import (
 	theme "fyne.io/fyne/v2/theme"
 	widget "fyne.io/fyne/v2/widget"
 	crypt "github.com/vkuznet/ecm/crypt"
+	vt "github.com/vkuznet/ecm/vault"
 )
 
 // helper function to fetch attribute value from preference and assign default
@@ -36,8 +37,10 @@ type Settings struct {
 	theme           *widget.Select
 	vaultCipher     *widget.Select
 	vaultDirectory  *widget.Entry
+	vaultKeyFile    *widget.Entry
 	vaultName       *widget.Entry
 	vaultAutologout *widget.Entry
+	breachDataset   *widget.Entry
 	fontSize        *widget.Select
 }
 
@@ -89,9 +92,10 @@ func (r *Settings) onVaultDirectoryChanged(v string) {
 		_vault.Directory = v
 		_vault.Records = nil
 		err := _vault.Read()
-		if err != nil {
+		if err != nil && !vt.PartialError(err) {
 			appLog("ERROR", "fail to read vault record", err)
 		} else {
+			warnRecords(err)
 			msg := fmt.Sprintf("Read vault %s, found %d records", v, len(_vault.Records))
 			appLog("INFO", msg, nil)
 			// refresh ui records
@@ -102,9 +106,32 @@ func (r *Settings) onVaultDirectoryChanged(v string) {
 	}
 	r.app.Preferences().SetString("VaultDirectory", v)
 }
+func (r *Settings) onVaultKeyFileChanged(v string) {
+	if v != "" {
+		if _, err := os.Stat(v); err != nil {
+			appLog("ERROR", "unable to find vault key file", err)
+			return
+		}
+	}
+	_vault.KeyFile = v
+	r.app.Preferences().SetString("VaultKeyFile", v)
+}
 func (r *Settings) onVaultNameChanged(v string) {
 	r.app.Preferences().SetString("VaultName", v)
 }
+func (r *Settings) onBreachDatasetChanged(v string) {
+	if v != "" {
+		if _, err := os.Stat(v); err != nil {
+			appLog("ERROR", "unable to find breached passwords dataset", err)
+			return
+		}
+	}
+	r.app.Preferences().SetString("BreachDataset", v)
+	// refresh ui records to show breaches of record passwords
+	if appRecords != nil {
+		appRecords.Refresh()
+	}
+}
 
 func (r *Settings) buildUI() *container.Scroll {
 
@@ -116,14 +143,22 @@ func (r *Settings) buildUI() *container.Scroll {
 	vaultDirectory := pref.String("VaultDirectory")
 	r.vaultDirectory = &widget.Entry{Text: vaultDirectory, OnSubmitted: r.onVaultDirectoryChanged}
 
+	vaultKeyFile := pref.String("VaultKeyFile")
+	r.vaultKeyFile = &widget.Entry{Text: vaultKeyFile, OnSubmitted: r.onVaultKeyFileChanged}
+	r.vaultKeyFile.PlaceHolder = "optional key file used along with master password"
+
 	vaultName := pref.String("VaultName")
 	r.vaultName = &widget.Entry{Text: vaultName, OnSubmitted: r.onVaultNameChanged}
 
+	breachDataset := pref.String("BreachDataset")
+	r.breachDataset = &widget.Entry{Text: breachDataset, OnSubmitted: r.onBreachDatasetChanged}
+	r.breachDataset.PlaceHolder = "optional HIBP SHA-1 hash file or directory of hash ranges"
+
 	// set autologout settings
 	r.vaultAutologout = widget.NewEntryWithData(autoThreshold)
 	r.vaultAutologout.OnSubmitted = r.onAutologoutChanged
 
-	r.vaultCipher = widget.NewSelect(crypt.SupportedCiphers, r.onVaultCipherChanged)
+	r.vaultCipher = widget.NewSelect(crypt.SupportedCiphers(), r.onVaultCipherChanged)
 	r.vaultCipher.SetSelected(vaultCipher)
 
 	fontSizes := []string{"Tiny", "Small", "Large", "Normal", "Huge"}
@@ -154,8 +189,12 @@ func (r *Settings) buildUI() *container.Scroll {
 		r.vaultCipher,
 		newBoldLabel("Vault directory"),
 		r.vaultDirectory,
+		newBoldLabel("Vault key file"),
+		r.vaultKeyFile,
 		newBoldLabel("Vault name"),
 		r.vaultName,
+		newBoldLabel("Breached passwords dataset"),
+		r.breachDataset,
 	)
 
 	return container.NewScroll(container.NewVBox(
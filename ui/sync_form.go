@@ -14,6 +14,7 @@ import (
 	theme "fyne.io/fyne/v2/theme"
 	widget "fyne.io/fyne/v2/widget"
 	ecmsync "github.com/vkuznet/ecm/sync"
+	vt "github.com/vkuznet/ecm/vault"
 	"golang.org/x/exp/errors"
 )
 
@@ -319,6 +320,11 @@ func syncFunc(app fyne.App, vdir, src string, local bool) {
 		rurl := fmt.Sprintf("%s/vault/%s/records?id=true", src, vname)
 		appLog("INFO", msg, nil)
 		err = ecmsync.SyncFromServer(rurl, dst)
+		if err == nil {
+			// attachments are transferred independently of records
+			burl := fmt.Sprintf("%s/vault/%s/blobs", src, vname)
+			err = ecmsync.SyncBlobsFromServer(burl, dst)
+		}
 	} else {
 		appLog("INFO", msg, nil)
 		mobile := true
@@ -338,7 +344,9 @@ func syncFunc(app fyne.App, vdir, src string, local bool) {
 	_vault.Records = nil
 	// read again vault records
 	err = _vault.Read()
-	if err != nil {
+	if vt.PartialError(err) {
+		warnRecords(err)
+	} else if err != nil {
 		msg := fmt.Sprintf("unable to read the vault records, %v", err)
 		appLog("ERROR", msg, err)
 		syncStatus.Set(msg)
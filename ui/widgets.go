@@ -3,7 +3,6 @@ package main
 import (
 	"fmt"
 	"image/color"
-	"log"
 	"net/url"
 
 	fyne "fyne.io/fyne/v2"
@@ -65,7 +64,6 @@ func MainWindow(app fyne.App, w fyne.Window) {
 
 	password := widget.NewPasswordEntry()
 	password.OnSubmitted = func(p string) {
-		log.Println("password", p)
 		var err error
 		if err != nil {
 			ErrorWindow(w)
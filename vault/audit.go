@@ -0,0 +1,205 @@
+package vault
+
+// audit module checks health of vault passwords. The audit finds passwords
+// which are reused across records, weak passwords, i.e. passwords with low
+// entropy or passwords which can be guessed by known patterns, and passwords
+// which are not changed within rotation period. It also flags login records
+// with empty passwords, insecure http:// URLs and without TOTP. The audit is
+// performed on vault records which are already read.
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// DefaultRotationPeriod defines period after which password is considered stale
+const DefaultRotationPeriod = 365 * 24 * time.Hour
+
+// MinPasswordEntropy defines minimal entropy in bits of password which is not weak
+const MinPasswordEntropy = 50
+
+// audit issue kinds ordered by their severity
+const (
+	AuditEmpty    = "empty"    // login record without password
+	AuditReused   = "reused"   // password is used by several records
+	AuditWeak     = "weak"     // password has low entropy or known pattern
+	AuditStale    = "stale"    // password is not changed within rotation period
+	AuditInsecure = "insecure" // record URL uses http:// scheme
+	AuditNoTOTP   = "no-totp"  // login record without TOTP
+)
+
+// AuditKinds lists audit issue kinds ordered by their severity
+var AuditKinds = []string{AuditEmpty, AuditReused, AuditWeak, AuditStale, AuditInsecure, AuditNoTOTP}
+
+// AuditIssue represents single issue found by vault audit
+type AuditIssue struct {
+	ID     string `json:"id"`     // record ID
+	Name   string `json:"name"`   // record name
+	Kind   string `json:"kind"`   // issue kind, see AuditKinds
+	Detail string `json:"detail"` // issue description
+}
+
+// AuditReport represents report of vault audit
+type AuditReport struct {
+	Time      time.Time      `json:"time"`      // time of the audit
+	Records   int            `json:"records"`   // number of audited records
+	Passwords int            `json:"passwords"` // number of audited passwords
+	Days      int            `json:"days"`      // password rotation period in days
+	Counts    map[string]int `json:"counts"`    // number of issues of every kind
+	Issues    []AuditIssue   `json:"issues"`    // found issues
+}
+
+// Summary provides single line summary of audit report
+func (r *AuditReport) Summary() string {
+	var out []string
+	for _, kind := range AuditKinds {
+		out = append(out, fmt.Sprintf("%s: %d", kind, r.Counts[kind]))
+	}
+	return fmt.Sprintf("audited %d records with %d passwords, %s", r.Records, r.Passwords, strings.Join(out, ", "))
+}
+
+// Healthy checks if audit report does not have issues
+func (r *AuditReport) Healthy() bool {
+	return len(r.Issues) == 0
+}
+
+// helper function to get vault password rotation period
+func (v *Vault) rotationPeriod() time.Duration {
+	if v.RotationPeriod <= 0 {
+		return DefaultRotationPeriod
+	}
+	return v.RotationPeriod
+}
+
+// PasswordEntropy estimates entropy of given password in bits based on its
+// length and classes of its characters
+func PasswordEntropy(password string) float64 {
+	var lower, upper, digit, symbol, other bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r) && r < unicode.MaxASCII:
+			lower = true
+		case unicode.IsUpper(r) && r < unicode.MaxASCII:
+			upper = true
+		case unicode.IsDigit(r) && r < unicode.MaxASCII:
+			digit = true
+		case r < unicode.MaxASCII:
+			symbol = true
+		default:
+			other = true
+		}
+	}
+	pool := 0
+	for _, c := range []struct {
+		used bool
+		size int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 100}} {
+		if c.used {
+			pool += c.size
+		}
+	}
+	if pool == 0 {
+		return 0
+	}
+	return float64(len([]rune(password))) * math.Log2(float64(pool))
+}
+
+// helper function to provide record name used in audit report
+func auditName(rec *VaultRecord) string {
+	if name := rec.Map["Name"]; name != "" {
+		return name
+	}
+	return rec.ID
+}
+
+// Audit checks health of passwords of vault records and provides audit
+// report, the issues are ordered by their severity and record names
+func (v *Vault) Audit() *AuditReport {
+	period := v.rotationPeriod()
+	report := &AuditReport{
+		Time:    time.Now(),
+		Records: len(v.Records),
+		Days:    int(period / (24 * time.Hour)),
+		Counts:  make(map[string]int),
+	}
+	add := func(rec *VaultRecord, kind, detail string) {
+		issue := AuditIssue{ID: rec.ID, Name: auditName(rec), Kind: kind, Detail: detail}
+		report.Issues = append(report.Issues, issue)
+		report.Counts[kind]++
+	}
+
+	// records are grouped by their passwords to find reused ones, i.e.
+	// by SHA-256 hashes of passwords kept in secure buffers
+	passwords := make(map[[sha256.Size]byte][]int)
+	for idx := range v.Records {
+		rec := &v.Records[idx]
+		login := rec.RecordKind() == "login"
+		password := rec.Secret("Password")
+		if len(password) == 0 {
+			if login {
+				add(rec, AuditEmpty, "login record does not have password")
+			}
+		} else {
+			report.Passwords++
+			hash := sha256.Sum256(password)
+			passwords[hash] = append(passwords[hash], idx)
+			entropy := PasswordEntropy(rec.Value("Password"))
+			strength := rec.PasswordStrength()
+			if strength.Weak() || entropy < MinPasswordEntropy {
+				detail := fmt.Sprintf("score %d of 4, entropy %.0f bits, crack time %s",
+					strength.Score, entropy, strength.CrackTimeString())
+				if strength.Warning != "" {
+					detail = fmt.Sprintf("%s, %s", detail, strings.ToLower(strength.Warning))
+				}
+				add(rec, AuditWeak, detail)
+			}
+			if !rec.ModificationTime.IsZero() && time.Since(rec.ModificationTime) > period {
+				days := int(time.Since(rec.ModificationTime) / (24 * time.Hour))
+				add(rec, AuditStale, fmt.Sprintf("password is not changed for %d days", days))
+			}
+		}
+		if url := rec.Map["URL"]; strings.HasPrefix(strings.ToLower(strings.TrimSpace(url)), "http://") {
+			add(rec, AuditInsecure, fmt.Sprintf("URL %s does not use https", url))
+		}
+		if login && len(rec.Secret(TOTPKey)) == 0 {
+			add(rec, AuditNoTOTP, "login record does not have TOTP")
+		}
+	}
+	for _, indexes := range passwords {
+		if len(indexes) < 2 {
+			continue
+		}
+		for _, idx := range indexes {
+			var names []string
+			for _, i := range indexes {
+				if i != idx {
+					names = append(names, auditName(&v.Records[i]))
+				}
+			}
+			sort.Strings(names)
+			detail := fmt.Sprintf("password is shared with %s", strings.Join(names, ", "))
+			add(&v.Records[idx], AuditReused, detail)
+		}
+	}
+
+	severity := make(map[string]int)
+	for idx, kind := range AuditKinds {
+		severity[kind] = idx
+	}
+	sort.SliceStable(report.Issues, func(i, j int) bool {
+		a, b := report.Issues[i], report.Issues[j]
+		if a.Kind != b.Kind {
+			return severity[a.Kind] < severity[b.Kind]
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.ID < b.ID
+	})
+	return report
+}
@@ -0,0 +1,630 @@
+package vault
+
+// blob module keeps record attachments. The attachment content is encrypted
+// as a stream with vault data key and kept in blobs/<name> area of the vault,
+// where name is HMAC-SHA256 of attachment hash keyed by vault data key, and
+// hash is SHA-256 checksum of attachment content which is kept only in
+// encrypted vault records. Therefore identical attachments are stored only
+// once, blobs can be transferred independently of vault records, and blob
+// names do not reveal attachment content. Blobs which are no longer
+// referenced by vault records, their versions or deleted records are removed
+// by CollectBlobs.
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	crypt "github.com/vkuznet/ecm/crypt"
+	utils "github.com/vkuznet/ecm/utils"
+)
+
+// legacyFilesDir defines name of vault area where old vaults kept encrypted
+// files, the files are moved to vault blobs when vault is migrated
+const legacyFilesDir = "files"
+
+// BlobsDir defines name of vault area which keeps encrypted attachments
+const BlobsDir = "blobs"
+
+// Attachment represents vault record attachment
+type Attachment struct {
+	Name string `json:"name"` // attachment name
+	Hash string `json:"hash"` // SHA-256 checksum of attachment content
+	Size int64  `json:"size"` // attachment size
+}
+
+// UnmarshalJSON implements json.Unmarshaler interface, records written by
+// old vaults keep path of attached file instead of attachment
+func (a *Attachment) UnmarshalJSON(data []byte) error {
+	var path string
+	if err := json.Unmarshal(data, &path); err == nil {
+		*a = Attachment{Name: filepath.Base(path)}
+		return nil
+	}
+	type attachment Attachment
+	return json.Unmarshal(data, (*attachment)(a))
+}
+
+// Attachment provides record attachment with given name
+func (r *VaultRecord) Attachment(name string) (Attachment, bool) {
+	for _, att := range r.Attachments {
+		if att.Name == name {
+			return att, true
+		}
+	}
+	return Attachment{}, false
+}
+
+// ValidBlob checks if given string is valid blob name
+func ValidBlob(name string) bool {
+	if len(name) != 2*sha256.Size {
+		return false
+	}
+	_, err := hex.DecodeString(name)
+	return err == nil
+}
+
+// helper function to provide name of blob which keeps content with given
+// hash, the name is keyed by vault data key
+func blobName(key []byte, hash string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("ecm blob:"))
+	mac.Write([]byte(hash))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// BlobFile provides file name of blob with given name
+func (v *Vault) BlobFile(name string) (string, error) {
+	if !ValidBlob(name) {
+		return "", fmt.Errorf("invalid blob name '%s'", name)
+	}
+	return filepath.Join(v.Directory, BlobsDir, name), nil
+}
+
+// helper function to provide file name of blob which keeps content with
+// given hash, blobs written by old vaults are named after content hash
+func (v *Vault) hashFile(key []byte, hash string) (string, error) {
+	fname, err := v.BlobFile(blobName(key, hash))
+	if err != nil || utils.FileExist(fname) {
+		return fname, err
+	}
+	if legacy, err := v.BlobFile(hash); err == nil && utils.FileExist(legacy) {
+		return legacy, nil
+	}
+	return fname, nil
+}
+
+// Blobs provides sorted list of names of vault blobs
+func (v *Vault) Blobs() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(v.Directory, BlobsDir))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var out []string
+	for _, entry := range entries {
+		if !entry.IsDir() && ValidBlob(entry.Name()) {
+			out = append(out, entry.Name())
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// EncryptStream encrypts content of given reader in constant memory and
+// keeps it as vault blob, it returns SHA-256 hash and size of the content
+// which are kept by record attachment referring to the blob
+func (v *Vault) EncryptStream(r io.Reader) (string, int64, error) {
+	unlock, err := v.lockDir(true)
+	if err != nil {
+		return "", 0, err
+	}
+	defer unlock()
+	return v.storeBlob(r)
+}
+
+// DecryptStream decrypts vault blob of content with given SHA-256 hash in
+// constant memory and writes the content to given writer
+func (v *Vault) DecryptStream(hash string, w io.Writer) error {
+	unlock, err := v.lockDir(false)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	key, err := v.DataKey()
+	if err != nil {
+		return err
+	}
+	fname, err := v.hashFile(key, hash)
+	if err != nil {
+		return err
+	}
+	match := func(sum string) bool { return sum == hash }
+	return readBlob(fname, key, match, w)
+}
+
+// helper function to encrypt content of given reader and keep it as blob,
+// it returns hash and size of the content
+func (v *Vault) storeBlob(r io.Reader) (string, int64, error) {
+	key, err := v.writeKey()
+	if err != nil {
+		return "", 0, err
+	}
+	bdir := filepath.Join(v.Directory, BlobsDir)
+	err = os.MkdirAll(bdir, 0700)
+	if err != nil {
+		return "", 0, err
+	}
+	// blob hash is known only when its content is read, therefore content
+	// is encrypted to temporary file first
+	file, err := os.CreateTemp(bdir, "blob-*.tmp")
+	if err != nil {
+		return "", 0, err
+	}
+	tmp := file.Name()
+	defer os.Remove(tmp)
+	hash := sha256.New()
+	var size int64
+	writer, err := crypt.NewWriter(file, key, v.Cipher)
+	if err == nil {
+		size, err = io.Copy(writer, io.TeeReader(r, hash))
+	}
+	if err == nil {
+		err = writer.Close()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+	fname := filepath.Join(bdir, blobName(key, sum))
+	// identical content is kept only once
+	if utils.FileExist(fname) {
+		return sum, size, nil
+	}
+	return sum, size, renameFile(tmp, fname)
+}
+
+// ReadBlob decrypts blob with given name and writes its content to given
+// writer. The content is verified against blob name before it is written,
+// therefore the blob is decrypted twice: first to verify its content and
+// then to write it, and nothing is written to the writer if blob does not
+// match its name. Decrypted content is never kept in memory or on disk
+func (v *Vault) ReadBlob(name string, w io.Writer) error {
+	unlock, err := v.lockDir(false)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	fname, err := v.BlobFile(name)
+	if err != nil {
+		return err
+	}
+	key, err := v.DataKey()
+	if err != nil {
+		return err
+	}
+	// blobs written by old vaults are named after content hash
+	match := func(sum string) bool {
+		return sum == name || hmac.Equal([]byte(blobName(key, sum)), []byte(name))
+	}
+	return readBlob(fname, key, match, w)
+}
+
+// helper function to decrypt given blob file with given key and write its
+// content to given writer once checksum of the content matches the blob
+func readBlob(fname string, key []byte, match func(sum string) bool, w io.Writer) error {
+	if err := decryptBlob(fname, key, match, io.Discard); err != nil {
+		return err
+	}
+	return decryptBlob(fname, key, match, w)
+}
+
+// helper function to decrypt given blob file with given key, write its
+// content to given writer and verify checksum of the content
+func decryptBlob(fname string, key []byte, match func(sum string) bool, w io.Writer) error {
+	file, err := os.Open(fname)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	reader, err := crypt.NewReader(file, key)
+	if err != nil {
+		return err
+	}
+	sum := sha256.New()
+	_, err = io.Copy(io.MultiWriter(w, sum), reader)
+	if err != nil {
+		return err
+	}
+	if !match(hex.EncodeToString(sum.Sum(nil))) {
+		return fmt.Errorf("content of blob %s does not match its name", filepath.Base(fname))
+	}
+	return nil
+}
+
+// WriteBlob writes encrypted blob with given name as is, it is used to
+// transfer blobs between vaults which share vault data key
+func (v *Vault) WriteBlob(name string, r io.Reader) error {
+	unlock, err := v.lockDir(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	fname, err := v.BlobFile(name)
+	if err != nil {
+		return err
+	}
+	if utils.FileExist(fname) {
+		return nil
+	}
+	return copyStream(fname, r)
+}
+
+// helper function to write content of given reader to given file via
+// temporary file
+func copyStream(fname string, r io.Reader) error {
+	err := os.MkdirAll(filepath.Dir(fname), 0700)
+	if err != nil {
+		return err
+	}
+	file, err := createTemp(fname)
+	if err != nil {
+		return err
+	}
+	tmp := file.Name()
+	defer os.Remove(tmp)
+	_, err = io.Copy(file, r)
+	if err == nil {
+		err = file.Sync()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return renameFile(tmp, fname)
+}
+
+// AddAttachment encrypts given file and attaches it to vault record
+func (v *Vault) AddAttachment(rid, fname string) (Attachment, error) {
+	file, err := os.Open(fname)
+	if err != nil {
+		return Attachment{}, err
+	}
+	defer file.Close()
+	return v.Attach(rid, filepath.Base(fname), file)
+}
+
+// Attach encrypts content of given reader and attaches it to vault record
+// under given name, existing attachment with the same name is replaced
+func (v *Vault) Attach(rid, name string, r io.Reader) (Attachment, error) {
+	unlock, err := v.lockDir(true)
+	if err != nil {
+		return Attachment{}, err
+	}
+	defer unlock()
+	if name == "" {
+		return Attachment{}, errors.New("attachment name is not provided")
+	}
+	rec, err := v.LoadRecord(rid)
+	if err != nil {
+		return Attachment{}, err
+	}
+	hash, size, err := v.EncryptStream(r)
+	if err != nil {
+		return Attachment{}, err
+	}
+	att := Attachment{Name: name, Hash: hash, Size: size}
+	attachments := []Attachment{}
+	for _, a := range rec.Attachments {
+		if a.Name != name {
+			attachments = append(attachments, a)
+		}
+	}
+	rec.Attachments = append(attachments, att)
+	err = v.Update(rec)
+	if err != nil {
+		return Attachment{}, err
+	}
+	if v.Verbose > 0 {
+		log.Printf("attachment %s of record %s is stored in blob %s", name, rid, hash)
+	}
+	return att, nil
+}
+
+// ExtractAttachment decrypts attachment of vault record and writes its
+// content to given writer
+func (v *Vault) ExtractAttachment(rid, name string, w io.Writer) error {
+	unlock, err := v.lockDir(false)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	rec, err := v.LoadRecord(rid)
+	if err != nil {
+		return err
+	}
+	att, ok := rec.Attachment(name)
+	if !ok {
+		return fmt.Errorf("record %s does not have attachment %s", rid, name)
+	}
+	if att.Hash == "" {
+		return fmt.Errorf("attachment %s of record %s does not have content", name, rid)
+	}
+	return v.DecryptStream(att.Hash, w)
+}
+
+// RemoveAttachment removes attachment from vault record, the blob of the
+// attachment is kept until it is removed by CollectBlobs since it may be
+// used by other records or record versions
+func (v *Vault) RemoveAttachment(rid, name string) error {
+	unlock, err := v.lockDir(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	rec, err := v.LoadRecord(rid)
+	if err != nil {
+		return err
+	}
+	if _, ok := rec.Attachment(name); !ok {
+		return fmt.Errorf("record %s does not have attachment %s", rid, name)
+	}
+	attachments := []Attachment{}
+	for _, a := range rec.Attachments {
+		if a.Name != name {
+			attachments = append(attachments, a)
+		}
+	}
+	rec.Attachments = attachments
+	return v.Update(rec)
+}
+
+// helper function to move encrypted files which old vaults kept in files
+// area of the vault and its trash to vault blobs, the files are attached
+// to their records. It returns number of moved files
+func (v *Vault) migrateStreams() (int, error) {
+	streams, err := filepath.Glob(filepath.Join(v.Directory, legacyFilesDir, "*"))
+	if err != nil {
+		return 0, err
+	}
+	trashStreams, err := filepath.Glob(filepath.Join(v.Directory, TrashDir, "*", legacyFilesDir, "*"))
+	if err != nil {
+		return 0, err
+	}
+	streams = append(streams, trashStreams...)
+	var count int
+	for _, fname := range streams {
+		if strings.HasSuffix(fname, ".tmp") {
+			continue
+		}
+		ok, err := v.migrateStream(fname)
+		if err != nil {
+			return count, fmt.Errorf("unable to migrate %s, error %v", fname, err)
+		}
+		if ok {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// helper function to move given encrypted file from files area to vault
+// blobs and attach it to the record which owns the file
+func (v *Vault) migrateStream(fname string) (bool, error) {
+	rid := filepath.Base(fname)
+	// record file is kept next to files area, either in vault or in trash,
+	// and record may be deleted after its file was written
+	rdir := filepath.Dir(filepath.Dir(fname))
+	if rdir == v.Directory && !utils.FileExist(filepath.Join(rdir, rid)) {
+		rdir = filepath.Join(v.Directory, TrashDir, rid)
+	}
+	rfile := filepath.Join(rdir, rid)
+	if !utils.FileExist(rfile) {
+		log.Printf("encrypted file %s does not belong to any vault record", fname)
+		return false, nil
+	}
+	rec, err := v.readRecord(rfile, rid)
+	if err != nil {
+		return false, err
+	}
+	key, err := v.DataKey()
+	if err != nil {
+		return false, err
+	}
+	file, err := os.Open(fname)
+	if err != nil {
+		return false, err
+	}
+	reader, err := crypt.NewReader(file, key)
+	if err != nil {
+		file.Close()
+		return false, err
+	}
+	hash, size, err := v.storeBlob(reader)
+	file.Close()
+	if err != nil {
+		return false, err
+	}
+	name := rec.Map["Name"]
+	if name == "" {
+		name = rid
+	}
+	attachments := []Attachment{}
+	for _, a := range rec.Attachments {
+		if a.Name != name {
+			attachments = append(attachments, a)
+		}
+	}
+	rec.Attachments = append(attachments, Attachment{Name: name, Hash: hash, Size: size})
+	if rdir == v.Directory {
+		err = v.Update(rec)
+	} else {
+		err = v.writeTrashRecord(rfile, rec)
+		rec.Wipe()
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := os.Remove(fname); err != nil {
+		return false, err
+	}
+	// files area is removed once it is empty
+	os.Remove(filepath.Dir(fname))
+	return true, nil
+}
+
+// helper function to encrypt given deleted record and write it to given
+// trash file
+func (v *Vault) writeTrashRecord(fname string, rec VaultRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	defer crypt.WipeBytes(data)
+	edata, err := v.EncryptRecord(rec.ID, data)
+	if err != nil {
+		return err
+	}
+	return writeFile(fname, edata)
+}
+
+// helper function to re-encrypt given blob file with new key and rename it
+// after content hash keyed by new key
+func rotateBlob(fname string, oldKey, newKey []byte, cipher string) error {
+	file, err := os.Open(fname)
+	if err != nil {
+		return err
+	}
+	reader, err := crypt.NewReader(file, oldKey)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	hash := sha256.New()
+	err = writeStream(fname, io.TeeReader(reader, hash), newKey, cipher)
+	file.Close()
+	if err != nil {
+		return err
+	}
+	name := blobName(newKey, hex.EncodeToString(hash.Sum(nil)))
+	return renameFile(fname, filepath.Join(filepath.Dir(fname), name))
+}
+
+// helper function to collect names of blobs referenced by vault records,
+// their versions and deleted records
+func (v *Vault) blobRefs() (map[string]bool, error) {
+	key, err := v.DataKey()
+	if err != nil {
+		return nil, err
+	}
+	// record files are mapped to their record IDs
+	rids := make(map[string]string)
+	files, err := v.Files()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range files {
+		rids[filepath.Join(v.Directory, name)] = name
+	}
+	trash, err := v.Trash()
+	if err != nil {
+		return nil, err
+	}
+	for _, rec := range trash {
+		rids[filepath.Join(v.Directory, TrashDir, rec.ID, rec.ID)] = rec.ID
+	}
+	versions, err := filepath.Glob(filepath.Join(v.Directory, HistoryDir, "*", "*"))
+	if err != nil {
+		return nil, err
+	}
+	trashVersions, err := filepath.Glob(filepath.Join(v.Directory, TrashDir, "*", HistoryDir, "*", "*"))
+	if err != nil {
+		return nil, err
+	}
+	for _, fname := range append(versions, trashVersions...) {
+		rids[fname] = filepath.Base(filepath.Dir(fname))
+	}
+
+	refs := make(map[string]bool)
+	for fname, rid := range rids {
+		if info, err := os.Stat(fname); err != nil || info.IsDir() || info.Size() == 0 {
+			continue
+		}
+		// blobs can not be collected if any record is not readable
+		rec, err := v.readRecord(fname, rid)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read %s, error %v", fname, err)
+		}
+		for _, att := range rec.Attachments {
+			if att.Hash != "" {
+				// blobs written by old vaults are named after content hash
+				refs[blobName(key, att.Hash)] = true
+				refs[att.Hash] = true
+			}
+		}
+		rec.Wipe()
+	}
+	return refs, nil
+}
+
+// CollectBlobs removes blobs which are not referenced by vault records,
+// their versions or deleted records, it returns names of removed blobs
+func (v *Vault) CollectBlobs() ([]string, error) {
+	unlock, err := v.lockDir(true)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	refs, err := v.blobRefs()
+	if err != nil {
+		return nil, err
+	}
+	blobs, err := v.Blobs()
+	if err != nil {
+		return nil, err
+	}
+	var removed []string
+	for _, name := range blobs {
+		if refs[name] {
+			continue
+		}
+		err := os.Remove(filepath.Join(v.Directory, BlobsDir, name))
+		if err != nil {
+			return removed, err
+		}
+		if v.Verbose > 0 {
+			log.Printf("unreferenced blob %s is removed", name)
+		}
+		removed = append(removed, name)
+	}
+	// remove leftovers of interrupted writes
+	tmps, err := filepath.Glob(filepath.Join(v.Directory, BlobsDir, "*.tmp"))
+	if err == nil {
+		for _, fname := range tmps {
+			if info, err := os.Stat(fname); err == nil && time.Since(info.ModTime()) > time.Hour {
+				os.Remove(fname)
+			}
+		}
+	}
+	return removed, nil
+}
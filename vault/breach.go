@@ -0,0 +1,237 @@
+package vault
+
+// breach module checks record passwords against local copy of Have I Been
+// Pwned (HIBP) dataset of SHA-1 password hashes, see
+// https://haveibeenpwned.com/Passwords
+// The dataset can be either single file of HASH:COUNT lines sorted by hash,
+// which is searched by binary search, or directory of range files in
+// k-anonymity style, where every file is named after first 5 characters of
+// SHA-1 hash, e.g. 21BD1 or 21BD1.txt, and keeps SUFFIX:COUNT lines of
+// hashes with that prefix. Passwords are never sent over the network.
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// length of hash prefix used by HIBP range files
+const breachPrefixLength = 5
+
+// maximum length of dataset line, lines are hash, colon and count
+const breachLineLength = 128
+
+// BreachDB represents local copy of HIBP dataset of SHA-1 password hashes
+type BreachDB struct {
+	Path string   // path of dataset file or directory
+	file *os.File // sorted dataset file, nil for directory of range files
+	size int64    // size of dataset file
+}
+
+// Breach represents number of times record password is found in breached
+// passwords dataset
+type Breach struct {
+	ID    string `json:"id"`    // record ID
+	Name  string `json:"name"`  // record name
+	Count int    `json:"count"` // number of times password appears in the dataset, zero if it is not found
+}
+
+// OpenBreachDB opens HIBP dataset kept in given file or directory
+func OpenBreachDB(path string) (*BreachDB, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	db := &BreachDB{Path: path}
+	if info.IsDir() {
+		return db, nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	db.file = file
+	db.size = info.Size()
+	return db, nil
+}
+
+// Close closes HIBP dataset
+func (db *BreachDB) Close() error {
+	if db.file == nil {
+		return nil
+	}
+	return db.file.Close()
+}
+
+// PasswordHash provides upper case hex SHA-1 hash of given password used by
+// HIBP dataset
+func PasswordHash(password string) string {
+	return passwordHash([]byte(password))
+}
+
+// helper function to provide HIBP hash of given password data
+func passwordHash(data []byte) string {
+	sum := sha1.Sum(data)
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// Count provides number of times given password appears in HIBP dataset,
+// zero count means that password is not found
+func (db *BreachDB) Count(password string) (int, error) {
+	return db.CountHash(PasswordHash(password))
+}
+
+// CountHash provides number of times password with given SHA-1 hash appears
+// in HIBP dataset
+func (db *BreachDB) CountHash(hash string) (int, error) {
+	hash = strings.ToUpper(hash)
+	if len(hash) != 2*sha1.Size {
+		return 0, fmt.Errorf("invalid SHA-1 hash '%s'", hash)
+	}
+	if db.file != nil {
+		return db.searchFile(hash)
+	}
+	return db.searchRange(hash)
+}
+
+// helper function to parse dataset line into upper case hash and count,
+// lines without count represent single occurrence
+func parseBreachLine(line []byte) (string, int) {
+	line = bytes.TrimSpace(line)
+	hash, count, found := bytes.Cut(line, []byte(":"))
+	if !found {
+		return strings.ToUpper(string(hash)), 1
+	}
+	val, err := strconv.Atoi(string(count))
+	if err != nil {
+		val = 1
+	}
+	return strings.ToUpper(string(hash)), val
+}
+
+// helper function to read dataset line which starts at given offset, it
+// returns the line and offset of the next line
+func (db *BreachDB) readLine(off int64) ([]byte, int64, error) {
+	buf := make([]byte, breachLineLength)
+	n, err := db.file.ReadAt(buf, off)
+	if err != nil && err != io.EOF {
+		return nil, 0, err
+	}
+	buf = buf[:n]
+	if idx := bytes.IndexByte(buf, '\n'); idx >= 0 {
+		return buf[:idx], off + int64(idx) + 1, nil
+	}
+	if off+int64(n) < db.size {
+		return nil, 0, fmt.Errorf("line at offset %d of %s is too long", off, db.Path)
+	}
+	return buf, db.size, nil
+}
+
+// helper function to find offset of first line which starts at or after
+// given offset
+func (db *BreachDB) lineStart(off int64) (int64, error) {
+	if off == 0 {
+		return 0, nil
+	}
+	// line starts after new line character which precedes given offset
+	_, next, err := db.readLine(off - 1)
+	return next, err
+}
+
+// helper function to find hash in sorted dataset file by binary search over
+// file offsets, lo and hi offsets bound lines which may keep the hash
+func (db *BreachDB) searchFile(hash string) (int, error) {
+	lo, hi := int64(0), db.size
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		start, err := db.lineStart(mid)
+		if err != nil {
+			return 0, err
+		}
+		if start >= hi {
+			// no line starts in upper half, check first line of the range
+			start = lo
+		}
+		line, next, err := db.readLine(start)
+		if err != nil {
+			return 0, err
+		}
+		val, count := parseBreachLine(line)
+		switch {
+		case val == hash:
+			return count, nil
+		case hash < val:
+			if start == lo {
+				return 0, nil
+			}
+			hi = start
+		default:
+			lo = next
+		}
+	}
+	return 0, nil
+}
+
+// helper function to find hash in range file of its prefix
+func (db *BreachDB) searchRange(hash string) (int, error) {
+	prefix, suffix := hash[:breachPrefixLength], hash[breachPrefixLength:]
+	var file *os.File
+	for _, name := range []string{prefix, prefix + ".txt", strings.ToLower(prefix), strings.ToLower(prefix) + ".txt"} {
+		f, err := os.Open(filepath.Join(db.Path, name))
+		if err == nil {
+			file = f
+			break
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return 0, err
+		}
+	}
+	if file == nil {
+		// dataset does not have hashes with given prefix
+		return 0, nil
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		val, count := parseBreachLine(scanner.Bytes())
+		// range files may keep either hash suffixes or full hashes
+		if val == suffix || val == hash {
+			return count, nil
+		}
+	}
+	return 0, scanner.Err()
+}
+
+// Breaches checks passwords of vault records against HIBP dataset, records
+// without password are skipped. The breached records are listed first.
+func (v *Vault) Breaches(db *BreachDB) ([]Breach, error) {
+	var out []Breach
+	for idx := range v.Records {
+		rec := &v.Records[idx]
+		password := rec.Secret("Password")
+		if len(password) == 0 {
+			continue
+		}
+		count, err := db.CountHash(passwordHash(password))
+		if err != nil {
+			return nil, fmt.Errorf("unable to check password of record %s, error %v", rec.ID, err)
+		}
+		out = append(out, Breach{ID: rec.ID, Name: auditName(rec), Count: count})
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].Name < out[j].Name
+	})
+	return out, nil
+}
@@ -0,0 +1,280 @@
+package vault
+
+// conceal module keeps values of sensitive record fields, e.g. passwords,
+// TOTP seeds and concealed fields of record schemas, in secure buffers.
+// Go strings can not be zeroed, therefore decrypted records never keep
+// these values in record map. The map keeps empty value of sensitive field
+// while its value is kept in secure buffer which is zeroed when record is
+// wiped. Records are encoded and decoded as JSON without converting these
+// values to strings, and values are provided as bytes by Secret method and
+// as strings only by Value method for callers which display them.
+
+import (
+	"encoding/json"
+	"errors"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/vkuznet/ecm/crypt"
+)
+
+// Secret provides value of given record field as bytes, values of sensitive
+// fields are kept in secure buffer and they are valid until record is wiped,
+// therefore provided data should not be changed or kept
+func (r *VaultRecord) Secret(key string) []byte {
+	if val := r.Map[key]; val != "" {
+		return []byte(val)
+	}
+	if b, ok := r.secrets[key]; ok {
+		return b.Bytes()
+	}
+	return nil
+}
+
+// Value provides value of given record field, the value of sensitive field
+// is copied to string which can not be wiped, therefore it should be used
+// only to display or to copy the value
+func (r *VaultRecord) Value(key string) string {
+	if val := r.Map[key]; val != "" || !SensitiveKey(key) {
+		return val
+	}
+	return string(r.Secret(key))
+}
+
+// SetValue sets value of given record field, the value of sensitive field
+// is moved to secure buffer
+func (r *VaultRecord) SetValue(key, val string) {
+	if !SensitiveKey(key) {
+		if r.Map == nil {
+			r.Map = make(Record)
+		}
+		r.Map[key] = val
+		return
+	}
+	// record copies share their map, therefore it is changed on the copy
+	rmap := make(Record, len(r.Map)+1)
+	for k, v := range r.Map {
+		rmap[k] = v
+	}
+	rmap[key] = val
+	r.Map = rmap
+	secrets := make(map[string]*crypt.SecureBuffer, len(r.secrets))
+	for k, b := range r.secrets {
+		if k != key {
+			secrets[k] = b
+		}
+	}
+	r.secrets = secrets
+	r.Conceal()
+}
+
+// Conceal moves values of sensitive fields of the record to secure buffers
+func (r *VaultRecord) Conceal() {
+	var keys []string
+	for key, val := range r.Map {
+		if val != "" && SensitiveKey(key) {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return
+	}
+	// record copies share their map, therefore concealed record gets new one
+	rmap := make(Record, len(r.Map))
+	for k, v := range r.Map {
+		rmap[k] = v
+	}
+	secrets := make(map[string]*crypt.SecureBuffer, len(r.secrets)+len(keys))
+	for k, b := range r.secrets {
+		secrets[k] = b
+	}
+	for _, key := range keys {
+		secrets[key] = crypt.SecureBytes([]byte(rmap[key]))
+		rmap[key] = ""
+	}
+	r.Map, r.secrets = rmap, secrets
+}
+
+// helper type to encode and decode vault record without its custom JSON
+// methods
+type jsonRecord VaultRecord
+
+// MarshalJSON implements json.Marshaler interface, values of sensitive
+// fields are encoded from their secure buffers
+func (r VaultRecord) MarshalJSON() ([]byte, error) {
+	var quoted [][]byte
+	defer func() {
+		for _, data := range quoted {
+			crypt.WipeBytes(data)
+		}
+	}()
+	rmap := make(map[string]json.RawMessage, len(r.Map))
+	for key, val := range r.Map {
+		if b, ok := r.secrets[key]; ok && val == "" {
+			data := quoteBytes(b.Bytes())
+			quoted = append(quoted, data)
+			rmap[key] = data
+			continue
+		}
+		data, err := json.Marshal(val)
+		if err != nil {
+			return nil, err
+		}
+		rmap[key] = data
+	}
+	rec := struct {
+		jsonRecord
+		Map map[string]json.RawMessage
+	}{jsonRecord: jsonRecord(r), Map: rmap}
+	if r.Map == nil {
+		rec.Map = nil
+	}
+	return json.Marshal(rec)
+}
+
+// UnmarshalJSON implements json.Unmarshaler interface, values of sensitive
+// fields are decoded directly to secure buffers
+func (r *VaultRecord) UnmarshalJSON(data []byte) error {
+	var rec struct {
+		jsonRecord
+		Map map[string]json.RawMessage
+	}
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return err
+	}
+	*r = VaultRecord(rec.jsonRecord)
+	if rec.Map == nil {
+		return nil
+	}
+	r.Map = make(Record, len(rec.Map))
+	for key, raw := range rec.Map {
+		if !SensitiveKey(key) {
+			var val string
+			if err := json.Unmarshal(raw, &val); err != nil {
+				return err
+			}
+			r.Map[key] = val
+			continue
+		}
+		val, err := unquoteBytes(raw)
+		crypt.WipeBytes(raw)
+		if err != nil {
+			return err
+		}
+		r.Map[key] = ""
+		if len(val) > 0 {
+			if r.secrets == nil {
+				r.secrets = make(map[string]*crypt.SecureBuffer)
+			}
+			r.secrets[key] = crypt.SecureBytes(val)
+		}
+	}
+	return nil
+}
+
+// helper function to encode given data as JSON string without converting
+// it to Go string
+func quoteBytes(data []byte) []byte {
+	const hexDigits = "0123456789abcdef"
+	out := make([]byte, 0, 2*len(data)+2)
+	out = append(out, '"')
+	for _, c := range data {
+		switch {
+		case c == '"' || c == '\\':
+			out = append(out, '\\', c)
+		case c < 0x20:
+			out = append(out, '\\', 'u', '0', '0', hexDigits[c>>4], hexDigits[c&0xF])
+		default:
+			out = append(out, c)
+		}
+	}
+	return append(out, '"')
+}
+
+// helper function to decode given JSON string to bytes without converting
+// it to Go string, JSON null is decoded to empty data
+func unquoteBytes(raw []byte) ([]byte, error) {
+	if string(raw) == "null" {
+		return nil, nil
+	}
+	if len(raw) < 2 || raw[0] != '"' || raw[len(raw)-1] != '"' {
+		return nil, errors.New("record value is not JSON string")
+	}
+	raw = raw[1 : len(raw)-1]
+	// decoded value is never longer than encoded one, therefore the output
+	// is not reallocated and no copies of the value are left behind
+	out := make([]byte, 0, len(raw))
+	for i := 0; i < len(raw); i++ {
+		c := raw[i]
+		if c != '\\' {
+			out = append(out, c)
+			continue
+		}
+		i++
+		if i == len(raw) {
+			crypt.WipeBytes(out)
+			return nil, errors.New("invalid escape in record value")
+		}
+		switch raw[i] {
+		case '"', '\\', '/':
+			out = append(out, raw[i])
+		case 'b':
+			out = append(out, '\b')
+		case 'f':
+			out = append(out, '\f')
+		case 'n':
+			out = append(out, '\n')
+		case 'r':
+			out = append(out, '\r')
+		case 't':
+			out = append(out, '\t')
+		case 'u':
+			r, ok := hexRune(raw[i+1:])
+			if !ok {
+				crypt.WipeBytes(out)
+				return nil, errors.New("invalid unicode escape in record value")
+			}
+			i += 4
+			if utf16.IsSurrogate(r) {
+				if i+2 < len(raw) && raw[i+1] == '\\' && raw[i+2] == 'u' {
+					if low, ok := hexRune(raw[i+3:]); ok {
+						if dec := utf16.DecodeRune(r, low); dec != utf8.RuneError {
+							r = dec
+							i += 6
+						}
+					}
+				}
+			}
+			var buf [utf8.UTFMax]byte
+			n := utf8.EncodeRune(buf[:], r)
+			out = append(out, buf[:n]...)
+			crypt.WipeBytes(buf[:])
+		default:
+			crypt.WipeBytes(out)
+			return nil, errors.New("invalid escape in record value")
+		}
+	}
+	return out, nil
+}
+
+// helper function to decode rune from four hex digits of given data
+func hexRune(data []byte) (rune, bool) {
+	if len(data) < 4 {
+		return 0, false
+	}
+	var r rune
+	for _, c := range data[:4] {
+		r <<= 4
+		switch {
+		case c >= '0' && c <= '9':
+			r |= rune(c - '0')
+		case c >= 'a' && c <= 'f':
+			r |= rune(c - 'a' + 10)
+		case c >= 'A' && c <= 'F':
+			r |= rune(c - 'A' + 10)
+		default:
+			return 0, false
+		}
+	}
+	return r, true
+}
@@ -0,0 +1,381 @@
+package vault
+
+// folder module organizes vault records in nested folders. Every record
+// keeps path of its folder, e.g. work/servers, and records without folder
+// belong to the vault root. The folders are implied by records in them,
+// while folders without records are kept in encrypted vault folders file,
+// therefore folder names are never kept in plain text. Folder operations
+// rely on vault index, such that records are decrypted only if they are
+// moved between folders.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	utils "github.com/vkuznet/ecm/utils"
+)
+
+// FoldersFile defines name of vault file which keeps vault folders
+const FoldersFile = "vault.folders"
+
+// FolderSeparator defines separator of folder path elements
+const FolderSeparator = "/"
+
+// FolderInfo represents vault folder
+type FolderInfo struct {
+	Path  string `json:"path"`  // folder path
+	Name  string `json:"name"`  // folder name, i.e. last element of its path
+	Depth int    `json:"depth"` // folder depth, top level folders have zero depth
+	Count int    `json:"count"` // number of records in the folder
+	Total int    `json:"total"` // number of records in the folder and its sub-folders
+}
+
+// CleanFolder provides canonical folder path, e.g. " work//servers/ " is
+// converted to work/servers, empty path represents vault root
+func CleanFolder(path string) (string, error) {
+	var elems []string
+	for _, elem := range strings.Split(path, FolderSeparator) {
+		elem = strings.TrimSpace(elem)
+		if elem == "" {
+			continue
+		}
+		if elem == "." || elem == ".." {
+			return "", fmt.Errorf("invalid folder path '%s'", path)
+		}
+		elems = append(elems, elem)
+	}
+	return strings.Join(elems, FolderSeparator), nil
+}
+
+// InFolder checks if given path is the folder or one of its sub-folders,
+// every path belongs to the vault root
+func InFolder(path, folder string) bool {
+	if folder == "" {
+		return true
+	}
+	return path == folder || strings.HasPrefix(path, folder+FolderSeparator)
+}
+
+// ParentFolder provides path of parent folder of given folder
+func ParentFolder(path string) string {
+	if idx := strings.LastIndex(path, FolderSeparator); idx >= 0 {
+		return path[:idx]
+	}
+	return ""
+}
+
+// FolderName provides name of given folder, i.e. last element of its path
+func FolderName(path string) string {
+	return path[strings.LastIndex(path, FolderSeparator)+1:]
+}
+
+// helper function to compare folder paths element by element, such that
+// sub-folders follow their parent folder
+func folderLess(a, b string) bool {
+	ea := strings.Split(a, FolderSeparator)
+	eb := strings.Split(b, FolderSeparator)
+	for i := 0; i < len(ea) && i < len(eb); i++ {
+		if ea[i] != eb[i] {
+			return ea[i] < eb[i]
+		}
+	}
+	return len(ea) < len(eb)
+}
+
+// helper function to read folders kept in vault folders file
+func (v *Vault) readFolders() ([]string, error) {
+	data, err := os.ReadFile(filepath.Join(v.Directory, FoldersFile))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	data, err = v.DecryptRecord(FoldersFile, data)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt vault folders, error %v", err)
+	}
+	var folders []string
+	err = json.Unmarshal(data, &folders)
+	return folders, err
+}
+
+// helper function to write folders to vault folders file
+func (v *Vault) writeFolders(folders []string) error {
+	sort.Slice(folders, func(i, j int) bool { return folderLess(folders[i], folders[j]) })
+	data, err := json.Marshal(folders)
+	if err != nil {
+		return err
+	}
+	edata, err := v.EncryptRecord(FoldersFile, data)
+	if err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(v.Directory, FoldersFile), edata)
+}
+
+// helper function to provide folders of vault records, the folders are
+// taken from vault index and loaded records
+func (v *Vault) recordFolders() (map[string]string, error) {
+	if v.index == nil {
+		// records which can not be read are not listed
+		if err := v.ReadIndex(); err != nil && !PartialError(err) {
+			return nil, err
+		}
+	}
+	out := make(map[string]string)
+	for rid, e := range v.index.Entries {
+		out[rid] = e.Folder
+	}
+	for _, rec := range v.Records {
+		out[rec.ID] = rec.Folder
+	}
+	return out, nil
+}
+
+// Folders provides vault folders along with their record counts, the
+// sub-folders follow their parent folder
+func (v *Vault) Folders() ([]FolderInfo, error) {
+	unlock, err := v.lockDir(false)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	folders, err := v.readFolders()
+	if err != nil {
+		return nil, err
+	}
+	records, err := v.recordFolders()
+	if err != nil {
+		return nil, err
+	}
+	infos := make(map[string]*FolderInfo)
+	// helper function to add folder along with its parent folders
+	var add func(path string) *FolderInfo
+	add = func(path string) *FolderInfo {
+		if info, ok := infos[path]; ok {
+			return info
+		}
+		info := &FolderInfo{Path: path, Name: FolderName(path), Depth: strings.Count(path, FolderSeparator)}
+		infos[path] = info
+		if parent := ParentFolder(path); parent != "" {
+			add(parent)
+		}
+		return info
+	}
+	for _, path := range folders {
+		add(path)
+	}
+	for _, path := range records {
+		if path == "" {
+			continue
+		}
+		add(path).Count++
+		for p := path; p != ""; p = ParentFolder(p) {
+			infos[p].Total++
+		}
+	}
+	var out []FolderInfo
+	for _, info := range infos {
+		out = append(out, *info)
+	}
+	sort.Slice(out, func(i, j int) bool { return folderLess(out[i].Path, out[j].Path) })
+	return out, nil
+}
+
+// helper function to check if vault has given folder
+func (v *Vault) hasFolder(path string) (bool, error) {
+	folders, err := v.Folders()
+	if err != nil {
+		return false, err
+	}
+	for _, f := range folders {
+		if f.Path == path {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// CreateFolder creates new vault folder, parent folders are created too
+func (v *Vault) CreateFolder(path string) error {
+	path, err := CleanFolder(path)
+	if err != nil {
+		return err
+	}
+	unlock, err := v.lockDir(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if path == "" {
+		return errors.New("folder path is not provided")
+	}
+	found, err := v.hasFolder(path)
+	if err != nil {
+		return err
+	}
+	if found {
+		return fmt.Errorf("folder %s already exists", path)
+	}
+	folders, err := v.readFolders()
+	if err != nil {
+		return err
+	}
+	return v.writeFolders(append(folders, path))
+}
+
+// MoveRecord moves vault record to given folder, empty folder represents
+// vault root
+func (v *Vault) MoveRecord(rid, folder string) error {
+	folder, err := CleanFolder(folder)
+	if err != nil {
+		return err
+	}
+	unlock, err := v.lockDir(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	rec, err := v.LoadRecord(rid)
+	if err != nil {
+		return err
+	}
+	if rec.Folder == folder {
+		return nil
+	}
+	rec.Folder = folder
+	return v.Update(rec)
+}
+
+// helper function to move folder along with its sub-folders and records,
+// new path of every moved folder is given by replace function
+func (v *Vault) moveFolder(path string, replace func(string) string) error {
+	unlock, err := v.lockDir(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	records, err := v.recordFolders()
+	if err != nil {
+		return err
+	}
+	var rids []string
+	for rid, folder := range records {
+		if InFolder(folder, path) {
+			rids = append(rids, rid)
+		}
+	}
+	sort.Strings(rids)
+	for _, rid := range rids {
+		err := v.MoveRecord(rid, replace(records[rid]))
+		if err != nil {
+			return fmt.Errorf("unable to move record %s, error %v", rid, err)
+		}
+	}
+	folders, err := v.readFolders()
+	if err != nil {
+		return err
+	}
+	// parent folder is kept even if it does not have records
+	var out []string
+	if parent := ParentFolder(path); parent != "" {
+		out = append(out, parent)
+	}
+	for _, f := range folders {
+		if InFolder(f, path) {
+			f = replace(f)
+		}
+		if f != "" && !utils.InList(f, out) {
+			out = append(out, f)
+		}
+	}
+	return v.writeFolders(out)
+}
+
+// RenameFolder renames vault folder, its sub-folders and records are moved
+// along with the folder, the new path should not exist
+func (v *Vault) RenameFolder(path, newPath string) error {
+	path, err := CleanFolder(path)
+	if err != nil {
+		return err
+	}
+	newPath, err = CleanFolder(newPath)
+	if err != nil {
+		return err
+	}
+	if path == "" || newPath == "" {
+		return errors.New("folder path is not provided")
+	}
+	if InFolder(newPath, path) {
+		return fmt.Errorf("folder %s can not be moved into itself", path)
+	}
+	found, err := v.hasFolder(path)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("no folder %s found in a vault", path)
+	}
+	found, err = v.hasFolder(newPath)
+	if err != nil {
+		return err
+	}
+	if found {
+		return fmt.Errorf("folder %s already exists", newPath)
+	}
+	return v.moveFolder(path, func(f string) string {
+		return newPath + strings.TrimPrefix(f, path)
+	})
+}
+
+// MoveFolder moves vault folder into given parent folder, empty parent
+// represents vault root
+func (v *Vault) MoveFolder(path, parent string) error {
+	path, err := CleanFolder(path)
+	if err != nil {
+		return err
+	}
+	parent, err = CleanFolder(parent)
+	if err != nil {
+		return err
+	}
+	newPath := FolderName(path)
+	if parent != "" {
+		newPath = parent + FolderSeparator + newPath
+	}
+	return v.RenameFolder(path, newPath)
+}
+
+// DeleteFolder deletes vault folder along with its sub-folders, records of
+// deleted folders are moved to parent folder of the deleted one
+func (v *Vault) DeleteFolder(path string) error {
+	path, err := CleanFolder(path)
+	if err != nil {
+		return err
+	}
+	unlock, err := v.lockDir(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if path == "" {
+		return errors.New("folder path is not provided")
+	}
+	found, err := v.hasFolder(path)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("no folder %s found in a vault", path)
+	}
+	parent := ParentFolder(path)
+	return v.moveFolder(path, func(string) string {
+		return parent
+	})
+}
@@ -8,6 +8,7 @@ require (
 	github.com/vkuznet/ecm/crypt v0.0.0-20220920150436-14c90da1146b
 	github.com/vkuznet/ecm/storage v0.0.0-20220920150436-14c90da1146b
 	github.com/vkuznet/ecm/utils v0.0.0-20220920150436-14c90da1146b
+	golang.org/x/sys v0.0.0-20220919091848-fb04ddd9f9c8
 )
 
 require (
@@ -15,6 +16,5 @@ require (
 	github.com/mattn/go-isatty v0.0.16 // indirect
 	golang.org/x/crypto v0.0.0-20220919173607-35f4265a4bc0 // indirect
 	golang.org/x/exp/errors v0.0.0-20220916125017-b168a2c6b86b // indirect
-	golang.org/x/sys v0.0.0-20220919091848-fb04ddd9f9c8 // indirect
 	golang.org/x/term v0.0.0-20220919170432-7a66f970e087 // indirect
 )
@@ -0,0 +1,339 @@
+package vault
+
+// history module keeps previous versions of vault records. Every time the
+// record is written its current file is copied into history/<rid>/<version>
+// area of the vault, where version is the time when the copied file was
+// written. The versions are encrypted as their records, therefore they can
+// be listed and restored without vault secret.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vkuznet/ecm/crypt"
+	utils "github.com/vkuznet/ecm/utils"
+)
+
+// HistoryDir defines name of vault area which keeps versions of vault records
+const HistoryDir = "history"
+
+// CurrentVersion defines version name of current vault record
+const CurrentVersion = "current"
+
+// VersionFormat defines time format of version names, the names are
+// sortable and can be used as file names on all platforms
+const VersionFormat = "20060102T150405.000000000Z"
+
+// RecordVersion represents version of vault record
+type RecordVersion struct {
+	Version string    `json:"version"` // version name
+	Time    time.Time `json:"time"`    // time when version was written
+	Size    int64     `json:"size"`    // size of encrypted version
+}
+
+// FieldDiff represents difference of record field between two versions
+type FieldDiff struct {
+	Key string `json:"key"` // record key
+	Old string `json:"old"` // value in old version
+	New string `json:"new"` // value in new version
+}
+
+// Kind provides kind of field change, i.e. added, removed or changed
+func (d FieldDiff) Kind() string {
+	if d.Old == "" {
+		return "added"
+	} else if d.New == "" {
+		return "removed"
+	}
+	return "changed"
+}
+
+// String provides string representation of field change, values of
+// sensitive fields are not revealed
+func (d FieldDiff) String() string {
+	oldVal, newVal := d.Old, d.New
+	if SensitiveKey(d.Key) {
+		oldVal, newVal = maskValue(oldVal), maskValue(newVal)
+	}
+	switch d.Kind() {
+	case "added":
+		return fmt.Sprintf("+ %s: %s", d.Key, newVal)
+	case "removed":
+		return fmt.Sprintf("- %s: %s", d.Key, oldVal)
+	}
+	return fmt.Sprintf("~ %s: %s -> %s", d.Key, oldVal, newVal)
+}
+
+// SensitiveKey checks if given record key holds sensitive value which
+// should not be shown as is, e.g. password, TOTP seed or any field which is
+// concealed by record schemas
+func SensitiveKey(key string) bool {
+	return strings.ToLower(key) == "password" || key == TOTPKey || concealedKey(key)
+}
+
+// helper function to mask sensitive value
+func maskValue(val string) string {
+	if val == "" {
+		return ""
+	}
+	return "********"
+}
+
+// DiffRecords provides field level difference between two records, the
+// fields are ordered by their keys
+func DiffRecords(oldRec, newRec VaultRecord) []FieldDiff {
+	keys := make(map[string]bool)
+	for k := range oldRec.Map {
+		keys[k] = true
+	}
+	for k := range newRec.Map {
+		keys[k] = true
+	}
+	var out []FieldDiff
+	for k := range keys {
+		oldVal, newVal := oldRec.Value(k), newRec.Value(k)
+		if oldVal != newVal {
+			out = append(out, FieldDiff{Key: k, Old: oldVal, New: newVal})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out
+}
+
+// helper function to get file name of given record version
+func (v *Vault) versionFile(rid, version string) (string, error) {
+	if rid == "" || filepath.Base(rid) != rid || strings.HasPrefix(rid, ".") {
+		return "", fmt.Errorf("invalid record ID '%s'", rid)
+	}
+	if version == CurrentVersion {
+		return filepath.Join(v.Directory, rid), nil
+	}
+	if _, err := time.Parse(VersionFormat, version); err != nil {
+		return "", fmt.Errorf("invalid record version '%s'", version)
+	}
+	return filepath.Join(v.Directory, HistoryDir, rid, version), nil
+}
+
+// VersionFile provides file name of given record version, the file keeps
+// record version encrypted as vault record
+func (v *Vault) VersionFile(rid, version string) (string, error) {
+	fname, err := v.versionFile(rid, version)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(fname); err != nil {
+		msg := fmt.Sprintf("record %s does not have version %s", rid, version)
+		return "", errors.New(msg)
+	}
+	return fname, nil
+}
+
+// helper function to copy current record file into record history
+func (v *Vault) archiveRecord(rid string) error {
+	fname := filepath.Join(v.Directory, rid)
+	info, err := os.Stat(fname)
+	if err != nil || info.Size() == 0 {
+		// there is nothing to archive
+		return nil
+	}
+	hdir := filepath.Join(v.Directory, HistoryDir, rid)
+	err = os.MkdirAll(hdir, 0700)
+	if err != nil {
+		return err
+	}
+	// version is named after the time when it was written, records written
+	// within the same time are kept as subsequent versions
+	tstamp := info.ModTime().UTC()
+	hname := filepath.Join(hdir, tstamp.Format(VersionFormat))
+	for utils.FileExist(hname) {
+		tstamp = tstamp.Add(time.Nanosecond)
+		hname = filepath.Join(hdir, tstamp.Format(VersionFormat))
+	}
+	_, err = utils.Copy(fname, hname)
+	return err
+}
+
+// helper function to re-encrypt versions of given record kept in given vault
+// area with vault data key, i.e. versions written by old vaults or archived
+// when their record was migrated. It returns number of re-encrypted versions
+func (v *Vault) migrateVersions(dir, rid string) (int, error) {
+	versions, err := filepath.Glob(filepath.Join(dir, HistoryDir, rid, "*"))
+	if err != nil {
+		return 0, err
+	}
+	var count int
+	for _, hname := range versions {
+		ok, err := v.migrateFile(hname, rid)
+		if err != nil {
+			return count, err
+		}
+		if ok {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// helper function to re-encrypt given file of vault record with vault data
+// key unless it is already bound to the record. Files encrypted with vault
+// secret are skipped if vault is unlocked without it. It returns true if the
+// file is re-encrypted
+func (v *Vault) migrateFile(fname, rid string) (bool, error) {
+	data, err := os.ReadFile(fname)
+	if errors.Is(err, os.ErrNotExist) || len(data) == 0 {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	header, _, err := crypt.ParseHeader(data)
+	if err == nil && header.KDF == nil && header.Version >= crypt.HeaderVersionAD {
+		return false, nil
+	}
+	if (err != nil || header.KDF != nil) && !v.HasSecret() {
+		log.Printf("record file %s is encrypted with vault secret and can not be migrated without it", fname)
+		return false, nil
+	}
+	data, err = v.DecryptRecord(rid, data)
+	if err != nil {
+		return false, fmt.Errorf("unable to decrypt %s, error %v", fname, err)
+	}
+	defer crypt.WipeBytes(data)
+	var rec VaultRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return false, fmt.Errorf("unable to unmarshal %s, error %v", fname, err)
+	}
+	rec.Wipe()
+	if rec.ID != rid {
+		return false, fmt.Errorf("vault record %s does not match its file %s", rec.ID, fname)
+	}
+	edata, err := v.EncryptRecord(rid, data)
+	if err != nil {
+		return false, err
+	}
+	return true, writeFile(fname, edata)
+}
+
+// History provides versions of given record ordered from the newest to the
+// oldest one, the current record is the first version
+func (v *Vault) History(rid string) ([]RecordVersion, error) {
+	unlock, err := v.lockDir(false)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	fname, err := v.versionFile(rid, CurrentVersion)
+	if err != nil {
+		return nil, err
+	}
+	var versions []RecordVersion
+	if info, err := os.Stat(fname); err == nil {
+		versions = append(versions, RecordVersion{Version: CurrentVersion, Time: info.ModTime(), Size: info.Size()})
+	}
+	entries, err := os.ReadDir(filepath.Join(v.Directory, HistoryDir, rid))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	var archived []RecordVersion
+	for _, entry := range entries {
+		tstamp, err := time.Parse(VersionFormat, entry.Name())
+		if err != nil || entry.IsDir() {
+			continue
+		}
+		var size int64
+		if info, err := entry.Info(); err == nil {
+			size = info.Size()
+		}
+		archived = append(archived, RecordVersion{Version: entry.Name(), Time: tstamp, Size: size})
+	}
+	sort.Slice(archived, func(i, j int) bool { return archived[i].Time.After(archived[j].Time) })
+	versions = append(versions, archived...)
+	if len(versions) == 0 {
+		msg := fmt.Sprintf("Unable to find vault record '%s'", rid)
+		return nil, errors.New(msg)
+	}
+	return versions, nil
+}
+
+// RecordVersion provides content of given record version
+func (v *Vault) RecordVersion(rid, version string) (VaultRecord, error) {
+	unlock, err := v.lockDir(false)
+	if err != nil {
+		return VaultRecord{}, err
+	}
+	defer unlock()
+	fname, err := v.VersionFile(rid, version)
+	if err != nil {
+		return VaultRecord{}, err
+	}
+	return v.readRecord(fname, rid)
+}
+
+// Diff provides field level difference between two versions of the record
+func (v *Vault) Diff(rid, oldVersion, newVersion string) ([]FieldDiff, error) {
+	oldRec, err := v.RecordVersion(rid, oldVersion)
+	if err != nil {
+		return nil, err
+	}
+	newRec, err := v.RecordVersion(rid, newVersion)
+	if err != nil {
+		return nil, err
+	}
+	return DiffRecords(oldRec, newRec), nil
+}
+
+// Restore restores given version of the record, the current record is kept
+// in record history. The record is restored at file level, therefore vault
+// secret is only required to update vault records in memory and vault index
+func (v *Vault) Restore(rid, version string) error {
+	unlock, err := v.lockDir(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if version == CurrentVersion {
+		return nil
+	}
+	hname, err := v.VersionFile(rid, version)
+	if err != nil {
+		return err
+	}
+	err = v.archiveRecord(rid)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(hname)
+	if err != nil {
+		return err
+	}
+	fname := filepath.Join(v.Directory, rid)
+	err = writeFile(fname, data)
+	if err != nil {
+		return err
+	}
+	if !v.hasKey() {
+		return nil
+	}
+	// update vault records in memory and vault index
+	rec, err := v.readRecord(fname, rid)
+	if err != nil {
+		return err
+	}
+	for i, r := range v.Records {
+		if r.ID == rid {
+			v.Records[i] = rec
+			break
+		}
+	}
+	if err := v.indexRecords([]VaultRecord{rec}, nil, false); err != nil {
+		log.Printf("unable to update vault index, error %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,381 @@
+package vault
+
+// index module keeps meta-data of vault records in single encrypted index
+// file. The index allows to list and search vault records after single
+// decryption while full records are loaded lazily. Every index entry keeps
+// hash of encrypted record file, therefore the index is validated against
+// vault records without their decryption and records changed outside of
+// the vault, e.g. by sync, are re-indexed when index is read.
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vkuznet/ecm/crypt"
+)
+
+// IndexFile defines name of vault index file
+const IndexFile = "vault.index"
+
+// IndexVersion defines version of vault index format, vault index of older
+// version is rebuilt when it is read
+const IndexVersion = 3
+
+// IndexEntry represents meta-data of vault record kept in vault index
+type IndexEntry struct {
+	ID               string    `json:"id"`     // record ID
+	Kind             string    `json:"kind"`   // record kind
+	Folder           string    `json:"folder"` // record folder
+	Name             string    `json:"name"`   // record name
+	Tags             string    `json:"tags"`   // record tags
+	URL              string    `json:"url"`    // record URL
+	ModificationTime time.Time `json:"mtime"`  // record modification time
+	Hash             string    `json:"hash"`   // sha256 hash of encrypted record file
+}
+
+// helper function to compare two index entries
+func (e IndexEntry) equal(o IndexEntry) bool {
+	return e.ID == o.ID && e.Kind == o.Kind && e.Folder == o.Folder && e.Name == o.Name && e.Tags == o.Tags && e.URL == o.URL &&
+		e.Hash == o.Hash && e.ModificationTime.Equal(o.ModificationTime)
+}
+
+// Index represents vault index
+type Index struct {
+	Version int                   `json:"version"` // index version
+	Entries map[string]IndexEntry `json:"entries"` // index entries
+}
+
+// NewIndex creates new empty vault index
+func NewIndex() *Index {
+	return &Index{Version: IndexVersion, Entries: make(map[string]IndexEntry)}
+}
+
+// ParseIndex parses decrypted vault index
+func ParseIndex(data []byte) (*Index, error) {
+	index := NewIndex()
+	err := json.Unmarshal(data, index)
+	if err != nil {
+		return nil, err
+	}
+	if index.Version > IndexVersion {
+		return nil, fmt.Errorf("unsupported vault index version %d", index.Version)
+	}
+	if index.Entries == nil {
+		index.Entries = make(map[string]IndexEntry)
+	}
+	return index, nil
+}
+
+// List provides index entries ordered by record names
+func (i *Index) List() []IndexEntry {
+	var out []IndexEntry
+	for _, e := range i.Entries {
+		out = append(out, e)
+	}
+	sort.Slice(out, func(a, b int) bool {
+		if out[a].Name == out[b].Name {
+			return out[a].ID < out[b].ID
+		}
+		return out[a].Name < out[b].Name
+	})
+	return out
+}
+
+// Search provides index entries of records which match or may match given
+// query, the query terms of fields which are not indexed are not evaluated
+func (i *Index) Search(q *Query) []IndexEntry {
+	var out []IndexEntry
+	for _, e := range i.List() {
+		if q.match(indexTarget{entry: &e}) != noMatch {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// helper function to create index entry of given record, record values
+// are copied since they are wiped along with the record
+func newIndexEntry(rec VaultRecord, hash string) IndexEntry {
+	return IndexEntry{
+		ID:               rec.ID,
+		Kind:             rec.RecordKind(),
+		Folder:           strings.Clone(rec.Folder),
+		Name:             strings.Clone(rec.Map["Name"]),
+		Tags:             FormatTags(rec.Tags()),
+		URL:              strings.Clone(rec.Map["URL"]),
+		ModificationTime: rec.ModificationTime,
+		Hash:             hash,
+	}
+}
+
+// helper function to calculate hash of given file
+func fileHash(fname string) (string, error) {
+	data, err := os.ReadFile(fname)
+	if err != nil {
+		return "", err
+	}
+	return dataHash(data), nil
+}
+
+// helper function to get hash of given record file content
+func dataHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// helper function to read vault index file, empty index is provided if
+// vault does not have index yet
+func (v *Vault) readIndex() (*Index, error) {
+	data, err := os.ReadFile(filepath.Join(v.Directory, IndexFile))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return NewIndex(), nil
+		}
+		return nil, err
+	}
+	data, err = v.DecryptRecord(IndexFile, data)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt vault index, error %v", err)
+	}
+	index, err := ParseIndex(data)
+	if err != nil {
+		return nil, err
+	}
+	if index.Version < IndexVersion {
+		// entries of older index lack meta-data, e.g. record kind
+		return NewIndex(), nil
+	}
+	return index, nil
+}
+
+// helper function to write vault index file, index of vault which does not
+// have data key yet is kept in memory only
+func (v *Vault) writeIndex() error {
+	unlock, err := v.lockDir(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	key, err := v.DataKey()
+	if err == ErrNoDataKey {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(v.index)
+	if err != nil {
+		return err
+	}
+	edata, err := crypt.EncryptWithKeyAD(data, key, v.Cipher, recordAD(IndexFile))
+	if err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(v.Directory, IndexFile), edata)
+}
+
+// ReadIndex reads vault index and validates it against vault records, the
+// records which are not indexed or changed since they were indexed are
+// decrypted and re-indexed. Records which can not be decrypted are reported
+// by RecordErrors while other records are indexed
+func (v *Vault) ReadIndex() error {
+	unlock, err := v.lockDir(false)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	index, err := v.readIndex()
+	if err != nil {
+		return err
+	}
+	files, err := v.Files()
+	if err != nil {
+		return err
+	}
+	changed := false
+	found := make(map[string]bool)
+	var recErrors RecordErrors
+	var decrypt decryptFunc
+	for _, rid := range files {
+		found[rid] = true
+		fname := filepath.Join(v.Directory, rid)
+		hash, err := fileHash(fname)
+		if err != nil {
+			recErrors = append(recErrors, RecordError{File: fname, Err: err})
+			continue
+		}
+		if e, ok := index.Entries[rid]; ok && e.Hash == hash {
+			continue
+		}
+		// vault data key is obtained once when the first changed record
+		// is decrypted
+		if decrypt == nil {
+			var release func()
+			decrypt, release, err = v.recordDecrypter()
+			if err != nil {
+				return err
+			}
+			defer release()
+		}
+		// record is indexed with hash of data it is read from
+		rec, hash, err := v.decodeRecord(fname, rid, decrypt)
+		if err != nil {
+			if v.Verbose > 1 {
+				log.Println("unable to read ", fname, " error ", err)
+			}
+			recErrors = append(recErrors, RecordError{File: fname, Err: err})
+			continue
+		}
+		index.Entries[rid] = newIndexEntry(rec, hash)
+		changed = true
+		// replace loaded record with its new content
+		loaded := false
+		for i, r := range v.Records {
+			if r.ID == rid {
+				v.Records[i].Wipe()
+				v.Records[i] = rec
+				loaded = true
+				break
+			}
+		}
+		if !loaded {
+			rec.Wipe()
+		}
+	}
+	for rid := range index.Entries {
+		if !found[rid] {
+			delete(index.Entries, rid)
+			changed = true
+		}
+	}
+	v.index = index
+	if changed {
+		if v.Verbose > 0 {
+			log.Printf("update vault index with %d records", len(index.Entries))
+		}
+		// index is validated when it is read, therefore it is kept in
+		// memory if it can not be written
+		if err := v.writeIndex(); err != nil {
+			log.Printf("unable to update vault index, error %v", err)
+		}
+	}
+	if len(recErrors) > 0 {
+		return recErrors
+	}
+	return nil
+}
+
+// Index provides index entries of vault records ordered by record names,
+// vault index should be read first
+func (v *Vault) Index() []IndexEntry {
+	if v.index == nil {
+		return nil
+	}
+	return v.index.List()
+}
+
+// LoadRecord provides vault record with given ID, the record is read from
+// vault area unless it is already loaded
+func (v *Vault) LoadRecord(rid string) (VaultRecord, error) {
+	unlock, err := v.lockDir(false)
+	if err != nil {
+		return VaultRecord{}, err
+	}
+	defer unlock()
+	if rec, err := v.Record(rid); err == nil {
+		return rec, nil
+	}
+	fname := filepath.Join(v.Directory, rid)
+	if filepath.Base(rid) != rid || !recordFile(rid) {
+		return VaultRecord{}, fmt.Errorf("invalid record ID '%s'", rid)
+	}
+	if _, err := os.Stat(fname); err != nil {
+		msg := fmt.Sprintf("Unable to find vault record '%s'", rid)
+		return VaultRecord{}, errors.New(msg)
+	}
+	rec, err := v.ReadRecord(fname)
+	if err != nil {
+		return rec, err
+	}
+	v.Records = append(v.Records, rec)
+	return rec, nil
+}
+
+// helper function to update vault index with given records, records which
+// are not given are removed from the index if full is set. Records read
+// under shared lock are indexed with given hashes of data they are read
+// from, other records are indexed with hashes of their files
+func (v *Vault) indexRecords(records []VaultRecord, hashes map[string]string, full bool) error {
+	unlock, err := v.lockDir(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if v.index == nil {
+		index, err := v.readIndex()
+		if err != nil {
+			return err
+		}
+		v.index = index
+	}
+	changed := false
+	found := make(map[string]bool)
+	for _, rec := range records {
+		found[rec.ID] = true
+		hash, ok := hashes[rec.ID]
+		if !ok {
+			var err error
+			hash, err = fileHash(filepath.Join(v.Directory, rec.ID))
+			if err != nil {
+				return err
+			}
+		}
+		entry := newIndexEntry(rec, hash)
+		if e, ok := v.index.Entries[rec.ID]; !ok || !e.equal(entry) {
+			v.index.Entries[rec.ID] = entry
+			changed = true
+		}
+	}
+	if full {
+		for rid := range v.index.Entries {
+			if !found[rid] {
+				delete(v.index.Entries, rid)
+				changed = true
+			}
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return v.writeIndex()
+}
+
+// helper function to remove record from vault index
+func (v *Vault) unindexRecord(rid string) error {
+	unlock, err := v.lockDir(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if v.index == nil {
+		index, err := v.readIndex()
+		if err != nil {
+			return err
+		}
+		v.index = index
+	}
+	if _, ok := v.index.Entries[rid]; !ok {
+		return nil
+	}
+	delete(v.index.Entries, rid)
+	return v.writeIndex()
+}
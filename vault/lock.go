@@ -0,0 +1,170 @@
+package vault
+
+// lock module provides advisory lock of vault directory which is shared by
+// cli, term, ui and server processes. Vault readers hold shared lock and
+// vault writers hold exclusive lock of vault lock file, and the lock is
+// waited for no longer than vault lock timeout. The lock is re-entrant
+// within the vault, such that vault operations can be composed, and shared
+// lock of the reader is upgraded to exclusive one when reader has to write,
+// e.g. when vault index is updated while vault records are read. Since
+// upgrade releases shared lock before exclusive one is acquired, another
+// writer may change the vault in between, therefore data read under shared
+// lock is written only if it is validated by hash of read content, e.g.
+// vault index, otherwise exclusive lock is taken up front. The lock is held
+// by the vault rather than by goroutine, and a vault should not be shared
+// by goroutines which change it without additional synchronization.
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// LockFile defines name of vault file which is used to lock the vault
+const LockFile = "vault.lock"
+
+// DefaultLockTimeout defines how long vault lock is waited for by default
+const DefaultLockTimeout = 10 * time.Second
+
+// interval between attempts to acquire vault lock
+const lockRetryInterval = 20 * time.Millisecond
+
+// ErrLockTimeout is returned when vault lock is not acquired within timeout
+var ErrLockTimeout = errors.New("vault is locked by another process")
+
+// helper type which keeps state of vault directory lock
+type dirLock struct {
+	mu        sync.Mutex
+	file      *os.File // opened vault lock file
+	depth     int      // number of nested vault operations which hold the lock
+	exclusive bool     // lock is held by vault writer
+}
+
+// helper function to get vault lock timeout
+func (v *Vault) lockTimeout() time.Duration {
+	if v.LockTimeout <= 0 {
+		return DefaultLockTimeout
+	}
+	return v.LockTimeout
+}
+
+// helper function to acquire lock of given file within given timeout
+func acquireLock(file *os.File, exclusive bool, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		ok, err := tryLock(file, exclusive)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("unable to lock %s within %v, %w", file.Name(), timeout, ErrLockTimeout)
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+// helper function to lock vault directory, writers use exclusive lock and
+// readers use shared one. It returns function which releases the lock.
+func (v *Vault) lockDir(exclusive bool) (func(), error) {
+	l := v.dirState()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.depth == 0 {
+		file, err := v.openLockFile(exclusive)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				// vault directory does not exist yet, there is nothing to lock
+				return func() {}, nil
+			}
+			if !exclusive {
+				// vault directory is not writable, e.g. read-only copy of the
+				// vault, therefore there are no writers readers should wait for
+				if v.Verbose > 1 {
+					log.Printf("vault %s is read without lock, error %v", v.Directory, err)
+				}
+				return func() {}, nil
+			}
+			return nil, err
+		}
+		if err := acquireLock(file, exclusive, v.lockTimeout()); err != nil {
+			file.Close()
+			return nil, err
+		}
+		l.file, l.exclusive, l.depth = file, exclusive, 1
+		return func() { v.unlockDir(false) }, nil
+	}
+	if exclusive && !l.exclusive {
+		// lock conversion is not atomic on all platforms, therefore shared
+		// lock is released before exclusive lock is acquired
+		if err := unlockFile(l.file); err != nil {
+			return nil, err
+		}
+		if err := acquireLock(l.file, true, v.lockTimeout()); err != nil {
+			if rerr := acquireLock(l.file, false, v.lockTimeout()); rerr != nil {
+				log.Printf("unable to restore shared lock of vault %s, error %v", v.Directory, rerr)
+			}
+			return nil, err
+		}
+		l.exclusive = true
+		l.depth++
+		return func() { v.unlockDir(true) }, nil
+	}
+	l.depth++
+	return func() { v.unlockDir(false) }, nil
+}
+
+// helper function to open vault lock file, readers open existing lock file
+// read-only and create it only if it does not exist yet
+func (v *Vault) openLockFile(exclusive bool) (*os.File, error) {
+	fname := filepath.Join(v.Directory, LockFile)
+	if !exclusive {
+		file, err := os.Open(fname)
+		if err == nil || !errors.Is(err, os.ErrNotExist) {
+			return file, err
+		}
+	}
+	return os.OpenFile(fname, os.O_RDWR|os.O_CREATE, 0600)
+}
+
+// helper function to get state of vault directory lock
+func (v *Vault) dirState() *dirLock {
+	vaultState.Lock()
+	defer vaultState.Unlock()
+	if v.dirLock == nil {
+		v.dirLock = &dirLock{}
+	}
+	return v.dirLock
+}
+
+// helper function to release vault directory lock, the downgrade flag is set
+// if released lock was upgraded from shared to exclusive one
+func (v *Vault) unlockDir(downgrade bool) {
+	l := v.dirState()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.depth--
+	if l.depth == 0 {
+		if err := unlockFile(l.file); err != nil {
+			log.Printf("unable to unlock vault %s, error %v", v.Directory, err)
+		}
+		l.file.Close()
+		l.file, l.exclusive = nil, false
+		return
+	}
+	if downgrade {
+		if err := unlockFile(l.file); err != nil {
+			log.Printf("unable to unlock vault %s, error %v", v.Directory, err)
+		}
+		if err := acquireLock(l.file, false, v.lockTimeout()); err != nil {
+			log.Printf("unable to restore shared lock of vault %s, error %v", v.Directory, err)
+		}
+		l.exclusive = false
+	}
+}
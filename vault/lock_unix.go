@@ -0,0 +1,31 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package vault
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// helper function to try to lock given file without waiting, it returns
+// false if file is locked by another process
+func tryLock(file *os.File, exclusive bool) (bool, error) {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	err := syscall.Flock(int(file.Fd()), how|syscall.LOCK_NB)
+	if err == nil {
+		return true, nil
+	}
+	if errors.Is(err, syscall.EWOULDBLOCK) || errors.Is(err, syscall.EINTR) {
+		return false, nil
+	}
+	return false, err
+}
+
+// helper function to unlock given file
+func unlockFile(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,332 @@
+package vault
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/vkuznet/ecm/crypt"
+)
+
+// MetaFile defines name of the vault key file which keeps vault meta-data
+const MetaFile = "vault.json"
+
+// MetaVersion defines current version of vault meta-data
+const MetaVersion = 3
+
+// ErrNoDataKey is returned when vault does not have data key
+var ErrNoDataKey = errors.New("vault does not have data key")
+
+// ErrRecoveryShares is returned when vault data key which was split into
+// recovery shares is rotated without force, since rotation invalidates them
+var ErrRecoveryShares = errors.New("vault data key has recovery shares")
+
+// Meta represents vault meta-data, i.e. parameters of key derivation
+// function used to derive vault key from its secret and vault data key
+// wrapped by the vault key. The data key encrypts vault records, therefore
+// change of vault secret only requires to wrap data key again. The data key
+// is also wrapped for every vault recipient such that teammates can open
+// the vault with their own identities
+type Meta struct {
+	Version    int             // meta-data version
+	KDF        *crypt.KDF      // key derivation function and its parameters
+	WrappedKey []byte          // vault data key encrypted with vault key
+	Recipients []*crypt.Stanza // vault data key wrapped for vault recipients
+	Shares     int             // number of recovery shares of vault data key
+	Threshold  int             // number of recovery shares required to recover the key
+
+	keys *crypt.KeyCache // cache of keys derived from vault secret
+}
+
+// NewMeta creates new vault meta-data with given key derivation function,
+// if kdf is nil the default one with new random salt is used
+func NewMeta(kdf *crypt.KDF) (*Meta, error) {
+	var err error
+	if kdf == nil {
+		kdf, err = crypt.NewKDF("")
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := kdf.Validate(); err != nil {
+		return nil, err
+	}
+	return &Meta{Version: MetaVersion, KDF: kdf}, nil
+}
+
+// LegacyMeta provides meta-data of old vaults which records are encrypted
+// with unsalted key derivation
+func LegacyMeta() *Meta {
+	return &Meta{KDF: &crypt.KDF{Name: crypt.KDFLegacy}}
+}
+
+// ReadMeta reads vault meta-data from given vault directory
+func ReadMeta(vdir string) (*Meta, error) {
+	data, err := os.ReadFile(filepath.Join(vdir, MetaFile))
+	if err != nil {
+		return nil, err
+	}
+	return ParseMeta(data)
+}
+
+// FindMeta reads meta-data of the vault which holds given file, the file can
+// be either vault record or encrypted blob from vault blobs area
+func FindMeta(fname string) (*Meta, error) {
+	vdir := filepath.Dir(fname)
+	if filepath.Base(vdir) == BlobsDir {
+		vdir = filepath.Dir(vdir)
+	}
+	return ReadMeta(vdir)
+}
+
+// ParseMeta parses vault meta-data from given data
+func ParseMeta(data []byte) (*Meta, error) {
+	var meta Meta
+	err := json.Unmarshal(data, &meta)
+	if err != nil {
+		return nil, err
+	}
+	if meta.KDF == nil {
+		return nil, errors.New("vault meta-data does not contain key derivation parameters")
+	}
+	if err := meta.KDF.Validate(); err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+// Write writes vault meta-data to given vault directory
+func (m *Meta) Write(vdir string) error {
+	data, err := json.MarshalIndent(m, "", "   ")
+	if err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(vdir, MetaFile), data)
+}
+
+// helper function to write data to given file via temporary file such
+// that readers never see partially written file, the data is flushed to
+// disk before temporary file is renamed into place
+func writeFile(fname string, data []byte) error {
+	file, err := createTemp(fname)
+	if err != nil {
+		return err
+	}
+	tmp := file.Name()
+	defer os.Remove(tmp)
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return renameFile(tmp, fname)
+}
+
+// helper function to create temporary file in directory of given file, the
+// temporary file has unique name such that concurrent writers never share it
+func createTemp(fname string) (*os.File, error) {
+	return os.CreateTemp(filepath.Dir(fname), filepath.Base(fname)+".*.tmp")
+}
+
+// helper function to rename temporary file into place of given file and
+// flush directory entry to disk, directories can not be flushed on all
+// platforms and therefore directory flush is best effort
+func renameFile(tmp, fname string) error {
+	err := os.Rename(tmp, fname)
+	if err != nil {
+		return err
+	}
+	if dir, err := os.Open(filepath.Dir(fname)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
+
+// Key derives vault key from given secret
+func (m *Meta) Key(secret []byte) ([]byte, error) {
+	return m.keys.Key(m.KDF, secret)
+}
+
+// DataKey unwraps vault data key using given secret
+func (m *Meta) DataKey(secret []byte) ([]byte, error) {
+	if len(m.WrappedKey) == 0 {
+		return nil, ErrNoDataKey
+	}
+	key, err := m.Key(secret)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := crypt.DecryptWithKey(m.WrappedKey, key, "")
+	if err != nil {
+		return nil, fmt.Errorf("unable to unwrap vault data key, error %v", err)
+	}
+	return dataKey, nil
+}
+
+// WrapKey wraps given vault data key with vault key derived from given secret
+func (m *Meta) WrapKey(dataKey, secret []byte, cipher string) error {
+	if m.KDF.Name == crypt.KDFLegacy {
+		return errors.New("vault data key can not be wrapped with legacy key derivation")
+	}
+	key, err := m.Key(secret)
+	if err != nil {
+		return err
+	}
+	wrappedKey, err := crypt.EncryptWithKey(dataKey, key, crypt.GetCipher(cipher))
+	if err != nil {
+		return err
+	}
+	m.WrappedKey = wrappedKey
+	return nil
+}
+
+// IdentityKey unwraps vault data key using given identity
+func (m *Meta) IdentityKey(identity *crypt.Identity) ([]byte, error) {
+	dataKey, err := identity.UnwrapKey(m.Recipients)
+	if err != nil {
+		return nil, fmt.Errorf("unable to unwrap vault data key, error %v", err)
+	}
+	return dataKey, nil
+}
+
+// AddRecipient wraps given vault data key for given recipient
+func (m *Meta) AddRecipient(dataKey []byte, recipient *crypt.Recipient) error {
+	for _, s := range m.Recipients {
+		if s.Recipient == recipient.String() {
+			return fmt.Errorf("recipient %s already exists", s.Recipient)
+		}
+	}
+	stanza, err := crypt.WrapKey(dataKey, recipient)
+	if err != nil {
+		return err
+	}
+	m.Recipients = append(m.Recipients, stanza)
+	return nil
+}
+
+// RemoveRecipient removes given recipient from the vault
+func (m *Meta) RemoveRecipient(recipient *crypt.Recipient) error {
+	for i, s := range m.Recipients {
+		if s.Recipient == recipient.String() {
+			m.Recipients = append(m.Recipients[:i], m.Recipients[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("recipient %s is not found", recipient.String())
+}
+
+// RewrapRecipients wraps given vault data key for all vault recipients
+func (m *Meta) RewrapRecipients(dataKey []byte) error {
+	var stanzas []*crypt.Stanza
+	for _, s := range m.Recipients {
+		recipient, err := crypt.ParseRecipient(s.Recipient)
+		if err != nil {
+			return err
+		}
+		stanza, err := crypt.WrapKey(dataKey, recipient)
+		if err != nil {
+			return err
+		}
+		stanzas = append(stanzas, stanza)
+	}
+	m.Recipients = stanzas
+	return nil
+}
+
+// Decrypt decrypts given data using vault secret. The cipher and key
+// derivation parameters are taken from the data header, records encrypted
+// with raw key are decrypted with vault data key. Records written by old
+// vaults without header are decrypted with given cipher using either vault
+// key or legacy key derivation, they will be encrypted with vault data key
+// next time they are written
+func (m *Meta) Decrypt(data, secret []byte, cipher string) ([]byte, error) {
+	return m.DecryptRecord("", data, secret, cipher)
+}
+
+// DecryptRecord decrypts data of given vault record using vault secret.
+// Records encrypted with vault data key are authenticated along with their
+// ID, therefore data of one record can not be used in place of another one
+func (m *Meta) DecryptRecord(rid string, data, secret []byte, cipher string) ([]byte, error) {
+	if header, _, err := crypt.ParseHeader(data); err == nil && header.KDF == nil {
+		dataKey, err := m.DataKey(secret)
+		if err != nil {
+			return nil, err
+		}
+		return crypt.DecryptWithKeyAD(data, dataKey, cipher, recordAD(rid))
+	}
+	if crypt.HasHeader(data) {
+		return m.keys.Decrypt(data, secret, cipher)
+	}
+	var errs []string
+	if m.KDF.Name != crypt.KDFLegacy {
+		key, err := m.Key(secret)
+		if err != nil {
+			return nil, err
+		}
+		out, err := crypt.DecryptWithKey(data, key, cipher)
+		if err == nil {
+			return out, nil
+		}
+		errs = append(errs, fmt.Sprintf("kdf:%s, error:%s", m.KDF.Name, err))
+	}
+	out, err := crypt.DecryptWithKey(data, crypt.LegacyKey(secret), cipher)
+	if err == nil {
+		return out, nil
+	}
+	errs = append(errs, fmt.Sprintf("kdf:%s, error:%s", crypt.KDFLegacy, err))
+	return nil, errors.New(strings.Join(errs, " "))
+}
+
+// helper function to provide associated data of vault record with given ID
+func recordAD(rid string) []byte {
+	if rid == "" {
+		return nil
+	}
+	return []byte(rid)
+}
+
+// helper function to read existing or create new vault meta-data
+func initMeta(vdir string, kdf *crypt.KDF) (*Meta, error) {
+	meta, err := ReadMeta(vdir)
+	if err == nil {
+		return meta, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	meta, err = NewMeta(kdf)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.MarshalIndent(meta, "", "   ")
+	if err != nil {
+		return nil, err
+	}
+	// create meta-data file exclusively such that concurrent clients
+	// of the vault will share the same salt
+	fname := filepath.Join(vdir, MetaFile)
+	file, err := os.OpenFile(fname, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if errors.Is(err, os.ErrExist) {
+		return ReadMeta(vdir)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if _, err := file.Write(data); err != nil {
+		return nil, err
+	}
+	if err := file.Sync(); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
@@ -0,0 +1,91 @@
+package vault
+
+// pool module provides bounded pool of workers used to read and write
+// vault records concurrently. Most of the time of vault read and write is
+// spent in key derivation and record encryption, therefore records are
+// processed by number of workers which matches number of CPUs.
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// RecordError represents error of single vault record file
+type RecordError struct {
+	File string // record file name
+	Err  error  // record error
+}
+
+// Error implements error interface
+func (e RecordError) Error() string {
+	return fmt.Sprintf("%s: %v", e.File, e.Err)
+}
+
+// Unwrap provides underlying error of the record
+func (e RecordError) Unwrap() error {
+	return e.Err
+}
+
+// RecordErrors represents errors of vault record files, the vault records
+// which do not fail are still processed
+type RecordErrors []RecordError
+
+// Error implements error interface
+func (e RecordErrors) Error() string {
+	var errs []string
+	for _, err := range e {
+		errs = append(errs, err.Error())
+	}
+	return fmt.Sprintf("%d vault records failed: %s", len(e), strings.Join(errs, "; "))
+}
+
+// PartialError checks if given error only reports vault records which failed
+// while other vault records were processed
+func PartialError(err error) bool {
+	var recErrors RecordErrors
+	return errors.As(err, &recErrors)
+}
+
+// RecordFailures provides errors of vault records which failed, it is empty
+// if given error does not report vault records
+func RecordFailures(err error) RecordErrors {
+	var recErrors RecordErrors
+	errors.As(err, &recErrors)
+	return recErrors
+}
+
+// helper function to get number of vault workers
+func (v *Vault) workers() int {
+	if v.Workers > 0 {
+		return v.Workers
+	}
+	return runtime.NumCPU()
+}
+
+// helper function to process given number of items by bounded pool of
+// workers, the function is called with index of item to process and it
+// should keep its results by that index to preserve order of the items
+func runPool(items, workers int, fn func(idx int)) {
+	if workers > items {
+		workers = items
+	}
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range jobs {
+				fn(idx)
+			}
+		}()
+	}
+	for idx := 0; idx < items; idx++ {
+		jobs <- idx
+	}
+	close(jobs)
+	wg.Wait()
+}
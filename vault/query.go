@@ -0,0 +1,686 @@
+package vault
+
+// query module provides query language of vault records. The query consists
+// of terms which are combined by AND (default), OR and NOT (or -term)
+// operators and grouped by parentheses, e.g.
+//
+//	kind:login tag:work (url:github OR name:~gitlab) -note:"old account" sort:-mtime
+//
+// Every term has the following form:
+//
+//	value        value is found in record name, tags or URL
+//	field:value  value is found in given record field (case insensitive)
+//	field:"a b"  quoted phrase is found in given record field
+//	field:/re/   record field matches regular expression
+//	field:~value record field fuzzy matches the value, i.e. it contains a
+//	             word within small edit distance of the value
+//
+// The special fields are: id (record ID), kind (record kind, e.g. login or
+// note), folder (record folder or any of its sub-folders) and tag (single
+// record tag which should be equal to the value). The sort:field terms
+// order matched records, the -field sorts them in descending order and
+// mtime field is record modification time. Sensitive record fields, e.g.
+// password, can not be queried.
+//
+// The query is parsed once and evaluated against vault records. It can be
+// evaluated against vault index entries too, in this case the terms of
+// fields which are not kept in the index are unknown and the records are
+// loaded only if query may match them.
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// MaxQueryDepth defines maximal depth of nested NOT operators and groups of
+// the query, deeper queries are rejected to bound recursion of the parser
+const MaxQueryDepth = 32
+
+// helper type which represents result of query evaluation
+type queryMatch int
+
+const (
+	noMatch      queryMatch = iota // query does not match the record
+	unknownMatch                   // query match can not be determined
+	fullMatch                      // query matches the record
+)
+
+// helper type which represents operator of query term
+type queryOp int
+
+const (
+	opContains queryOp = iota // case insensitive sub-string match
+	opRegex                   // regular expression match
+	opFuzzy                   // fuzzy match
+)
+
+// helper interface which provides values of record fields to query terms,
+// known flag is false if values of the field are not available
+type queryTarget interface {
+	values(field string) (vals []string, known bool)
+}
+
+// helper interface of query expression nodes
+type queryNode interface {
+	eval(t queryTarget) queryMatch
+}
+
+// helper type of query term
+type termNode struct {
+	field string         // lower case field name, empty for default fields
+	op    queryOp        // term operator
+	value string         // lower case term value
+	re    *regexp.Regexp // compiled regular expression of opRegex
+}
+
+// helper types of query boolean operators
+type notNode struct{ node queryNode }
+type andNode struct{ nodes []queryNode }
+type orNode struct{ nodes []queryNode }
+
+// helper type of sort key
+type sortKey struct {
+	field string // lower case field name
+	desc  bool   // descending order
+}
+
+// Query represents parsed query of vault records
+type Query struct {
+	Text  string    // query text
+	expr  queryNode // query expression, nil expression matches all records
+	order []sortKey // sort keys of matched records
+}
+
+// ParseQuery parses given query text
+func ParseQuery(text string) (*Query, error) {
+	tokens, err := lexQuery(text)
+	if err != nil {
+		return nil, err
+	}
+	p := &queryParser{tokens: tokens}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected '%s' in query", p.tokens[p.pos].text)
+	}
+	return &Query{Text: text, expr: expr, order: p.order}, nil
+}
+
+// FieldQuery provides query which matches records whose field has given
+// value, e.g. FieldQuery("tag", "work") matches the same records as tag:work
+// query, the value is not parsed and can contain any characters
+func FieldQuery(field, value string) *Query {
+	field = strings.ToLower(field)
+	node := &termNode{field: field, op: opContains, value: strings.ToLower(value)}
+	return &Query{Text: fmt.Sprintf("%s:%q", field, value), expr: node}
+}
+
+// And provides query which matches records matched by both queries, the
+// records are ordered by sort terms of both queries
+func (q *Query) And(o *Query) *Query {
+	out := &Query{Text: strings.TrimSpace(q.Text + " " + o.Text)}
+	out.order = append(append(out.order, q.order...), o.order...)
+	switch {
+	case q.expr == nil:
+		out.expr = o.expr
+	case o.expr == nil:
+		out.expr = q.expr
+	default:
+		out.expr = &andNode{nodes: []queryNode{q.expr, o.expr}}
+	}
+	return out
+}
+
+// Match checks if query matches given vault record
+func (q *Query) Match(rec VaultRecord) bool {
+	return q.match(recordTarget{rec: &rec}) == fullMatch
+}
+
+// helper function to evaluate query against given target
+func (q *Query) match(t queryTarget) queryMatch {
+	if q.expr == nil {
+		return fullMatch
+	}
+	return q.expr.eval(t)
+}
+
+// Sort orders vault records according to query sort terms, the order of
+// records is preserved if query does not have them
+func (q *Query) Sort(records []VaultRecord) {
+	if len(q.order) == 0 {
+		return
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		for _, key := range q.order {
+			cmp := compareField(&records[i], &records[j], key.field)
+			if cmp == 0 {
+				continue
+			}
+			if key.desc {
+				return cmp > 0
+			}
+			return cmp < 0
+		}
+		return false
+	})
+}
+
+// helper function to compare given field of two records
+func compareField(a, b *VaultRecord, field string) int {
+	if field == "mtime" {
+		if a.ModificationTime.Before(b.ModificationTime) {
+			return -1
+		} else if a.ModificationTime.After(b.ModificationTime) {
+			return 1
+		}
+		return 0
+	}
+	aval, bval := firstValue(recordTarget{rec: a}, field), firstValue(recordTarget{rec: b}, field)
+	return strings.Compare(strings.ToLower(aval), strings.ToLower(bval))
+}
+
+// helper function to get first value of the field
+func firstValue(t queryTarget, field string) string {
+	if vals, _ := t.values(field); len(vals) > 0 {
+		return vals[0]
+	}
+	return ""
+}
+
+// Search provides vault records which match given query, the records are
+// loaded from vault index if vault records are loaded lazily
+func (v *Vault) Search(q *Query) []VaultRecord {
+	var out []VaultRecord
+	if !v.readAll && v.index != nil {
+		for _, e := range v.index.List() {
+			m := q.match(indexTarget{entry: &e})
+			if m == noMatch {
+				continue
+			}
+			rec, err := v.LoadRecord(e.ID)
+			if err != nil {
+				log.Printf("unable to load vault record %s, error %v", e.ID, err)
+				continue
+			}
+			if m == unknownMatch && !q.Match(rec) {
+				continue
+			}
+			out = append(out, rec)
+		}
+	} else {
+		for _, rec := range v.Records {
+			if q.Match(rec) {
+				out = append(out, rec)
+			}
+		}
+	}
+	q.Sort(out)
+	return out
+}
+
+// Query provides vault records which match given query text
+func (v *Vault) Query(text string) ([]VaultRecord, error) {
+	q, err := ParseQuery(text)
+	if err != nil {
+		return nil, err
+	}
+	return v.Search(q), nil
+}
+
+// helper type which provides values of vault record fields
+type recordTarget struct {
+	rec *VaultRecord
+}
+
+// helper function to provide values of record field
+func (t recordTarget) values(field string) ([]string, bool) {
+	switch field {
+	case "":
+		return []string{t.rec.Map["Name"], t.rec.Map["Tags"], t.rec.Map["URL"]}, true
+	case "id":
+		return []string{t.rec.ID}, true
+	case "kind":
+		return []string{t.rec.RecordKind()}, true
+	case "folder":
+		return []string{t.rec.Folder}, true
+	case "tag":
+		return t.rec.Tags(), true
+	}
+	for key, val := range t.rec.Map {
+		if strings.ToLower(key) == field {
+			return []string{val}, true
+		}
+	}
+	return nil, true
+}
+
+// helper type which provides values of vault index entry fields
+type indexTarget struct {
+	entry *IndexEntry
+}
+
+// helper function to provide values of index entry field
+func (t indexTarget) values(field string) ([]string, bool) {
+	switch field {
+	case "":
+		return []string{t.entry.Name, t.entry.Tags, t.entry.URL}, true
+	case "id":
+		return []string{t.entry.ID}, true
+	case "kind":
+		return []string{t.entry.Kind}, true
+	case "folder":
+		return []string{t.entry.Folder}, true
+	case "name":
+		return []string{t.entry.Name}, true
+	case "tags":
+		return []string{t.entry.Tags}, true
+	case "url":
+		return []string{t.entry.URL}, true
+	case "tag":
+		return ParseTags(t.entry.Tags), true
+	}
+	return nil, false
+}
+
+// helper function to evaluate query term
+func (n *termNode) eval(t queryTarget) queryMatch {
+	vals, known := t.values(n.field)
+	for _, val := range vals {
+		if n.matchValue(val) {
+			return fullMatch
+		}
+	}
+	if known {
+		return noMatch
+	}
+	return unknownMatch
+}
+
+// helper function to match single value of the field
+func (n *termNode) matchValue(val string) bool {
+	switch n.op {
+	case opRegex:
+		return n.re.MatchString(val)
+	case opFuzzy:
+		return fuzzyMatch(strings.ToLower(val), n.value)
+	}
+	if n.field == "tag" || n.field == "kind" {
+		return strings.ToLower(val) == n.value
+	}
+	if n.field == "folder" {
+		return InFolder(strings.ToLower(val), strings.Trim(n.value, FolderSeparator))
+	}
+	return strings.Contains(strings.ToLower(val), n.value)
+}
+
+// helper function to evaluate NOT operator
+func (n *notNode) eval(t queryTarget) queryMatch {
+	return fullMatch - n.node.eval(t)
+}
+
+// helper function to evaluate AND operator
+func (n *andNode) eval(t queryTarget) queryMatch {
+	out := fullMatch
+	for _, node := range n.nodes {
+		if m := node.eval(t); m < out {
+			out = m
+		}
+		if out == noMatch {
+			break
+		}
+	}
+	return out
+}
+
+// helper function to evaluate OR operator
+func (n *orNode) eval(t queryTarget) queryMatch {
+	out := noMatch
+	for _, node := range n.nodes {
+		if m := node.eval(t); m > out {
+			out = m
+		}
+		if out == fullMatch {
+			break
+		}
+	}
+	return out
+}
+
+// helper function to check if value contains a word within small edit
+// distance of given term, both should be in lower case
+func fuzzyMatch(val, term string) bool {
+	if strings.Contains(val, term) {
+		return true
+	}
+	maxDist := 1
+	if len([]rune(term)) > 6 {
+		maxDist = 2
+	}
+	words := strings.FieldsFunc(val, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, word := range words {
+		if editDistance(word, term) <= maxDist {
+			return true
+		}
+	}
+	return false
+}
+
+// helper function to calculate Levenshtein distance between two strings
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min3(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+// helper function to get minimum of three integers
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// helper type of query token kinds
+type tokenKind int
+
+const (
+	tokTerm tokenKind = iota
+	tokLParen
+	tokRParen
+	tokAnd
+	tokOr
+	tokNot
+)
+
+// helper type of query token
+type queryToken struct {
+	kind  tokenKind
+	text  string  // token text
+	field string  // term field
+	op    queryOp // term operator
+	value string  // term value
+}
+
+// helper function to split query text into tokens
+func lexQuery(text string) ([]queryToken, error) {
+	var tokens []queryToken
+	rs := []rune(text)
+	pos := 0
+	for pos < len(rs) {
+		r := rs[pos]
+		switch {
+		case unicode.IsSpace(r):
+			pos++
+		case r == '(':
+			tokens = append(tokens, queryToken{kind: tokLParen, text: "("})
+			pos++
+		case r == ')':
+			tokens = append(tokens, queryToken{kind: tokRParen, text: ")"})
+			pos++
+		case r == '-' && pos+1 < len(rs) && !unicode.IsSpace(rs[pos+1]):
+			tokens = append(tokens, queryToken{kind: tokNot, text: "-"})
+			pos++
+		default:
+			tok, next, err := lexTerm(rs, pos)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, tok)
+			pos = next
+		}
+	}
+	return tokens, nil
+}
+
+// helper function to read single query term starting at given position
+func lexTerm(rs []rune, start int) (queryToken, int, error) {
+	tok := queryToken{kind: tokTerm}
+	pos := start
+	// read field name, URLs like https://host are not treated as fields
+	for pos < len(rs) && (unicode.IsLetter(rs[pos]) || unicode.IsDigit(rs[pos]) || rs[pos] == '_') {
+		pos++
+	}
+	if pos > start && pos < len(rs) && rs[pos] == ':' && !strings.HasPrefix(string(rs[pos+1:]), "//") {
+		tok.field = strings.ToLower(string(rs[start:pos]))
+		pos++
+	} else {
+		pos = start
+	}
+	if pos < len(rs) && rs[pos] == '~' {
+		tok.op = opFuzzy
+		pos++
+	}
+	quoted := false
+	if pos < len(rs) && (rs[pos] == '"' || (rs[pos] == '/' && tok.op != opFuzzy)) {
+		delim := rs[pos]
+		if delim == '/' {
+			tok.op = opRegex
+		}
+		quoted = true
+		pos++
+		var val []rune
+		closed := false
+		for pos < len(rs) {
+			if rs[pos] == '\\' && pos+1 < len(rs) && rs[pos+1] == delim {
+				val = append(val, delim)
+				pos += 2
+				continue
+			}
+			if rs[pos] == delim {
+				closed = true
+				pos++
+				break
+			}
+			val = append(val, rs[pos])
+			pos++
+		}
+		if !closed {
+			return tok, pos, fmt.Errorf("unterminated %c in query", delim)
+		}
+		tok.value = string(val)
+	} else {
+		vstart := pos
+		for pos < len(rs) && !unicode.IsSpace(rs[pos]) && rs[pos] != '(' && rs[pos] != ')' {
+			pos++
+		}
+		tok.value = string(rs[vstart:pos])
+	}
+	tok.text = string(rs[start:pos])
+	if tok.field == "" && !quoted && tok.op == opContains {
+		switch tok.value {
+		case "AND":
+			tok.kind = tokAnd
+		case "OR":
+			tok.kind = tokOr
+		case "NOT":
+			tok.kind = tokNot
+		}
+	}
+	if tok.kind == tokTerm && tok.value == "" && !quoted {
+		return tok, pos, fmt.Errorf("missing value of query term '%s'", tok.text)
+	}
+	return tok, pos, nil
+}
+
+// helper type of query parser
+type queryParser struct {
+	tokens []queryToken
+	pos    int
+	depth  int       // depth of nested expressions
+	order  []sortKey // sort keys
+}
+
+// helper function to peek current token
+func (p *queryParser) peek() *queryToken {
+	if p.pos < len(p.tokens) {
+		return &p.tokens[p.pos]
+	}
+	return nil
+}
+
+// helper function to parse OR expression
+func (p *queryParser) parseOr() (queryNode, error) {
+	var nodes []queryNode
+	for {
+		node, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+		if tok := p.peek(); tok == nil || tok.kind != tokOr {
+			break
+		}
+		p.pos++
+	}
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	for _, node := range nodes {
+		if node == nil {
+			return nil, errors.New("missing operand of OR in query")
+		}
+	}
+	return &orNode{nodes: nodes}, nil
+}
+
+// helper function to parse AND expression, AND operator is optional
+func (p *queryParser) parseAnd() (queryNode, error) {
+	var nodes []queryNode
+	for {
+		tok := p.peek()
+		if tok == nil || tok.kind == tokOr || tok.kind == tokRParen {
+			break
+		}
+		if tok.kind == tokAnd {
+			p.pos++
+			if next := p.peek(); next == nil || next.kind == tokOr || next.kind == tokRParen {
+				return nil, errors.New("missing operand of AND in query")
+			}
+			continue
+		}
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if node != nil {
+			nodes = append(nodes, node)
+		}
+	}
+	switch len(nodes) {
+	case 0:
+		return nil, nil
+	case 1:
+		return nodes[0], nil
+	}
+	return &andNode{nodes: nodes}, nil
+}
+
+// helper function to enter nested expression of the query
+func (p *queryParser) enter() error {
+	if p.depth >= MaxQueryDepth {
+		return fmt.Errorf("query is nested deeper than %d levels", MaxQueryDepth)
+	}
+	p.depth++
+	return nil
+}
+
+// helper function to parse NOT expression, group or single term
+func (p *queryParser) parseUnary() (queryNode, error) {
+	tok := p.peek()
+	p.pos++
+	switch tok.kind {
+	case tokNot:
+		if p.peek() == nil {
+			return nil, errors.New("missing operand of NOT in query")
+		}
+		if err := p.enter(); err != nil {
+			return nil, err
+		}
+		node, err := p.parseUnary()
+		p.depth--
+		if err != nil {
+			return nil, err
+		}
+		if node == nil {
+			return nil, errors.New("missing operand of NOT in query")
+		}
+		return &notNode{node: node}, nil
+	case tokLParen:
+		if err := p.enter(); err != nil {
+			return nil, err
+		}
+		node, err := p.parseOr()
+		p.depth--
+		if err != nil {
+			return nil, err
+		}
+		if next := p.peek(); next == nil || next.kind != tokRParen {
+			return nil, errors.New("missing ')' in query")
+		}
+		p.pos++
+		if node == nil {
+			return nil, errors.New("empty group in query")
+		}
+		return node, nil
+	case tokRParen, tokAnd, tokOr:
+		return nil, fmt.Errorf("unexpected '%s' in query", tok.text)
+	}
+	return p.parseTerm(tok)
+}
+
+// helper function to parse query term
+func (p *queryParser) parseTerm(tok *queryToken) (queryNode, error) {
+	if SensitiveKey(tok.field) {
+		return nil, fmt.Errorf("field %s can not be queried", tok.field)
+	}
+	if tok.field == "sort" {
+		if p.depth > 0 {
+			return nil, errors.New("sort term should be used at top level of query")
+		}
+		key := sortKey{field: strings.ToLower(tok.value)}
+		if strings.HasPrefix(key.field, "-") {
+			key.field, key.desc = key.field[1:], true
+		}
+		if key.field == "" || SensitiveKey(key.field) {
+			return nil, fmt.Errorf("invalid sort field '%s'", tok.value)
+		}
+		p.order = append(p.order, key)
+		return nil, nil
+	}
+	node := &termNode{field: tok.field, op: tok.op, value: strings.ToLower(tok.value)}
+	if tok.op == opRegex {
+		re, err := regexp.Compile(tok.value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression '%s', error %v", tok.value, err)
+		}
+		node.re = re
+	}
+	return node, nil
+}
@@ -0,0 +1,400 @@
+package vault
+
+// schema module provides registry of vault record kinds. Every kind declares
+// its fields along with their types and validation rules, e.g. login record
+// has Name, Login, Password, TOTP, URL, Tags and Note fields. New records are
+// created from the schema of their kind and frontends render record forms
+// from it. Records may have fields which are not declared by their schema,
+// such fields are kept as is and they are not validated.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	crypt "github.com/vkuznet/ecm/crypt"
+)
+
+// FieldType represents type of record field
+type FieldType string
+
+// list of supported field types
+const (
+	TextField      FieldType = "text"      // single line text
+	ConcealedField FieldType = "concealed" // secret value which is not shown
+	URLField       FieldType = "url"       // URL with scheme and host
+	DateField      FieldType = "date"      // date, e.g. 2022-11-05 or 11/2022
+	TOTPField      FieldType = "totp"      // otpauth:// URI or base32 secret
+	EmailField     FieldType = "email"     // email address
+	PhoneField     FieldType = "phone"     // phone number
+	MultilineField FieldType = "multiline" // multi-line text
+)
+
+// DateFormats defines supported formats of date fields
+var DateFormats = []string{"2006-01-02", "2006-01", "01/2006", "01/06"}
+
+// phone number pattern, digits with optional separators and country code
+var phonePattern = regexp.MustCompile(`^\+?[0-9][0-9 ()\-.]{4,19}$`)
+
+// SchemaField represents field of record schema
+type SchemaField struct {
+	Key         string    `json:"key"`                   // record key
+	Type        FieldType `json:"type"`                  // field type
+	Required    bool      `json:"required"`              // field value should be provided
+	Pattern     string    `json:"pattern,omitempty"`     // regular expression which value should match
+	Placeholder string    `json:"placeholder,omitempty"` // example of field value shown by frontends
+
+	// Check provides additional validation of the field value
+	Check func(val string) error `json:"-"`
+
+	re *regexp.Regexp // compiled pattern
+}
+
+// Concealed checks if field value should not be shown
+func (f SchemaField) Concealed() bool {
+	return f.Type == ConcealedField || f.Type == TOTPField
+}
+
+// Validate validates given value of the field, empty value is only
+// checked if field is required
+func (f SchemaField) Validate(val string) error {
+	if val == "" {
+		if f.Required {
+			return errors.New("value is required")
+		}
+		return nil
+	}
+	if f.Type != MultilineField && f.Type != ConcealedField && strings.ContainsAny(val, "\r\n") {
+		return errors.New("value should be single line")
+	}
+	switch f.Type {
+	case URLField:
+		if u, err := url.Parse(val); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("invalid URL '%s', it should have scheme and host", val)
+		}
+	case EmailField:
+		if addr, err := mail.ParseAddress(val); err != nil || addr.Address != val {
+			return fmt.Errorf("invalid email address '%s'", val)
+		}
+	case PhoneField:
+		if !phonePattern.MatchString(val) {
+			return fmt.Errorf("invalid phone number '%s'", val)
+		}
+	case DateField:
+		valid := false
+		for _, layout := range DateFormats {
+			if _, err := time.Parse(layout, val); err == nil {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return fmt.Errorf("invalid date '%s', supported formats %s", val, strings.Join(DateFormats, ", "))
+		}
+	case TOTPField:
+		if _, err := crypt.ParseTOTP(val); err != nil {
+			return fmt.Errorf("invalid TOTP seed, %v", err)
+		}
+	}
+	if f.re != nil && !f.re.MatchString(val) {
+		return fmt.Errorf("value does not match pattern %s", f.Pattern)
+	}
+	if f.Check != nil {
+		return f.Check(val)
+	}
+	return nil
+}
+
+// Schema represents schema of vault record kind
+type Schema struct {
+	Kind   string        `json:"kind"`   // record kind
+	Title  string        `json:"title"`  // human readable name of the kind
+	Fields []SchemaField `json:"fields"` // record fields
+}
+
+// Field provides schema field of given record key
+func (s Schema) Field(key string) (SchemaField, bool) {
+	for _, f := range s.Fields {
+		if f.Key == key {
+			return f, true
+		}
+	}
+	return SchemaField{}, false
+}
+
+// Keys provides record keys declared by the schema
+func (s Schema) Keys() []string {
+	var keys []string
+	for _, f := range s.Fields {
+		keys = append(keys, f.Key)
+	}
+	return keys
+}
+
+// Validate validates fields of given record
+func (s Schema) Validate(rec VaultRecord) error {
+	var msgs []string
+	for _, f := range s.Fields {
+		if err := f.Validate(rec.Value(f.Key)); err != nil {
+			msgs = append(msgs, fmt.Sprintf("%s: %v", f.Key, err))
+		}
+	}
+	if len(msgs) > 0 {
+		return fmt.Errorf("invalid %s record, %s", s.Kind, strings.Join(msgs, "; "))
+	}
+	return nil
+}
+
+// schema registry
+var (
+	schemaMutex  sync.RWMutex
+	schemaKinds  []string
+	schemaByKind = make(map[string]Schema)
+)
+
+// RegisterSchema adds schema of record kind to the registry, the schema of
+// existing kind is replaced
+func RegisterSchema(s Schema) error {
+	if s.Kind == "" || strings.ToLower(s.Kind) != s.Kind || strings.ContainsAny(s.Kind, " \t:") {
+		return fmt.Errorf("invalid record kind '%s'", s.Kind)
+	}
+	if s.Title == "" {
+		s.Title = s.Kind
+	}
+	keys := make(map[string]bool)
+	fields := make([]SchemaField, len(s.Fields))
+	for i, f := range s.Fields {
+		if f.Key == "" || keys[f.Key] {
+			return fmt.Errorf("invalid or duplicate field '%s' of %s schema", f.Key, s.Kind)
+		}
+		keys[f.Key] = true
+		switch f.Type {
+		case TextField, ConcealedField, URLField, DateField, TOTPField, EmailField, PhoneField, MultilineField:
+		default:
+			return fmt.Errorf("unsupported type '%s' of %s field", f.Type, f.Key)
+		}
+		if f.Pattern != "" {
+			re, err := regexp.Compile(f.Pattern)
+			if err != nil {
+				return fmt.Errorf("invalid pattern of %s field, error %v", f.Key, err)
+			}
+			f.re = re
+		}
+		fields[i] = f
+	}
+	s.Fields = fields
+	schemaMutex.Lock()
+	defer schemaMutex.Unlock()
+	if _, ok := schemaByKind[s.Kind]; !ok {
+		schemaKinds = append(schemaKinds, s.Kind)
+	}
+	schemaByKind[s.Kind] = s
+	return nil
+}
+
+// LookupSchema provides schema of given record kind
+func LookupSchema(kind string) (Schema, bool) {
+	schemaMutex.RLock()
+	defer schemaMutex.RUnlock()
+	s, ok := schemaByKind[kind]
+	return s, ok
+}
+
+// Schemas provides schemas of all record kinds in order of their registration
+func Schemas() []Schema {
+	schemaMutex.RLock()
+	defer schemaMutex.RUnlock()
+	var out []Schema
+	for _, kind := range schemaKinds {
+		out = append(out, schemaByKind[kind])
+	}
+	return out
+}
+
+// Kinds provides list of registered record kinds
+func Kinds() []string {
+	schemaMutex.RLock()
+	defer schemaMutex.RUnlock()
+	return append([]string{}, schemaKinds...)
+}
+
+// helper function to check if record key is concealed by any schema, keys
+// are compared case insensitively
+func concealedKey(key string) bool {
+	schemaMutex.RLock()
+	defer schemaMutex.RUnlock()
+	for _, s := range schemaByKind {
+		for _, f := range s.Fields {
+			if f.Concealed() && strings.EqualFold(f.Key, key) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// helper function to check card number by Luhn algorithm
+func luhnCheck(val string) error {
+	var sum, count int
+	for i := len(val) - 1; i >= 0; i-- {
+		c := val[i]
+		if c == ' ' || c == '-' {
+			continue
+		}
+		d := int(c - '0')
+		if count%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		count++
+	}
+	if sum%10 != 0 {
+		return errors.New("invalid card number checksum")
+	}
+	return nil
+}
+
+// helper function to check JSON value
+func jsonCheck(val string) error {
+	if !json.Valid([]byte(val)) {
+		return errors.New("invalid JSON")
+	}
+	return nil
+}
+
+// builtinSchemas defines schemas of built-in record kinds
+var builtinSchemas = []Schema{
+	{Kind: "login", Title: "Login", Fields: []SchemaField{
+		{Key: "Name", Type: TextField, Required: true, Placeholder: "record name"},
+		{Key: "Login", Type: TextField, Placeholder: "login name"},
+		{Key: "Password", Type: ConcealedField},
+		{Key: TOTPKey, Type: TOTPField, Placeholder: "otpauth:// URI or base32 secret"},
+		{Key: "URL", Type: URLField, Placeholder: "e.g. https://abc.com"},
+		{Key: "Tags", Type: TextField, Placeholder: "tag1,tag2,..."},
+		{Key: "Note", Type: MultilineField},
+	}},
+	{Kind: "card", Title: "Credit card", Fields: []SchemaField{
+		{Key: "Name", Type: TextField, Required: true, Placeholder: "record name"},
+		{Key: "Cardholder", Type: TextField, Placeholder: "name on the card"},
+		{Key: "Number", Type: ConcealedField, Pattern: `^[0-9][0-9 \-]{10,21}[0-9]$`, Check: luhnCheck},
+		{Key: "Expiry", Type: DateField, Placeholder: "MM/YYYY"},
+		{Key: "CVC", Type: ConcealedField, Pattern: `^[0-9]{3,4}$`},
+		{Key: "PIN", Type: ConcealedField, Pattern: `^[0-9]{4,12}$`},
+		{Key: "Phone", Type: PhoneField, Placeholder: "+1-888-888-8888"},
+		{Key: "Tags", Type: TextField, Placeholder: "tag1,tag2,..."},
+		{Key: "Note", Type: MultilineField},
+	}},
+	{Kind: "note", Title: "Note", Fields: []SchemaField{
+		{Key: "Name", Type: TextField, Required: true, Placeholder: "record name"},
+		{Key: "Note", Type: MultilineField},
+		{Key: "Tags", Type: TextField, Placeholder: "tag1,tag2,..."},
+	}},
+	{Kind: "identity", Title: "Identity", Fields: []SchemaField{
+		{Key: "Name", Type: TextField, Required: true, Placeholder: "record name"},
+		{Key: "FirstName", Type: TextField},
+		{Key: "LastName", Type: TextField},
+		{Key: "Email", Type: EmailField, Placeholder: "name@example.com"},
+		{Key: "Phone", Type: PhoneField, Placeholder: "+1-888-888-8888"},
+		{Key: "Address", Type: MultilineField},
+		{Key: "Birthday", Type: DateField, Placeholder: "YYYY-MM-DD"},
+		{Key: "Tags", Type: TextField, Placeholder: "tag1,tag2,..."},
+		{Key: "Note", Type: MultilineField},
+	}},
+	{Kind: "ssh", Title: "SSH key", Fields: []SchemaField{
+		{Key: "Name", Type: TextField, Required: true, Placeholder: "record name"},
+		{Key: "Host", Type: TextField, Placeholder: "host name"},
+		{Key: "Login", Type: TextField, Placeholder: "login name"},
+		{Key: "PrivateKey", Type: ConcealedField, Required: true},
+		{Key: "PublicKey", Type: MultilineField, Placeholder: "ssh-ed25519 AAAA..."},
+		{Key: "Passphrase", Type: ConcealedField},
+		{Key: "Tags", Type: TextField, Placeholder: "tag1,tag2,..."},
+		{Key: "Note", Type: MultilineField},
+	}},
+	{Kind: "wifi", Title: "Wi-Fi", Fields: []SchemaField{
+		{Key: "Name", Type: TextField, Required: true, Placeholder: "record name"},
+		{Key: "SSID", Type: TextField, Required: true, Placeholder: "network name"},
+		{Key: "Password", Type: ConcealedField},
+		{Key: "Security", Type: TextField, Pattern: `^(?i)(none|wep|wpa|wpa2|wpa3)$`, Placeholder: "WPA2"},
+		{Key: "Tags", Type: TextField, Placeholder: "tag1,tag2,..."},
+		{Key: "Note", Type: MultilineField},
+	}},
+	{Kind: "api", Title: "API key", Fields: []SchemaField{
+		{Key: "Name", Type: TextField, Required: true, Placeholder: "record name"},
+		{Key: "URL", Type: URLField, Placeholder: "e.g. https://api.abc.com"},
+		{Key: "Key", Type: ConcealedField, Required: true},
+		{Key: "Secret", Type: ConcealedField},
+		{Key: "Tags", Type: TextField, Placeholder: "tag1,tag2,..."},
+		{Key: "Note", Type: MultilineField},
+	}},
+	{Kind: "json", Title: "JSON", Fields: []SchemaField{
+		{Key: "Name", Type: TextField, Required: true, Placeholder: "record name"},
+		{Key: "JSON", Type: MultilineField, Placeholder: `{"attribute":"value"}`, Check: jsonCheck},
+		{Key: "Tags", Type: TextField, Placeholder: "tag1,tag2,..."},
+	}},
+	{Kind: "file", Title: "File", Fields: []SchemaField{
+		{Key: "Name", Type: TextField, Required: true, Placeholder: "file name"},
+		{Key: "File", Type: TextField},
+		{Key: "Size", Type: TextField},
+		{Key: "Tags", Type: TextField, Placeholder: "tag1,tag2,..."},
+	}},
+}
+
+func init() {
+	for _, s := range builtinSchemas {
+		if err := RegisterSchema(s); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// helper function to infer kind of records which were written without it
+func inferKind(rec *VaultRecord) string {
+	has := func(key string) bool {
+		_, ok := rec.Map[key]
+		return ok
+	}
+	switch {
+	case has("File") || len(rec.Attachments) > 0:
+		return "file"
+	case has("Number") && has("CVC"):
+		return "card"
+	case has("Login") || has("Password") || has("URL") || has(TOTPKey):
+		return "login"
+	case has("JSON"):
+		return "json"
+	}
+	return "note"
+}
+
+// RecordKind provides kind of vault record, the kind of records written
+// without it is inferred from their keys
+func (r *VaultRecord) RecordKind() string {
+	if r.Kind != "" {
+		return r.Kind
+	}
+	return inferKind(r)
+}
+
+// Schema provides schema of vault record kind
+func (r *VaultRecord) Schema() (Schema, bool) {
+	return LookupSchema(r.RecordKind())
+}
+
+// Validate validates vault record against schema of its kind, records of
+// unknown kinds are not validated
+func (r *VaultRecord) Validate() error {
+	s, ok := r.Schema()
+	if !ok {
+		return nil
+	}
+	return s.Validate(*r)
+}
@@ -0,0 +1,280 @@
+package vault
+
+// tag module manages record tags. Tags are kept in record Tags field and
+// they are parsed as normalized set, i.e. tags are lower case, unique and
+// sorted, and written back separated by comma. Tags can be renamed, merged
+// or deleted across all vault records, such bulk operations stage all
+// changed records before any record is replaced, keep backups of replaced
+// records and restore them if any record can not be replaced.
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	utils "github.com/vkuznet/ecm/utils"
+)
+
+// TagsKey defines record field which keeps record tags
+const TagsKey = "Tags"
+
+// TagSeparator defines separator of normalized record tags
+const TagSeparator = ","
+
+// TagInfo represents vault tag along with number of records which have it
+type TagInfo struct {
+	Name  string `json:"name"`  // tag name
+	Count int    `json:"count"` // number of records with the tag
+}
+
+// NormalizeTag provides canonical form of given tag
+func NormalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(tag))
+}
+
+// ParseTags parses given tags string into normalized set of tags, the tags
+// can be separated by comma, semicolon or white space
+func ParseTags(tags string) []string {
+	var out []string
+	for _, tag := range strings.FieldsFunc(tags, func(r rune) bool {
+		return r == ',' || r == ';' || unicode.IsSpace(r)
+	}) {
+		tag = NormalizeTag(tag)
+		if tag != "" && !utils.InList(tag, out) {
+			out = append(out, tag)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// FormatTags provides tags string of given tags
+func FormatTags(tags []string) string {
+	return strings.Join(ParseTags(strings.Join(tags, TagSeparator)), TagSeparator)
+}
+
+// Tags provides normalized set of record tags
+func (r *VaultRecord) Tags() []string {
+	return ParseTags(r.Map[TagsKey])
+}
+
+// HasTag checks if record has given tag
+func (r *VaultRecord) HasTag(tag string) bool {
+	return utils.InList(NormalizeTag(tag), r.Tags())
+}
+
+// SetTags sets record tags
+func (r *VaultRecord) SetTags(tags []string) {
+	if r.Map == nil {
+		r.Map = make(Record)
+	}
+	r.Map[TagsKey] = FormatTags(tags)
+}
+
+// helper function to normalize record tags, records without Tags field
+// are kept as is
+func normalizeTags(rec *VaultRecord) {
+	if tags, ok := rec.Map[TagsKey]; ok {
+		rec.Map[TagsKey] = FormatTags(ParseTags(tags))
+	}
+}
+
+// Tags provides vault tags along with number of records which have them,
+// the tags are taken from vault index and loaded records
+func (v *Vault) Tags() ([]TagInfo, error) {
+	if v.index == nil {
+		// records which can not be read are not listed
+		if err := v.ReadIndex(); err != nil && !PartialError(err) {
+			return nil, err
+		}
+	}
+	tags := make(map[string]string)
+	for rid, e := range v.index.Entries {
+		tags[rid] = e.Tags
+	}
+	for _, rec := range v.Records {
+		tags[rec.ID] = rec.Map[TagsKey]
+	}
+	counts := make(map[string]int)
+	for _, val := range tags {
+		for _, tag := range ParseTags(val) {
+			counts[tag]++
+		}
+	}
+	var out []TagInfo
+	for tag, count := range counts {
+		out = append(out, TagInfo{Name: tag, Count: count})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
+
+// RenameTag renames tag in all vault records, records which already have
+// new tag keep it only once. It returns number of changed records
+func (v *Vault) RenameTag(tag, newTag string) (int, error) {
+	return v.MergeTags([]string{tag}, newTag)
+}
+
+// MergeTags replaces given tags by target tag in all vault records, it
+// returns number of changed records
+func (v *Vault) MergeTags(tags []string, target string) (int, error) {
+	target = NormalizeTag(target)
+	if len(ParseTags(target)) != 1 {
+		return 0, fmt.Errorf("invalid tag '%s'", target)
+	}
+	tags = ParseTags(strings.Join(tags, TagSeparator))
+	if len(tags) == 0 {
+		return 0, errors.New("tags are not provided")
+	}
+	return v.changeTags(func(rtags []string) []string {
+		var out []string
+		for _, tag := range rtags {
+			if utils.InList(tag, tags) {
+				tag = target
+			}
+			out = append(out, tag)
+		}
+		return out
+	})
+}
+
+// DeleteTag removes tag from all vault records, it returns number of
+// changed records
+func (v *Vault) DeleteTag(tag string) (int, error) {
+	tag = NormalizeTag(tag)
+	if tag == "" {
+		return 0, errors.New("tag is not provided")
+	}
+	return v.changeTags(func(rtags []string) []string {
+		var out []string
+		for _, t := range rtags {
+			if t != tag {
+				out = append(out, t)
+			}
+		}
+		return out
+	})
+}
+
+// helper function to apply given change to tags of all vault records. The
+// changed records are encrypted to staging area first, then current records
+// are kept in backups area and record history, and only then the records
+// are replaced. If any record can not be replaced the already replaced
+// records are restored from their backups.
+func (v *Vault) changeTags(change func(tags []string) []string) (int, error) {
+	unlock, err := v.lockDir(true)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+	if v.index == nil {
+		if err := v.ReadIndex(); err != nil {
+			return 0, err
+		}
+	}
+	// index provides tags of all records, therefore only records whose tags
+	// are changed are decrypted
+	var rids []string
+	for rid, e := range v.index.Entries {
+		tags := ParseTags(e.Tags)
+		if FormatTags(change(tags)) != FormatTags(tags) {
+			rids = append(rids, rid)
+		}
+	}
+	sort.Strings(rids)
+	var records []VaultRecord
+	for _, rid := range rids {
+		rec, err := v.LoadRecord(rid)
+		if err != nil {
+			return 0, fmt.Errorf("unable to load record %s, error %v", rid, err)
+		}
+		tags := rec.Tags()
+		if FormatTags(change(tags)) == FormatTags(tags) {
+			continue
+		}
+		// loaded record shares its map with in-memory record, which should
+		// be kept intact until changed record replaces its file
+		rmap := make(Record, len(rec.Map))
+		for key, val := range rec.Map {
+			rmap[key] = val
+		}
+		rec.Map = rmap
+		rec.SetTags(change(tags))
+		rec.ModificationTime = time.Now()
+		records = append(records, rec)
+	}
+	if len(records) == 0 {
+		return 0, nil
+	}
+
+	key, err := v.writeKey()
+	if err != nil {
+		return 0, err
+	}
+	sdir, err := os.MkdirTemp(v.Directory, "tags-*.tmp")
+	if err != nil {
+		return 0, err
+	}
+	defer os.RemoveAll(sdir)
+	for _, rec := range records {
+		err := rec.writeRecord(sdir, key, v.Cipher, v.Verbose)
+		if err != nil {
+			return 0, fmt.Errorf("unable to write record %s, error %v", rec.ID, err)
+		}
+	}
+
+	bdir := filepath.Join(v.Directory, "backups")
+	err = os.MkdirAll(bdir, 0755)
+	if err != nil {
+		return 0, err
+	}
+	bsubdir, err := utils.BackupTDir(bdir)
+	if err != nil {
+		return 0, err
+	}
+	for _, rec := range records {
+		if err := utils.BackupFile(v.Directory, rec.ID, bdir); err != nil {
+			return 0, fmt.Errorf("unable to backup record %s, error %v", rec.ID, err)
+		}
+		if err := v.archiveRecord(rec.ID); err != nil {
+			return 0, fmt.Errorf("unable to archive record %s, error %v", rec.ID, err)
+		}
+	}
+
+	for idx, rec := range records {
+		err := os.Rename(filepath.Join(sdir, rec.ID), filepath.Join(v.Directory, rec.ID))
+		if err == nil {
+			continue
+		}
+		for _, r := range records[:idx] {
+			_, rerr := utils.Copy(filepath.Join(bsubdir, r.ID), filepath.Join(v.Directory, r.ID))
+			if rerr != nil {
+				log.Printf("unable to restore record %s from backup, error %v", r.ID, rerr)
+			}
+		}
+		return 0, fmt.Errorf("unable to replace record %s, error %v", rec.ID, err)
+	}
+
+	for _, rec := range records {
+		for i, r := range v.Records {
+			if r.ID == rec.ID {
+				v.Records[i] = rec
+			}
+		}
+	}
+	v.ModificationTime = time.Now()
+	err = v.indexRecords(records, nil, false)
+	if err != nil {
+		log.Printf("unable to update vault index, error %v", err)
+	}
+	if v.Verbose > 0 {
+		log.Printf("tags of %d records are changed", len(records))
+	}
+	return len(records), nil
+}
@@ -0,0 +1,288 @@
+package vault
+
+// trash module keeps deleted vault records. The deleted record is moved to
+// trash/<rid> area of the vault along with its encrypted file and history,
+// and the time of deletion is kept in trash/<rid>/deleted file. The records
+// stay encrypted in the trash, therefore they can be deleted, listed and
+// restored without vault secret. The records which stay in the trash longer
+// than vault trash retention period are purged when another record is moved
+// to the trash, i.e. by vault writer, or explicitly by PurgeTrash.
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	utils "github.com/vkuznet/ecm/utils"
+)
+
+// TrashDir defines name of vault area which keeps deleted vault records
+const TrashDir = "trash"
+
+// DefaultTrashRetention defines how long deleted records are kept in the trash
+const DefaultTrashRetention = 30 * 24 * time.Hour
+
+// name of the file which keeps deletion time of the record
+const deletedFile = "deleted"
+
+// TrashEntry represents deleted vault record
+type TrashEntry struct {
+	ID      string    `json:"id"`      // record ID
+	Deleted time.Time `json:"deleted"` // time of deletion
+	Expires time.Time `json:"expires"` // time when record will be purged
+}
+
+// helper function to get vault trash retention period, negative retention
+// keeps deleted records forever
+func (v *Vault) trashRetention() time.Duration {
+	if v.TrashRetention == 0 {
+		return DefaultTrashRetention
+	}
+	return v.TrashRetention
+}
+
+// helper function to get trash area of given record
+func (v *Vault) trashDir(rid string) (string, error) {
+	if rid == "" || filepath.Base(rid) != rid || strings.HasPrefix(rid, ".") {
+		return "", fmt.Errorf("invalid record ID '%s'", rid)
+	}
+	return filepath.Join(v.Directory, TrashDir, rid), nil
+}
+
+// helper function to check if vault records can be decrypted
+func (v *Vault) hasKey() bool {
+	return v.HasSecret() || v.Identity != nil || v.RecoveryKey != nil
+}
+
+// helper function to move file if it exists
+func moveFile(src, dst string) error {
+	if !utils.FileExist(src) {
+		return nil
+	}
+	err := os.MkdirAll(filepath.Dir(dst), 0700)
+	if err != nil {
+		return err
+	}
+	return os.Rename(src, dst)
+}
+
+// helper function to move record file and its history between vault and
+// given area
+func (v *Vault) moveRecord(rid, srcDir, dstDir string) error {
+	paths := []string{rid, filepath.Join(HistoryDir, rid)}
+	for _, path := range paths {
+		err := moveFile(filepath.Join(srcDir, path), filepath.Join(dstDir, path))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TrashRecord moves vault record to the trash
+func (v *Vault) TrashRecord(rid string) error {
+	unlock, err := v.lockDir(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	tdir, err := v.trashDir(rid)
+	if err != nil {
+		return err
+	}
+	if !utils.FileExist(filepath.Join(v.Directory, rid)) {
+		msg := fmt.Sprintf("no record %s found in a vault", rid)
+		return errors.New(msg)
+	}
+	// record with the same ID could be deleted before
+	err = os.RemoveAll(tdir)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(tdir, 0700)
+	if err != nil {
+		return err
+	}
+	tstamp := time.Now().UTC().Format(time.RFC3339Nano)
+	err = os.WriteFile(filepath.Join(tdir, deletedFile), []byte(tstamp), 0600)
+	if err != nil {
+		return err
+	}
+	err = v.moveRecord(rid, v.Directory, tdir)
+	if err != nil {
+		return err
+	}
+	if v.Verbose > 0 {
+		log.Printf("record %s is moved to the trash", rid)
+	}
+	// vault index is updated when it is read if vault is locked
+	if v.hasKey() {
+		if err := v.unindexRecord(rid); err != nil {
+			log.Printf("unable to update vault index, error %v", err)
+		}
+	}
+
+	// purge records which stay in the trash longer than retention period
+	if count, err := v.PurgeTrash(); err != nil {
+		log.Printf("unable to purge vault trash, error %v", err)
+	} else if count > 0 && v.Verbose > 0 {
+		log.Printf("purged %d records from vault trash", count)
+	}
+	return nil
+}
+
+// Trash provides list of deleted records ordered from the most recently
+// deleted one
+func (v *Vault) Trash() ([]TrashEntry, error) {
+	unlock, err := v.lockDir(false)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	entries, err := os.ReadDir(filepath.Join(v.Directory, TrashDir))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var out []TrashEntry
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		fname := filepath.Join(v.Directory, TrashDir, entry.Name(), deletedFile)
+		data, err := os.ReadFile(fname)
+		if err != nil {
+			log.Printf("unable to read deletion time of %s, error %v", entry.Name(), err)
+			continue
+		}
+		deleted, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(string(data)))
+		if err != nil {
+			log.Printf("unable to parse deletion time of %s, error %v", entry.Name(), err)
+			continue
+		}
+		rec := TrashEntry{ID: entry.Name(), Deleted: deleted}
+		if retention := v.trashRetention(); retention > 0 {
+			rec.Expires = deleted.Add(retention)
+		}
+		out = append(out, rec)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Deleted.After(out[j].Deleted) })
+	return out, nil
+}
+
+// TrashedRecord provides content of deleted record
+func (v *Vault) TrashedRecord(rid string) (VaultRecord, error) {
+	unlock, err := v.lockDir(false)
+	if err != nil {
+		return VaultRecord{}, err
+	}
+	defer unlock()
+	tdir, err := v.trashDir(rid)
+	if err != nil {
+		return VaultRecord{}, err
+	}
+	fname := filepath.Join(tdir, rid)
+	if !utils.FileExist(fname) {
+		msg := fmt.Sprintf("no record %s found in the trash", rid)
+		return VaultRecord{}, errors.New(msg)
+	}
+	return v.readRecord(fname, rid)
+}
+
+// RestoreRecord restores deleted record from the trash, the restored record
+// is added to vault records if vault is unlocked
+func (v *Vault) RestoreRecord(rid string) error {
+	unlock, err := v.lockDir(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	tdir, err := v.trashDir(rid)
+	if err != nil {
+		return err
+	}
+	if !utils.FileExist(filepath.Join(tdir, rid)) {
+		msg := fmt.Sprintf("no record %s found in the trash", rid)
+		return errors.New(msg)
+	}
+	if utils.FileExist(filepath.Join(v.Directory, rid)) {
+		msg := fmt.Sprintf("record %s already exists in a vault", rid)
+		return errors.New(msg)
+	}
+	err = v.moveRecord(rid, tdir, v.Directory)
+	if err != nil {
+		return err
+	}
+	err = os.RemoveAll(tdir)
+	if err != nil {
+		return err
+	}
+	if v.hasKey() {
+		rec, err := v.readRecord(filepath.Join(v.Directory, rid), rid)
+		if err != nil {
+			return err
+		}
+		v.Records = append(v.Records, rec)
+		if err := v.indexRecords([]VaultRecord{rec}, nil, false); err != nil {
+			log.Printf("unable to update vault index, error %v", err)
+		}
+	}
+	return nil
+}
+
+// PurgeRecord permanently deletes record from the trash
+func (v *Vault) PurgeRecord(rid string) error {
+	unlock, err := v.lockDir(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	tdir, err := v.trashDir(rid)
+	if err != nil {
+		return err
+	}
+	if !utils.FileExist(tdir) {
+		msg := fmt.Sprintf("no record %s found in the trash", rid)
+		return errors.New(msg)
+	}
+	return os.RemoveAll(tdir)
+}
+
+// PurgeTrash permanently deletes records which stay in the trash longer
+// than vault trash retention period, it returns number of purged records
+func (v *Vault) PurgeTrash() (int, error) {
+	unlock, err := v.lockDir(true)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+	if v.trashRetention() < 0 {
+		return 0, nil
+	}
+	records, err := v.Trash()
+	if err != nil {
+		return 0, err
+	}
+	var count int
+	for _, rec := range records {
+		if time.Now().Before(rec.Expires) {
+			continue
+		}
+		err := v.PurgeRecord(rec.ID)
+		if err != nil {
+			return count, err
+		}
+		if v.Verbose > 0 {
+			log.Printf("record %s is purged from the trash", rec.ID)
+		}
+		count++
+	}
+	return count, nil
+}
@@ -7,7 +7,6 @@ import (
 	"text/tabwriter"
 
 	"github.com/fatih/color"
-	utils "github.com/vkuznet/ecm/utils"
 )
 
 // TabularPrint provide tabular print of reocrds
@@ -21,34 +20,64 @@ func TabularPrint(records []VaultRecord) {
 	for _, rec := range records {
 		fmt.Fprintf(w, "\n------------")
 		fmt.Fprintf(w, "\nID:\t%s", rec.ID)
-		for _, key := range OrderedKeys {
-			if val, ok := rec.Map[key]; ok {
-				if strings.ToLower(key) == "password" {
-					newVal := "*"
-					for i := 0; i < len(val); i++ {
-						newVal += "*"
-					}
-					val = newVal
-				}
-				fmt.Fprintf(w, "\n%v:\t%v", key, val)
-			}
+		fmt.Fprintf(w, "\nKind:\t%s", rec.RecordKind())
+		if rec.Folder != "" {
+			fmt.Fprintf(w, "\nFolder:\t%s", rec.Folder)
 		}
-		for key, val := range rec.Map {
-			if utils.InList(key, OrderedKeys) {
+		// record keys follow order of record schema
+		for _, key := range rec.Keys() {
+			val, ok := rec.Map[key]
+			if !ok {
 				continue
 			}
-			if strings.ToLower(key) == "password" {
-				newVal := "*"
-				for i := 0; i < len(val); i++ {
-					newVal += "*"
-				}
-				val = newVal
+			if SensitiveKey(key) {
+				val = "*" + strings.Repeat("*", len(rec.Secret(key)))
 			}
 			fmt.Fprintf(w, "\n%v:\t%v", key, val)
 		}
 		fmt.Fprintf(w, "\n")
 	}
+}
+
+// TabularAuditPrint provides tabular print of vault audit report
+func TabularAuditPrint(report *AuditReport) {
+	w := new(tabwriter.Writer)
+	// minwidth, tabwidth, padding, padchar, flags
+	w.Init(os.Stdout, 8, 8, 2, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintf(w, "Audit of %d records with %d passwords, rotation period %d days\n",
+		report.Records, report.Passwords, report.Days)
+	fmt.Fprintf(w, "\nIssue\tRecords\n")
+	for _, kind := range AuditKinds {
+		fmt.Fprintf(w, "%s\t%d\n", kind, report.Counts[kind])
+	}
+	if len(report.Issues) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "\nIssue\tName\tID\tDetail\n")
+	for _, issue := range report.Issues {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", issue.Kind, issue.Name, issue.ID, issue.Detail)
+	}
+}
 
+// TabularBreachPrint provides tabular print of records checked against
+// breached passwords dataset
+func TabularBreachPrint(breaches []Breach) {
+	w := new(tabwriter.Writer)
+	// minwidth, tabwidth, padding, padchar, flags
+	w.Init(os.Stdout, 8, 8, 2, ' ', 0)
+	defer w.Flush()
+	count := 0
+	for _, b := range breaches {
+		if b.Count > 0 {
+			count++
+		}
+	}
+	fmt.Fprintf(w, "Found %d of %d passwords in breached passwords dataset\n", count, len(breaches))
+	fmt.Fprintf(w, "\nName\tID\tBreaches\n")
+	for _, b := range breaches {
+		fmt.Fprintf(w, "%s\t%s\t%d\n", b.Name, b.ID, b.Count)
+	}
 }
 
 // helper function to return black message on white bold foreground
@@ -1,7 +1,6 @@
 package vault
 
 import (
-	"bufio"
 	"encoding/csv"
 	"encoding/json"
 	"errors"
@@ -10,9 +9,9 @@ import (
 	"log"
 	"os"
 	"path/filepath"
-	"regexp"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	uuid "github.com/google/uuid"
@@ -33,32 +32,65 @@ func recordAttribute(key string) string {
 }
 
 // OrderedKeys show list of records keys to be display in specific order
-var OrderedKeys = []string{"Name", "Login", "Password", "URL", "Tags", "Note"}
+var OrderedKeys = []string{"Name", "Login", "Password", "TOTP", "URL", "Tags", "Note"}
+
+// TOTPKey defines record key of TOTP seed, either otpauth:// URI or base32 secret
+const TOTPKey = "TOTP"
 
 // Record represent map of key-valut pairs
 type Record map[string]string
 
 // VaultRecord represents full vault record
 type VaultRecord struct {
-	ID               string    // record ID
-	Map              Record    // record map (key-vault pairs)
-	Attachments      []string  // record attachment files
-	ModificationTime time.Time // record modification time
+	ID               string       // record ID
+	Kind             string       // record kind, see Schemas
+	Folder           string       // record folder path, see Folders
+	Map              Record       // record map (key-vault pairs)
+	Attachments      []Attachment // record attachments kept in vault blobs
+	ModificationTime time.Time    // record modification time
+
+	secrets map[string]*crypt.SecureBuffer // values of sensitive fields, see Conceal
 }
 
-// String provides string representation of vault record
+// String provides string representation of vault record, record values are
+// never revealed, therefore the record can be safely logged
 func (r *VaultRecord) String() string {
-	data, err := json.MarshalIndent(r, "", "   ")
+	rmap := make(Record)
+	for k, v := range r.Map {
+		if v != "" || len(r.Secret(k)) > 0 {
+			v = "***"
+		}
+		rmap[k] = v
+	}
+	rec := VaultRecord{ID: r.ID, Kind: r.Kind, Folder: r.Folder, Map: rmap, Attachments: r.Attachments, ModificationTime: r.ModificationTime}
+	data, err := json.MarshalIndent(rec, "", "   ")
 	if err == nil {
 		return string(data)
 	}
 	return ""
 }
 
-// Keys provides vault record keys
+// Wipe zeroes values of sensitive fields of the record and removes other
+// values, the record can not be used afterwards
+func (r *VaultRecord) Wipe() {
+	for _, b := range r.secrets {
+		b.Wipe()
+	}
+	r.secrets = nil
+	for k := range r.Map {
+		delete(r.Map, k)
+	}
+	r.Map = nil
+}
+
+// Keys provides vault record keys, the keys declared by schema of record
+// kind are followed by other record keys
 func (r *VaultRecord) Keys() []string {
 	// predefined keys order
 	keys := []string{"Name", "Login", "Password"}
+	if s, ok := r.Schema(); ok {
+		keys = s.Keys()
+	}
 	// output keys
 	var out []string
 	// map keys
@@ -68,7 +100,7 @@ func (r *VaultRecord) Keys() []string {
 	}
 	sort.Sort(utils.StringList(mapKeys))
 	for _, k := range mapKeys {
-		if k == "Name" || k == "Login" || k == "Password" {
+		if utils.InList(k, keys) {
 			continue
 		}
 		out = append(out, k)
@@ -77,24 +109,19 @@ func (r *VaultRecord) Keys() []string {
 	return keys
 }
 
-// WriteRecord writes single record to the vault area
-func (r *VaultRecord) WriteRecord(vdir, secret, cipher string, verbose int) error {
+// PasswordStrength estimates strength of record password, record name, login
+// and URL are considered as easy to guess words
+func (r *VaultRecord) PasswordStrength() *crypt.Strength {
+	return crypt.EstimateStrength(r.Value("Password"), r.Map["Name"], r.Map["Login"], r.Map["URL"])
+}
+
+// helper function to write record encrypted with given vault data key
+func (r *VaultRecord) writeRecord(vdir string, key []byte, cipher string, verbose int) error {
 	var err error
 	if r.ID == "" {
 		msg := fmt.Sprintf("unable to write record without ID, record %v", r)
 		return errors.New(msg)
 	}
-	// construct new fila name with provided cipher
-	//     fname := fmt.Sprintf("%s.%s", filepath.Join(vdir, r.ID), cipher)
-	fname := fmt.Sprintf("%s", filepath.Join(vdir, r.ID))
-	file, err := os.Create(fname)
-	if err != nil {
-		log.Println("unable to create file name", fname, " error ", err)
-		return err
-	}
-	defer file.Close()
-
-	w := bufio.NewWriter(file)
 	// marshall single record
 	data, err := json.Marshal(r)
 	if err != nil {
@@ -102,62 +129,93 @@ func (r *VaultRecord) WriteRecord(vdir, secret, cipher string, verbose int) erro
 		return err
 	}
 
-	// encrypt our record
-	if verbose > 1 {
-		log.Printf("record '%s' using cipher %s\n", string(data), cipher)
-	} else if verbose > 0 {
+	// encrypt our record, its plain data is wiped once it is encrypted
+	if verbose > 0 {
 		log.Printf("record '%s' using cipher %s\n", r.ID, cipher)
 	}
 	edata := data
 	if cipher != "" {
-		edata, err = crypt.Encrypt(data, secret, cipher)
+		edata, err = crypt.EncryptWithKeyAD(data, key, cipher, recordAD(r.ID))
+		crypt.WipeBytes(data)
 		if err != nil {
 			log.Println("unable to encrypt record, error ", err)
 			return err
 		}
 	}
 	if verbose > 1 {
-		log.Printf("write data record\n%v\nsecret '%v'", edata, secret)
+		log.Printf("write data record %s, %d bytes", r.ID, len(edata))
 	}
-	w.Write(edata)
-	w.Flush()
-	return nil
 
+	// construct new fila name with provided cipher
+	//     fname := fmt.Sprintf("%s.%s", filepath.Join(vdir, r.ID), cipher)
+	fname := fmt.Sprintf("%s", filepath.Join(vdir, r.ID))
+	err = writeFile(fname, edata)
+	if err != nil {
+		log.Println("unable to write file name", fname, " error ", err)
+	}
+	return err
+}
+
+// OTP provides current TOTP code of the record along with its remaining validity
+func (r *VaultRecord) OTP() (string, time.Duration, error) {
+	seed := r.Value(TOTPKey)
+	if seed == "" {
+		msg := fmt.Sprintf("record %s does not have TOTP seed", r.ID)
+		return "", 0, errors.New(msg)
+	}
+	totp, err := crypt.ParseTOTP(seed)
+	if err != nil {
+		return "", 0, err
+	}
+	return totp.Code(time.Now())
 }
 
-// NewVaultRecord creates new VaultRecord
+// NewVaultRecord creates new VaultRecord of given kind with empty fields of
+// its schema, login record is created for unknown kind
 func NewVaultRecord(kind string) *VaultRecord {
 	uid := uuid.NewString()
 	rmap := make(Record)
-	var attributes []string
-	switch kind {
-	case "note":
-		attributes = []string{"Name", "Tags"}
-	case "file":
-		attributes = []string{"Name", "File", "Tags"}
-	default: // default login record
-		attributes = []string{"Name", "Login", "Password", "URL", "Tags"}
-	}
-	for _, attr := range attributes {
+	s, ok := LookupSchema(kind)
+	if !ok {
+		s, _ = LookupSchema("login")
+	}
+	for _, attr := range s.Keys() {
 		rmap[attr] = ""
 	}
-	return &VaultRecord{ID: uid, Map: rmap, ModificationTime: time.Now()}
+	return &VaultRecord{ID: uid, Kind: s.Kind, Map: rmap, ModificationTime: time.Now()}
 }
 
-// Vault represent our vault
+// Vault represent our vault, it is not safe for concurrent use by multiple
+// goroutines which change vault records
 type Vault struct {
-	Directory        string        // vault directory
-	Cipher           string        // vault cipher
-	Secret           string        // vault secret
-	Verbose          int           // verbose mode
-	Records          []VaultRecord // vault records
-	ModificationTime time.Time     // vault last modification time
-	LastBackup       string        // vault last backup
-	Size             int64         // vault size
-	Mode             string        // vault mode
-	Start            time.Time     // vault expire
+	Directory        string          // vault directory
+	Cipher           string          // vault cipher
+	KeyFile          string          // optional key file used along with vault secret
+	KDF              *crypt.KDF      // key derivation function for new vaults
+	Identity         *crypt.Identity // vault recipient identity used instead of secret
+	RecoveryKey      []byte          // vault data key recovered from secret shares
+	Verbose          int             // verbose mode
+	Records          []VaultRecord   // vault records
+	ModificationTime time.Time       // vault last modification time
+	LastBackup       string          // vault last backup
+	Size             int64           // vault size
+	Mode             string          // vault mode
+	Start            time.Time       // vault expire
+	TrashRetention   time.Duration   // how long deleted records are kept in the trash
+	RotationPeriod   time.Duration   // period after which record password should be changed, see Audit
+	Workers          int             // number of workers to read and write records, default is number of CPUs
+	LockTimeout      time.Duration   // how long vault lock is waited for, see DefaultLockTimeout
+
+	secretBuffer *crypt.SecureBuffer // secure buffer of vault secret
+	keys         *crypt.KeyCache     // cache of keys derived from vault secret
+	index        *Index              // vault index
+	readAll      bool                // all vault records are read
+	dirLock      *dirLock            // advisory lock of vault directory
 }
 
+// vaultState guards lazy initialization of internal state of vaults
+var vaultState sync.Mutex
+
 // AddRecord vault record
 func (v *Vault) AddRecord(kind string) (*VaultRecord, error) {
 	rec := NewVaultRecord(kind)
@@ -166,6 +224,17 @@ func (v *Vault) AddRecord(kind string) (*VaultRecord, error) {
 	return rec, err
 }
 
+// Record provides vault record with given ID
+func (v *Vault) Record(rid string) (VaultRecord, error) {
+	for _, rec := range v.Records {
+		if rec.ID == rid {
+			return rec, nil
+		}
+	}
+	msg := fmt.Sprintf("Unable to find vault record '%s'", rid)
+	return VaultRecord{}, errors.New(msg)
+}
+
 // EditRecord edits given vault record
 func (v *Vault) EditRecord(rid string) error {
 	var rec VaultRecord
@@ -197,16 +266,44 @@ func (v *Vault) EditRecord(rid string) error {
 			return err
 		}
 		if strings.ToLower(key) == "save" {
+			// record fields should be valid according to its schema
+			if err := rec.Validate(); err != nil {
+				fmt.Printf("\nWARNING: %v\n", err)
+				continue
+			}
+			// warn about weak password before saving the record
+			if _, ok := rec.Map["Password"]; ok {
+				if strength := rec.PasswordStrength(); strength.Weak() {
+					fmt.Printf("\nWARNING: record password is weak, %s\n", strength.Feedback())
+					ans, err := utils.ReadInput("\nSave record with weak password (y/N): ")
+					if err != nil {
+						return err
+					}
+					if strings.ToLower(ans) != "y" && strings.ToLower(ans) != "yes" {
+						continue
+					}
+				}
+			}
 			break
 		}
-		if val, ok := rec.Map[key]; ok {
-			if strings.ToLower(key) == "password" {
-				fmt.Println("\nRecord password: ")
+		val, ok := rec.Map[key]
+		if !ok {
+			// record may not have all fields of its schema
+			if s, found := rec.Schema(); found {
+				_, ok = s.Field(key)
+			}
+		}
+		if ok {
+			if SensitiveKey(key) {
+				fmt.Printf("\nRecord %s: \n", strings.ToLower(key))
 				val, err = utils.ReadPassword()
 			} else {
 				val, err = utils.ReadInput("\nRecord value   : ")
 			}
-			rec.Map[key] = val
+			rec.SetValue(key, val)
+			if key == "Password" {
+				fmt.Println(rec.PasswordStrength().Feedback())
+			}
 		} else {
 			log.Printf("WARNING: there is no '%s' in record", key)
 		}
@@ -220,17 +317,22 @@ func (v *Vault) EditRecord(rid string) error {
 
 // Delete deletes given vault record file from the vault directory
 func (v *Vault) DeleteRecordFile(rid string) error {
+	unlock, err := v.lockDir(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 	// physically delete vault record file
 	//     fname := fmt.Sprintf("%s.%s", filepath.Join(v.Directory, rid), v.Cipher)
 	fname := fmt.Sprintf("%s", filepath.Join(v.Directory, rid))
-	err := os.Remove(fname)
+	err = os.Remove(fname)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-// DeleteRecord vault record
+// DeleteRecord deletes vault record, the record file is moved to the trash
 func (v *Vault) DeleteRecord(rid string) error {
 	idx := -1
 	for i, rec := range v.Records {
@@ -240,7 +342,12 @@ func (v *Vault) DeleteRecord(rid string) error {
 	}
 	if idx > -1 {
 		v.Records = remove(v.Records, idx)
-	} else {
+	}
+	// move record file to the trash
+	if utils.FileExist(filepath.Join(v.Directory, rid)) {
+		return v.TrashRecord(rid)
+	}
+	if idx == -1 {
 		msg := fmt.Sprintf("no record %s found in a vault", rid)
 		return errors.New(msg)
 	}
@@ -253,31 +360,86 @@ func remove(s []VaultRecord, i int) []VaultRecord {
 	return s[:len(s)-1]
 }
 
-// EncryptFile provides ability to encrypt given file name and place into the vault
+// EncryptFile provides ability to encrypt given file name and place into the vault.
+// The file content is encrypted as a stream and kept in vault blobs area,
+// while vault record keeps file meta-data and refers to the file as its
+// attachment. The content of stdin is encrypted if file name is "-".
 func (v *Vault) EncryptFile(efile string) {
-	data, err := os.ReadFile(efile)
+	unlock, err := v.lockDir(true)
 	if err != nil {
-		log.Printf("unable to read file %s, error %v", efile, err)
+		log.Printf("unable to lock vault %s, error %v", v.Directory, err)
 		return
 	}
-	uid := uuid.NewString()
-	attachments := []string{efile}
+	defer unlock()
+	file, name := os.Stdin, "stdin"
+	if efile != "-" {
+		file, err = os.Open(efile)
+		if err != nil {
+			log.Printf("unable to read file %s, error %v", efile, err)
+			return
+		}
+		defer file.Close()
+		name = filepath.Base(efile)
+	}
+	hash, size, err := v.EncryptStream(file)
+	if err != nil {
+		log.Printf("unable to encrypt file %s, error %v", efile, err)
+		return
+	}
+	attachments := []Attachment{{Name: name, Hash: hash, Size: size}}
 	rmap := make(Record)
-	rmap["Data"] = string(data)
-	rmap["Name"] = filepath.Base(efile)
-	rmap["Tags"] = "file"
-	rec := VaultRecord{ID: uid, Map: rmap, Attachments: attachments}
-	rec.WriteRecord(v.Directory, v.Secret, v.Cipher, v.Verbose)
+	rmap["Name"] = name
+	rmap["Size"] = fmt.Sprintf("%d", size)
+	rec := VaultRecord{ID: uuid.NewString(), Kind: "file", Map: rmap, Attachments: attachments, ModificationTime: time.Now()}
+	err = v.writeRecord(rec)
+	if err != nil {
+		log.Printf("unable to write vault record %s, error %v", rec.ID, err)
+		return
+	}
 	log.Printf("created new vault record %s", rec.ID)
 }
 
+// helper function to encrypt data of given reader and write it to given
+// file via temporary file
+func writeStream(fname string, r io.Reader, key []byte, cipher string) error {
+	err := os.MkdirAll(filepath.Dir(fname), 0755)
+	if err != nil {
+		return err
+	}
+	file, err := createTemp(fname)
+	if err != nil {
+		return err
+	}
+	tmp := file.Name()
+	defer os.Remove(tmp)
+	writer, err := crypt.NewWriter(file, key, cipher)
+	if err == nil {
+		_, err = io.Copy(writer, r)
+	}
+	if err == nil {
+		err = writer.Close()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return renameFile(tmp, fname)
+}
+
 // Update vault records
 func (v *Vault) Update(rec VaultRecord) error {
+	// sensitive values of in-memory records are kept in secure buffers
+	rec.Conceal()
 	updated := false
 	for i, r := range v.Records {
 		if r.ID == rec.ID {
 			if v.Verbose > 0 {
-				log.Printf("update record %+v", rec)
+				log.Printf("update record %s", rec.ID)
 			}
 			rec.ModificationTime = time.Now()
 			v.Records[i] = rec
@@ -339,9 +501,339 @@ func (v *Vault) Create(vname string) error {
 			return err
 		}
 	}
+	// create vault meta-data with key derivation parameters, concurrent
+	// processes which create the same vault are serialized by vault lock
+	unlock, err := v.lockDir(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	_, err = initMeta(vaultDir, v.KDF)
+	return err
+}
+
+// helper function to check if given vault file name is a record file
+func recordFile(name string) bool {
+	return name != "backups" && name != MetaFile && name != IndexFile && name != FoldersFile && name != LockFile && !strings.HasSuffix(name, ".tmp")
+}
+
+// Meta returns vault meta-data, vaults without meta-data are considered
+// as legacy ones
+func (v *Vault) Meta() (*Meta, error) {
+	meta, err := ReadMeta(v.Directory)
+	if errors.Is(err, os.ErrNotExist) {
+		return LegacyMeta(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	meta.keys = v.keyCache()
+	return meta, nil
+}
+
+// helper function to get cache of keys derived from vault secret
+func (v *Vault) keyCache() *crypt.KeyCache {
+	vaultState.Lock()
+	defer vaultState.Unlock()
+	if v.keys == nil {
+		v.keys = &crypt.KeyCache{}
+	}
+	return v.keys
+}
+
+// WipeKeyCache wipes keys derived from vault secret, they are derived again
+// when vault secret is used next time
+func (v *Vault) WipeKeyCache() {
+	v.keyCache().Wipe()
+}
+
+// DataKey returns vault data key either recovered from secret shares,
+// unwrapped by vault identity, if it is set, or by vault secret. The
+// ErrNoDataKey is returned if vault does not have data key yet, the data
+// key is created when vault data is written for the first time
+func (v *Vault) DataKey() ([]byte, error) {
+	if v.RecoveryKey != nil {
+		return v.RecoveryKey, nil
+	}
+	if v.Identity != nil {
+		meta, err := ReadMeta(v.Directory)
+		if err != nil {
+			return nil, err
+		}
+		return meta.IdentityKey(v.Identity)
+	}
+	secret, err := v.secret()
+	if err != nil {
+		return nil, err
+	}
+	defer crypt.WipeBytes(secret)
+	meta, err := ReadMeta(v.Directory)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNoDataKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	meta.keys = v.keyCache()
+	return meta.DataKey(secret)
+}
+
+// helper function to get vault data key used to write vault data. The new
+// data key is created and wrapped with vault secret if vault does not have
+// it yet, but only if vault secret decrypts existing vault records
+func (v *Vault) writeKey() ([]byte, error) {
+	key, err := v.DataKey()
+	if err != ErrNoDataKey {
+		return key, err
+	}
+	// vault data key is created under exclusive lock such that concurrent
+	// writers do not create different keys
+	unlock, err := v.lockDir(true)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	key, err = v.DataKey()
+	if err != ErrNoDataKey {
+		return key, err
+	}
+	secret, err := v.secret()
+	if err != nil {
+		return nil, err
+	}
+	defer crypt.WipeBytes(secret)
+	if err := v.checkSecret(secret); err != nil {
+		return nil, err
+	}
+	meta, err := initMeta(v.Directory, v.KDF)
+	if err != nil {
+		return nil, err
+	}
+	meta.keys = v.keyCache()
+	newKey, err := crypt.GenerateKey("")
+	if err != nil {
+		return nil, err
+	}
+	err = meta.WrapKey(newKey[:], secret, v.Cipher)
+	if err != nil {
+		return nil, err
+	}
+	err = meta.Write(v.Directory)
+	if err != nil {
+		return nil, err
+	}
+	return newKey[:], nil
+}
+
+// helper function to check that given secret decrypts vault records which
+// are not encrypted with vault data key, i.e. records written by old vaults.
+// Vaults without such records accept any secret
+func (v *Vault) checkSecret(secret []byte) error {
+	meta, err := v.Meta()
+	if err != nil {
+		return err
+	}
+	files, err := v.Files()
+	if err != nil {
+		return err
+	}
+	var lastErr error
+	for _, rid := range files {
+		data, err := os.ReadFile(filepath.Join(v.Directory, rid))
+		if err != nil {
+			return err
+		}
+		if len(data) == 0 {
+			continue
+		}
+		if header, _, err := crypt.ParseHeader(data); err == nil && header.KDF == nil {
+			return fmt.Errorf("vault record %s is encrypted with data key which vault does not have", rid)
+		}
+		data, err = meta.DecryptRecord(rid, data, secret, v.Cipher)
+		if err == nil {
+			crypt.WipeBytes(data)
+			return nil
+		}
+		lastErr = err
+	}
+	if lastErr != nil {
+		return fmt.Errorf("vault secret does not decrypt vault records, error %v", lastErr)
+	}
 	return nil
 }
 
+// Unlock sets vault secret and checks it, the secret should either unwrap
+// vault data key or decrypt records of vaults which do not have data key
+// yet. The vault is locked again if the secret is wrong
+func (v *Vault) Unlock(secret []byte) error {
+	v.SetSecret(secret)
+	_, err := v.DataKey()
+	if err == ErrNoDataKey {
+		var s []byte
+		s, err = v.secret()
+		if err == nil {
+			err = v.checkSecret(s)
+			crypt.WipeBytes(s)
+		}
+	}
+	if err != nil {
+		v.Lock()
+	}
+	return err
+}
+
+// helper function to get secret used for vault key derivation, i.e. vault
+// secret combined with vault key file if it is provided. The secret is a
+// copy of vault secret which caller should wipe once it is used
+func (v *Vault) secret() ([]byte, error) {
+	var secret []byte
+	if v.secretBuffer != nil {
+		secret = v.secretBuffer.Bytes()
+	}
+	return crypt.CompositeSecret(secret, v.KeyFile)
+}
+
+// SetSecret sets vault secret, the secret is kept in secure buffer and
+// given data is wiped
+func (v *Vault) SetSecret(secret []byte) {
+	if v.secretBuffer != nil {
+		v.secretBuffer.Wipe()
+	}
+	v.secretBuffer = crypt.SecureBytes(secret)
+	// keys derived from previous secret are not valid anymore
+	v.WipeKeyCache()
+}
+
+// HasSecret checks if vault secret is set
+func (v *Vault) HasSecret() bool {
+	return v.secretBuffer != nil && v.secretBuffer.Len() > 0
+}
+
+// CheckSecret checks if given secret matches vault secret
+func (v *Vault) CheckSecret(secret []byte) bool {
+	return v.secretBuffer != nil && v.secretBuffer.Equal(secret)
+}
+
+// Wipe zeroes decrypted vault records and removes them from the vault, the
+// vault records should be read again to use them
+func (v *Vault) Wipe() {
+	for i := range v.Records {
+		v.Records[i].Wipe()
+	}
+	v.Records = nil
+	v.index = nil
+	v.readAll = false
+}
+
+// Lock wipes decrypted vault records along with vault secret, keys derived
+// from it and recovered data key, the vault secret should be set again to
+// unlock the vault
+func (v *Vault) Lock() {
+	v.Wipe()
+	if v.secretBuffer != nil {
+		v.secretBuffer.Wipe()
+		v.secretBuffer = nil
+	}
+	v.WipeKeyCache()
+	crypt.WipeBytes(v.RecoveryKey)
+	v.RecoveryKey = nil
+}
+
+// Encrypt encrypts given data using vault key and cipher
+func (v *Vault) Encrypt(data []byte) ([]byte, error) {
+	key, err := v.writeKey()
+	if err != nil {
+		return nil, err
+	}
+	return crypt.EncryptWithKey(data, key, v.Cipher)
+}
+
+// Decrypt decrypts given data using vault key
+func (v *Vault) Decrypt(data []byte) ([]byte, error) {
+	return v.DecryptRecord("", data)
+}
+
+// EncryptRecord encrypts data of given vault record, the record ID is
+// authenticated along with the data
+func (v *Vault) EncryptRecord(rid string, data []byte) ([]byte, error) {
+	key, err := v.writeKey()
+	if err != nil {
+		return nil, err
+	}
+	return crypt.EncryptWithKeyAD(data, key, v.Cipher, recordAD(rid))
+}
+
+// DecryptRecord decrypts data of given vault record, the data is only
+// decrypted if it was encrypted for the record with given ID
+func (v *Vault) DecryptRecord(rid string, data []byte) ([]byte, error) {
+	if v.Identity != nil || v.RecoveryKey != nil {
+		key, err := v.DataKey()
+		if err != nil {
+			return nil, err
+		}
+		return crypt.DecryptWithKeyAD(data, key, v.Cipher, recordAD(rid))
+	}
+	meta, err := v.Meta()
+	if err != nil {
+		return nil, err
+	}
+	secret, err := v.secret()
+	if err != nil {
+		return nil, err
+	}
+	defer crypt.WipeBytes(secret)
+	return meta.DecryptRecord(rid, data, secret, v.Cipher)
+}
+
+// helper function to provide function which decrypts vault records with
+// vault meta-data, secret and data key obtained once, such that records read
+// by pool of workers are decrypted without reading vault meta-data and
+// unwrapping vault data key for every record. The release function wipes
+// the secret and data key once records are decrypted
+func (v *Vault) recordDecrypter() (decryptFunc, func(), error) {
+	if v.Identity != nil || v.RecoveryKey != nil {
+		key, err := v.DataKey()
+		if err != nil {
+			return nil, nil, err
+		}
+		decrypt := func(rid string, data []byte) ([]byte, error) {
+			return crypt.DecryptWithKeyAD(data, key, v.Cipher, recordAD(rid))
+		}
+		return decrypt, func() {}, nil
+	}
+	meta, err := v.Meta()
+	if err != nil {
+		return nil, nil, err
+	}
+	secret, err := v.secret()
+	if err != nil {
+		return nil, nil, err
+	}
+	// wrong secret or missing data key are reported for every record
+	// by vault meta-data, see Meta.DecryptRecord
+	key, _ := meta.DataKey(secret)
+	decrypt := func(rid string, data []byte) ([]byte, error) {
+		if header, _, err := crypt.ParseHeader(data); err == nil && header.KDF == nil && key != nil {
+			return crypt.DecryptWithKeyAD(data, key, v.Cipher, recordAD(rid))
+		}
+		return meta.DecryptRecord(rid, data, secret, v.Cipher)
+	}
+	release := func() {
+		crypt.WipeBytes(secret)
+		crypt.WipeBytes(key)
+	}
+	return decrypt, release, nil
+}
+
+// helper function to write given record encrypted with vault data key
+func (v *Vault) writeRecord(rec VaultRecord) error {
+	key, err := v.writeKey()
+	if err != nil {
+		return err
+	}
+	return rec.writeRecord(v.Directory, key, v.Cipher, v.Verbose)
+}
+
 // Files returns list of vault files
 func (v *Vault) Files() ([]string, error) {
 	files, err := os.ReadDir(v.Directory)
@@ -350,7 +842,7 @@ func (v *Vault) Files() ([]string, error) {
 	}
 	var out []string
 	for _, f := range files {
-		if f.Name() != "backups" {
+		if !f.IsDir() && recordFile(f.Name()) {
 			out = append(out, f.Name())
 		}
 	}
@@ -359,20 +851,57 @@ func (v *Vault) Files() ([]string, error) {
 
 // Read reads vault records
 func (v *Vault) Read() error {
-	files, err := os.ReadDir(v.Directory)
+	unlock, err := v.lockDir(false)
 	if err != nil {
 		return err
 	}
-	// TODO: we can parallelize the read from vault area via goroutine pool
-	for _, file := range files {
-		fname := filepath.Join(v.Directory, file.Name())
-		rec, err := v.ReadRecord(fname)
-		if err != nil {
+	defer unlock()
+	entries, err := os.ReadDir(v.Directory)
+	if err != nil {
+		return err
+	}
+	var files []string
+	for _, file := range entries {
+		if file.IsDir() || !recordFile(file.Name()) {
+			continue
+		}
+		files = append(files, filepath.Join(v.Directory, file.Name()))
+	}
+
+	// records are decrypted by pool of workers, their order follows order
+	// of vault files, vault data key is obtained once and shared by workers
+	decrypt, release, err := v.recordDecrypter()
+	if err != nil {
+		return err
+	}
+	defer release()
+	records := make([]VaultRecord, len(files))
+	hashes := make([]string, len(files))
+	errs := make([]error, len(files))
+	runPool(len(files), v.workers(), func(idx int) {
+		records[idx], hashes[idx], errs[idx] = v.decodeRecord(files[idx], filepath.Base(files[idx]), decrypt)
+	})
+	var recErrors RecordErrors
+	// records are indexed with hashes of data they are read from, since
+	// vault may be changed while shared lock is upgraded to write the index
+	readHashes := make(map[string]string)
+	for idx, rec := range records {
+		if errs[idx] != nil {
 			if v.Verbose > 1 {
-				log.Println("unable to read ", fname, " error ", err)
+				log.Println("unable to read ", files[idx], " error ", errs[idx])
 			}
-		} else {
-			v.Records = append(v.Records, rec)
+			recErrors = append(recErrors, RecordError{File: files[idx], Err: errs[idx]})
+			continue
+		}
+		v.Records = append(v.Records, rec)
+		readHashes[rec.ID] = hashes[idx]
+	}
+
+	v.readAll = true
+	if v.hasKey() {
+		err = v.indexRecords(v.Records, readHashes, true)
+		if err != nil && v.Verbose > 0 {
+			log.Printf("unable to update vault index, error %v", err)
 		}
 	}
 
@@ -385,47 +914,80 @@ func (v *Vault) Read() error {
 	} else {
 		log.Printf("unable to get stat for %s, error %v", v.Directory, err)
 	}
+	if len(recErrors) > 0 {
+		return recErrors
+	}
 	return nil
 }
 
-// helper function to read vault and return list of records
+// Write writes all vault records, the records are encrypted by pool of
+// workers and errors of records which are not written are returned
 func (v *Vault) Write() error {
-	// TODO: we can parallelize the read from vault area via goroutine pool
-	for _, rec := range v.Records {
-		err := rec.WriteRecord(v.Directory, v.Secret, v.Cipher, v.Verbose)
+	unlock, err := v.lockDir(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if len(v.Records) == 0 {
+		return nil
+	}
+	// vault data key is derived once and shared by all workers
+	key, err := v.writeKey()
+	if err != nil {
+		return err
+	}
+	errs := make([]error, len(v.Records))
+	runPool(len(v.Records), v.workers(), func(idx int) {
+		normalizeTags(&v.Records[idx])
+		errs[idx] = v.Records[idx].writeRecord(v.Directory, key, v.Cipher, v.Verbose)
+	})
+	var recErrors RecordErrors
+	for idx, err := range errs {
 		if err != nil {
-			log.Printf("unable to write vault record %s, error %v", rec.ID, err)
-			return err
+			rid := v.Records[idx].ID
+			log.Printf("unable to write vault record %s, error %v", rid, err)
+			recErrors = append(recErrors, RecordError{File: filepath.Join(v.Directory, rid), Err: err})
 		}
 	}
+	err = v.indexRecords(v.Records, nil, false)
+	if err != nil {
+		log.Printf("unable to update vault index, error %v", err)
+	}
+	if len(recErrors) > 0 {
+		return recErrors
+	}
 	return nil
 }
 
 // WriteRecord provides write record functionality of vault
 func (v *Vault) WriteRecord(rec VaultRecord) error {
-
-	// create backups vault area
-	bdir := filepath.Join(v.Directory, "backups")
-	err := os.MkdirAll(bdir, 0755)
+	unlock, err := v.lockDir(true)
 	if err != nil {
-		log.Printf("unable to create %s, error %v", bdir, err)
 		return err
 	}
+	defer unlock()
+	// record tags are kept as normalized set
+	normalizeTags(&rec)
 
-	// backup existing record if it exists
-	err = utils.BackupFile(v.Directory, rec.ID, bdir)
+	// keep existing record in record history
+	err = v.archiveRecord(rec.ID)
 	if err != nil {
-		if v.Verbose > 0 {
-			log.Println("unable to make backup for record", rec.ID, " error ", err)
-		}
+		log.Printf("unable to archive vault record %s, error %v", rec.ID, err)
+		return err
 	}
 
 	// write record to the vault area
-	err = rec.WriteRecord(v.Directory, v.Secret, v.Cipher, v.Verbose)
+	err = v.writeRecord(rec)
 	if err != nil {
 		log.Printf("unable to write vault record %s, error %v", rec.ID, err)
 		return err
 	}
+
+	// update vault index, it is re-built when it is read if update fails
+	err = v.indexRecords([]VaultRecord{rec}, nil, false)
+	if err != nil {
+		log.Printf("unable to update vault index, error %v", err)
+	}
 	return nil
 }
 
@@ -448,69 +1010,61 @@ func (v *Vault) ReadRecord(fname string) (VaultRecord, error) {
 		log.Println("unable to change file permission of", fname)
 	}
 
-	// open file
-	file, err := os.Open(fname)
-	if err != nil {
-		log.Println("unable to open a vault", err)
-		return rec, err
-	}
-	// remember to close the file at the end of the program
-	defer file.Close()
+	return v.readRecord(fname, filepath.Base(fname))
+}
 
-	// read data from the record file
-	data, err := os.ReadFile(fname)
+// helper function to read record with given ID from given file, e.g.
+// current record file or one of its versions
+func (v *Vault) readRecord(fname, rid string) (VaultRecord, error) {
+	rec, _, err := v.decodeRecord(fname, rid, v.DecryptRecord)
+	return rec, err
+}
+
+// helper type of function which decrypts data of vault record with given ID
+type decryptFunc func(rid string, data []byte) ([]byte, error)
+
+// helper function to read record with given ID from given file and decrypt
+// it with given function, it also provides hash of read file content
+func (v *Vault) decodeRecord(fname, rid string, decrypt decryptFunc) (VaultRecord, string, error) {
+	var rec VaultRecord
+	// read data from the record file
+	data, err := os.ReadFile(fname)
 	if err != nil {
-		return rec, err
-	}
-	var decryptedErrors []string
-	for _, cipher := range crypt.SupportedCiphers {
-		data, err = crypt.Decrypt(data, v.Secret, cipher)
-		if err != nil {
-			msg := fmt.Sprintf("cipher:%s, error:%s", cipher, err)
-			decryptedErrors = append(decryptedErrors, msg)
-		} else {
-			break // we successfully decrypted the record
-		}
+		return rec, "", err
 	}
-	if len(decryptedErrors) == len(crypt.SupportedCiphers) {
-		err := errors.New(strings.Join(decryptedErrors, " "))
-		return rec, err
+	hash := dataHash(data)
+	data, err = decrypt(rid, data)
+	if err != nil {
+		return rec, hash, err
 	}
 
 	err = json.Unmarshal(data, &rec)
+	crypt.WipeBytes(data)
 	if err != nil {
 		log.Println("ERROR: unable to unmarshal the data", err)
-		return rec, err
+		return rec, hash, err
+	}
+	// records written without kind remember their inferred kind
+	if rec.Kind == "" {
+		rec.Kind = inferKind(&rec)
+	}
+	// records written by old vaults are not bound to their file name,
+	// therefore we check that record content matches its file
+	if rec.ID != rid {
+		msg := fmt.Sprintf("vault record %s does not match its file %s", rec.ID, fname)
+		return rec, hash, errors.New(msg)
 	}
-	return rec, nil
+	return rec, hash, nil
 }
 
-// Find method finds given pattern in our vault and return its index
+// Find method finds vault records which match given query, see ParseQuery
+// for query syntax
 func (v *Vault) Find(pat string) []VaultRecord {
-	var ids []string
-	var out []VaultRecord
-	for _, rec := range v.Records {
-		for key, val := range rec.Map {
-			if strings.Contains(key, pat) {
-				if !utils.InList(rec.ID, ids) {
-					ids = append(ids, rec.ID)
-					out = append(out, rec)
-				}
-				if v.Verbose > 0 {
-					log.Println("match record key")
-				}
-			} else if matched, err := regexp.MatchString(pat, val); err == nil && matched {
-				if v.Verbose > 0 {
-					log.Println("matched record value")
-				}
-				if !utils.InList(rec.ID, ids) {
-					ids = append(ids, rec.ID)
-					out = append(out, rec)
-				}
-			}
-		}
+	records, err := v.Query(pat)
+	if err != nil {
+		log.Printf("unable to parse query '%s', error %v", pat, err)
 	}
-	return out
+	return records
 }
 
 // Info provides information about the vault
@@ -520,50 +1074,501 @@ func (v *Vault) Info() string {
 	mode := v.Mode
 	cipher := v.Cipher
 	nrec := len(v.Records)
-	info := fmt.Sprintf("vault %s\nLast modified: %s\nSize %s, mode %s\n%d records, encrypted with %s cipher", v.Directory, tstamp, size, mode, nrec, cipher)
+	kdf := crypt.KDFLegacy
+	if meta, err := v.Meta(); err == nil {
+		kdf = meta.KDF.String()
+	}
+	info := fmt.Sprintf("vault %s\nLast modified: %s\nSize %s, mode %s\n%d records, encrypted with %s cipher\nKey derivation %s", v.Directory, tstamp, size, mode, nrec, cipher, kdf)
 	if v.Verbose > 0 {
 		log.Println(info)
 	}
 	return info
 }
 
-// Recreate re-creates vault records with new password and cipher
-func (v *Vault) Recreate(secret, cipher string) error {
+// Recreate changes vault secret, key file and cipher. The vault data key is
+// wrapped with the new credentials, while records are re-encrypted only if
+// cipher is changed, see RotateKey for force flag
+func (v *Vault) Recreate(secret, keyFile, cipher string, force bool) error {
+	if cipher != "" && cipher != v.Cipher {
+		oldCipher := v.Cipher
+		v.Cipher = cipher
+		err := v.RotateKey(force)
+		if err != nil {
+			v.Cipher = oldCipher
+			return err
+		}
+	}
+	err := v.ChangeCredentials(secret, keyFile)
+	if err != nil {
+		return err
+	}
+	log.Printf("Vault %s changed its secret, records are encrypted using cipher %s", v.Directory, v.Cipher)
+	return nil
+}
+
+// ChangeSecret changes vault secret and key derivation parameters. Only the
+// vault data key is wrapped again, records written by old vaults which are
+// not encrypted with the data key are first re-encrypted with it
+func (v *Vault) ChangeSecret(secret string) error {
+	return v.ChangeCredentials(secret, v.KeyFile)
+}
+
+// ChangeCredentials changes vault secret and key file, the empty key file
+// means that vault is unlocked by its secret only
+func (v *Vault) ChangeCredentials(secret, keyFile string) error {
+	unlock, err := v.lockDir(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	newSecret, err := crypt.CompositeSecret([]byte(secret), keyFile)
+	if err != nil {
+		return err
+	}
+	defer crypt.WipeBytes(newSecret)
+	key, err := v.writeKey()
+	if err != nil {
+		return err
+	}
+	meta, err := ReadMeta(v.Directory)
+	if err != nil {
+		return err
+	}
+	// records which are not encrypted with data key can not be read
+	// with new secret, therefore we re-encrypt them
+	_, err = v.Migrate()
+	if err != nil {
+		return err
+	}
+	// wrap data key with new secret using fresh salt
+	kdf := meta.KDF
+	if v.KDF != nil {
+		kdf = v.KDF
+	}
+	kdf, err = renewKDF(kdf)
+	if err != nil {
+		return err
+	}
+	newMeta, err := NewMeta(kdf)
+	if err != nil {
+		return err
+	}
+	err = newMeta.WrapKey(key, newSecret, v.Cipher)
+	if err != nil {
+		return err
+	}
+	newMeta.Recipients = meta.Recipients
+	newMeta.Shares, newMeta.Threshold = meta.Shares, meta.Threshold
+	bdir := filepath.Join(v.Directory, "backups")
+	if err := os.MkdirAll(bdir, 0755); err == nil {
+		err = utils.BackupFile(v.Directory, MetaFile, bdir)
+		if err != nil && v.Verbose > 0 {
+			log.Println("unable to make backup of vault key file, error ", err)
+		}
+	}
+	err = newMeta.Write(v.Directory)
+	if err != nil {
+		return err
+	}
+	v.SetSecret([]byte(secret))
+	v.KeyFile = keyFile
+	crypt.WipeBytes(v.RecoveryKey)
+	v.RecoveryKey = nil
+	return nil
+}
+
+// SplitKey splits vault data key into n secret shares with threshold k,
+// any k shares can recover the vault, see RecoverKey. The data key of new
+// vault is created such that recovery kit can be made before vault records.
+// Records written by old vaults are migrated first, since they are not
+// encrypted with data key and can not be recovered from the shares
+func (v *Vault) SplitKey(n, k int) ([]*crypt.Share, error) {
+	unlock, err := v.lockDir(true)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	key, err := v.writeKey()
+	if err != nil {
+		return nil, err
+	}
+	if v.HasSecret() {
+		if _, err := v.Migrate(); err != nil {
+			return nil, err
+		}
+	}
+	shares, err := crypt.SplitSecret(key, n, k)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := ReadMeta(v.Directory)
+	if err != nil {
+		return nil, err
+	}
+	meta.Shares, meta.Threshold = n, k
+	err = meta.Write(v.Directory)
+	if err != nil {
+		return nil, err
+	}
+	return shares, nil
+}
+
+// RecoveryShares provides number of recovery shares of vault data key and
+// their threshold, zeros mean that the key was not split into shares
+func (v *Vault) RecoveryShares() (int, int) {
+	meta, err := ReadMeta(v.Directory)
+	if err != nil {
+		return 0, 0
+	}
+	return meta.Shares, meta.Threshold
+}
+
+// RecoverKey recovers vault data key from given secret shares and unlocks
+// the vault with it. The vault can be re-keyed afterwards with new secret
+// via ChangeCredentials
+func (v *Vault) RecoverKey(shares []*crypt.Share) error {
+	key, err := crypt.CombineShares(shares)
+	if err != nil {
+		return err
+	}
+	// make sure that recovered key belongs to this vault
+	files, err := v.Files()
+	if err != nil {
+		return err
+	}
+	for _, fname := range files {
+		data, err := os.ReadFile(filepath.Join(v.Directory, fname))
+		if err != nil {
+			return err
+		}
+		// records of old vaults are not encrypted with data key
+		if header, _, err := crypt.ParseHeader(data); err != nil || header.KDF != nil {
+			continue
+		}
+		if _, err := crypt.DecryptWithKeyAD(data, key, v.Cipher, recordAD(fname)); err != nil {
+			return fmt.Errorf("recovered key is not a data key of vault %s, error %v", v.Directory, err)
+		}
+		break
+	}
+	v.RecoveryKey = key
+	v.Identity = nil
+	return nil
+}
+
+// RotateKey creates new vault data key and re-encrypts all vault records
+// with it. The new key is wrapped with vault secret and for the current list
+// of vault recipients, i.e. removed recipients lose access to the vault.
+// The copy of the original vault directory is kept aside. Recovery shares of
+// the old key can not recover the vault afterwards, therefore the vault
+// with recovery shares is rotated only with force flag and its shares
+// should be issued again, see SplitKey
+func (v *Vault) RotateKey(force bool) error {
+	unlock, err := v.lockDir(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if !v.HasSecret() {
+		return errors.New("vault secret is required to rotate vault data key")
+	}
+	secret, err := v.secret()
+	if err != nil {
+		return err
+	}
+	defer crypt.WipeBytes(secret)
+	// make sure that vault has data key and our secret unwraps it
+	oldKey, err := v.writeKey()
+	if err != nil {
+		return err
+	}
+	meta, err := ReadMeta(v.Directory)
+	if err != nil {
+		return err
+	}
+	if meta.Shares > 0 && !force {
+		return fmt.Errorf("%w, rotation invalidates %d issued shares", ErrRecoveryShares, meta.Shares)
+	}
+	// make sure that records match their files before they are bound
+	// to their names with new key
+	_, err = v.Migrate()
+	if err != nil {
+		return err
+	}
+	meta, err = ReadMeta(v.Directory)
+	if err != nil {
+		return err
+	}
+	meta.Shares, meta.Threshold = 0, 0
+	newKey, err := crypt.GenerateKey("")
+	if err != nil {
+		return err
+	}
+	// re-encrypt all records in memory first to avoid partial rotation
+	// if some record can not be decrypted
+	files, err := v.Files()
+	if err != nil {
+		return err
+	}
+	// record files along with their versions and deleted records are
+	// bound to their record IDs
+	rids := make(map[string]string)
+	for _, name := range files {
+		rids[filepath.Join(v.Directory, name)] = name
+	}
+	trash, err := v.Trash()
+	if err != nil {
+		return err
+	}
+	for _, rec := range trash {
+		rids[filepath.Join(v.Directory, TrashDir, rec.ID, rec.ID)] = rec.ID
+	}
+	if iname := filepath.Join(v.Directory, IndexFile); utils.FileExist(iname) {
+		rids[iname] = IndexFile
+	}
+	if fname := filepath.Join(v.Directory, FoldersFile); utils.FileExist(fname) {
+		rids[fname] = FoldersFile
+	}
+	for fname, rid := range rids {
+		versions, err := filepath.Glob(filepath.Join(filepath.Dir(fname), HistoryDir, rid, "*"))
+		if err != nil {
+			return err
+		}
+		for _, hname := range versions {
+			rids[hname] = rid
+		}
+	}
+	records := make(map[string][]byte)
+	for fname, rid := range rids {
+		data, err := os.ReadFile(fname)
+		if err != nil {
+			return err
+		}
+		data, err = v.DecryptRecord(rid, data)
+		if err != nil {
+			return fmt.Errorf("unable to decrypt %s, error %v", fname, err)
+		}
+		data, err = crypt.EncryptWithKeyAD(data, newKey[:], v.Cipher, recordAD(rid))
+		if err != nil {
+			return err
+		}
+		records[fname] = data
+	}
+	err = meta.WrapKey(newKey[:], secret, v.Cipher)
+	if err != nil {
+		return err
+	}
+	err = meta.RewrapRecipients(newKey[:])
+	if err != nil {
+		return err
+	}
+
 	// make copy of existing vault directory
 	tstamp := time.Now().Format(time.RFC3339)
 	dstDir := fmt.Sprintf("%s.%s", v.Directory, tstamp)
-	err := CopyDir(v.Directory, dstDir)
+	err = CopyDir(v.Directory, dstDir)
 	if err != nil {
 		return err
 	}
 	log.Printf("Original vault records are saved in %s", dstDir)
-	// get all existing records
-	for _, rec := range v.Records {
-		err := rec.WriteRecord(v.Directory, secret, cipher, v.Verbose)
+	// re-encrypt vault blobs, the original blobs are kept in vault copy if
+	// rotation will be interrupted, and blob names are keyed by vault data
+	// key, therefore blobs are renamed
+	blobs, err := v.Blobs()
+	if err != nil {
+		return err
+	}
+	for _, name := range blobs {
+		fname := filepath.Join(v.Directory, BlobsDir, name)
+		err = rotateBlob(fname, oldKey, newKey[:], v.Cipher)
 		if err != nil {
-			return err
+			return fmt.Errorf("unable to re-encrypt %s, error %v", fname, err)
 		}
-		// delete record from the vault
-		err = v.DeleteRecord(rec.ID)
+	}
+	for fname, data := range records {
+		err = writeFile(fname, data)
 		if err != nil {
 			return err
 		}
-		// delete existing record file from vault directory
-		err = v.DeleteRecordFile(rec.ID)
+	}
+	err = meta.Write(v.Directory)
+	if err != nil {
+		return err
+	}
+	log.Printf("Vault %s rotated its data key and re-encrypted %d records using cipher %s", v.Directory, len(files), v.Cipher)
+	return nil
+}
+
+// Migrate re-encrypts vault records written by old vaults with vault data
+// key, such that every record is bound to its file name. Versions of the
+// records and deleted records are re-encrypted as well, therefore they can
+// be read once vault secret is changed. Records which do not match their
+// files are rejected. Records encrypted with vault secret are skipped if
+// vault is unlocked without it, e.g. by recovery shares, since they can not
+// be decrypted. It returns number of migrated records
+func (v *Vault) Migrate() (int, error) {
+	unlock, err := v.lockDir(true)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+	files, err := v.Files()
+	if err != nil {
+		return 0, err
+	}
+	var count int
+	for _, name := range files {
+		fname := filepath.Join(v.Directory, name)
+		data, err := os.ReadFile(fname)
+		if err != nil {
+			return count, err
+		}
+		header, _, err := crypt.ParseHeader(data)
+		if err == nil && header.KDF == nil && header.Version >= crypt.HeaderVersionAD {
+			continue
+		}
+		if (err != nil || header.KDF != nil) && !v.HasSecret() {
+			log.Printf("record %s is encrypted with vault secret and can not be migrated without it", fname)
+			continue
+		}
+		rec, err := v.ReadRecord(fname)
 		if err != nil {
-			return err
+			return count, fmt.Errorf("unable to read %s, error %v", fname, err)
+		}
+		err = v.WriteRecord(rec)
+		if err != nil {
+			return count, err
 		}
+		count++
 	}
-	// change vault secret and cipher
-	v.Secret = secret
-	v.Cipher = cipher
-	log.Printf("Vault changed and re-encrypted all records in %s using cipher %s", v.Directory, v.Cipher)
-	return nil
+	// record versions are migrated after records since migrated records
+	// archive their old files
+	var versions int
+	for _, name := range files {
+		n, err := v.migrateVersions(v.Directory, name)
+		versions += n
+		if err != nil {
+			return count, err
+		}
+	}
+	trash, err := v.Trash()
+	if err != nil {
+		return count, err
+	}
+	for _, entry := range trash {
+		tdir := filepath.Join(v.Directory, TrashDir, entry.ID)
+		ok, err := v.migrateFile(filepath.Join(tdir, entry.ID), entry.ID)
+		if err != nil {
+			return count, err
+		}
+		if ok {
+			count++
+		}
+		n, err := v.migrateVersions(tdir, entry.ID)
+		versions += n
+		if err != nil {
+			return count, err
+		}
+	}
+	if versions > 0 && v.Verbose > 0 {
+		log.Printf("Vault %s migrated %d record versions", v.Directory, versions)
+	}
+	// encrypted files of old vaults become attachments of their records
+	streams, err := v.migrateStreams()
+	if err != nil {
+		return count, err
+	}
+	if streams > 0 && v.Verbose > 0 {
+		log.Printf("Vault %s moved %d encrypted files to vault blobs", v.Directory, streams)
+	}
+	return count, nil
+}
+
+// AddRecipient wraps vault data key for given recipient, such that the
+// vault can be opened with recipient identity
+func (v *Vault) AddRecipient(recipient string) error {
+	unlock, err := v.lockDir(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	r, err := crypt.ParseRecipient(recipient)
+	if err != nil {
+		return err
+	}
+	key, err := v.writeKey()
+	if err != nil {
+		return err
+	}
+	meta, err := ReadMeta(v.Directory)
+	if err != nil {
+		return err
+	}
+	err = meta.AddRecipient(key, r)
+	if err != nil {
+		return err
+	}
+	return meta.Write(v.Directory)
+}
+
+// RemoveRecipient removes given recipient from the vault. The recipient
+// may still know vault data key, therefore vault key should be rotated
+// afterwards
+func (v *Vault) RemoveRecipient(recipient string) error {
+	unlock, err := v.lockDir(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	r, err := crypt.ParseRecipient(recipient)
+	if err != nil {
+		return err
+	}
+	meta, err := ReadMeta(v.Directory)
+	if err != nil {
+		return err
+	}
+	err = meta.RemoveRecipient(r)
+	if err != nil {
+		return err
+	}
+	return meta.Write(v.Directory)
+}
+
+// Recipients returns list of vault recipients
+func (v *Vault) Recipients() ([]string, error) {
+	meta, err := v.Meta()
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, s := range meta.Recipients {
+		out = append(out, s.Recipient)
+	}
+	return out, nil
+}
+
+// helper function to create new key derivation function with parameters
+// of given one and fresh salt
+func renewKDF(kdf *crypt.KDF) (*crypt.KDF, error) {
+	if kdf.Name == crypt.KDFLegacy {
+		return crypt.NewKDF("")
+	}
+	out, err := crypt.NewKDF(kdf.Name)
+	if err != nil {
+		return nil, err
+	}
+	out.Time, out.Memory, out.Threads = kdf.Time, kdf.Memory, kdf.Threads
+	return out, nil
 }
 
 // Import allows to import vault records to a given file
 // CSV, JSON or ECM-JSON data-format are supported
 func (v *Vault) Import(fname, oname string) error {
+	unlock, err := v.lockDir(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 	// open file
 	f, err := os.Open(fname)
 	if err != nil {
@@ -609,8 +1614,9 @@ func (v *Vault) Import(fname, oname string) error {
 				key := recordAttribute(headers[idx])
 				vRecord.Map[key] = values[idx]
 			}
+			vRecord.Conceal()
 			if v.Verbose > 0 {
-				log.Println("Import VaultRecord\n", vRecord.String())
+				log.Println("Import VaultRecord", vRecord.ID)
 			}
 			records = append(records, *vRecord)
 		}
@@ -631,8 +1637,9 @@ func (v *Vault) Import(fname, oname string) error {
 			for key, val := range rec {
 				vRecord.Map[key] = fmt.Sprintf("%s", val)
 			}
+			vRecord.Conceal()
 			if v.Verbose > 0 {
-				log.Println("Import VaultRecord\n", vRecord.String())
+				log.Println("Import VaultRecord", vRecord.ID)
 			}
 			records = append(records, *vRecord)
 		}
@@ -642,7 +1649,7 @@ func (v *Vault) Import(fname, oname string) error {
 		// check if our destination is a vault
 		if oname == v.Directory {
 			for _, rec := range records {
-				err := rec.WriteRecord(v.Directory, v.Secret, v.Cipher, v.Verbose)
+				err := v.writeRecord(rec)
 				if err != nil {
 					log.Printf("unable to write vault record %s, error %v", rec.ID, err)
 					return err
@@ -689,8 +1696,15 @@ func (v *Vault) Export(fname string) error {
 	return err
 }
 
-// Sync implements sync procedure to given storage interace
+// Sync implements sync procedure to given storage interace, vault is locked
+// for writing up front since records and blobs missing in vault are written
+// based on vault content
 func (v *Vault) Sync(dst storage.Storage) error {
+	unlock, err := v.lockDir(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 	// get list of vault record ids
 	var vaultRecordIds []string
 	for _, rec := range v.Records {
@@ -713,7 +1727,7 @@ func (v *Vault) Sync(dst storage.Storage) error {
 			log.Println("unable to marshal vault record, error: ", err)
 			return err
 		}
-		edata, err := crypt.Encrypt(data, v.Secret, v.Cipher)
+		edata, err := v.EncryptRecord(rec.ID, data)
 		if err != nil {
 			log.Println("unable to encrypt vault record, error: ", err)
 			return err
@@ -737,7 +1751,7 @@ func (v *Vault) Sync(dst storage.Storage) error {
 			return err
 		}
 		// decrypt the data using our vault
-		data, err := crypt.Decrypt(edata, v.Secret, v.Cipher)
+		data, err := v.DecryptRecord(rid, edata)
 		if err != nil {
 			log.Printf("unable to decrypt data, error %v", err)
 			return err
@@ -749,6 +1763,10 @@ func (v *Vault) Sync(dst storage.Storage) error {
 			log.Println("unable to unmarshal the data, error: ", err)
 			return err
 		}
+		if rec.ID != rid {
+			msg := fmt.Sprintf("storage record %s does not match its ID %s", rid, rec.ID)
+			return errors.New(msg)
+		}
 		v.Records = append(v.Records, rec)
 		err = v.WriteRecord(rec)
 		if err != nil {
@@ -756,5 +1774,52 @@ func (v *Vault) Sync(dst storage.Storage) error {
 			return err
 		}
 	}
+	return v.syncBlobs(dst)
+}
+
+// helper function to sync vault blobs with given storage, blobs are
+// transferred encrypted as is independently of vault records
+func (v *Vault) syncBlobs(dst storage.Storage) error {
+	vaultBlobs, err := v.Blobs()
+	if err != nil {
+		return err
+	}
+	storageBlobs, err := dst.Blobs()
+	if err != nil {
+		log.Println("unable to get storage blobs, error: ", err)
+		return err
+	}
+	// push missing blobs to storage
+	for _, name := range vaultBlobs {
+		if utils.InList(name, storageBlobs) {
+			continue
+		}
+		file, err := os.Open(filepath.Join(v.Directory, BlobsDir, name))
+		if err != nil {
+			return err
+		}
+		err = dst.WriteBlob(name, file)
+		file.Close()
+		if err != nil {
+			log.Printf("unable to write blob %s to storage, error: %v", name, err)
+			return err
+		}
+	}
+	// pull missing blobs from storage
+	for _, name := range storageBlobs {
+		if utils.InList(name, vaultBlobs) || !ValidBlob(name) {
+			continue
+		}
+		reader, err := dst.ReadBlob(name)
+		if err != nil {
+			log.Printf("unable to read blob %s from storage, error: %v", name, err)
+			return err
+		}
+		err = v.WriteBlob(name, reader)
+		reader.Close()
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
@@ -1,10 +1,21 @@
 package vault
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/vkuznet/ecm/crypt"
 )
 
 func tempDir() string {
@@ -123,3 +134,2082 @@ func BenchmarkDeleteRecord(b *testing.B) {
 		}
 	}
 }
+
+// TestVaultLegacyRecord function
+func TestVaultLegacyRecord(t *testing.T) {
+	vdir := tempDir()
+	defer os.RemoveAll(vdir)
+
+	// write record encrypted with legacy key derivation
+	secret := "test"
+	rec := NewVaultRecord("login")
+	data, err := json.Marshal(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cipher := crypt.CipherAES{}
+	edata, err := cipher.Encrypt(data, crypt.LegacyKey([]byte(secret)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fname := filepath.Join(vdir, rec.ID)
+	err = os.WriteFile(fname, edata, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vault := Vault{Directory: vdir, Cipher: "aes", Start: time.Now()}
+	vault.SetSecret([]byte(secret))
+	err = vault.Create(vdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// wrong secret should neither unlock the vault nor create its data key
+	wrong := Vault{Directory: vdir, Cipher: "aes", Start: time.Now()}
+	if err := wrong.Unlock([]byte("wrong")); err == nil {
+		t.Error("vault is unlocked with wrong secret")
+	}
+	wrong.SetSecret([]byte("wrong"))
+	if err := wrong.WriteRecord(*NewVaultRecord("login")); err == nil {
+		t.Error("record is written with wrong secret")
+	}
+	if _, err := wrong.SplitKey(2, 2); err == nil {
+		t.Error("data key is split with wrong secret")
+	}
+	meta, err := ReadMeta(vdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(meta.WrappedKey) != 0 {
+		t.Fatal("vault data key is created with wrong secret")
+	}
+	if err := vault.Unlock([]byte(secret)); err != nil {
+		t.Fatal(err)
+	}
+
+	err = vault.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vault.Records) != 1 {
+		t.Fatalf("unable to read legacy record, found %d records", len(vault.Records))
+	}
+
+	// write record back, it should be upgraded to salted vault key and header
+	err = vault.WriteRecord(vault.Records[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	edata, err = os.ReadFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta, err = ReadMeta(vdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, _, err := crypt.ParseHeader(edata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.KDF != nil || len(meta.WrappedKey) == 0 {
+		t.Errorf("record with header %s is not encrypted with vault data key", header.String())
+	}
+	if _, err := meta.DecryptRecord(rec.ID, edata, []byte(secret), "nacl"); err != nil {
+		t.Errorf("unable to decrypt upgraded record, error %v", err)
+	}
+	if _, err := meta.DecryptRecord(rec.ID, edata, []byte("wrong"), "aes"); err == nil {
+		t.Error("record is decrypted with wrong secret")
+	}
+}
+
+// TestVaultRecordBinding function
+func TestVaultRecordBinding(t *testing.T) {
+	vdir := tempDir()
+	defer os.RemoveAll(vdir)
+
+	secret := "test"
+	vault := Vault{Directory: vdir, Cipher: "aes", Start: time.Now()}
+	vault.SetSecret([]byte(secret))
+	err := vault.Create(vdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec1, err := vault.AddRecord("login")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec2, err := vault.AddRecord("login")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// write record encrypted with legacy key derivation
+	rec3 := NewVaultRecord("login")
+	data, err := json.Marshal(rec3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cipher := crypt.CipherAES{}
+	edata, err := cipher.Encrypt(data, crypt.LegacyKey([]byte(secret)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fname3 := filepath.Join(vdir, rec3.ID)
+	err = os.WriteFile(fname3, edata, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// legacy record should be migrated and bound to its file name
+	count, err := vault.Migrate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("wrong number of migrated records %d", count)
+	}
+	edata, err = os.ReadFile(fname3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, _, err := crypt.ParseHeader(edata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Version != crypt.HeaderVersionAD || header.KDF != nil {
+		t.Errorf("record with header %s is not migrated", header.String())
+	}
+	if _, err := vault.ReadRecord(fname3); err != nil {
+		t.Errorf("unable to read migrated record, error %v", err)
+	}
+
+	// swapped records should be rejected
+	fname1 := filepath.Join(vdir, rec1.ID)
+	fname2 := filepath.Join(vdir, rec2.ID)
+	data1, err := os.ReadFile(fname1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data2, err := os.ReadFile(fname2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(fname1, data2, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(fname2, data1, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vault.ReadRecord(fname1); err == nil {
+		t.Error("swapped record is read")
+	}
+	if _, err := vault.ReadRecord(fname2); err == nil {
+		t.Error("swapped record is read")
+	}
+}
+
+// TestVaultDataKey function
+func TestVaultDataKey(t *testing.T) {
+	vdir := tempDir()
+	defer os.RemoveAll(vdir)
+
+	secret := "test"
+	vault := Vault{Directory: vdir, Cipher: "aes", Start: time.Now()}
+	vault.SetSecret([]byte(secret))
+	err := vault.Create(vdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		_, err := vault.AddRecord("login")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	rec := vault.Records[0]
+	fname := filepath.Join(vdir, rec.ID)
+	edata, err := os.ReadFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// change of vault secret should only wrap data key again
+	newSecret := "new-test"
+	err = vault.ChangeSecret(newSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(edata, data) {
+		t.Error("vault record is re-encrypted when vault secret is changed")
+	}
+	if _, err := vault.ReadRecord(fname); err != nil {
+		t.Errorf("unable to read vault record with new secret, error %v", err)
+	}
+	oldVault := Vault{Directory: vdir, Cipher: "aes"}
+	oldVault.SetSecret([]byte(secret))
+	if _, err := oldVault.ReadRecord(fname); err == nil {
+		t.Error("vault record is read with old secret")
+	}
+
+	// rotation of data key should re-encrypt all records
+	err = vault.RotateKey(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copies, err := filepath.Glob(vdir + ".*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range copies {
+		defer os.RemoveAll(dir)
+	}
+	if len(copies) != 1 {
+		t.Errorf("wrong number of vault copies %v", copies)
+	}
+	data, err = os.ReadFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(edata, data) {
+		t.Error("vault record is not re-encrypted when data key is rotated")
+	}
+	vault.Records = nil
+	err = vault.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vault.Records) != 3 {
+		t.Errorf("wrong number of vault records %d after data key rotation", len(vault.Records))
+	}
+}
+
+// TestVaultRecipients function
+func TestVaultRecipients(t *testing.T) {
+	vdir := tempDir()
+	defer os.RemoveAll(vdir)
+
+	vault := Vault{Directory: vdir, Cipher: "aes", Start: time.Now()}
+	vault.SetSecret([]byte("test"))
+	err := vault.Create(vdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, err := vault.AddRecord("login")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fname := filepath.Join(vdir, rec.ID)
+	identity, err := crypt.GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipient := identity.Recipient().String()
+	err = vault.AddRecipient(recipient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := vault.AddRecipient(recipient); err == nil {
+		t.Error("same recipient is added twice")
+	}
+	recipients, err := vault.Recipients()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recipients) != 1 || recipients[0] != recipient {
+		t.Errorf("wrong list of vault recipients %v", recipients)
+	}
+
+	// recipient should open the vault with its identity
+	idVault := Vault{Directory: vdir, Cipher: "aes", Identity: identity}
+	if _, err := idVault.ReadRecord(fname); err != nil {
+		t.Errorf("unable to read vault record with identity, error %v", err)
+	}
+
+	// removed recipient should lose access after data key rotation
+	err = vault.RemoveRecipient(recipient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = vault.RotateKey(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copies, err := filepath.Glob(vdir + ".*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range copies {
+		defer os.RemoveAll(dir)
+	}
+	if _, err := idVault.ReadRecord(fname); err == nil {
+		t.Error("vault record is read by removed recipient")
+	}
+	if _, err := vault.ReadRecord(fname); err != nil {
+		t.Errorf("unable to read vault record after data key rotation, error %v", err)
+	}
+}
+
+// TestVaultKeyFile function
+func TestVaultKeyFile(t *testing.T) {
+	vdir := tempDir()
+	defer os.RemoveAll(vdir)
+	kdir := tempDir()
+	defer os.RemoveAll(kdir)
+	keyFile := filepath.Join(kdir, "ecm.key")
+	err := crypt.GenerateKeyFile(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret := "test"
+	vault := Vault{Directory: vdir, Cipher: "aes", KeyFile: keyFile, Start: time.Now()}
+	vault.SetSecret([]byte(secret))
+	err = vault.Create(vdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, err := vault.AddRecord("login")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fname := filepath.Join(vdir, rec.ID)
+
+	// both secret and key file are required to read the vault
+	noKeyVault := Vault{Directory: vdir, Cipher: "aes"}
+	noKeyVault.SetSecret([]byte(secret))
+	if _, err := noKeyVault.ReadRecord(fname); err == nil {
+		t.Error("vault record is read without key file")
+	}
+	if _, err := vault.ReadRecord(fname); err != nil {
+		t.Errorf("unable to read vault record with key file, error %v", err)
+	}
+
+	// drop key file from vault credentials
+	err = vault.ChangeCredentials(secret, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := noKeyVault.ReadRecord(fname); err != nil {
+		t.Errorf("unable to read vault record without key file, error %v", err)
+	}
+}
+
+// TestVaultEncryptFile function
+func TestVaultEncryptFile(t *testing.T) {
+	vdir := tempDir()
+	defer os.RemoveAll(vdir)
+
+	vault := Vault{Directory: vdir, Cipher: "nacl", Start: time.Now()}
+	vault.SetSecret([]byte("test"))
+	err := vault.Create(vdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// create file which spans several stream chunks
+	data := make([]byte, 2*crypt.ChunkSize+5)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	efile := filepath.Join(vdir, "backups", "file.bin")
+	err = os.MkdirAll(filepath.Dir(efile), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(efile, data, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vault.EncryptFile(efile)
+	err = vault.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vault.Records) != 1 {
+		t.Fatalf("wrong number of vault records %d", len(vault.Records))
+	}
+	rec := vault.Records[0]
+	if rec.Map["Name"] != "file.bin" || rec.Kind != "file" || len(rec.Tags()) != 0 {
+		t.Errorf("wrong file record %s %+v", rec.Kind, rec.Map)
+	}
+	if _, ok := rec.Map["Data"]; ok {
+		t.Error("file content is kept in vault record")
+	}
+	if len(rec.Attachments) != 1 || rec.Attachments[0].Size != int64(len(data)) {
+		t.Fatalf("wrong attachments of file record %+v", rec.Attachments)
+	}
+	for i := 0; i < 2; i++ {
+		var buf bytes.Buffer
+		err = vault.ExtractAttachment(rec.ID, "file.bin", &buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, buf.Bytes()) {
+			t.Error("wrong content of decrypted vault file")
+		}
+		// vault files should be re-encrypted with new data key
+		err = vault.RotateKey(false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		copies, err := filepath.Glob(vdir + ".*")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, dir := range copies {
+			os.RemoveAll(dir)
+		}
+	}
+
+	// old vaults kept encrypted files in files area of the vault and its
+	// trash, the files are attached to their records when vault is migrated
+	key, err := vault.DataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec1, err := vault.AddRecord("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec1.Map["Name"] = "old.bin"
+	if err := vault.Update(*rec1); err != nil {
+		t.Fatal(err)
+	}
+	rec2, err := vault.AddRecord("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	streams := map[string]string{
+		filepath.Join(vdir, legacyFilesDir, rec1.ID): "old file",
+		filepath.Join(vdir, legacyFilesDir, rec2.ID): "deleted file",
+	}
+	for fname, content := range streams {
+		err = writeStream(fname, strings.NewReader(content), key, vault.Cipher)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := vault.DeleteRecord(rec2.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vault.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(vdir, legacyFilesDir)); err == nil {
+		t.Error("files area is kept after vault migration")
+	}
+	var buf bytes.Buffer
+	err = vault.ExtractAttachment(rec1.ID, "old.bin", &buf)
+	if err != nil || buf.String() != "old file" {
+		t.Errorf("wrong migrated file %q, error %v", buf.String(), err)
+	}
+	if err := vault.RestoreRecord(rec2.ID); err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	err = vault.ExtractAttachment(rec2.ID, rec2.ID, &buf)
+	if err != nil || buf.String() != "deleted file" {
+		t.Errorf("wrong migrated file of deleted record %q, error %v", buf.String(), err)
+	}
+	if removed, err := vault.CollectBlobs(); err != nil || len(removed) != 0 {
+		t.Errorf("migrated files are collected %v, error %v", removed, err)
+	}
+}
+
+// TestVaultStream function
+func TestVaultStream(t *testing.T) {
+	vdir := tempDir()
+	defer os.RemoveAll(vdir)
+
+	vault := Vault{Directory: vdir, Cipher: "aes", Start: time.Now()}
+	vault.SetSecret([]byte("test"))
+	err := vault.Create(vdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// content spans several stream chunks
+	data := bytes.Repeat([]byte("stream content "), crypt.ChunkSize/4)
+	hash, size, err := vault.EncryptStream(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(data)
+	if hash != hex.EncodeToString(sum[:]) || size != int64(len(data)) {
+		t.Errorf("wrong stream hash %s or size %d", hash, size)
+	}
+	var buf bytes.Buffer
+	if err := vault.DecryptStream(hash, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Error("decrypted stream does not match its content")
+	}
+	buf.Reset()
+	if err := vault.DecryptStream(strings.Repeat("0", 64), &buf); err == nil || buf.Len() != 0 {
+		t.Error("unknown stream is decrypted")
+	}
+}
+
+// TestVaultAttachments function
+func TestVaultAttachments(t *testing.T) {
+	vdir := tempDir()
+	defer os.RemoveAll(vdir)
+
+	vault := Vault{Directory: vdir, Cipher: "aes", Start: time.Now()}
+	vault.SetSecret([]byte("test"))
+	err := vault.Create(vdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec1, err := vault.AddRecord("note")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec2, err := vault.AddRecord("note")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("attachment content")
+	att1, err := vault.Attach(rec1.ID, "a.txt", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	att2, err := vault.Attach(rec2.ID, "b.txt", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// identical content is kept in single blob, the blob name does not
+	// reveal content hash
+	key, err := vault.DataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	name1 := blobName(key, att1.Hash)
+	blobs, err := vault.Blobs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if att1.Hash != att2.Hash || len(blobs) != 1 || blobs[0] != name1 || name1 == att1.Hash {
+		t.Errorf("attachments are not deduplicated, %v %v blobs %v", att1, att2, blobs)
+	}
+	// blob keeps encrypted content
+	blob, err := os.ReadFile(filepath.Join(vdir, BlobsDir, name1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(blob, data) {
+		t.Error("blob keeps plain attachment content")
+	}
+	var buf bytes.Buffer
+	err = vault.ExtractAttachment(rec2.ID, "b.txt", &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Errorf("wrong attachment content %s", buf.String())
+	}
+	if err := vault.ExtractAttachment(rec2.ID, "a.txt", &buf); err == nil {
+		t.Error("unknown attachment is extracted")
+	}
+
+	// blob is kept while it is referenced by any record or record version
+	err = vault.RemoveAttachment(rec1.ID, "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = vault.RemoveAttachment(rec2.ID, "b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	removed, err := vault.CollectBlobs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 0 {
+		t.Errorf("blob referenced by record history is removed %v", removed)
+	}
+	for _, rid := range []string{rec1.ID, rec2.ID} {
+		err = vault.DeleteRecord(rid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tdir, _ := vault.trashDir(rid)
+		err = vault.PurgeRecord(rid)
+		if _, e := os.Stat(tdir); err != nil || e == nil {
+			t.Fatalf("unable to purge record %s, error %v", rid, err)
+		}
+	}
+	removed, err = vault.CollectBlobs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || removed[0] != name1 {
+		t.Errorf("unreferenced blob is not removed %v", removed)
+	}
+
+	// records written by old vaults keep paths of attached files
+	var rec VaultRecord
+	err = json.Unmarshal([]byte(`{"ID":"123","Attachments":["/tmp/file.txt"]}`), &rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if att, ok := rec.Attachment("file.txt"); !ok || att.Hash != "" {
+		t.Errorf("wrong legacy attachment %+v", rec.Attachments)
+	}
+
+	// content of blob which does not match its hash is not written
+	rec3, err := vault.AddRecord("note")
+	if err != nil {
+		t.Fatal(err)
+	}
+	att3, err := vault.Attach(rec3.ID, "c.txt", bytes.NewReader([]byte("content")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	att4, err := vault.Attach(rec3.ID, "d.txt", bytes.NewReader([]byte("other content")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fname3, _ := vault.BlobFile(blobName(key, att3.Hash))
+	fname4, _ := vault.BlobFile(blobName(key, att4.Hash))
+	blob3, err := os.ReadFile(fname3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob, err = os.ReadFile(fname4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(fname3, blob, 0600); err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if err := vault.ExtractAttachment(rec3.ID, "c.txt", &buf); err == nil || buf.Len() != 0 {
+		t.Errorf("content of mismatched blob is written, %d bytes, error %v", buf.Len(), err)
+	}
+	if err := vault.ReadBlob(filepath.Base(fname3), &buf); err == nil || buf.Len() != 0 {
+		t.Errorf("content of mismatched blob is read, %d bytes, error %v", buf.Len(), err)
+	}
+
+	// blobs written by old vaults are named after content hash and they
+	// are renamed when vault key is rotated
+	legacy, _ := vault.BlobFile(att3.Hash)
+	if err := os.Remove(fname3); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(legacy, blob3, 0600); err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if err := vault.ExtractAttachment(rec3.ID, "c.txt", &buf); err != nil || buf.String() != "content" {
+		t.Errorf("unable to extract legacy blob %q, error %v", buf.String(), err)
+	}
+	if refs, err := vault.blobRefs(); err != nil || !refs[att3.Hash] {
+		t.Errorf("legacy blob is not referenced, error %v", err)
+	}
+	defer func() {
+		copies, _ := filepath.Glob(vdir + ".*")
+		for _, dir := range copies {
+			os.RemoveAll(dir)
+		}
+	}()
+	if err := vault.RotateKey(false); err != nil {
+		t.Fatal(err)
+	}
+	key, err = vault.DataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	blobs, err = vault.Blobs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []string{blobName(key, att3.Hash), blobName(key, att4.Hash)}
+	sort.Strings(expect)
+	if strings.Join(blobs, ",") != strings.Join(expect, ",") {
+		t.Errorf("blobs are not renamed after key rotation, %v expect %v", blobs, expect)
+	}
+	for name, content := range map[string]string{"c.txt": "content", "d.txt": "other content"} {
+		buf.Reset()
+		if err := vault.ExtractAttachment(rec3.ID, name, &buf); err != nil || buf.String() != content {
+			t.Errorf("unable to extract %s after key rotation %q, error %v", name, buf.String(), err)
+		}
+	}
+}
+
+// TestRecordOTP function
+func TestRecordOTP(t *testing.T) {
+	rec := NewVaultRecord("login")
+	if _, _, err := rec.OTP(); err == nil {
+		t.Error("record without TOTP seed provides OTP code")
+	}
+	rec.Map[TOTPKey] = "otpauth://totp/ECM:test?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ&digits=8&period=60"
+	code, remaining, err := rec.OTP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(code) != 8 || remaining > time.Minute {
+		t.Errorf("wrong OTP code %s valid for %v", code, remaining)
+	}
+}
+
+// TestVaultRecoverKey function
+func TestVaultRecoverKey(t *testing.T) {
+	vdir := tempDir()
+	defer os.RemoveAll(vdir)
+
+	vault := Vault{Directory: vdir, Cipher: "aes", Start: time.Now()}
+	vault.SetSecret([]byte("test"))
+	err := vault.Create(vdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, err := vault.AddRecord("login")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fname := filepath.Join(vdir, rec.ID)
+	shares, err := vault.SplitKey(3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n, k := vault.RecoveryShares(); n != 3 || k != 2 {
+		t.Errorf("wrong number of recovery shares %d and threshold %d", n, k)
+	}
+
+	// record written by old vault after the split can not be recovered
+	legacy := NewVaultRecord("login")
+	data, err := json.Marshal(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cipher := crypt.CipherAES{}
+	edata, err := cipher.Encrypt(data, crypt.LegacyKey([]byte("test")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lname := filepath.Join(vdir, legacy.ID)
+	if err := os.WriteFile(lname, edata, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// the secret is lost, but two shares unlock the vault
+	lost := Vault{Directory: vdir, Cipher: "aes", Start: time.Now()}
+	if err := lost.RecoverKey(shares[:1]); err == nil {
+		t.Error("vault key is recovered from single share")
+	}
+	err = lost.RecoverKey([]*crypt.Share{shares[2], shares[0]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lost.ReadRecord(fname); err != nil {
+		t.Errorf("unable to read vault record with recovered key, error %v", err)
+	}
+
+	// re-key the vault with new secret
+	err = lost.ChangeCredentials("new secret", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newVault := Vault{Directory: vdir, Cipher: "aes"}
+	newVault.SetSecret([]byte("new secret"))
+	if _, err := newVault.ReadRecord(fname); err != nil {
+		t.Errorf("unable to read vault record with new secret, error %v", err)
+	}
+
+	// rotation invalidates recovery shares and requires force
+	if err := os.Remove(lname); err != nil {
+		t.Fatal(err)
+	}
+	if err := newVault.RotateKey(false); !errors.Is(err, ErrRecoveryShares) {
+		t.Errorf("vault with recovery shares is rotated without force, error %v", err)
+	}
+	if err := newVault.RotateKey(true); err != nil {
+		t.Fatal(err)
+	}
+	copies, _ := filepath.Glob(vdir + ".*")
+	for _, dir := range copies {
+		os.RemoveAll(dir)
+	}
+	if n, _ := newVault.RecoveryShares(); n != 0 {
+		t.Errorf("rotated vault keeps %d recovery shares", n)
+	}
+	if err := lost.RecoverKey(shares[:2]); err == nil {
+		t.Error("rotated vault is unlocked with old shares")
+	}
+
+	// shares of another vault are rejected
+	odir := tempDir()
+	defer os.RemoveAll(odir)
+	other := Vault{Directory: odir, Cipher: "aes", Start: time.Now()}
+	other.SetSecret([]byte("test"))
+	if err := other.Create(odir); err != nil {
+		t.Fatal(err)
+	}
+	otherShares, err := other.SplitKey(2, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := newVault.RecoverKey(otherShares); err == nil {
+		t.Error("vault is unlocked with shares of another vault")
+	}
+}
+
+// TestVaultLock function
+func TestVaultLock(t *testing.T) {
+	vdir := tempDir()
+	defer os.RemoveAll(vdir)
+
+	vault := Vault{Directory: vdir, Cipher: "aes", Start: time.Now()}
+	vault.SetSecret([]byte("test"))
+	err := vault.Create(vdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, err := vault.AddRecord("login")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec.Map["Password"] = "password"
+	if err := vault.WriteRecord(*rec); err != nil {
+		t.Fatal(err)
+	}
+	vault.Records = nil
+	if err := vault.Read(); err != nil {
+		t.Fatal(err)
+	}
+	if !vault.CheckSecret([]byte("test")) {
+		t.Error("vault secret is not kept in secure buffer")
+	}
+	password := vault.Records[0].Value("Password")
+	if password != "password" {
+		t.Fatalf("wrong record password %s", password)
+	}
+	if vault.Records[0].Map["Password"] != "" {
+		t.Error("record password is kept in record map")
+	}
+	secret := vault.Records[0].Secret("Password")
+	if strings.Contains(vault.Records[0].String(), "password\"") {
+		t.Error("record string reveals record values")
+	}
+
+	// lock wipes records and secret
+	first := &vault.Records[0]
+	vault.Lock()
+	if len(vault.Records) != 0 || vault.HasSecret() {
+		t.Error("vault is not locked")
+	}
+	if first.Map != nil {
+		t.Error("decrypted record values are not removed")
+	}
+	if string(secret) == "password" {
+		t.Error("record password is not zeroed")
+	}
+	if err := vault.Read(); err == nil && len(vault.Records) != 0 {
+		t.Error("locked vault records are read without secret")
+	}
+	vault.SetSecret([]byte("test"))
+	if err := vault.Read(); err != nil || len(vault.Records) != 1 {
+		t.Errorf("unable to read vault records after unlock, error %v", err)
+	}
+}
+
+// TestRecordConceal function
+func TestRecordConceal(t *testing.T) {
+	rec := NewVaultRecord("login")
+	rec.Map["Name"] = "name"
+	rec.SetValue("Password", "pa\"ss\\wo\u00e9rd\n\U0001F600")
+	if rec.Map["Password"] != "" || rec.Map["Name"] != "name" {
+		t.Fatalf("wrong record map %+v", rec.Map)
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out VaultRecord
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Value("Password") != rec.Value("Password") || out.Value("Name") != "name" {
+		t.Errorf("wrong record values %q %q", out.Value("Password"), out.Value("Name"))
+	}
+	if out.Map["Password"] != "" || out.ID != rec.ID {
+		t.Errorf("wrong decoded record %+v", out)
+	}
+
+	// escaped unicode and surrogate pairs are decoded to their runes
+	raw := `{"ID":"1","Map":{"Password":"\u00e9\ud83d\ude00\/"}}`
+	if err := json.Unmarshal([]byte(raw), &out); err != nil {
+		t.Fatal(err)
+	}
+	if val := out.Value("Password"); val != "\u00e9\U0001F600/" {
+		t.Errorf("wrong decoded password %q", val)
+	}
+
+	// copies of the record keep their values when record value is changed
+	copyRec := *rec
+	rec.SetValue("Password", "new")
+	if copyRec.Value("Password") == "new" || rec.Value("Password") != "new" {
+		t.Error("record copy shares changed value")
+	}
+	rec.Wipe()
+	if rec.Value("Password") != "" || len(rec.Secret("Password")) != 0 {
+		t.Error("record values are not removed")
+	}
+}
+
+// TestVaultHistory function
+func TestVaultHistory(t *testing.T) {
+	vdir := tempDir()
+	defer os.RemoveAll(vdir)
+
+	vault := Vault{Directory: vdir, Cipher: "aes", Start: time.Now()}
+	vault.SetSecret([]byte("test"))
+	err := vault.Create(vdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, err := vault.AddRecord("login")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"v1", "v2", "v3"} {
+		rec.Map["Name"] = name
+		rec.Map["Password"] = "password-" + name
+		if err := vault.WriteRecord(*rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	versions, err := vault.History(rec.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// new record is written when it is added to the vault
+	if len(versions) != 4 || versions[0].Version != CurrentVersion {
+		t.Fatalf("wrong record history %+v", versions)
+	}
+	first := versions[2].Version
+	old, err := vault.RecordVersion(rec.ID, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if old.Map["Name"] != "v1" {
+		t.Errorf("wrong name of first version %s", old.Map["Name"])
+	}
+
+	// diff between first and current versions
+	diff, err := vault.Diff(rec.ID, first, CurrentVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff) != 2 || diff[0].Key != "Name" || diff[0].Old != "v1" || diff[0].New != "v3" {
+		t.Fatalf("wrong record diff %+v", diff)
+	}
+	if diff[1].Kind() != "changed" || strings.Contains(diff[1].String(), "password-") {
+		t.Errorf("wrong password diff %s", diff[1].String())
+	}
+
+	// invalid versions are rejected
+	if _, err := vault.RecordVersion(rec.ID, "../"+rec.ID); err == nil {
+		t.Error("invalid record version is accepted")
+	}
+
+	// restore first version, the current one is kept in history
+	vault.Records = []VaultRecord{*rec}
+	if err := vault.Restore(rec.ID, first); err != nil {
+		t.Fatal(err)
+	}
+	if vault.Records[0].Map["Name"] != "v1" {
+		t.Errorf("record is not restored, name %s", vault.Records[0].Map["Name"])
+	}
+	versions, err = vault.History(rec.ID)
+	if err != nil || len(versions) != 5 {
+		t.Fatalf("wrong record history after restore %+v, error %v", versions, err)
+	}
+
+	// record versions are re-encrypted with rotated key
+	if err := vault.RotateKey(false); err != nil {
+		t.Fatal(err)
+	}
+	copies, _ := filepath.Glob(vdir + ".*")
+	for _, dir := range copies {
+		defer os.RemoveAll(dir)
+	}
+	old, err = vault.RecordVersion(rec.ID, versions[1].Version)
+	if err != nil || old.Map["Name"] != "v3" {
+		t.Errorf("unable to read record version after key rotation, error %v", err)
+	}
+
+	// record written by old vault along with its old version
+	legacy := NewVaultRecord("login")
+	cipher := crypt.CipherAES{}
+	for _, fname := range []string{
+		filepath.Join(vdir, HistoryDir, legacy.ID, time.Now().Add(-time.Hour).UTC().Format(VersionFormat)),
+		filepath.Join(vdir, legacy.ID),
+	} {
+		data, err := json.Marshal(legacy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		edata, err := cipher.Encrypt(data, crypt.LegacyKey([]byte("test")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(filepath.Dir(fname), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fname, edata, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// versions are readable with new secret and they are rotated with it
+	if err := vault.ChangeSecret("new secret"); err != nil {
+		t.Fatal(err)
+	}
+	newVault := Vault{Directory: vdir, Cipher: "aes"}
+	newVault.SetSecret([]byte("new secret"))
+	versions, err = newVault.History(legacy.ID)
+	if err != nil || len(versions) != 3 {
+		t.Fatalf("wrong history of migrated record %+v, error %v", versions, err)
+	}
+	for _, version := range versions {
+		if _, err := newVault.RecordVersion(legacy.ID, version.Version); err != nil {
+			t.Errorf("unable to read version %s with new secret, error %v", version.Version, err)
+		}
+	}
+	if err := newVault.RotateKey(false); err != nil {
+		t.Fatal(err)
+	}
+	copies, _ = filepath.Glob(vdir + ".*")
+	for _, dir := range copies {
+		defer os.RemoveAll(dir)
+	}
+	if _, err := newVault.RecordVersion(legacy.ID, versions[2].Version); err != nil {
+		t.Errorf("unable to read version of migrated record after key rotation, error %v", err)
+	}
+}
+
+// TestVaultTrash function
+func TestVaultTrash(t *testing.T) {
+	vdir := tempDir()
+	defer os.RemoveAll(vdir)
+
+	vault := Vault{Directory: vdir, Cipher: "aes", Start: time.Now()}
+	vault.SetSecret([]byte("test"))
+	err := vault.Create(vdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rids []string
+	for _, name := range []string{"first", "second"} {
+		rec, err := vault.AddRecord("login")
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec.Map["Name"] = name
+		if err := vault.Update(*rec); err != nil {
+			t.Fatal(err)
+		}
+		rids = append(rids, rec.ID)
+	}
+	rid := rids[0]
+
+	// deleted record is moved to the trash along with its history
+	if err := vault.DeleteRecord(rid); err != nil {
+		t.Fatal(err)
+	}
+	vault.Records = nil
+	if err := vault.Read(); err != nil {
+		t.Fatal(err)
+	}
+	if len(vault.Records) != 1 || vault.Records[0].ID != rids[1] {
+		t.Fatalf("deleted record is read from the vault %v", vault.Records)
+	}
+	if _, err := vault.History(rid); err == nil {
+		t.Error("history of deleted record is kept in the vault")
+	}
+	trash, err := vault.Trash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trash) != 1 || trash[0].ID != rid || !trash[0].Expires.After(time.Now()) {
+		t.Fatalf("wrong vault trash %+v", trash)
+	}
+	rec, err := vault.TrashedRecord(rid)
+	if err != nil || rec.Map["Name"] != "first" {
+		t.Errorf("unable to read deleted record, error %v", err)
+	}
+
+	// restore deleted record
+	if err := vault.RestoreRecord(rid); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vault.Record(rid); err != nil {
+		t.Error("restored record is not added to vault records")
+	}
+	if versions, err := vault.History(rid); err != nil || len(versions) != 2 {
+		t.Errorf("history of restored record is lost, versions %v, error %v", versions, err)
+	}
+	if trash, _ := vault.Trash(); len(trash) != 0 {
+		t.Errorf("restored record is kept in the trash %+v", trash)
+	}
+
+	// deleted records are purged after retention period by vault writer
+	// which moves another record to the trash, while readers keep them
+	if err := vault.DeleteRecord(rid); err != nil {
+		t.Fatal(err)
+	}
+	vault.TrashRetention = time.Nanosecond
+	vault.Records = nil
+	if err := vault.Read(); err != nil {
+		t.Fatal(err)
+	}
+	if trash, _ := vault.Trash(); len(trash) != 1 {
+		t.Errorf("deleted record is purged by vault reader %+v", trash)
+	}
+	if err := vault.DeleteRecord(rids[1]); err != nil {
+		t.Fatal(err)
+	}
+	if trash, _ := vault.Trash(); len(trash) != 0 {
+		t.Errorf("deleted record is not purged %+v", trash)
+	}
+	if err := vault.RestoreRecord(rid); err == nil {
+		t.Error("purged record is restored")
+	}
+}
+
+// TestVaultIndex function
+func TestVaultIndex(t *testing.T) {
+	vdir := tempDir()
+	defer os.RemoveAll(vdir)
+
+	vault := Vault{Directory: vdir, Cipher: "aes", Start: time.Now()}
+	vault.SetSecret([]byte("test"))
+	err := vault.Create(vdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rids []string
+	for _, name := range []string{"github", "gitlab", "bank"} {
+		rec, err := vault.AddRecord("login")
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec.Map["Name"] = name
+		rec.Map["URL"] = "https://" + name + ".com"
+		if err := vault.Update(*rec); err != nil {
+			t.Fatal(err)
+		}
+		rids = append(rids, rec.ID)
+	}
+	files, err := vault.Files()
+	if err != nil || len(files) != 3 {
+		t.Fatalf("wrong vault files %v, error %v", files, err)
+	}
+
+	// records are listed and found from the index and loaded lazily
+	lazy := Vault{Directory: vdir, Cipher: "aes", Start: time.Now()}
+	lazy.SetSecret([]byte("test"))
+	if err := lazy.ReadIndex(); err != nil {
+		t.Fatal(err)
+	}
+	entries := lazy.Index()
+	if len(entries) != 3 || entries[0].Name != "bank" || entries[0].URL != "https://bank.com" {
+		t.Fatalf("wrong vault index %+v", entries)
+	}
+	records := lazy.Find("git")
+	if len(records) != 2 || len(lazy.Records) != 2 {
+		t.Errorf("wrong records found in vault index %v", records)
+	}
+	if records := lazy.Find("git"); len(records) != 2 || len(lazy.Records) != 2 {
+		t.Errorf("records are loaded more than once %d", len(lazy.Records))
+	}
+
+	// index is updated incrementally when record is written and deleted
+	rec, err := vault.Record(rids[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec.Map["Name"] = "savings"
+	if err := vault.Update(rec); err != nil {
+		t.Fatal(err)
+	}
+	if err := vault.DeleteRecord(rids[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := lazy.ReadIndex(); err != nil {
+		t.Fatal(err)
+	}
+	entries = lazy.Index()
+	if len(entries) != 2 || entries[1].Name != "savings" {
+		t.Fatalf("vault index is not updated %+v", entries)
+	}
+
+	// records changed outside of the vault are re-indexed
+	other := Vault{Directory: vdir, Cipher: "aes", Start: time.Now()}
+	other.SetSecret([]byte("test"))
+	rec.Map["Name"] = "checking"
+	if err := other.writeRecord(rec); err != nil {
+		t.Fatal(err)
+	}
+	if err := lazy.ReadIndex(); err != nil {
+		t.Fatal(err)
+	}
+	if entries := lazy.Find("checking"); len(entries) != 1 {
+		t.Errorf("changed record is not re-indexed %+v", lazy.Index())
+	}
+
+	// records which can not be decrypted are reported while other records
+	// are indexed
+	broken := filepath.Join(vdir, "broken")
+	if err := os.WriteFile(broken, []byte("broken record"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	err = lazy.ReadIndex()
+	var recErrors RecordErrors
+	if !errors.As(err, &recErrors) || len(recErrors) != 1 || recErrors[0].File != broken {
+		t.Errorf("broken record is not reported, error %v", err)
+	}
+	if entries := lazy.Index(); len(entries) != 2 {
+		t.Errorf("wrong vault index with broken record %+v", entries)
+	}
+}
+
+// helper function to create vault with given number of records
+func poolVault(vdir string, nrec int) (Vault, error) {
+	vault := Vault{Directory: vdir, Cipher: "aes", Start: time.Now()}
+	vault.SetSecret([]byte("test"))
+	err := vault.Create(vdir)
+	if err != nil {
+		return vault, err
+	}
+	for i := 0; i < nrec; i++ {
+		rec := NewVaultRecord("login")
+		rec.Map["Name"] = fmt.Sprintf("record-%d", i)
+		rec.Map["Password"] = fmt.Sprintf("password-%d", i)
+		vault.Records = append(vault.Records, *rec)
+	}
+	err = vault.Write()
+	return vault, err
+}
+
+// TestVaultPool function
+func TestVaultPool(t *testing.T) {
+	vdir := tempDir()
+	defer os.RemoveAll(vdir)
+
+	vault, err := poolVault(vdir, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := vault.Files()
+	if err != nil || len(files) != 20 {
+		t.Fatalf("wrong vault files %v, error %v", files, err)
+	}
+
+	// records are read in order of vault files regardless of workers
+	for _, workers := range []int{1, 4, 64} {
+		vault := Vault{Directory: vdir, Cipher: "aes", Start: time.Now(), Workers: workers}
+		vault.SetSecret([]byte("test"))
+		if err := vault.Read(); err != nil {
+			t.Fatal(err)
+		}
+		if len(vault.Records) != len(files) {
+			t.Fatalf("read %d records with %d workers", len(vault.Records), workers)
+		}
+		for i, rec := range vault.Records {
+			if rec.ID != files[i] {
+				t.Fatalf("wrong order of records with %d workers, %s != %s", workers, rec.ID, files[i])
+			}
+		}
+	}
+
+	// errors of broken records are collected while other records are read
+	broken := filepath.Join(vdir, files[3])
+	if err := os.WriteFile(broken, []byte("broken record"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	vault = Vault{Directory: vdir, Cipher: "aes", Start: time.Now()}
+	vault.SetSecret([]byte("test"))
+	err = vault.Read()
+	var recErrors RecordErrors
+	if !errors.As(err, &recErrors) || !PartialError(err) {
+		t.Fatalf("wrong error of broken record %v", err)
+	}
+	if len(recErrors) != 1 || recErrors[0].File != broken {
+		t.Errorf("wrong record errors %v", recErrors)
+	}
+	if failures := RecordFailures(err); len(failures) != 1 || failures[0].File != broken {
+		t.Errorf("wrong record failures %v", failures)
+	}
+	if failures := RecordFailures(errors.New("vault error")); len(failures) != 0 {
+		t.Errorf("record failures of vault error %v", failures)
+	}
+	if len(vault.Records) != len(files)-1 {
+		t.Errorf("read %d records along with broken one", len(vault.Records))
+	}
+
+	// all records are written back and the broken one is replaced
+	rec := NewVaultRecord("login")
+	rec.ID = files[3]
+	vault.Records = append(vault.Records, *rec)
+	if err := vault.Write(); err != nil {
+		t.Fatal(err)
+	}
+	vault = Vault{Directory: vdir, Cipher: "aes", Start: time.Now()}
+	vault.SetSecret([]byte("test"))
+	if err := vault.Read(); err != nil || len(vault.Records) != len(files) {
+		t.Errorf("unable to read written records, found %d, error %v", len(vault.Records), err)
+	}
+}
+
+// helper function to benchmark vault read with given number of workers
+func benchmarkRead(b *testing.B, workers int) {
+	vdir := tempDir()
+	defer os.RemoveAll(vdir)
+	if _, err := poolVault(vdir, 200); err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		vault := Vault{Directory: vdir, Cipher: "aes", Start: time.Now(), Workers: workers}
+		vault.SetSecret([]byte("test"))
+		if err := vault.Read(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// helper function to benchmark vault write with given number of workers
+func benchmarkWrite(b *testing.B, workers int) {
+	vdir := tempDir()
+	defer os.RemoveAll(vdir)
+	vault, err := poolVault(vdir, 200)
+	if err != nil {
+		b.Fatal(err)
+	}
+	vault.Workers = workers
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if err := vault.Write(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkRead provides benchmark test of vault Read with pool of workers
+func BenchmarkRead(b *testing.B) {
+	benchmarkRead(b, 0)
+}
+
+// BenchmarkReadSequential provides benchmark test of vault Read with single worker
+func BenchmarkReadSequential(b *testing.B) {
+	benchmarkRead(b, 1)
+}
+
+// BenchmarkWrite provides benchmark test of vault Write with pool of workers
+func BenchmarkWrite(b *testing.B) {
+	benchmarkWrite(b, 0)
+}
+
+// BenchmarkWriteSequential provides benchmark test of vault Write with single worker
+func BenchmarkWriteSequential(b *testing.B) {
+	benchmarkWrite(b, 1)
+}
+
+// TestVaultQuery function
+func TestVaultQuery(t *testing.T) {
+	vdir := tempDir()
+	defer os.RemoveAll(vdir)
+
+	vault := Vault{Directory: vdir, Cipher: "aes", Start: time.Now()}
+	vault.SetSecret([]byte("test"))
+	err := vault.Create(vdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := []struct {
+		kind, name, url, tags, login string
+	}{
+		{"login", "GitHub", "https://github.com", "work, dev", "alice"},
+		{"login", "GitLab", "https://gitlab.com", "home", "bob"},
+		{"login", "Bank", "http://bank.com", "finance", "alice"},
+		{"note", "Old account", "", "homework", ""},
+	}
+	for i, r := range records {
+		rec, err := vault.AddRecord(r.kind)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec.Map["Name"] = r.name
+		rec.Map["Tags"] = r.tags
+		if r.kind == "login" {
+			rec.Map["URL"] = r.url
+			rec.Map["Login"] = r.login
+		}
+		rec.ModificationTime = time.Now().Add(time.Duration(i) * time.Hour)
+		if err := vault.Update(*rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	names := func(records []VaultRecord) string {
+		var out []string
+		for _, rec := range records {
+			out = append(out, rec.Map["Name"])
+		}
+		return strings.Join(out, ",")
+	}
+	queries := map[string]string{
+		"git sort:name":                                   "GitHub,GitLab",
+		"kind:login tag:work url:github":                  "GitHub",
+		"tag:home":                                        "GitLab",
+		"tags:home sort:name":                             "GitLab,Old account",
+		"kind:note OR login:bob sort:name":                "GitLab,Old account",
+		"NOT kind:login":                                  "Old account",
+		"kind:login -(login:alice AND url:git) sort:name": "Bank,GitLab",
+		`name:"old account"`:                              "Old account",
+		"url:/^http:/":                                    "Bank",
+		"name:~gihub":                                     "GitHub",
+		"kind:login sort:-mtime":                          "Bank,GitLab,GitHub",
+		"https://github.com":                              "GitHub",
+		"sort:name":                                       "Bank,GitHub,GitLab,Old account",
+	}
+	for text, expect := range queries {
+		found, err := vault.Query(text)
+		if err != nil {
+			t.Errorf("unable to query '%s', error %v", text, err)
+			continue
+		}
+		if got := names(found); got != expect {
+			t.Errorf("query '%s' found %s, expected %s", text, got, expect)
+		}
+	}
+
+	// invalid queries are rejected by the parser
+	for _, text := range []string{"name:", "(git", "git)", "url:/[/", "password:secret", `name:"git`, "NOT", "git OR", "-(sort:name)"} {
+		if _, err := ParseQuery(text); err == nil {
+			t.Errorf("invalid query '%s' is parsed", text)
+		}
+	}
+
+	// nesting of the query is bounded
+	nested := strings.Repeat("(", MaxQueryDepth) + "git" + strings.Repeat(")", MaxQueryDepth)
+	if _, err := ParseQuery(nested); err != nil {
+		t.Errorf("unable to parse nested query, error %v", err)
+	}
+	for _, text := range []string{"(" + nested + ")", strings.Repeat("NOT ", MaxQueryDepth+1) + "git", strings.Repeat("(", 100000)} {
+		if _, err := ParseQuery(text); err == nil || !strings.Contains(err.Error(), "nested") {
+			t.Errorf("deeply nested query is parsed, error %v", err)
+		}
+	}
+
+	// only records which may match the query are loaded from vault index
+	lazy := Vault{Directory: vdir, Cipher: "aes", Start: time.Now()}
+	lazy.SetSecret([]byte("test"))
+	if err := lazy.ReadIndex(); err != nil {
+		t.Fatal(err)
+	}
+	found, err := lazy.Query("url:git login:alice")
+	if err != nil || names(found) != "GitHub" || len(lazy.Records) != 2 {
+		t.Errorf("wrong lazy query results %s, loaded %d records, error %v", names(found), len(lazy.Records), err)
+	}
+}
+
+// TestVaultSchema function
+func TestVaultSchema(t *testing.T) {
+	for _, kind := range []string{"login", "card", "note", "identity", "ssh", "wifi", "api"} {
+		if _, ok := LookupSchema(kind); !ok {
+			t.Errorf("no schema of built-in %s kind", kind)
+		}
+	}
+
+	// new records are created from schema of their kind
+	rec := NewVaultRecord("card")
+	if rec.Kind != "card" || strings.Join(rec.Keys()[:3], ",") != "Name,Cardholder,Number" {
+		t.Fatalf("wrong card record %s %v", rec.Kind, rec.Keys())
+	}
+	if !SensitiveKey("CVC") || !SensitiveKey("number") || SensitiveKey("Cardholder") {
+		t.Error("wrong concealed fields of card schema")
+	}
+	rec.Map["Number"] = "4111 1111 1111 1112"
+	rec.Map["Expiry"] = "13/2030"
+	rec.Map["CVC"] = "12"
+	err := rec.Validate()
+	if err == nil {
+		t.Fatal("invalid card record is validated")
+	}
+	for _, key := range []string{"Name", "Number", "Expiry", "CVC"} {
+		if !strings.Contains(err.Error(), key+":") {
+			t.Errorf("no validation error of %s field, %v", key, err)
+		}
+	}
+	rec.Map["Name"] = "visa"
+	rec.Map["Number"] = "4111 1111 1111 1111"
+	rec.Map["Expiry"] = "12/2030"
+	rec.Map["CVC"] = "123"
+	if err := rec.Validate(); err != nil {
+		t.Error(err)
+	}
+	identity := NewVaultRecord("identity")
+	identity.Map["Name"] = "me"
+	identity.Map["Email"] = "me at example.com"
+	identity.Map["Phone"] = "call me"
+	if err := identity.Validate(); err == nil {
+		t.Error("invalid identity record is validated")
+	}
+
+	// custom kinds can be registered
+	err = RegisterSchema(Schema{Kind: "bad kind"})
+	if err == nil {
+		t.Error("invalid schema is registered")
+	}
+	err = RegisterSchema(Schema{Kind: "license", Fields: []SchemaField{
+		{Key: "Name", Type: TextField, Required: true},
+		{Key: "Serial", Type: ConcealedField, Pattern: `^[A-Z0-9-]+$`},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	license := NewVaultRecord("license")
+	license.Map["Name"] = "editor"
+	license.Map["Serial"] = "abc"
+	if license.Kind != "license" || license.Validate() == nil {
+		t.Errorf("wrong record of custom kind %s", license.Kind)
+	}
+
+	// record kind is kept in the vault and inferred for old records
+	vdir := tempDir()
+	defer os.RemoveAll(vdir)
+	vault := Vault{Directory: vdir, Cipher: "aes", Start: time.Now()}
+	vault.SetSecret([]byte("test"))
+	if err := vault.Create(vdir); err != nil {
+		t.Fatal(err)
+	}
+	old := VaultRecord{ID: "old", Map: Record{"Name": "old", "Note": "text"}}
+	for _, r := range []VaultRecord{*rec, old} {
+		if err := vault.WriteRecord(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lazy := Vault{Directory: vdir, Cipher: "aes", Start: time.Now()}
+	lazy.SetSecret([]byte("test"))
+	if err := lazy.ReadIndex(); err != nil {
+		t.Fatal(err)
+	}
+	entries := lazy.Index()
+	if len(entries) != 2 || entries[0].Kind != "note" || entries[1].Kind != "card" {
+		t.Fatalf("wrong kinds of indexed records %+v", entries)
+	}
+	found, err := lazy.Query("kind:card")
+	if err != nil || len(found) != 1 || found[0].Kind != "card" || len(lazy.Records) != 1 {
+		t.Errorf("wrong records of card kind %v, error %v", found, err)
+	}
+}
+
+// TestVaultFolders function
+func TestVaultFolders(t *testing.T) {
+	if path, err := CleanFolder(" work//servers/ "); err != nil || path != "work/servers" {
+		t.Errorf("wrong clean folder %s, error %v", path, err)
+	}
+	if _, err := CleanFolder("work/../home"); err == nil {
+		t.Error("folder path with parent element is accepted")
+	}
+	if !InFolder("work/servers", "work") || InFolder("workshop", "work") || !InFolder("home", "") {
+		t.Error("wrong folder membership")
+	}
+
+	vdir := tempDir()
+	defer os.RemoveAll(vdir)
+	vault := Vault{Directory: vdir, Cipher: "aes", Start: time.Now()}
+	vault.SetSecret([]byte("test"))
+	if err := vault.Create(vdir); err != nil {
+		t.Fatal(err)
+	}
+	var rids []string
+	for _, folder := range []string{"work", "work/servers", "work/servers", ""} {
+		rec, err := vault.AddRecord("login")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := vault.MoveRecord(rec.ID, folder); err != nil {
+			t.Fatal(err)
+		}
+		rids = append(rids, rec.ID)
+	}
+	if err := vault.CreateFolder("home/bills"); err != nil {
+		t.Fatal(err)
+	}
+	if err := vault.CreateFolder("work"); err == nil {
+		t.Error("existing folder is created again")
+	}
+	folders, err := vault.Folders()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, f := range folders {
+		paths = append(paths, fmt.Sprintf("%s:%d:%d", f.Path, f.Count, f.Total))
+	}
+	expect := "home:0:0 home/bills:0:0 work:1:3 work/servers:2:2"
+	if strings.Join(paths, " ") != expect {
+		t.Errorf("wrong vault folders %v, expect %s", paths, expect)
+	}
+
+	// folders and records of folders are read from vault index
+	lazy := Vault{Directory: vdir, Cipher: "aes", Start: time.Now()}
+	lazy.SetSecret([]byte("test"))
+	if err := lazy.ReadIndex(); err != nil {
+		t.Fatal(err)
+	}
+	records, err := lazy.Query(`folder:"work"`)
+	if err != nil || len(records) != 3 {
+		t.Errorf("wrong records of work folder %v, error %v", records, err)
+	}
+	if folders, err := lazy.Folders(); err != nil || len(folders) != 4 {
+		t.Errorf("wrong folders of vault index %+v, error %v", folders, err)
+	}
+
+	// records and sub-folders follow renamed and moved folders
+	if err := vault.RenameFolder("work", "job"); err != nil {
+		t.Fatal(err)
+	}
+	if err := vault.RenameFolder("job", "job/servers/old"); err == nil {
+		t.Error("folder is moved into itself")
+	}
+	if err := vault.MoveFolder("job/servers", "home"); err != nil {
+		t.Fatal(err)
+	}
+	rec, err := vault.LoadRecord(rids[1])
+	if err != nil || rec.Folder != "home/servers" {
+		t.Errorf("wrong folder of moved record %s, error %v", rec.Folder, err)
+	}
+	rec, err = vault.LoadRecord(rids[0])
+	if err != nil || rec.Folder != "job" {
+		t.Errorf("wrong folder of renamed record %s, error %v", rec.Folder, err)
+	}
+
+	// records of deleted folder are moved to its parent folder
+	if err := vault.DeleteFolder("home"); err != nil {
+		t.Fatal(err)
+	}
+	if err := vault.DeleteFolder("home"); err == nil {
+		t.Error("deleted folder is deleted again")
+	}
+	other := Vault{Directory: vdir, Cipher: "aes", Start: time.Now()}
+	other.SetSecret([]byte("test"))
+	if err := other.ReadIndex(); err != nil {
+		t.Fatal(err)
+	}
+	folders, err = other.Folders()
+	if err != nil || len(folders) != 1 || folders[0].Path != "job" {
+		t.Errorf("wrong folders after deletion %+v, error %v", folders, err)
+	}
+	if records, err := other.Query(`folder:"job"`); err != nil || len(records) != 1 {
+		t.Errorf("wrong records of job folder %v, error %v", records, err)
+	}
+	rec, err = other.LoadRecord(rids[2])
+	if err != nil || rec.Folder != "" {
+		t.Errorf("record of deleted folder is not moved to vault root %s, error %v", rec.Folder, err)
+	}
+}
+
+// TestVaultTags function
+func TestVaultTags(t *testing.T) {
+	if tags := ParseTags(" Work, bank;work  Home "); strings.Join(tags, ",") != "bank,home,work" {
+		t.Errorf("wrong parsed tags %v", tags)
+	}
+
+	vdir := tempDir()
+	defer os.RemoveAll(vdir)
+	vault := Vault{Directory: vdir, Cipher: "aes", Start: time.Now()}
+	vault.SetSecret([]byte("test"))
+	if err := vault.Create(vdir); err != nil {
+		t.Fatal(err)
+	}
+	var rids []string
+	for _, tags := range []string{"Work bank", "work,Job", "home", ""} {
+		rec, err := vault.AddRecord("login")
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec.Map["Tags"] = tags
+		if err := vault.Update(*rec); err != nil {
+			t.Fatal(err)
+		}
+		rids = append(rids, rec.ID)
+	}
+	// tags are normalized when records are written
+	rec, err := vault.LoadRecord(rids[0])
+	if err != nil || rec.Map["Tags"] != "bank,work" || !rec.HasTag("WORK") {
+		t.Errorf("tags are not normalized %s, error %v", rec.Map["Tags"], err)
+	}
+
+	// helper function to provide tags of vault index
+	tagCounts := func() string {
+		lazy := Vault{Directory: vdir, Cipher: "aes", Start: time.Now()}
+		lazy.SetSecret([]byte("test"))
+		tags, err := lazy.Tags()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out []string
+		for _, tag := range tags {
+			out = append(out, fmt.Sprintf("%s:%d", tag.Name, tag.Count))
+		}
+		return strings.Join(out, " ")
+	}
+	if tags := tagCounts(); tags != "bank:1 home:1 job:1 work:2" {
+		t.Errorf("wrong vault tags %s", tags)
+	}
+
+	count, err := vault.RenameTag("Job", "work")
+	if err != nil || count != 1 {
+		t.Errorf("wrong number of records with renamed tag %d, error %v", count, err)
+	}
+	if tags := tagCounts(); tags != "bank:1 home:1 work:2" {
+		t.Errorf("wrong vault tags after rename %s", tags)
+	}
+	count, err = vault.MergeTags([]string{"bank", "home"}, "personal")
+	if err != nil || count != 2 {
+		t.Errorf("wrong number of records with merged tags %d, error %v", count, err)
+	}
+	if _, err := vault.MergeTags([]string{"work"}, "a b"); err == nil {
+		t.Error("tags are merged into invalid tag")
+	}
+	count, err = vault.DeleteTag("work")
+	if err != nil || count != 2 {
+		t.Errorf("wrong number of records with deleted tag %d, error %v", count, err)
+	}
+	if tags := tagCounts(); tags != "personal:2" {
+		t.Errorf("wrong vault tags after delete %s", tags)
+	}
+	if count, err := vault.DeleteTag("work"); err != nil || count != 0 {
+		t.Errorf("records without tag are changed %d, error %v", count, err)
+	}
+
+	// changed records keep their previous versions in backups and history
+	backups, err := filepath.Glob(filepath.Join(vdir, "backups", "*", rids[0]))
+	if err != nil || len(backups) != 1 {
+		t.Errorf("wrong backups of changed record %v, error %v", backups, err)
+	}
+	versions, err := vault.History(rids[0])
+	if err != nil || len(versions) < 3 {
+		t.Errorf("wrong history of changed record %v, error %v", versions, err)
+	}
+	rec, err = vault.Record(rids[0])
+	if err != nil || rec.Map["Tags"] != "personal" {
+		t.Errorf("wrong tags of loaded record %s, error %v", rec.Map["Tags"], err)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(vdir, "tags-*")); len(matches) != 0 {
+		t.Errorf("staging area is not removed %v", matches)
+	}
+
+	// records are kept intact when their tags can not be changed
+	bdir := filepath.Join(vdir, "backups")
+	if err := os.Rename(bdir, bdir+".orig"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(bdir, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vault.DeleteTag("personal"); err == nil {
+		t.Error("tags are changed without record backups")
+	}
+	rec, err = vault.Record(rids[0])
+	if err != nil || rec.Map["Tags"] != "personal" {
+		t.Errorf("in-memory record is changed after failure %s, error %v", rec.Map["Tags"], err)
+	}
+	if tags := tagCounts(); tags != "personal:2" {
+		t.Errorf("wrong vault tags after failure %s", tags)
+	}
+	os.Remove(bdir)
+	if err := os.Rename(bdir+".orig", bdir); err != nil {
+		t.Fatal(err)
+	}
+
+	// staging area left by interrupted change is not considered as record
+	sdir := filepath.Join(vdir, "tags-123.tmp")
+	if err := os.MkdirAll(sdir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(sdir, rids[0]), []byte("data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	fresh := Vault{Directory: vdir, Cipher: "aes", Start: time.Now()}
+	fresh.SetSecret([]byte("test"))
+	if err := fresh.Read(); err != nil {
+		t.Fatal(err)
+	}
+	files, err := fresh.Files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range files {
+		if name == "tags-123.tmp" {
+			t.Errorf("staging area is listed as vault file %v", files)
+		}
+	}
+	if len(fresh.Records) != len(rids) {
+		t.Errorf("wrong number of vault records %d", len(fresh.Records))
+	}
+
+	// tag term is combined with top level OR query
+	query, err := ParseQuery("kind:login OR kind:note")
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := vault.Search(query.And(FieldQuery("tag", "Personal")))
+	if len(records) != 2 {
+		t.Errorf("wrong records with personal tag %v", records)
+	}
+}
+
+// TestVaultAudit function
+func TestVaultAudit(t *testing.T) {
+	strong := "x8#Kq!m2Zr@v9LpT"
+	records := []VaultRecord{
+		{ID: "1", Kind: "login", Map: Record{"Name": "github", "Password": strong, "URL": "https://github.com", TOTPKey: "JBSWY3DPEHPK3PXP"}, ModificationTime: time.Now()},
+		{ID: "2", Kind: "login", Map: Record{"Name": "gitlab", "Password": strong, "URL": "https://gitlab.com", TOTPKey: "JBSWY3DPEHPK3PXP"}, ModificationTime: time.Now()},
+		{ID: "3", Kind: "login", Map: Record{"Name": "bank", "Password": "password1", "URL": "http://bank.com"}, ModificationTime: time.Now().Add(-400 * 24 * time.Hour)},
+		{ID: "4", Kind: "login", Map: Record{"Name": "empty", "Password": ""}, ModificationTime: time.Now()},
+		{ID: "5", Kind: "note", Map: Record{"Name": "note", "Note": "text"}, ModificationTime: time.Now().Add(-400 * 24 * time.Hour)},
+	}
+	vault := Vault{Records: records}
+	report := vault.Audit()
+	if report.Records != 5 || report.Passwords != 3 || report.Days != 365 {
+		t.Errorf("wrong audit report %+v", report)
+	}
+	var issues []string
+	for _, issue := range report.Issues {
+		issues = append(issues, issue.Kind+":"+issue.Name)
+	}
+	expect := "empty:empty reused:github reused:gitlab weak:bank stale:bank insecure:bank no-totp:bank no-totp:empty"
+	if strings.Join(issues, " ") != expect {
+		t.Errorf("wrong audit issues %v, expect %s", issues, expect)
+	}
+	if report.Counts[AuditNoTOTP] != 2 || report.Healthy() {
+		t.Errorf("wrong audit counts %v", report.Counts)
+	}
+	if !strings.Contains(report.Issues[1].Detail, "gitlab") {
+		t.Errorf("wrong detail of reused password %s", report.Issues[1].Detail)
+	}
+
+	// rotation period is taken from the vault
+	vault.RotationPeriod = 500 * 24 * time.Hour
+	if report := vault.Audit(); report.Counts[AuditStale] != 0 || report.Days != 500 {
+		t.Errorf("wrong stale passwords %+v", report.Counts)
+	}
+	if bits := PasswordEntropy("abc"); bits < 14 || bits > 15 {
+		t.Errorf("wrong password entropy %f", bits)
+	}
+}
+
+// TestVaultBreaches function
+func TestVaultBreaches(t *testing.T) {
+	vdir := tempDir()
+	defer os.RemoveAll(vdir)
+
+	// dataset of sorted hashes along with range files of the same hashes
+	counts := map[string]int{"password1": 2427546, "qwerty": 3912816}
+	var lines []string
+	for i := 0; i < 1000; i++ {
+		lines = append(lines, fmt.Sprintf("%s:%d", PasswordHash(fmt.Sprintf("pwd-%d", i)), i+1))
+	}
+	for password, count := range counts {
+		lines = append(lines, fmt.Sprintf("%s:%d", PasswordHash(password), count))
+	}
+	sort.Strings(lines)
+	fname := filepath.Join(vdir, "hibp.txt")
+	if err := os.WriteFile(fname, []byte(strings.Join(lines, "\r\n")), 0600); err != nil {
+		t.Fatal(err)
+	}
+	rdir := filepath.Join(vdir, "ranges")
+	if err := os.Mkdir(rdir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range lines {
+		name := filepath.Join(rdir, line[:5]+".txt")
+		file, err := os.OpenFile(name, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(file, "%s\r\n", line[5:])
+		file.Close()
+	}
+
+	vault := Vault{Records: []VaultRecord{
+		{ID: "1", Map: Record{"Name": "bank", "Password": "password1"}},
+		{ID: "2", Map: Record{"Name": "github", "Password": "x8#Kq!m2Zr@v9LpT"}},
+		{ID: "3", Map: Record{"Name": "mail", "Password": "qwerty"}},
+		{ID: "4", Map: Record{"Name": "note", "Note": "text"}},
+	}}
+	for _, path := range []string{fname, rdir} {
+		db, err := OpenBreachDB(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, line := range lines {
+			hash, count := parseBreachLine([]byte(line))
+			if found, err := db.CountHash(hash); err != nil || found != count {
+				t.Fatalf("hash %s is not found in %s, count %d, error %v", hash, path, found, err)
+			}
+		}
+		if count, err := db.Count("not-breached"); err != nil || count != 0 {
+			t.Errorf("wrong count of not breached password %d, error %v", count, err)
+		}
+		breaches, err := vault.Breaches(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out []string
+		for _, b := range breaches {
+			out = append(out, fmt.Sprintf("%s:%d", b.Name, b.Count))
+		}
+		expect := "mail:3912816 bank:2427546 github:0"
+		if strings.Join(out, " ") != expect {
+			t.Errorf("wrong breaches of %s %v, expect %s", path, out, expect)
+		}
+		db.Close()
+	}
+}
+
+// TestVaultDirLock function
+func TestVaultDirLock(t *testing.T) {
+	vdir := tempDir()
+	defer os.RemoveAll(vdir)
+	vault := Vault{Directory: vdir, Cipher: "aes", Start: time.Now()}
+	vault.SetSecret([]byte("test"))
+	if err := vault.Create(vdir); err != nil {
+		t.Fatal(err)
+	}
+	rec, err := vault.AddRecord("login")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// another process of the same vault waits for the lock within its timeout
+	timeout := 100 * time.Millisecond
+	other := Vault{Directory: vdir, Cipher: "aes", LockTimeout: timeout}
+	other.SetSecret([]byte("test"))
+	unlock, err := vault.lockDir(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the lock is re-entrant within the vault
+	if err := vault.WriteRecord(*rec); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if err := other.Read(); !errors.Is(err, ErrLockTimeout) {
+		t.Errorf("vault is read while it is locked, error %v", err)
+	}
+	if time.Since(start) < timeout {
+		t.Error("vault lock is not waited for")
+	}
+	if err := other.WriteRecord(*rec); !errors.Is(err, ErrLockTimeout) {
+		t.Errorf("record is written while vault is locked, error %v", err)
+	}
+	unlock()
+
+	// readers share the lock while writers wait for it
+	unlock, err = vault.lockDir(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.LoadRecord(rec.ID); err != nil {
+		t.Errorf("record is not read along with another reader, error %v", err)
+	}
+	if err := other.WriteRecord(*rec); !errors.Is(err, ErrLockTimeout) {
+		t.Errorf("record is written while vault is read, error %v", err)
+	}
+	// vault index is written only under exclusive lock, while reader
+	// keeps validated index in memory
+	if err := os.Remove(filepath.Join(vdir, IndexFile)); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.ReadIndex(); err != nil {
+		t.Errorf("unable to read vault index along with another reader, error %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(vdir, IndexFile)); err == nil {
+		t.Error("vault index is written while vault is read")
+	}
+	if len(other.Index()) != 1 {
+		t.Errorf("wrong vault index entries %v", other.Index())
+	}
+	unlock()
+	if err := other.ReadIndex(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(vdir, IndexFile)); err != nil {
+		t.Errorf("vault index is not written, error %v", err)
+	}
+
+	// concurrent writers of the same vault do not corrupt its records
+	done := make(chan error)
+	for i := 0; i < 4; i++ {
+		go func(i int) {
+			writer := Vault{Directory: vdir, Cipher: "aes"}
+			writer.SetSecret([]byte("test"))
+			r := *rec
+			var err error
+			for j := 0; j < 5 && err == nil; j++ {
+				r.Map = Record{"Name": fmt.Sprintf("writer-%d-%d", i, j)}
+				err = writer.WriteRecord(r)
+			}
+			done <- err
+		}(i)
+	}
+	for i := 0; i < 4; i++ {
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	}
+	reader := Vault{Directory: vdir, Cipher: "aes"}
+	reader.SetSecret([]byte("test"))
+	if err := reader.Read(); err != nil {
+		t.Errorf("unable to read vault records, error %v", err)
+	}
+	if len(reader.Records) != 1 || !strings.HasPrefix(reader.Records[0].Map["Name"], "writer-") {
+		t.Errorf("wrong vault records %+v", reader.Records)
+	}
+	tmps, err := filepath.Glob(filepath.Join(vdir, "*.tmp"))
+	if err != nil || len(tmps) != 0 {
+		t.Errorf("temporary files %v are left in vault, error %v", tmps, err)
+	}
+
+	// readers open vault lock file read-only
+	unlock, err = reader.lockDir(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reader.dirLock.file.Write([]byte("lock")); err == nil {
+		t.Error("vault lock file is writable by vault reader")
+	}
+	unlock()
+
+	// readers of vault which is not writable do not lock it
+	if err := os.Remove(filepath.Join(vdir, LockFile)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(vdir, 0500); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(vdir, 0700)
+	// privileged user can write to any directory
+	if file, err := os.Create(filepath.Join(vdir, LockFile)); err == nil {
+		file.Close()
+		return
+	}
+	reader.Records = nil
+	if err := reader.Read(); err != nil || len(reader.Records) != 1 {
+		t.Errorf("unable to read vault which is not writable, error %v", err)
+	}
+}
@@ -4,7 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
-	"math/rand"
+	"log"
 	"net/http"
 	"strconv"
 	"syscall/js"
@@ -87,8 +87,8 @@ func showRecords() ([]byte, error) {
 	return data, err
 }
 
-// helper function to create new vault record
-func newRecord(rmap vt.Record) ([]byte, error) {
+// helper function to create new vault record of given kind
+func newRecord(kind string, rmap vt.Record) ([]byte, error) {
 	var data []byte
 	var err error
 
@@ -96,8 +96,11 @@ func newRecord(rmap vt.Record) ([]byte, error) {
 	records := document.Call("getElementById", "records")
 	uid := uuid.NewString()
 
-	vrec := vt.VaultRecord{ID: uid, Map: rmap, ModificationTime: time.Now()}
-	err = postData("record", vrec)
+	vrec := vt.VaultRecord{ID: uid, Kind: kind, Map: rmap, ModificationTime: time.Now()}
+	err = vrec.Validate()
+	if err == nil {
+		err = postData("record", vrec)
+	}
 	msg := fmt.Sprintf("New record created with UUID: %s", uid)
 	if err != nil {
 		msg = fmt.Sprintf("Failt to create new record %s, error %v", uid, err)
@@ -108,53 +111,27 @@ func newRecord(rmap vt.Record) ([]byte, error) {
 	return data, err
 }
 
-func loginRecord() ([]byte, error) {
-	rmap := make(vt.Record)
-	document := js.Global().Get("document")
-	rmap["Name"] = document.Call("getElementById", "new-record-name").Get("value").String()
-	rmap["Login"] = document.Call("getElementById", "new-record-login").Get("value").String()
-	rmap["Password"] = document.Call("getElementById", "new-record-password").Get("value").String()
-	rmap["Tags"] = document.Call("getElementById", "new-record-tags").Get("value").String()
-	rmap["Url"] = document.Call("getElementById", "new-record-url").Get("value").String()
-	return newRecord(rmap)
-
-}
-func cardRecord() ([]byte, error) {
-	rmap := make(vt.Record)
-	document := js.Global().Get("document")
-	rmap["Name"] = document.Call("getElementById", "new-card-name").Get("value").String()
-	rmap["Number"] = document.Call("getElementById", "new-card-number").Get("value").String()
-	rmap["Code"] = document.Call("getElementById", "new-card-code").Get("value").String()
-	rmap["Tags"] = document.Call("getElementById", "new-card-tags").Get("value").String()
-	rmap["Date"] = document.Call("getElementById", "new-card-date").Get("value").String()
-	rmap["Phone"] = document.Call("getElementById", "new-card-phone").Get("value").String()
-	return newRecord(rmap)
-}
-func jsonRecord() ([]byte, error) {
-	rmap := make(vt.Record)
-	// get all inputs for login record
-	document := js.Global().Get("document")
-	rmap["name"] = document.Call("getElementById", "new-json-name").Get("value").String()
-	json := document.Call("getElementById", "new-json-record").Get("value").String()
-	// TODO: I need to parse and decompose JSON into individual key-value pairs
-	rmap["JSON"] = json
-	return newRecord(rmap)
-}
-func noteRecord() ([]byte, error) {
+// helper function to create new record from input fields of its kind schema
+func kindRecord(kind string) ([]byte, error) {
+	schema, ok := vt.LookupSchema(kind)
+	if !ok {
+		return []byte{}, fmt.Errorf("unknown record kind %s", kind)
+	}
 	rmap := make(vt.Record)
 	document := js.Global().Get("document")
-	rmap["Name"] = document.Call("getElementById", "new-note-name").Get("value").String()
-	rmap["Note"] = document.Call("getElementById", "new-note-record").Get("value").String()
-	return newRecord(rmap)
+	for _, field := range schema.Fields {
+		id := fmt.Sprintf("new-%s-%s", kind, field.Key)
+		rmap[field.Key] = document.Call("getElementById", id).Get("value").String()
+	}
+	return newRecord(kind, rmap)
 }
 func uploadFile(fname string, size int, ftype, content string) ([]byte, error) {
 	rmap := make(vt.Record)
 	rmap["Name"] = fname
 	rmap["Size"] = fmt.Sprintf("%d", size)
 	rmap["Type"] = ftype
-	rmap["Tags"] = "file"
 	rmap["Data"] = content
-	return newRecord(rmap)
+	return newRecord("file", rmap)
 }
 
 func syncHosts() ([]byte, error) {
@@ -169,31 +146,28 @@ func createVault() ([]byte, error) {
 }
 
 func newPassword() ([]byte, error) {
-	const voc string = "abcdfghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
-	const numbers string = "0123456789"
-	const symbols string = "!@#$%&*+_-="
 	document := js.Global().Get("document")
 	docRecords := document.Call("getElementById", "new-password")
 	size := document.Call("getElementById", "password-size").Get("value").String()
 	chars := document.Call("getElementById", "characters").Get("value").String()
-	var password string
+	var numbers, symbols bool
 	if chars == "chars+numbers" {
-		password = generatePassword(size, voc+numbers)
+		numbers = true
 	} else if chars == "chars+numbers+symbols" {
-		password = generatePassword(size, voc+numbers+symbols)
-	} else {
-		password = generatePassword(size, voc)
+		numbers = true
+		symbols = true
 	}
-	docRecords.Set("innerHTML", fmt.Sprintf("New password: <b>%s</b>", password))
+	password, entropy := generatePassword(size, numbers, symbols)
+	docRecords.Set("innerHTML", fmt.Sprintf("New password: <b>%s</b> (%.1f bits)", password, entropy))
 	result := make(map[string]string)
 	result["Result"] = "new password was generated"
 	data, err := json.Marshal(result)
 	return data, err
 }
 
-// helper function to generate password of certain length and chars
-func generatePassword(size interface{}, chars string) string {
-	rand.Seed(time.Now().UnixNano())
+// helper function to generate password of certain length and characters,
+// it returns password and its entropy in bits
+func generatePassword(size interface{}, numbers, symbols bool) (string, float64) {
 	length := 16
 	switch v := size.(type) {
 	case string:
@@ -202,9 +176,10 @@ func generatePassword(size interface{}, chars string) string {
 	case int, int32, int64:
 		length = v.(int)
 	}
-	password := ""
-	for i := 0; i < length; i++ {
-		password += string([]rune(chars)[rand.Intn(len(chars))])
+	policy := crypt.NewPasswordPolicy(length, numbers, symbols)
+	password, entropy, err := policy.Generate()
+	if err != nil {
+		log.Println("unable to generate password", err)
 	}
-	return password
+	return password, entropy
 }
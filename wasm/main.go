@@ -5,13 +5,13 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net/http"
 	"regexp"
 	"sort"
 	"strings"
 	"syscall/js"
 	"time"
 
-	"github.com/vkuznet/ecm/crypt"
 	vt "github.com/vkuznet/ecm/vault"
 	//dom "honnef.co/go/js/dom/v2"
 )
@@ -34,6 +34,13 @@ type LoginRecord struct {
 	Note     string
 	Tags     string
 	URL      string
+	loaded   bool // full record is loaded
+}
+
+// HTTPVaultRecord represents encrypted vault record provided by ECM server
+type HTTPVaultRecord struct {
+	ID   string `json:"id"`
+	Data []byte `json:"data"`
 }
 
 // RecordMap type defines our ECM record map
@@ -44,6 +51,7 @@ type RecordsManager struct {
 	Map           RecordMap
 	RenewInterval int64
 	Expire        int64
+	Meta          *vt.Meta
 }
 
 // global records manager which holds all vault records
@@ -52,7 +60,7 @@ var recordsManager *RecordsManager
 // helper function to get ECM records
 func (mgr *RecordsManager) update(url, cipher, password string) error {
 	if recordsManager.Map == nil || recordsManager.Expire < time.Now().Unix() {
-		rmap, err := getRecords(url, cipher, password)
+		rmap, meta, err := getRecords(url, cipher, password)
 		if err != nil {
 			rmap := make(RecordMap)
 			rid := "12345"
@@ -64,61 +72,164 @@ func (mgr *RecordsManager) update(url, cipher, password string) error {
 			rmap[rid] = lrec
 		}
 		mgr.Map = rmap
+		mgr.Meta = meta
 		mgr.Expire = time.Now().Unix() + mgr.RenewInterval
 		return err
 	}
 	return nil
 }
 
-// helper function to get ECM records from given URL
-func getRecords(url, cipher, password string) (RecordMap, error) {
+// helper function to get ECM records from given URL, the records are read
+// from vault index if vault provides it and they are loaded lazily
+func getRecords(url, cipher, password string) (RecordMap, *vt.Meta, error) {
 	rmap := make(RecordMap)
 
 	// Make the HTTP request
 	client, err := httpClient()
 	if err != nil {
-		return rmap, err
+		return rmap, nil, err
+	}
+	meta := getMeta(client, url)
+	if imap, err := getIndexRecords(client, url, meta, cipher, password); err == nil {
+		return imap, meta, nil
 	}
 
-	// get results from our url
-	res, err := client.Get(url)
+	// get results from our url, records are requested along with their IDs
+	// since vault records are bound to their IDs
+	rurl := url + "?id=true"
+	if strings.Contains(url, "?") {
+		rurl = url + "&id=true"
+	}
+	res, err := client.Get(rurl)
 	if err != nil {
-		return rmap, err
+		return rmap, meta, err
 	}
 	defer res.Body.Close()
 
 	// Read the response body
 	data, err := ioutil.ReadAll(res.Body)
 	if err != nil {
-		return rmap, err
+		return rmap, meta, err
 	}
-	// records represent list of file names
-	var records [][]byte
+	// records represent list of record IDs and their encrypted data
+	var records []HTTPVaultRecord
 	err = json.Unmarshal(data, &records)
 	if err != nil {
-		return rmap, err
+		return rmap, meta, err
 	}
 	for _, rec := range records {
-		data, err := crypt.Decrypt(rec, password, cipher)
+		lrec, err := decryptRecord(meta, rec.ID, rec.Data, cipher, password)
 		if err != nil {
-			return rmap, err
+			return rmap, meta, err
 		}
-		var vrec vt.VaultRecord
-		err = json.Unmarshal(data, &vrec)
-		lrec := LoginRecord{
-			ID:       vrec.ID,
-			Login:    vrec.Map["Login"],
-			Password: vrec.Map["Password"],
-			Note:     vrec.Map["Note"],
-			Name:     vrec.Map["Name"],
-			Tags:     vrec.Map["Tags"],
-			URL:      vrec.Map["URL"],
-		}
-		rmap[vrec.ID] = lrec
+		rmap[lrec.ID] = lrec
+	}
+	return rmap, meta, nil
+}
+
+// helper function to decrypt vault record
+func decryptRecord(meta *vt.Meta, rid string, edata []byte, cipher, password string) (LoginRecord, error) {
+	data, err := meta.DecryptRecord(rid, edata, []byte(password), cipher)
+	if err != nil {
+		return LoginRecord{}, err
+	}
+	var vrec vt.VaultRecord
+	err = json.Unmarshal(data, &vrec)
+	if err != nil {
+		return LoginRecord{}, err
+	}
+	lrec := LoginRecord{
+		ID:       vrec.ID,
+		Login:    vrec.Value("Login"),
+		Password: vrec.Value("Password"),
+		Note:     vrec.Value("Note"),
+		Name:     vrec.Value("Name"),
+		Tags:     vrec.Value("Tags"),
+		URL:      vrec.Value("URL"),
+		loaded:   true,
+	}
+	return lrec, nil
+}
+
+// helper function to get ECM records from vault index, it only requires
+// single decryption and records are loaded when they are shown
+func getIndexRecords(client *http.Client, url string, meta *vt.Meta, cipher, password string) (RecordMap, error) {
+	rmap := make(RecordMap)
+	iurl := strings.Replace(url, "/records", "/"+vt.IndexFile, 1)
+	res, err := client.Get(iurl)
+	if err != nil {
+		return rmap, err
+	}
+	defer res.Body.Close()
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return rmap, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return rmap, fmt.Errorf("unable to get vault index, status %d", res.StatusCode)
+	}
+	data, err = meta.DecryptRecord(vt.IndexFile, data, []byte(password), cipher)
+	if err != nil {
+		return rmap, err
+	}
+	index, err := vt.ParseIndex(data)
+	if err != nil {
+		return rmap, err
+	}
+	for _, e := range index.List() {
+		rmap[e.ID] = LoginRecord{ID: e.ID, Name: e.Name, Tags: e.Tags, URL: e.URL}
 	}
 	return rmap, nil
 }
 
+// helper function to load full record from given records URL
+func (mgr *RecordsManager) load(url, rid, cipher, password string) (LoginRecord, error) {
+	lrec := mgr.Map[rid]
+	if lrec.loaded || mgr.Meta == nil {
+		return lrec, nil
+	}
+	client, err := httpClient()
+	if err != nil {
+		return lrec, err
+	}
+	res, err := client.Get(strings.Replace(url, "/records", "/"+rid, 1))
+	if err != nil {
+		return lrec, err
+	}
+	defer res.Body.Close()
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return lrec, err
+	}
+	lrec, err = decryptRecord(mgr.Meta, rid, data, cipher, password)
+	if err != nil {
+		return mgr.Map[rid], err
+	}
+	mgr.Map[rid] = lrec
+	return lrec, nil
+}
+
+// helper function to get vault meta-data from given records URL, if vault
+// does not provide meta-data we fall back to legacy key derivation
+func getMeta(client *http.Client, url string) *vt.Meta {
+	murl := strings.Replace(url, "/records", "/"+vt.MetaFile, 1)
+	res, err := client.Get(murl)
+	if err != nil {
+		return vt.LegacyMeta()
+	}
+	defer res.Body.Close()
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil || res.StatusCode != http.StatusOK {
+		return vt.LegacyMeta()
+	}
+	meta, err := vt.ParseMeta(data)
+	if err != nil {
+		log.Println("unable to parse vault meta-data", err)
+		return vt.LegacyMeta()
+	}
+	return meta
+}
+
 // main function sets JS functions
 func main() {
 	// log time, filename, and line number
@@ -132,10 +243,7 @@ func main() {
 	js.Global().Set("records", recordsWrapper())
 	js.Global().Set("uploadFile", uploadFileWrapper())
 
-	js.Global().Set("addRecord", actionWrapper("login_record"))
-	js.Global().Set("addJsonRecord", actionWrapper("json_record"))
-	js.Global().Set("addNote", actionWrapper("note_record"))
-	js.Global().Set("addCard", actionWrapper("card_record"))
+	js.Global().Set("addKindRecord", kindRecordWrapper())
 	js.Global().Set("addVault", actionWrapper("new_vault"))
 	js.Global().Set("syncHosts", actionWrapper("sync_hosts"))
 	js.Global().Set("showRecords", actionWrapper("show_records"))
@@ -222,6 +330,22 @@ func actionWrapper(action string) js.Func {
 	})
 }
 
+// wrapper function to add new record of given kind
+func kindRecordWrapper() js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		if len(args) != 1 {
+			return "Invalid no of arguments passed"
+		}
+		action := "kind_record:" + args[0].String()
+		handler := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+			go ActionHandler(action, args)
+			return nil
+		})
+		promiseConstructor := js.Global().Get("Promise")
+		return promiseConstructor.New(handler)
+	})
+}
+
 // ActionHandler handles vault action
 func ActionHandler(action string, args []js.Value) {
 	resolve := args[0]
@@ -232,14 +356,8 @@ func ActionHandler(action string, args []js.Value) {
 	var err error
 	if action == "show_records" {
 		data, err = showRecords()
-	} else if action == "login_record" {
-		data, err = loginRecord()
-	} else if action == "json_record" {
-		data, err = jsonRecord()
-	} else if action == "card_record" {
-		data, err = cardRecord()
-	} else if action == "note_record" {
-		data, err = noteRecord()
+	} else if strings.HasPrefix(action, "kind_record:") {
+		data, err = kindRecord(strings.TrimPrefix(action, "kind_record:"))
 	} else if action == "sync_host" {
 		data, err = syncHosts()
 	} else if action == "new_vault" {
@@ -270,12 +388,8 @@ func ActionHandler(action string, args []js.Value) {
 // wrapper function to generate new password
 func genPasswordWrapper() js.Func {
 	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
-		const voc string = "abcdfghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
-		const numbers string = "0123456789"
-		//         const symbols string = "!@#$%&*+_-="
 		size := args[0].Int()
-		chars := voc + numbers
-		password := generatePassword(size, chars)
+		password, _ := generatePassword(size, true, false)
 		document := js.Global().Get("document")
 		docRecords := document.Call("getElementById", "new-password")
 		docRecords.Set("innerHTML", password)
@@ -363,6 +477,7 @@ func updateRecords(url, cipher, passphrase, pattern, pageUrl string, extention b
 		if !ok {
 			continue
 		}
+
 		count += 1
 		// skip records which does not match page url
 		if pageUrl != "" && pattern == "" {
@@ -391,6 +506,13 @@ func updateRecords(url, cipher, passphrase, pattern, pageUrl string, extention b
 		if count > nrec {
 			continue
 		}
+		// records read from vault index are loaded when they are shown
+		if rec, err := recordsManager.load(url, key, cipher, passphrase); err == nil {
+			login = rec.Login
+			password = rec.Password
+		} else {
+			log.Printf("unable to load record %s, error %v", key, err)
+		}
 		rids = append(rids, key)
 
 		// construct frontend UI
@@ -505,6 +627,9 @@ func updateRecords(url, cipher, passphrase, pattern, pageUrl string, extention b
 			if count > nrec {
 				break
 			}
+			if _, err := recordsManager.load(url, key, cipher, passphrase); err != nil {
+				log.Printf("unable to load record %s, error %v", key, err)
+			}
 			rids = append(rids, key)
 		}
 	}